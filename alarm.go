@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"math"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// AlarmConfig describes the alarm created with -create-alarm.
+type AlarmConfig struct {
+	Name              string
+	Threshold         float64
+	EvaluationPeriods int32
+	TopicARN          string
+
+	// TreatMissingData is passed through to CloudWatch when set.
+	TreatMissingData string
+}
+
+// ensureAlarm creates the unhealthy count alarm, or updates it in place if an
+// alarm with the same name already exists.
+func ensureAlarm(ctx context.Context, client CloudWatchAPI, config AlarmConfig) error {
+	input := &cloudwatch.PutMetricAlarmInput{
+		AlarmName:          aws.String(config.Name),
+		AlarmDescription:   aws.String("etcd cluster " + *etcdName + " is unhealthy (managed by etcd-monitor)"),
+		Namespace:          aws.String(*namespace),
+		MetricName:         aws.String(*metricName),
+		Dimensions:         metricDimensions(),
+		Statistic:          types.StatisticMaximum,
+		Period:             aws.Int32(metricPeriod()),
+		EvaluationPeriods:  aws.Int32(config.EvaluationPeriods),
+		Threshold:          aws.Float64(config.Threshold),
+		ComparisonOperator: types.ComparisonOperatorGreaterThanOrEqualToThreshold,
+	}
+	if config.TreatMissingData != "" {
+		input.TreatMissingData = aws.String(config.TreatMissingData)
+	}
+	if config.TopicARN != "" {
+		input.AlarmActions = []string{config.TopicARN}
+		input.OKActions = []string{config.TopicARN}
+	}
+
+	_, err := client.PutMetricAlarm(ctx, input)
+
+	return err
+}
+
+// deleteAlarm removes the alarm created by ensureAlarm.
+func deleteAlarm(ctx context.Context, client CloudWatchAPI, name string) error {
+	_, err := client.DeleteAlarms(ctx, &cloudwatch.DeleteAlarmsInput{
+		AlarmNames: []string{name},
+	})
+
+	return err
+}
+
+// metricPeriod returns the shortest alarm or dashboard period that covers at
+// least one check interval. Periods below a minute require high-resolution
+// metrics.
+func metricPeriod() int32 {
+	seconds := int32(math.Ceil(publishInterval.Seconds()))
+	if *highResolution {
+		for _, p := range []int32{10, 30} {
+			if seconds <= p {
+				return p
+			}
+		}
+	}
+
+	return int32(math.Max(1, math.Ceil(float64(seconds)/60))) * 60
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// AppliedIndexLagProbe detects followers that answer /health but trail the
+// leader in applying raft entries. It queries the status of every checked
+// endpoint at the same time and compares their applied index with the
+// leader's.
+type AppliedIndexLagProbe struct {
+	tolerance uint64
+	threshold uint64
+	intervals int
+
+	// over counts the consecutive checks each member has been above the
+	// threshold, by member name. A warning is logged once per streak when it
+	// reaches intervals.
+	over map[string]int
+	// leaderWarned is set once a missing leader was logged, until the leader
+	// is among the checked endpoints again.
+	leaderWarned bool
+}
+
+// NewAppliedIndexLagProbe returns a probe reporting lag up to tolerance entries
+// as zero and warning about members more than threshold entries behind for
+// intervals consecutive checks.
+func NewAppliedIndexLagProbe(tolerance, threshold uint64, intervals int) *AppliedIndexLagProbe {
+	return &AppliedIndexLagProbe{
+		tolerance: tolerance,
+		threshold: threshold,
+		intervals: intervals,
+		over:      make(map[string]int),
+	}
+}
+
+// Run publishes AppliedIndexLag per member and MaxAppliedIndexLag for the
+// cluster. It needs several checked endpoints including the leader; endpoints
+// whose status cannot be retrieved are skipped.
+func (p *AppliedIndexLagProbe) Run() {
+	current := endpoints
+	if len(current) < 2 {
+		return
+	}
+
+	statuses := make([]*StatusResponse, len(current))
+	queryEndpoints(current, func(i int, endpoint string) {
+		status, err := getStatus(endpoint)
+		if err != nil {
+			log.Printf("[WARN] Failed to get the status of %s for the applied index lag: %s", endpoint, err)
+			return
+		}
+		statuses[i] = status
+	})
+
+	var leader *StatusResponse
+	for _, status := range statuses {
+		if status != nil && status.Leader != 0 && status.Leader == status.Header.MemberID {
+			leader = status
+		}
+	}
+	if leader == nil {
+		if !p.leaderWarned {
+			log.Printf("[WARN] The leader is not among the checked endpoints, cannot compute the applied index lag")
+			p.leaderWarned = true
+		}
+		return
+	}
+	p.leaderWarned = false
+
+	names := memberNames(current[0])
+
+	var maxLag uint64
+	for _, status := range statuses {
+		if status == nil {
+			continue
+		}
+
+		name := names[status.Header.MemberID]
+		if name == "" {
+			name = fmt.Sprintf("%x", status.Header.MemberID)
+		}
+
+		var lag uint64
+		if leader.RaftAppliedIndex > status.RaftAppliedIndex {
+			lag = leader.RaftAppliedIndex - status.RaftAppliedIndex
+		}
+		if lag <= p.tolerance {
+			lag = 0
+		}
+		p.observeLag(name, lag)
+		if lag > maxLag {
+			maxLag = lag
+		}
+
+		metrics.Add(buildMetricData(memberDimensionSets(name), "AppliedIndexLag", float64(lag), types.StandardUnitCount, time.Now())...)
+	}
+
+	metrics.Add(newMetricData("MaxAppliedIndexLag", float64(maxLag), types.StandardUnitCount)...)
+}
+
+// observeLag tracks for how many consecutive checks the member has been above
+// the threshold and logs a warning once it has been for long enough.
+func (p *AppliedIndexLagProbe) observeLag(name string, lag uint64) {
+	if p.threshold == 0 || lag <= p.threshold {
+		delete(p.over, name)
+		return
+	}
+
+	p.over[name]++
+	if p.over[name] == p.intervals {
+		log.Printf("[WARN] etcd member %s has been more than %d entries behind the leader for %d checks, now %d",
+			name, p.threshold, p.intervals, lag)
+	}
+}
+
+// memberNames returns the member names by ID, listed through endpoint. It is
+// empty if the members cannot be listed.
+func memberNames(endpoint string) map[uint64]string {
+	names := make(map[uint64]string)
+	members, err := getMembers(endpoint)
+	if err != nil {
+		log.Printf("[WARN] Failed to list etcd members, using member IDs: %s", err)
+		return names
+	}
+	for _, member := range members {
+		names[member.ID] = member.Name
+	}
+
+	return names
+}
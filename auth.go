@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// etcdAuth are the etcd RBAC credentials, or nil if none are configured.
+var etcdAuth *etcdCredentials
+
+// etcdCredentials holds a username and the password read from a file. The
+// password is re-read on SIGHUP, so it is guarded for the background checks.
+type etcdCredentials struct {
+	username     string
+	passwordFile string
+
+	mu       sync.RWMutex
+	password string
+}
+
+// newEtcdCredentials returns the credentials for username with the password
+// read from passwordFile.
+func newEtcdCredentials(username, passwordFile string) (*etcdCredentials, error) {
+	c := &etcdCredentials{username: username, passwordFile: passwordFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// load (re-)reads the password file. A trailing newline is not part of the
+// password.
+func (c *etcdCredentials) load() error {
+	buff, err := ioutil.ReadFile(c.passwordFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the password file: %s", err)
+	}
+	password := strings.TrimRight(string(buff), "\r\n")
+	if password == "" {
+		return fmt.Errorf("the password file %s is empty", c.passwordFile)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.password = password
+
+	return nil
+}
+
+// get returns the username and the current password.
+func (c *etcdCredentials) get() (string, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.username, c.password
+}
+
+// basicAuthTransport adds the credentials as HTTP Basic auth to every request.
+type basicAuthTransport struct {
+	base        http.RoundTripper
+	credentials *etcdCredentials
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.credentials.get())
+
+	return t.base.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the base transport.
+func (t *basicAuthTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// printBanner prints the configuration of the monitor at startup. tlsConfig
+// is nil for plain http.
+func printBanner(tlsConfig *tls.Config, policy tlsPolicy) {
+	fmt.Println("==> etcd Monitor Configuration:")
+	fmt.Println("")
+	fmt.Printf("\t             Version: %s\n", version)
+	if *once {
+		fmt.Printf("\t            Run Once: true\n")
+	}
+	if *configFile != "" {
+		fmt.Printf("\t         Config File: %s\n", *configFile)
+	}
+	fmt.Printf("\t      Check interval: %s\n", *interval)
+	fmt.Printf("\t    Publish interval: %s\n", *publishInterval)
+	if maintenance != nil {
+		fmt.Printf("\t    Maintenance File: %s (%d windows, reported %s)\n", *maintenanceFilePath, len(maintenance.windows), *maintenanceReport)
+	}
+	if *maxPause > 0 && !*once {
+		fmt.Printf("\t           Max Pause: %s\n", *maxPause)
+	}
+	if *startupGracePeriod > 0 && !*once {
+		fmt.Printf("\tStartup Grace Period: %s (%s)\n", *startupGracePeriod, *startupGraceMode)
+	}
+	if jitter > 0 {
+		fmt.Printf("\t              Jitter: up to %s\n", jitter)
+	}
+	fmt.Printf("\t     Request Timeout: %s\n", *checkTimeout)
+	if *checkRetries > 0 {
+		fmt.Printf("\t       Check Retries: %d (after %s)\n", *checkRetries, *checkRetryDelay)
+	}
+	if thresholdsEnabled() {
+		fmt.Printf("\t   Health Thresholds: unhealthy after %d failed, healthy after %d passed checks\n", *failureThreshold, *recoveryThreshold)
+	}
+	fmt.Printf("\t    Reporter Timeout: %s\n", *reporterTimeout)
+	fmt.Printf("\t        etcd Address: %s\n", strings.Join(endpoints, ", "))
+	if len(endpoints) > 1 || *discover || *discoverySRV != "" {
+		fmt.Printf("\t    Unhealthy Policy: %s\n", *unhealthyPolicy)
+	}
+	if *discoverySRV != "" {
+		fmt.Printf("\t       SRV Discovery: %s (every %s)\n", *discoverySRV, *discoverySRVInterval)
+	}
+	if *discover {
+		fmt.Printf("\t           Discovery: every %d intervals (timeout %s)\n", *discoverEvery, *discoverTimeout)
+	}
+	fmt.Printf("\t          Check Mode: %s\n", *checkMode)
+	if *checkMode == checkModeHTTP {
+		fmt.Printf("\t        Health Check: %s %s\n", *healthMethod, *healthPath)
+	}
+	switch {
+	case tlsConfig == nil:
+		fmt.Printf("\t                 TLS: disabled\n")
+	case clientTLS.clientCertificate() != nil:
+		fmt.Printf("\t                 TLS: client certificate %q\n", clientTLS.clientCertificate().Subject.CommonName)
+	default:
+		fmt.Printf("\t                 TLS: no client certificate\n")
+	}
+	if tlsConfig != nil {
+		fmt.Printf("\t          TLS Policy: %s\n", policy)
+	}
+	if tlsConfig != nil && tlsServerNames != nil {
+		fmt.Printf("\t     TLS Server Name: %s\n", tlsServerNames)
+	}
+	switch {
+	case clientTLS.remote() && *tlsRefreshInterval > 0:
+		fmt.Printf("\t         TLS Refresh: on SIGHUP and every %s\n", *tlsRefreshInterval)
+	case clientTLS.remote():
+		fmt.Printf("\t         TLS Refresh: on SIGHUP\n")
+	}
+	if tlsConfig != nil && *insecureSkipVerify {
+		fmt.Printf("\t             WARNING: THE CERTIFICATES OF ETCD ARE NOT VERIFIED (-insecure-skip-verify)\n")
+	}
+	if *noKeepAlive {
+		fmt.Printf("\t         Connections: fresh connection per request\n")
+	} else {
+		fmt.Printf("\t         Connections: %d idle, idle timeout %s\n", *maxIdleConns, *idleConnTimeout)
+	}
+	if etcdAuth != nil {
+		fmt.Printf("\t       etcd Username: %s (password from %s)\n", etcdAuth.username, etcdAuth.passwordFile)
+	}
+	fmt.Printf("\t           etcd Name: %s\n", *etcdName)
+	fmt.Printf("\tCloudWatch Namespace: %s\n", *namespace)
+	fmt.Printf("\t         Metric Name: %s\n", *metricName)
+	fmt.Printf("\t      Dimension Name: %s\n", *dimensionName)
+	fmt.Printf("\t      Healthy Metric: %t\n", *healthyMetric)
+	fmt.Printf("\t        Status Probe: %t\n", *enableStatusProbe)
+	if *enableStatusProbe && *backendQuotaBytes > 0 {
+		fmt.Printf("\t       Backend Quota: %d (bytes)\n", *backendQuotaBytes)
+	}
+	fmt.Printf("\t        Leader Probe: %t\n", *enableLeaderProbe)
+	fmt.Printf("\t        Member Probe: %t\n", *enableMemberProbe)
+	if *enableMemberProbe && *expectedMembers > 0 {
+		fmt.Printf("\t    Expected Members: %d\n", *expectedMembers)
+	}
+	fmt.Printf("\t    etcd Alarm Probe: %t\n", *enableEtcdAlarmProbe)
+	fmt.Printf("\t  Revision Lag Probe: %t\n", *enableRevisionLagProbe)
+	fmt.Printf("\t   Applied Lag Probe: %t\n", *enableAppliedLagProbe)
+	fmt.Printf("\t     Peer URLs Check: %t\n", *checkPeerURLs)
+	if *consistencyCheckInterval > 0 {
+		fmt.Printf("\t   Consistency Check: every %s\n", *consistencyCheckInterval)
+	}
+	fmt.Printf("\t       Version Probe: %t\n", *enableVersionProbe)
+	if *enableVersionInfo {
+		fmt.Printf("\t        Version Info: every %d intervals\n", *versionInfoEvery)
+	}
+	if *scrapeMetricsURL != "" {
+		fmt.Printf("\t      Metrics Scrape: %s\n", *scrapeMetricsURL)
+	}
+	if len(forwardMetrics.values) > 0 {
+		fmt.Printf("\t   Forwarded Metrics: %s\n", strings.Join(forwardMetrics.values, ", "))
+	}
+	if *enableReadProbe {
+		fmt.Printf("\t          Read Probe: %s (%s, %s)\n", *readProbeKey, *readProbeAPI, *readProbeConsistency)
+	}
+	if *enableWriteProbe {
+		fmt.Printf("\t         Write Probe: %s (%s, TTL %s)\n", *writeProbeKey, *writeProbeAPI, *writeProbeTTL)
+	}
+	if *publishMode == publishModeChanges {
+		fmt.Printf("\t        Publish Mode: changes, heartbeat every %s\n", *heartbeatInterval)
+	}
+	for i, dims := range metricDimensionSets() {
+		label := ""
+		if i == 0 {
+			label = "Dimensions"
+		}
+		fmt.Printf("\t%20s: %s\n", label, formatDimensions(dims))
+	}
+	fmt.Printf("\t          AWS Region: %s\n", *awsRegion)
+	if *cloudwatchEndpoint != "" {
+		fmt.Printf("\t CloudWatch Endpoint: %s\n", *cloudwatchEndpoint)
+	}
+	if *assumeRoleARN != "" {
+		fmt.Printf("\t         Assume Role: %s\n", *assumeRoleARN)
+	}
+	fmt.Printf("\t     High Resolution: %t\n", *highResolution)
+	fmt.Printf("\t  CloudWatch Retries: %d\n", *maxRetries)
+	fmt.Printf("\t  CloudWatch Timeout: %s\n", *cloudwatchTimeout)
+	fmt.Printf("\t         Buffer Size: %d (datapoints)\n", *bufferSize)
+	if *bufferFile != "" {
+		fmt.Printf("\t         Buffer File: %s\n", *bufferFile)
+	}
+	if *createAlarm {
+		fmt.Printf("\t               Alarm: %s\n", *alarmName)
+	}
+	if *dryRun {
+		fmt.Printf("\t             Dry Run: metrics are logged, not published\n")
+	}
+	if *emf {
+		fmt.Printf("\t       Output Format: Embedded Metric Format (stdout)\n")
+	}
+	if *noCloudWatch {
+		fmt.Printf("\t          CloudWatch: disabled\n")
+	}
+	if *prometheusListen != "" {
+		fmt.Printf("\t Prometheus Exporter: %s/metrics\n", *prometheusListen)
+	}
+	if *listen != "" && !*once {
+		fmt.Printf("\t            Liveness: %s/healthz\n", *listen)
+	}
+	if *statsdAddress != "" {
+		fmt.Printf("\t              StatsD: %s (prefix %s)\n", *statsdAddress, *statsdPrefix)
+	}
+	if *dogstatsd {
+		fmt.Printf("\t           DogStatsD: %s\n", *dogstatsdAddress)
+	}
+	if *influxURL != "" {
+		fmt.Printf("\t            InfluxDB: %s (org %s, bucket %s)\n", *influxURL, *influxOrg, *influxBucket)
+	}
+	if *graphiteAddress != "" {
+		fmt.Printf("\t            Graphite: %s://%s (prefix %s)\n", *graphiteProtocol, *graphiteAddress, *graphitePrefix)
+	}
+	if *pushgatewayURL != "" {
+		fmt.Printf("\t         Pushgateway: %s (delete on exit: %t)\n", *pushgatewayURL, *pushgatewayDeleteOnExit)
+	}
+	if *jsonlOutput != "" {
+		fmt.Printf("\t   JSON Lines Output: %s\n", *jsonlOutput)
+	}
+	if *snsTopicARN != "" {
+		fmt.Printf("\t   SNS Notifications: %s\n", *snsTopicARN)
+	}
+	if *eventBridgeBus != "" {
+		fmt.Printf("\t         EventBridge: %s (reminder every %s)\n", *eventBridgeBus, *eventBridgeReminder)
+	}
+	if *sqsQueueURL != "" {
+		fmt.Printf("\t                 SQS: %s\n", *sqsQueueURL)
+	}
+	if *sesFrom != "" {
+		fmt.Printf("\t          SES Emails: %s (after %s, reminder every %s)\n", strings.Join(sesTo.values, ", "), *sesAfter, *sesReminder)
+	}
+	if *slackWebhookURL != "" || *slackWebhookURLFile != "" {
+		fmt.Printf("\t Slack Notifications: enabled (cooldown %s)\n", *slackCooldown)
+	}
+	if *pagerDutyRoutingKey != "" || *pagerDutyRoutingKeyFile != "" {
+		fmt.Printf("\t           PagerDuty: enabled (after %d failed checks)\n", *pagerDutyFailureThreshold)
+	}
+	if *webhookURL != "" {
+		fmt.Printf("\t             Webhook: %s (every check: %t)\n", *webhookURL, *webhookEveryCheck)
+	}
+	if *syslogEnabled || *syslogAddress != "" {
+		destination := "local"
+		if *syslogAddress != "" {
+			destination = *syslogAddress
+		}
+		fmt.Printf("\t              Syslog: %s (tag %s)\n", destination, *syslogTag)
+	}
+	if *stateFile != "" {
+		fmt.Printf("\t          State File: %s (mode %s)\n", *stateFile, *stateFileMode)
+	}
+	if *logsGroup != "" {
+		fmt.Printf("\t     CloudWatch Logs: %s/%s (%s)\n", *logsGroup, *logsStream, *logsEvents)
+	}
+	if *controlSocket != "" && !*once {
+		fmt.Printf("\t      Control Socket: %s\n", *controlSocket)
+	}
+	fmt.Println("")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// maxBackfillAge is how far in the past CloudWatch accepts datapoints, less
+// a safety margin for datapoints that are about to be published.
+const maxBackfillAge = 14*24*time.Hour - time.Hour
+
+// MetricBuffer holds datapoints that could not be published so they can be
+// backfilled with their original timestamps once CloudWatch is reachable
+// again. When a path is set the buffer is persisted there so it survives
+// restarts.
+type MetricBuffer struct {
+	maxSize int
+	path    string
+
+	mu   sync.Mutex
+	data []types.MetricDatum
+}
+
+// NewMetricBuffer returns a buffer holding at most maxSize datapoints,
+// loading previously buffered datapoints from path if it exists.
+func NewMetricBuffer(maxSize int, path string) (*MetricBuffer, error) {
+	b := &MetricBuffer{
+		maxSize: maxSize,
+		path:    path,
+	}
+	if path == "" {
+		return b, nil
+	}
+
+	buff, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buff, &b.data); err != nil {
+		return nil, err
+	}
+	b.expire()
+	b.evict()
+
+	return b, nil
+}
+
+// Len returns the number of buffered datapoints.
+func (b *MetricBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.data)
+}
+
+// Push appends data to the buffer, evicting the oldest datapoints if it is
+// full. It returns the number of evicted datapoints.
+func (b *MetricBuffer) Push(data ...types.MetricDatum) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, data...)
+	evicted := b.evict()
+	b.save()
+
+	return evicted
+}
+
+// Requeue puts data that was taken but could not be published back at the
+// front of the buffer.
+func (b *MetricBuffer) Requeue(data ...types.MetricDatum) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(append([]types.MetricDatum{}, data...), b.data...)
+	evicted := b.evict()
+	b.save()
+
+	return evicted
+}
+
+// Take removes and returns up to n of the oldest datapoints, discarding any
+// that have become too old to backfill.
+func (b *MetricBuffer) Take(n int) []types.MetricDatum {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expire()
+	if n > len(b.data) {
+		n = len(b.data)
+	}
+	data := b.data[:n:n]
+	b.data = b.data[n:]
+	b.save()
+
+	return data
+}
+
+// evict drops the oldest datapoints exceeding maxSize.
+func (b *MetricBuffer) evict() int {
+	n := len(b.data) - b.maxSize
+	if n <= 0 {
+		return 0
+	}
+
+	log.Printf("[WARN] Metric buffer full, discarding the %d oldest datapoints", n)
+	b.data = b.data[n:]
+
+	return n
+}
+
+// expire drops datapoints CloudWatch would no longer accept.
+func (b *MetricBuffer) expire() {
+	cutoff := time.Now().Add(-maxBackfillAge)
+
+	data := b.data[:0]
+	for _, datum := range b.data {
+		if aws.ToTime(datum.Timestamp).After(cutoff) {
+			data = append(data, datum)
+		}
+	}
+	if n := len(b.data) - len(data); n > 0 {
+		log.Printf("[WARN] Discarding %d buffered datapoints older than the CloudWatch backfill limit", n)
+	}
+	b.data = data
+}
+
+// save writes the buffer to its file, if any. The file is replaced
+// atomically so a crash never leaves a truncated buffer behind.
+func (b *MetricBuffer) save() {
+	if b.path == "" {
+		return
+	}
+
+	buff, err := json.Marshal(b.data)
+	if err != nil {
+		log.Printf("[ERROR] Failed to encode metric buffer: %s", err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path)+".tmp")
+	if err != nil {
+		log.Printf("[ERROR] Failed to write metric buffer: %s", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(buff)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), b.path)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to write metric buffer: %s", err)
+	}
+}
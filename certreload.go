@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tlsRefreshTimeout is how long refreshing the remote TLS sources may take.
+const tlsRefreshTimeout = 10 * time.Second
+
+// pemSource is where a piece of the TLS material is read from: a file, or a
+// secret fetched from a remote store.
+type pemSource interface {
+	// read returns the PEM content.
+	read() ([]byte, error)
+	// stamp changes when the content changes.
+	stamp() string
+	String() string
+}
+
+// remotePEMSource is a pemSource fetched from a remote store, whose content is
+// only fetched again on refresh.
+type remotePEMSource interface {
+	pemSource
+	refresh(ctx context.Context) error
+}
+
+// fileSource is a PEM file.
+type fileSource string
+
+// newFileSource returns the source of the file at path, nil if path is empty.
+func newFileSource(path string) pemSource {
+	if path == "" {
+		return nil
+	}
+
+	return fileSource(path)
+}
+
+func (f fileSource) read() ([]byte, error) {
+	return ioutil.ReadFile(string(f))
+}
+
+// stamp returns the size and modification time of the file.
+func (f fileSource) stamp() string {
+	info, err := os.Stat(string(f))
+	if err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("%d %s", info.Size(), info.ModTime())
+}
+
+func (f fileSource) String() string {
+	return "file " + string(f)
+}
+
+// tlsClients are the clients the remote TLS sources are fetched with, nil
+// unless such a source is set.
+type tlsClients struct {
+	secrets SecretsManagerAPI
+	ssm     SSMAPI
+}
+
+// tlsSource returns the source of the piece of the TLS material, "ca", "cert"
+// or "key": the file, the Secrets Manager secret or the SSM parameter, nil if
+// none is set. Setting more than one is an error.
+func tlsSource(ctx context.Context, piece, file, secret, param string, clients tlsClients) (pemSource, error) {
+	var set []string
+	for _, f := range []struct{ flag, value string }{
+		{"-" + piece + "-file", file},
+		{"-" + piece + "-secret-arn", secret},
+		{"-" + piece + "-ssm-param", param},
+	} {
+		if f.value != "" {
+			set = append(set, f.flag)
+		}
+	}
+	if len(set) > 1 {
+		return nil, fmt.Errorf("%s cannot be combined, set only one of them", strings.Join(set, ", "))
+	}
+
+	switch {
+	case secret != "":
+		s, err := newSecretSource(ctx, clients.secrets, secret)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case param != "":
+		s, err := newSSMSource(ctx, clients.ssm, param)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	return newFileSource(file), nil
+}
+
+// tlsMaterial is what is read from the TLS sources: the client certificate
+// and the CA bundle, either of which may be missing.
+type tlsMaterial struct {
+	cert    *tls.Certificate
+	leaf    *x509.Certificate
+	caCerts []*x509.Certificate
+	pool    *x509.CertPool
+}
+
+// readTLSMaterial reads the client certificate and key, if set, and the CA,
+// if set. Failing to read a source is told apart from invalid PEM content.
+func readTLSMaterial(cert, key, ca pemSource) (tlsMaterial, error) {
+	var m tlsMaterial
+
+	if cert != nil {
+		certPEM, err := cert.read()
+		if err != nil {
+			return tlsMaterial{}, fmt.Errorf("failed to read the client certificate: %s", err)
+		}
+		keyPEM, err := key.read()
+		if err != nil {
+			return tlsMaterial{}, fmt.Errorf("failed to read the client key: %s", err)
+		}
+		pair, leaf, err := parseClientCertificate(certPEM, keyPEM)
+		if err != nil {
+			return tlsMaterial{}, fmt.Errorf("failed to load the client certificate from %s and %s: %s", cert, key, err)
+		}
+		m.cert, m.leaf = &pair, leaf
+	}
+
+	if ca != nil {
+		caCert, err := ca.read()
+		if err != nil {
+			return tlsMaterial{}, fmt.Errorf("failed to read the CA: %s", err)
+		}
+		m.caCerts, err = parseCertificates(caCert)
+		if err != nil {
+			return tlsMaterial{}, fmt.Errorf("failed to parse the CA from %s: %s", ca, err)
+		}
+		if len(m.caCerts) == 0 {
+			return tlsMaterial{}, fmt.Errorf("no certificates found in the CA from %s", ca)
+		}
+		m.pool = x509.NewCertPool()
+		for _, c := range m.caCerts {
+			m.pool.AddCert(c)
+		}
+	}
+
+	return m, nil
+}
+
+// tlsFiles is the TLS material read from the cert, key and CA sources. Files
+// are read again on the next handshake after they changed, so that
+// certificates rotated e.g. by vault-agent are used without a restart; remote
+// sources once refreshed.
+type tlsFiles struct {
+	certSource, keySource, caSource pemSource
+
+	mu sync.Mutex
+	tlsMaterial
+	// stamp is the stamp of the sources when they were last read, failed
+	// when reading them last failed.
+	stamp, failed string
+}
+
+// loadTLSFiles reads the TLS sources. Failing to is an error, unlike failing
+// to reload them.
+func loadTLSFiles(cert, key, ca pemSource) (*tlsFiles, error) {
+	f := &tlsFiles{certSource: cert, keySource: key, caSource: ca}
+	f.stamp = f.stampSources()
+
+	m, err := readTLSMaterial(cert, key, ca)
+	if err != nil {
+		return nil, err
+	}
+	f.tlsMaterial = m
+
+	return f, nil
+}
+
+// sources returns the sources that are set.
+func (f *tlsFiles) sources() []pemSource {
+	var sources []pemSource
+	for _, s := range []pemSource{f.certSource, f.keySource, f.caSource} {
+		if s != nil {
+			sources = append(sources, s)
+		}
+	}
+
+	return sources
+}
+
+// stampSources returns the stamps of the sources, to tell whether they
+// changed.
+func (f *tlsFiles) stampSources() string {
+	var b strings.Builder
+	for _, s := range f.sources() {
+		fmt.Fprintf(&b, "%s: %s\n", s, s.stamp())
+	}
+
+	return b.String()
+}
+
+// reload reads the sources again if they changed. If they cannot be read,
+// e.g. when files are half written during a rotation, the previous material is
+// kept and reading them is tried again once they change again.
+func (f *tlsFiles) reload() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stamp := f.stampSources()
+	if stamp == f.stamp || stamp == f.failed {
+		return
+	}
+
+	m, err := readTLSMaterial(f.certSource, f.keySource, f.caSource)
+	if err != nil {
+		f.failed = stamp
+		log.Printf("[WARN] Failed to reload the TLS material, keeping the previous certificates: %s", err)
+		return
+	}
+	f.tlsMaterial, f.stamp, f.failed = m, stamp, ""
+
+	if m.leaf != nil {
+		log.Printf("[INFO] Reloaded the TLS material, client certificate %q expires on %s",
+			m.leaf.Subject.CommonName, m.leaf.NotAfter.Format(time.RFC3339))
+	} else {
+		log.Printf("[INFO] Reloaded the TLS material")
+	}
+}
+
+// refresh fetches the remote sources again, on SIGHUP and periodically, and
+// reloads the material if they changed. A source that cannot be fetched keeps
+// its previous content. nil files have nothing to refresh.
+func (f *tlsFiles) refresh(ctx context.Context) {
+	if f == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, tlsRefreshTimeout)
+	defer cancel()
+
+	for _, s := range f.sources() {
+		r, ok := s.(remotePEMSource)
+		if !ok {
+			continue
+		}
+		if err := r.refresh(ctx); err != nil {
+			log.Printf("[WARN] Failed to refresh the TLS material, keeping the previous one: %s", err)
+		}
+	}
+	f.reload()
+}
+
+// remote reports whether any source is remote, to be refreshed.
+func (f *tlsFiles) remote() bool {
+	if f == nil {
+		return false
+	}
+	for _, s := range f.sources() {
+		if _, ok := s.(remotePEMSource); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getClientCertificate is the tls.Config.GetClientCertificate of the client
+// certificate, reloading it if it changed.
+func (f *tlsFiles) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	f.reload()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.cert, nil
+}
+
+// withRootCAs returns config with the CA bundle currently in use, reloading
+// it if it changed, for a new connection. RootCAs cannot be replaced in a
+// config in use, so every connection takes a copy. config is returned as is
+// without a CA file.
+func (f *tlsFiles) withRootCAs(config *tls.Config) *tls.Config {
+	if f == nil || config == nil || f.caSource == nil {
+		return config
+	}
+	f.reload()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := config.Clone()
+	c.RootCAs = f.pool
+
+	return c
+}
+
+// certificates returns the client certificate and the CA bundle currently in
+// use. Either may be empty, both are for nil files.
+func (f *tlsFiles) certificates() (*x509.Certificate, []*x509.Certificate) {
+	if f == nil {
+		return nil, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.leaf, f.caCerts
+}
+
+// clientCertificate returns the client certificate currently in use, nil if
+// there is none.
+func (f *tlsFiles) clientCertificate() *x509.Certificate {
+	leaf, _ := f.certificates()
+	return leaf
+}
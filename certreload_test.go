@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rotateTestFile replaces the file at path with data, as a certificate
+// rotation does, its modification time moved past the previous one.
+func rotateTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateRotation(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "etcd-ca-2023"), newTestCA(t, "etcd-ca-2024")
+	oldClient := oldCA.issue(t, "etcd-monitor-2023", nil, time.Now().Add(24*time.Hour))
+	newClient := newCA.issue(t, "etcd-monitor-2024", nil, time.Now().Add(24*time.Hour))
+
+	// etcd moves to the new CA with the monitor, trusting both meanwhile.
+	first := oldCA.issue(t, "etcd-0", []string{"127.0.0.1"}, time.Now().Add(24*time.Hour))
+	var serverCert atomic.Value
+	serverCert.Store(first.pair())
+	server := startTLSServer(t, first, oldCA, func(c *tls.Config) {
+		c.ClientCAs.AddCert(newCA.cert)
+		base := c.Clone()
+		c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			current := base.Clone()
+			current.Certificates = []tls.Certificate{serverCert.Load().(tls.Certificate)}
+			return current, nil
+		}
+	})
+
+	dir := t.TempDir()
+	caFile := writeTestFile(t, dir, "ca.pem", oldCA.certPEM)
+	certFile := writeTestFile(t, dir, "client.pem", oldClient.certPEM)
+	keyFile := writeTestFile(t, dir, "client-key.pem", oldClient.keyPEM)
+	tlsConfig, files, err := newTLSConfig(newFileSource(certFile), newFileSource(keyFile), newFileSource(caFile), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every request makes a new handshake.
+	tr := newTransport(tlsConfig, transportOptions{noKeepAlive: true, tlsFiles: files})
+	logged := captureLog(t)
+
+	if client, err := getHealth(tr, server.URL); err != nil || client != "etcd-monitor-2023" {
+		t.Fatalf("presented %q (%v), want the first certificate", client, err)
+	}
+
+	serverCert.Store(newCA.issue(t, "etcd-0", []string{"127.0.0.1"}, time.Now().Add(24*time.Hour)).pair())
+	rotateTestFile(t, caFile, newCA.certPEM)
+	rotateTestFile(t, certFile, newClient.certPEM)
+	rotateTestFile(t, keyFile, newClient.keyPEM)
+
+	if client, err := getHealth(tr, server.URL); err != nil || client != "etcd-monitor-2024" {
+		t.Fatalf("presented %q (%v) after the rotation, want the new certificate", client, err)
+	}
+	if leaf := files.clientCertificate(); leaf.Subject.CommonName != "etcd-monitor-2024" {
+		t.Errorf("the expiry probe sees %q, want the new certificate", leaf.Subject.CommonName)
+	}
+	if !strings.Contains(logged.String(), `[INFO] Reloaded the TLS material, client certificate "etcd-monitor-2024"`) {
+		t.Errorf("the reload was not logged:\n%s", logged)
+	}
+
+	// A half written certificate keeps the previous one.
+	rotateTestFile(t, certFile, newClient.certPEM[:len(newClient.certPEM)/2])
+	if client, err := getHealth(tr, server.URL); err != nil || client != "etcd-monitor-2024" {
+		t.Errorf("presented %q (%v) with a half written certificate, want the previous one", client, err)
+	}
+	if !strings.Contains(logged.String(), "[WARN] Failed to reload the TLS material, keeping the previous certificates") {
+		t.Errorf("the failed reload was not logged:\n%s", logged)
+	}
+}
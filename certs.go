@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// parseClientCertificate parses the client certificate and key like
+// tls.X509KeyPair, but also accepts chains where the leaf certificate is not
+// the first PEM block. It returns the parsed leaf along with the pair.
+func parseClientCertificate(certPEM, keyPEM []byte) (tls.Certificate, *x509.Certificate, error) {
+	var leaf []byte
+	var chain []byte
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err == nil && leaf == nil && !c.IsCA {
+			leaf = pem.EncodeToMemory(block)
+			continue
+		}
+		chain = append(chain, pem.EncodeToMemory(block)...)
+	}
+	if leaf == nil {
+		// No obvious leaf, keep the PEM order.
+		leaf, chain = chain, nil
+	}
+
+	cert, err := tls.X509KeyPair(append(leaf, chain...), keyPEM)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	return cert, parsed, nil
+}
+
+// newTLSConfig builds the TLS configuration for talking to etcd. It returns nil
+// if no endpoint uses https and no files are configured, for plain http dev
+// clusters. The client certificate is optional for clusters that do not enforce
+// client authentication; without a CA the system pool is used. The cert, key
+// and CA are read from their sources, nil if unset. They are returned for the
+// expiry probe, and are reloaded as they are rotated. insecure skips the
+// verification of the server certificates, which a CA would only appear to
+// secure, so the two are rejected together.
+func newTLSConfig(cert, key, ca pemSource, https, insecure bool) (*tls.Config, *tlsFiles, error) {
+	switch {
+	case insecure && ca != nil:
+		return nil, nil, fmt.Errorf("-insecure-skip-verify cannot be combined with a CA (%s), the CA would not be used", ca)
+	case cert != nil && key == nil:
+		return nil, nil, fmt.Errorf("the client certificate (%s) is set but not its key, both are needed", cert)
+	case cert == nil && key != nil:
+		return nil, nil, fmt.Errorf("the client key (%s) is set but not its certificate, both are needed", key)
+	case !https && cert == nil && ca == nil:
+		return nil, nil, nil
+	}
+
+	files, err := loadTLSFiles(cert, key, ca)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if cert != nil {
+		tlsConfig.GetClientCertificate = files.getClientCertificate
+	}
+
+	switch {
+	case insecure:
+	case ca != nil:
+		tlsConfig.RootCAs = files.pool
+	default:
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, nil, fmt.Errorf("https address with no trust anchors: "+
+				"set -ca-file, the system certificate pool is not available: %s", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, files, nil
+}
+
+// parseCertificates returns all certificates in a PEM bundle.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+
+	return certs, nil
+}
+
+// daysRemaining returns the number of days until c expires, negative once it
+// has expired.
+func daysRemaining(c *x509.Certificate) float64 {
+	return time.Until(c.NotAfter).Hours() / 24
+}
+
+// checkTLSExpiry returns an error if the client certificate, or every CA
+// certificate, has expired, so that the monitor does not start only to fail
+// every handshake.
+func checkTLSExpiry(files *tlsFiles) error {
+	leaf, ca := files.certificates()
+	now := time.Now()
+
+	if leaf != nil && now.After(leaf.NotAfter) {
+		return fmt.Errorf("the client certificate %q expired on %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	var last *x509.Certificate
+	for _, c := range ca {
+		if last == nil || c.NotAfter.After(last.NotAfter) {
+			last = c
+		}
+	}
+	if last != nil && now.After(last.NotAfter) {
+		return fmt.Errorf("every certificate in the CA file expired, the last one %q on %s", last.Subject.CommonName, last.NotAfter.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// CertExpiryProbe publishes how long the certificates the monitor uses to talk
+// to etcd remain valid, so that they can be renewed before the monitor starts
+// reporting a healthy cluster as unhealthy.
+type CertExpiryProbe struct {
+	files    *tlsFiles
+	warnDays int
+	// warned is the certificate last warned about, and when.
+	warned     *x509.Certificate
+	warnedTime time.Time
+}
+
+// NewCertExpiryProbe returns a probe for the client certificate and the CA
+// bundle read from files, the ones in use at every run, logging a warning
+// daily once the first of them expires within warnDays, 0 disables the
+// warning. files may be nil.
+func NewCertExpiryProbe(files *tlsFiles, warnDays int) *CertExpiryProbe {
+	leaf, ca := files.certificates()
+	for _, c := range append([]*x509.Certificate{leaf}, ca...) {
+		if c != nil && time.Now().After(c.NotAfter) {
+			log.Printf("[WARN] Certificate %q expired on %s", c.Subject.CommonName, c.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	return &CertExpiryProbe{files: files, warnDays: warnDays}
+}
+
+// Run publishes ClientCertDaysRemaining, CACertDaysRemaining for the CA
+// certificate that expires first, and TLSMaterialDaysRemaining for the first
+// of all of them.
+func (p *CertExpiryProbe) Run() {
+	leaf, ca := p.files.certificates()
+	if leaf != nil {
+		metrics.Add(newMetricData("ClientCertDaysRemaining", daysRemaining(leaf), types.StandardUnitNone)...)
+	}
+
+	var first *x509.Certificate
+	for _, c := range ca {
+		if first == nil || c.NotAfter.Before(first.NotAfter) {
+			first = c
+		}
+	}
+	if first != nil {
+		metrics.Add(newMetricData("CACertDaysRemaining", daysRemaining(first), types.StandardUnitNone)...)
+	}
+
+	kind := "CA"
+	if leaf != nil && (first == nil || leaf.NotAfter.Before(first.NotAfter)) {
+		first, kind = leaf, "client"
+	}
+	if first == nil {
+		return
+	}
+	days := daysRemaining(first)
+	metrics.Add(newMetricData("TLSMaterialDaysRemaining", days, types.StandardUnitNone)...)
+
+	if p.warnDays > 0 && days < float64(p.warnDays) && (p.warned == nil || !first.Equal(p.warned) || time.Since(p.warnedTime) >= 24*time.Hour) {
+		state := fmt.Sprintf("expires in %.1f days, on", days)
+		if days < 0 {
+			state = "EXPIRED on"
+		}
+		log.Printf("[WARN] The %s certificate %q %s %s, renew it",
+			kind, first.Subject.String(), state, first.NotAfter.Format(time.RFC3339))
+		p.warned, p.warnedTime = first, time.Now()
+	}
+}
+
+// describeTLSError returns a hint naming the client certificate expiry if err
+// is a TLS failure, so that certificate problems can be told apart from etcd
+// failures, or an empty string otherwise. Without a client certificate, it
+// names a handshake rejected for the lack of one.
+func describeTLSError(err error) string {
+	if !isTLSError(err) {
+		return ""
+	}
+	clientCert := clientTLS.clientCertificate()
+	if clientCert == nil {
+		switch remoteTLSAlert(err) {
+		case "certificate required", "bad certificate":
+			// TLS 1.3, and TLS 1.2 servers other than Go's.
+			return "TLS handshake failed, server requires client certificate: set -cert-file and -key-file"
+		case "handshake failure":
+			// What Go answers over TLS 1.2, also to a cipher suite mismatch.
+			return "TLS handshake failed, the server may require a client certificate: set -cert-file and -key-file if so"
+		}
+		return "TLS handshake failed"
+	}
+
+	state := "expires"
+	if time.Now().After(clientCert.NotAfter) {
+		state = "EXPIRED"
+	}
+
+	return fmt.Sprintf("TLS handshake failed, the client certificate %s on %s",
+		state, clientCert.NotAfter.Format(time.RFC3339))
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key, parsed and PEM encoded.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCA returns a self-signed CA named name.
+func newTestCA(t *testing.T, name string) *testCert {
+	t.Helper()
+
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+	}, nil)
+}
+
+// issue returns a certificate for name signed by ca, for both servers and
+// clients, valid for the DNS names and IP addresses in hosts until notAfter.
+func (ca *testCert) issue(t *testing.T, name string, hosts []string, notAfter time.Time) *testCert {
+	t.Helper()
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    notAfter,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	return newTestCert(t, template, ca)
+}
+
+// newTestCert creates the certificate of template, signed by parent or
+// self-signed if parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// pair returns c as the certificate of a TLS server or client.
+func (c *testCert) pair() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// writeTestFile writes data to the file name in dir and returns its path.
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// startTLSServer starts a fake etcd serving cert, requiring a client
+// certificate signed by clientCA unless it is nil. It answers the health
+// check with the common name of the client certificate in the X-Client
+// header. configure, if set, changes its TLS configuration before it starts.
+func startTLSServer(t *testing.T, cert *testCert, clientCA *testCert, configure func(*tls.Config)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+		w.Write([]byte(`{"health":"true"}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert.pair()}}
+	if clientCA != nil {
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLS.ClientCAs = x509.NewCertPool()
+		server.TLS.ClientCAs.AddCert(clientCA.cert)
+	}
+	if configure != nil {
+		configure(server.TLS)
+	}
+	// The handshake errors of the tests are expected.
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// getHealth makes the health request to the server at url through tr and
+// returns the client certificate the server saw.
+func getHealth(tr http.RoundTripper, url string) (string, error) {
+	resp, err := newHTTPClient(tr, 5*time.Second).Get(url + "/health")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header.Get("X-Client"), nil
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	ca := newTestCA(t, "etcd-ca")
+	otherCA := newTestCA(t, "other-ca")
+	serverCert := ca.issue(t, "etcd-0", []string{"127.0.0.1"}, time.Now().Add(24*time.Hour))
+	clientCert := ca.issue(t, "etcd-monitor", nil, time.Now().Add(24*time.Hour))
+
+	dir := t.TempDir()
+	caFile := writeTestFile(t, dir, "ca.pem", ca.certPEM)
+	otherCAFile := writeTestFile(t, dir, "other-ca.pem", otherCA.certPEM)
+	certFile := writeTestFile(t, dir, "client.pem", clientCert.certPEM)
+	keyFile := writeTestFile(t, dir, "client-key.pem", clientCert.keyPEM)
+
+	tests := []struct {
+		name              string
+		cert, key, ca     string
+		requireClientCert bool
+		client            string
+		err               string
+	}{
+		{name: "CA only", ca: caFile},
+		{name: "client certificate", cert: certFile, key: keyFile, ca: caFile, requireClientCert: true, client: "etcd-monitor"},
+		{name: "client certificate required", ca: caFile, requireClientCert: true, err: "certificate required"},
+		{name: "mismatched CA", ca: otherCAFile, err: "certificate signed by unknown authority"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var clientCA *testCert
+			if test.requireClientCert {
+				clientCA = ca
+			}
+			server := startTLSServer(t, serverCert, clientCA, nil)
+
+			tlsConfig, files, err := newTLSConfig(newFileSource(test.cert), newFileSource(test.key), newFileSource(test.ca), true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := getHealth(newTransport(tlsConfig, transportOptions{tlsFiles: files}), server.URL)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("the health request failed: %s", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("the health request returned %v, want an error with %q", err, test.err)
+			case client != test.client:
+				t.Errorf("the server saw the client certificate %q, want %q", client, test.client)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		cert, key, ca pemSource
+		insecure      bool
+		err           string
+	}{
+		{"certificate without key", fileSource("client.pem"), nil, nil, false, "is set but not its key"},
+		{"key without certificate", nil, fileSource("client-key.pem"), nil, false, "is set but not its certificate"},
+		{"CA and insecure", nil, nil, fileSource("ca.pem"), true, "cannot be combined with a CA"},
+		{"missing CA", nil, nil, fileSource(filepath.Join(t.TempDir(), "ca.pem")), false, "failed to read the CA"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := newTLSConfig(test.cert, test.key, test.ca, true, test.insecure)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("newTLSConfig() = %v, want an error with %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestCertExpiryWarning(t *testing.T) {
+	days := func(n float64) time.Time {
+		return time.Now().Add(time.Duration(n * 24 * float64(time.Hour)))
+	}
+	tests := []struct {
+		name               string
+		caDays, clientDays float64
+		warnDays           int
+		remaining          float64
+		warning            string
+	}{
+		{"far from expiry", 365, 90, 30, 90, ""},
+		{"client certificate", 365, 5, 30, 5, `[WARN] The client certificate "CN=etcd-monitor" expires in 5.0 days, on`},
+		{"CA first", 10, 20, 30, 10, `[WARN] The CA certificate "CN=etcd-ca" expires in 10.0 days, on`},
+		{"expired", 365, -1, 30, -1, `[WARN] The client certificate "CN=etcd-monitor" EXPIRED on`},
+		{"warning disabled", 365, 5, 0, 5, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t, "-name", "etcd-a")
+			logged := captureLog(t)
+			publisher := &fakePublisher{}
+			saved := metrics
+			metrics = NewMetricBatch(publisher, *namespace, 0)
+			t.Cleanup(func() { metrics = saved })
+
+			ca := newTestCert(t, &x509.Certificate{
+				Subject:               pkix.Name{CommonName: "etcd-ca"},
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+				KeyUsage:              x509.KeyUsageCertSign,
+				NotBefore:             time.Now().Add(-time.Hour),
+				NotAfter:              days(test.caDays),
+			}, nil)
+			client := ca.issue(t, "etcd-monitor", nil, days(test.clientDays))
+			dir := t.TempDir()
+			files, err := loadTLSFiles(
+				newFileSource(writeTestFile(t, dir, "client.pem", client.certPEM)),
+				newFileSource(writeTestFile(t, dir, "client-key.pem", client.keyPEM)),
+				newFileSource(writeTestFile(t, dir, "ca.pem", ca.certPEM)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The warning is repeated daily, not on every check.
+			probe := NewCertExpiryProbe(files, test.warnDays)
+			probe.Run()
+			probe.Run()
+			warnings := strings.Count(logged.String(), "[WARN] The ")
+			if test.warning == "" && warnings != 0 {
+				t.Errorf("warned about the expiry:\n%s", logged)
+			}
+			if test.warning != "" && (warnings != 1 || !strings.Contains(logged.String(), test.warning)) {
+				t.Errorf("logged\n%s\nwant the warning %q once", logged, test.warning)
+			}
+			if test.warning != "" {
+				probe.warnedTime = probe.warnedTime.Add(-25 * time.Hour)
+				probe.Run()
+				if n := strings.Count(logged.String(), test.warning); n != 2 {
+					t.Errorf("warned %d times after a day, want the warning repeated", n)
+				}
+			}
+
+			metrics.Flush(context.Background())
+			var remaining []float64
+			for _, call := range publisher.published() {
+				for _, datum := range call {
+					if *datum.MetricName == "TLSMaterialDaysRemaining" {
+						s := statisticSet(datum)
+						remaining = append(remaining, *s.Sum / *s.SampleCount)
+					}
+				}
+			}
+			if len(remaining) != 1 || math.Abs(remaining[0]-test.remaining) > 0.01 {
+				t.Errorf("TLSMaterialDaysRemaining = %v, want %g", remaining, test.remaining)
+			}
+		})
+	}
+}
+
+func TestCheckTLSExpiry(t *testing.T) {
+	ca := newTestCA(t, "etcd-ca")
+	dir := t.TempDir()
+	caFile := newFileSource(writeTestFile(t, dir, "ca.pem", ca.certPEM))
+
+	for _, test := range []struct {
+		notAfter time.Time
+		err      string
+	}{
+		{time.Now().Add(time.Hour), ""},
+		{time.Now().Add(-time.Hour), `the client certificate "etcd-monitor" expired on`},
+	} {
+		client := ca.issue(t, "etcd-monitor", nil, test.notAfter)
+		files, err := loadTLSFiles(
+			newFileSource(writeTestFile(t, dir, "client.pem", client.certPEM)),
+			newFileSource(writeTestFile(t, dir, "client-key.pem", client.keyPEM)),
+			caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkTLSExpiry(files)
+		if (test.err == "" && err != nil) || (test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err))) {
+			t.Errorf("checkTLSExpiry() with a certificate expiring on %s = %v, want %q", test.notAfter.Format(time.RFC3339), err, test.err)
+		}
+	}
+}
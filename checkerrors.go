@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+// Error types of failed health checks, published as the ErrorType dimension
+// of CheckErrors.
+const (
+	errorTypeDNS               = "DNS"
+	errorTypeConnectionRefused = "ConnectionRefused"
+	errorTypeTLS               = "TLS"
+	errorTypeTimeout           = "Timeout"
+	errorTypeParse             = "Parse"
+	errorTypePermissionDenied  = "PermissionDenied"
+	errorTypeAuth              = "AuthFailed"
+	errorTypeUnavailable       = "Unavailable"
+	errorTypeOther             = "Other"
+)
+
+// maxLoggedBody is how much of an unexpected response body is logged.
+const maxLoggedBody = 200
+
+// httpStatusErrorType returns the error type of a health check answered with
+// an unexpected status code: its class, e.g. "HTTP4xx", so that auth problems
+// stand apart from an overloaded etcd.
+func httpStatusErrorType(code int) string {
+	return fmt.Sprintf("HTTP%dxx", code/100)
+}
+
+// classifyError returns the error type of a failed health request.
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return errorTypeDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errorTypeConnectionRefused
+	case isTLSError(err):
+		return errorTypeTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errorTypeTimeout
+	}
+
+	return errorTypeOther
+}
+
+// isTLSError reports whether err is a failed TLS handshake or certificate
+// verification.
+func isTLSError(err error) bool {
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var invalidErr x509.CertificateInvalidError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+
+	return errors.As(err, &alertErr) || errors.As(err, &verifyErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) ||
+		remoteTLSAlert(err) != ""
+}
+
+// remoteTLSAlert returns the alert etcd rejected the handshake with, e.g.
+// "certificate required", or an empty string if err is not one. crypto/tls
+// does not export the type of the alerts received, only their message.
+func remoteTLSAlert(err error) string {
+	const prefix = "remote error: tls: "
+	message := err.Error()
+	i := strings.Index(message, prefix)
+	if i < 0 {
+		return ""
+	}
+
+	return message[i+len(prefix):]
+}
+
+// isAuthError reports whether err is a rejected username or password from the
+// gRPC API.
+func isAuthError(err error) bool {
+	return errors.Is(err, rpctypes.ErrAuthFailed) || errors.Is(err, rpctypes.ErrInvalidAuthToken) ||
+		errors.Is(err, rpctypes.ErrUserEmpty) || errors.Is(err, rpctypes.ErrAuthNotEnabled)
+}
+
+// checkFailed logs why the health check of endpoint failed, records it for the
+// status of the endpoint and adds a CheckErrors datapoint with the given error
+// type to the attempt. It is additive to the unhealthy count.
+func checkFailed(endpoint, errorType, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("[ERROR] %s", message)
+	daemonState.observeError(endpoint, errorType, message)
+	attemptMetrics.add(endpoint, buildMetricData(clusterDimensionSets("ErrorType", errorType), "CheckErrors", 1, types.StandardUnitCount, time.Now())...)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// attemptMetrics holds the datapoints of the running health check attempt of
+// every endpoint, so that only those of the final attempt are published.
+var attemptMetrics = &endpointAttempts{data: make(map[string][]types.MetricDatum)}
+
+// endpointAttempts are the datapoints of the running attempts by endpoint.
+type endpointAttempts struct {
+	mu   sync.Mutex
+	data map[string][]types.MetricDatum
+}
+
+// add records datapoints of the running attempt on endpoint.
+func (a *endpointAttempts) add(endpoint string, data ...types.MetricDatum) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.data[endpoint] = append(a.data[endpoint], data...)
+}
+
+// discard drops the datapoints of a failed attempt that is retried.
+func (a *endpointAttempts) discard(endpoint string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.data, endpoint)
+}
+
+// publish adds the datapoints of the final attempt on endpoint to the batch.
+func (a *endpointAttempts) publish(endpoint string) {
+	a.mu.Lock()
+	data := a.data[endpoint]
+	delete(a.data, endpoint)
+	a.mu.Unlock()
+
+	metrics.Add(data...)
+}
+
+// checkEndpoint checks the health of endpoint, retrying a failed check up to
+// -check-retries times after -check-retry-delay. A retry is only made if it
+// can finish, at the request timeout, before deadline. Only the final attempt
+// counts: its latency and error are published. A check cancelled through ctx
+// publishes nothing.
+func checkEndpoint(ctx context.Context, endpoint string, deadline time.Time) bool {
+	check := checkEndpointHTTP
+	if etcdClients != nil {
+		check = checkEndpointGRPC
+	}
+
+	healthy := check(ctx, endpoint)
+	attempts := 1
+	for !healthy && attempts <= *checkRetries && ctx.Err() == nil {
+		if time.Now().Add(*checkRetryDelay + *checkTimeout).After(deadline) {
+			log.Printf("[WARN] Not retrying the health check of %s, another attempt could not finish before the next check", endpoint)
+			break
+		}
+		attemptMetrics.discard(endpoint)
+		select {
+		case <-time.After(*checkRetryDelay):
+		case <-ctx.Done():
+		}
+		healthy = check(ctx, endpoint)
+		attempts++
+	}
+	if ctx.Err() != nil {
+		attemptMetrics.discard(endpoint)
+		return false
+	}
+	attemptMetrics.publish(endpoint)
+
+	if attempts > 1 {
+		if healthy {
+			log.Printf("[INFO] Health check of %s passed at attempt %d of %d", endpoint, attempts, *checkRetries+1)
+		} else {
+			log.Printf("[INFO] Health check of %s failed %d attempts", endpoint, attempts)
+		}
+	}
+
+	return healthy
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// CloudWatchAPI is the part of the CloudWatch API used by the monitor.
+type CloudWatchAPI interface {
+	MetricPublisher
+
+	PutMetricAlarm(ctx context.Context, params *cloudwatch.PutMetricAlarmInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricAlarmOutput, error)
+	DeleteAlarms(ctx context.Context, params *cloudwatch.DeleteAlarmsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.DeleteAlarmsOutput, error)
+	PutDashboard(ctx context.Context, params *cloudwatch.PutDashboardInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutDashboardOutput, error)
+}
+
+// newCloudWatchClient returns the client metrics are published with. If
+// roleARN is set the client assumes that role; a non-empty endpoint replaces
+// the regional CloudWatch endpoint.
+func newCloudWatchClient(ctx context.Context, cfg aws.Config, roleARN, externalID, endpoint string) *cloudwatch.Client {
+	if roleARN != "" {
+		creds, err := assumeRoleCredentials(ctx, sts.NewFromConfig(cfg), roleARN, externalID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Credentials = creds
+	}
+
+	if endpoint != "" {
+		log.Printf("[WARN] Publishing metrics to custom CloudWatch endpoint %s", endpoint)
+
+		// Local emulators accept any credentials, so don't require real ones.
+		if roleARN == "" {
+			if cfg.Credentials == nil {
+				cfg.Credentials = credentials.NewStaticCredentialsProvider("test", "test", "")
+			} else if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+				cfg.Credentials = credentials.NewStaticCredentialsProvider("test", "test", "")
+			}
+		}
+	}
+
+	return cloudwatch.NewFromConfig(cfg, func(o *cloudwatch.Options) {
+		// Retries are handled by MetricBatch so they can be bounded by the interval.
+		o.Retryer = aws.NopRetryer{}
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+}
+
+// assumeRoleCredentials returns the credentials of roleARN assumed with
+// client, passing externalID if set. The role is assumed right away so that
+// failing to is a startup error; the cache assumes it again shortly before
+// the credentials expire.
+func assumeRoleCredentials(ctx context.Context, client stscreds.AssumeRoleAPIClient, roleARN, externalID string) (aws.CredentialsProvider, error) {
+	creds := aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(client, roleARN,
+		func(o *stscreds.AssumeRoleOptions) {
+			if externalID != "" {
+				o.ExternalID = aws.String(externalID)
+			}
+		}))
+	if _, err := creds.Retrieve(ctx); err != nil {
+		return nil, fmt.Errorf("failed to assume role %s: %s", roleARN, err)
+	}
+
+	return creds, nil
+}
+
+// CloudWatchReporter publishes the health metrics of every check through the
+// metric batch, which also carries the metrics of the probes.
+type CloudWatchReporter struct {
+	batch *MetricBatch
+
+	mu sync.Mutex
+	// lastCount is the previously reported unhealthy count, used to publish
+	// state transitions with priority. It is negative until the first report.
+	lastCount float64
+	// lastPublish is when the health metrics were last published in the
+	// "changes" publish mode.
+	lastPublish time.Time
+	// publishNow requests publishing at the end of the current check
+	// regardless of the publish window.
+	publishNow bool
+}
+
+// NewCloudWatchReporter returns a reporter adding to batch.
+func NewCloudWatchReporter(batch *MetricBatch) *CloudWatchReporter {
+	return &CloudWatchReporter{batch: batch, lastCount: -1}
+}
+
+// Report adds the unhealthy count of the cluster and of every endpoint, the
+// Healthy metric if enabled, and the raw unhealthy count if the thresholds
+// are, all stamped with the start of the check. A changed count is added with
+// priority; in the "changes" publish mode an unchanged one only as a
+// heartbeat.
+func (r *CloudWatchReporter) Report(ctx context.Context, result CheckResult) error {
+	sets := metricDimensionSets()
+	data := buildMetricData(sets, *metricName, result.UnhealthyCount, types.StandardUnitCount, result.Time)
+	if *healthyMetric {
+		data = append(data, buildMetricData(sets, "Healthy", 1-result.UnhealthyCount, types.StandardUnitNone, result.Time)...)
+	}
+	if thresholdsEnabled() {
+		data = append(data, buildMetricData(sets, "RawUnhealthyCount", result.RawUnhealthyCount, types.StandardUnitCount, result.Time)...)
+	}
+	for _, e := range result.Endpoints {
+		endpointCount := 1.0
+		if e.Healthy {
+			endpointCount = 0.0
+		}
+		endpointSets := endpointOnlyDimensionSets(e.Endpoint)
+		data = append(data, buildMetricData(endpointSets, *metricName, endpointCount, types.StandardUnitCount, result.Time)...)
+		if *healthyMetric {
+			data = append(data, buildMetricData(endpointSets, "Healthy", 1-endpointCount, types.StandardUnitNone, result.Time)...)
+		}
+	}
+	if result.State == healthStateStarting {
+		// Neither healthy nor unhealthy yet, alarms see missing data.
+		r.batch.Add(buildMetricData(sets, "Starting", 1, types.StandardUnitCount, result.Time)...)
+		return nil
+	}
+	if result.State == healthStateMaintenance && *maintenanceReport == maintenanceReportMetric {
+		r.batch.Add(buildMetricData(sets, "InMaintenance", 1, types.StandardUnitCount, result.Time)...)
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case result.UnhealthyCount != r.lastCount:
+		r.batch.AddPriority(data...)
+		r.lastPublish = result.Time
+		if *publishMode == publishModeChanges {
+			r.publishNow = true
+		}
+	case shouldPublishHeartbeat(r.lastPublish, result.Time):
+		r.batch.Add(data...)
+		r.lastPublish = result.Time
+	}
+	r.lastCount = result.UnhealthyCount
+
+	return nil
+}
+
+// Flush publishes the batch, adding the publish failures since the last one.
+func (r *CloudWatchReporter) Flush(ctx context.Context) {
+	reportPublishFailures()
+	r.batch.Flush(ctx)
+}
+
+// takePublishNow reports whether the last reports requested publishing right
+// away, and resets the request.
+func (r *CloudWatchReporter) takePublishNow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	publishNow := r.publishNow
+	r.publishNow = false
+
+	return publishNow
+}
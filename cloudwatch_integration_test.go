@@ -0,0 +1,109 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/encoding/cbor"
+)
+
+// cloudWatchEmulator answers PutMetricData like a local CloudWatch emulator
+// does, keeping the decoded requests.
+type cloudWatchEmulator struct {
+	mu       sync.Mutex
+	requests []cbor.Map
+}
+
+func (e *cloudWatchEmulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/operation/PutMetricData") || r.Header.Get("Smithy-Protocol") != "rpc-v2-cbor" {
+		http.Error(w, "unexpected request "+r.URL.Path, http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil && r.Header.Get("Content-Encoding") == "gzip" {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(body)); err == nil {
+			body, err = ioutil.ReadAll(gz)
+		}
+	}
+	var request cbor.Value
+	if err == nil {
+		request, err = cbor.Decode(body)
+	}
+	m, ok := request.(cbor.Map)
+	if err != nil || !ok {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	e.mu.Lock()
+	e.requests = append(e.requests, m)
+	e.mu.Unlock()
+
+	w.Header().Set("Smithy-Protocol", "rpc-v2-cbor")
+	w.Header().Set("Content-Type", "application/cbor")
+	w.Write(cbor.Encode(cbor.Map{}))
+}
+
+func TestPublishToEmulator(t *testing.T) {
+	setFlags(t, "-namespace", "etcd", "-endpoint-dimension")
+	emulator := &cloudWatchEmulator{}
+	server := httptest.NewServer(emulator)
+	defer server.Close()
+
+	ctx := context.Background()
+	client := newCloudWatchClient(ctx, aws.Config{Region: "us-east-1"}, "", "", server.URL)
+	batch := NewMetricBatch(client, *namespace, 0)
+	result := testResult()
+	if err := NewCloudWatchReporter(batch).Report(ctx, result); err != nil {
+		t.Fatal(err)
+	}
+	batch.Flush(ctx)
+
+	if len(emulator.requests) != 1 {
+		t.Fatalf("the emulator received %d PutMetricData requests, want 1", len(emulator.requests))
+	}
+	request := emulator.requests[0]
+	if request["Namespace"] != cbor.String("etcd") {
+		t.Errorf("Namespace = %v, want etcd", request["Namespace"])
+	}
+	data, ok := request["MetricData"].(cbor.List)
+	if !ok || len(data) == 0 {
+		t.Fatalf("MetricData = %v, want a list of datums", request["MetricData"])
+	}
+
+	names := make(map[string]bool)
+	for _, v := range data {
+		datum, ok := v.(cbor.Map)
+		if !ok {
+			t.Fatalf("datum = %v, want a map", v)
+		}
+		for _, field := range []string{"MetricName", "Dimensions", "StatisticValues", "StorageResolution", "Timestamp", "Unit"} {
+			if _, ok := datum[field]; !ok {
+				t.Errorf("datum %v has no %s", datum["MetricName"], field)
+			}
+		}
+		stamp, err := cbor.AsTime(datum["Timestamp"])
+		if err != nil || !stamp.Equal(result.Time.Truncate(time.Millisecond)) {
+			t.Errorf("datum %v has Timestamp %v, want %s", datum["MetricName"], datum["Timestamp"], result.Time)
+		}
+		if name, ok := datum["MetricName"].(cbor.String); ok {
+			names[string(name)] = true
+		}
+	}
+	if !names["UnhealthyCount"] {
+		t.Errorf("published metrics %v, want UnhealthyCount", names)
+	}
+}
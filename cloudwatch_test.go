@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// publishResult reports result through a CloudWatchReporter and returns the
+// data published on the next flush.
+func publishResult(t *testing.T, result CheckResult) []types.MetricDatum {
+	t.Helper()
+
+	client := &fakePublisher{}
+	batch := NewMetricBatch(client, "etcd", 0)
+	if err := NewCloudWatchReporter(batch).Report(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+	batch.Flush(context.Background())
+
+	var data []types.MetricDatum
+	for _, call := range client.published() {
+		data = append(data, call...)
+	}
+	if len(data) == 0 {
+		t.Fatal("nothing was published")
+	}
+
+	return data
+}
+
+// testResult returns the result of a check of a healthy endpoint.
+func testResult() CheckResult {
+	return CheckResult{
+		Time:      time.Now(),
+		State:     healthStateHealthy,
+		Endpoints: []EndpointStatus{{Endpoint: "http://etcd-0:2379", Healthy: true, State: healthStateHealthy}},
+	}
+}
+
+func TestStorageResolution(t *testing.T) {
+	tests := []struct {
+		args       []string
+		resolution int32
+	}{
+		{nil, 60},
+		{[]string{"-high-resolution"}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.args), func(t *testing.T) {
+			setFlags(t, append(test.args, "-endpoint-dimension")...)
+			for _, datum := range publishResult(t, testResult()) {
+				if got := aws.ToInt32(datum.StorageResolution); got != test.resolution {
+					t.Errorf("%s has StorageResolution %d, want %d", aws.ToString(datum.MetricName), got, test.resolution)
+				}
+			}
+		})
+	}
+}
+
+func TestTimestampIsCheckStart(t *testing.T) {
+	_, publisher := useCheckLoop(t, http.DefaultTransport)
+	captureLog(t)
+	gated := newGatedReporter()
+	reporters.Add("gated", gated)
+	reporters.Add("CloudWatch", cloudWatchReporter)
+
+	// An older result holds up the reporters, the check waits in the queue.
+	// It is still starting, so that its health metrics are not coalesced
+	// with those of the check.
+	older := testResult()
+	older.Time = time.Now().Add(-time.Hour)
+	older.State = healthStateStarting
+	checkResults.push(queuedResult{result: older})
+	<-gated.reported
+	result, ok := runCheckRecovered(context.Background(), nil, nil)
+	if !ok {
+		t.Fatal("the check failed")
+	}
+	time.Sleep(200 * time.Millisecond)
+	released := time.Now()
+	close(gated.release)
+	drainCheckResults(t)
+
+	metrics.Flush(context.Background())
+	var stamped int
+	for _, call := range publisher.published() {
+		for _, datum := range call {
+			if *datum.MetricName != *metricName && *datum.MetricName != "Healthy" {
+				continue
+			}
+			stamped++
+			if ts := aws.ToTime(datum.Timestamp); !ts.Equal(result.Time) {
+				t.Errorf("%s is stamped %s, want the check start %s, not the report at %s",
+					*datum.MetricName, ts.Format(time.RFC3339Nano), result.Time.Format(time.RFC3339Nano), released.Format(time.RFC3339Nano))
+			}
+		}
+	}
+	if stamped == 0 {
+		t.Errorf("published %v, want the health metrics", publisher.published())
+	}
+}
+
+// fakeSTS answers AssumeRole with credentials valid for expiry, or with err.
+type fakeSTS struct {
+	expiry time.Duration
+	err    error
+	calls  []*sts.AssumeRoleInput
+}
+
+func (s *fakeSTS) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	s.calls = append(s.calls, params)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String(fmt.Sprintf("ASIA%d", len(s.calls))),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(s.expiry)),
+	}}, nil
+}
+
+func TestAssumeRoleCredentials(t *testing.T) {
+	const role = "arn:aws:iam::123456789012:role/etcd-monitor"
+
+	tests := []struct {
+		name       string
+		externalID string
+	}{
+		{"without external ID", ""},
+		{"with external ID", "observability"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakeSTS{expiry: time.Hour}
+			creds, err := assumeRoleCredentials(context.Background(), client, role, test.externalID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := creds.Retrieve(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(client.calls) != 1 {
+				t.Fatalf("the role was assumed %d times, want once", len(client.calls))
+			}
+			call := client.calls[0]
+			if aws.ToString(call.RoleArn) != role {
+				t.Errorf("assumed role %s, want %s", aws.ToString(call.RoleArn), role)
+			}
+			if aws.ToString(call.ExternalId) != test.externalID {
+				t.Errorf("ExternalId = %q, want %q", aws.ToString(call.ExternalId), test.externalID)
+			}
+			if got.AccessKeyID != "ASIA1" {
+				t.Errorf("credentials have access key %s, want the assumed ASIA1", got.AccessKeyID)
+			}
+		})
+	}
+}
+
+func TestAssumeRoleCredentialsRefresh(t *testing.T) {
+	// The credentials expire as soon as they are issued.
+	client := &fakeSTS{}
+	creds, err := assumeRoleCredentials(context.Background(), client, "arn:aws:iam::123456789012:role/etcd-monitor", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := creds.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.calls) != 2 || got.AccessKeyID != "ASIA2" {
+		t.Errorf("expired credentials gave access key %s after %d AssumeRole calls, want ASIA2 after 2",
+			got.AccessKeyID, len(client.calls))
+	}
+}
+
+func TestAssumeRoleCredentialsFailure(t *testing.T) {
+	client := &fakeSTS{err: responseError(http.StatusForbidden, "AccessDenied")}
+	_, err := assumeRoleCredentials(context.Background(), client, "arn:aws:iam::123456789012:role/etcd-monitor", "")
+	if err == nil || !strings.Contains(err.Error(), "failed to assume role arn:aws:iam::123456789012:role/etcd-monitor") ||
+		!strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("assumeRoleCredentials() = %v, want the role and the STS error", err)
+	}
+}
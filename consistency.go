@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// maxConsistencyBackoff is the longest the consistency check waits between
+// attempts while a member does not support HashKV.
+const maxConsistencyBackoff = 24 * time.Hour
+
+// errHashKVUnsupported is returned when a member does not implement HashKV.
+var errHashKVUnsupported = errors.New("HashKV is not supported")
+
+// HashKVResponse is the subset of the HashKV response the consistency check
+// uses.
+type HashKVResponse struct {
+	Hash            uint32 `json:"hash"`
+	CompactRevision int64  `json:"compact_revision,string"`
+}
+
+// ConsistencyCheck verifies that all members hold the same data by comparing
+// their HashKV at a common revision. It is expensive, so it runs on its own
+// schedule rather than every check.
+type ConsistencyCheck struct {
+	seed     string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// NewConsistencyCheck returns a check listing the members through seed every
+// interval, giving up on a round after timeout.
+func NewConsistencyCheck(seed string, interval, timeout time.Duration) *ConsistencyCheck {
+	return &ConsistencyCheck{
+		seed:     seed,
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+// Start runs the check in the background, first right away and then every
+// interval. While a member does not support HashKV, the wait doubles up to a
+// day.
+func (c *ConsistencyCheck) Start() {
+	go func() {
+		wait := c.interval
+		for {
+			err := c.run()
+			switch {
+			case errors.Is(err, errHashKVUnsupported):
+				if wait *= 2; wait > maxConsistencyBackoff {
+					wait = maxConsistencyBackoff
+				}
+				log.Printf("[WARN] Skipping the consistency check, retrying in %s: %s", wait, err)
+			case err != nil:
+				wait = c.interval
+				log.Printf("[WARN] Skipping the consistency check: %s", err)
+			default:
+				wait = c.interval
+			}
+
+			time.Sleep(wait)
+		}
+	}()
+}
+
+// run compares the HashKV of all members and publishes ConsistencyCheckFailed.
+// Nothing is published if the hashes cannot be compared.
+func (c *ConsistencyCheck) run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	members, err := getMembersContext(ctx, c.seed)
+	if err != nil {
+		return fmt.Errorf("failed to list etcd members: %s", err)
+	}
+
+	// The lowest current revision has been applied by every member.
+	revisions := make([]int64, len(members))
+	errs := make([]error, len(members))
+	queryMembers(members, func(i int, endpoint string) {
+		status, err := getStatusContext(ctx, endpoint)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		revisions[i] = status.Header.Revision
+	})
+	var revision int64
+	for i, member := range members {
+		if len(member.ClientURLs) == 0 {
+			continue
+		}
+		if errs[i] != nil {
+			return fmt.Errorf("failed to get the status of %s: %s", member.Name, errs[i])
+		}
+		if revision == 0 || revisions[i] < revision {
+			revision = revisions[i]
+		}
+	}
+
+	hashes := make([]*HashKVResponse, len(members))
+	queryMembers(members, func(i int, endpoint string) {
+		hashes[i], errs[i] = getHashKV(ctx, endpoint, revision)
+	})
+
+	var first *HashKVResponse
+	var pairs []string
+	mismatch := false
+	for i, member := range members {
+		if len(member.ClientURLs) == 0 {
+			continue
+		}
+		if errs[i] != nil {
+			return fmt.Errorf("failed to get the hash of %s at revision %d: %w", member.Name, revision, errs[i])
+		}
+		if first == nil {
+			first = hashes[i]
+		}
+		if hashes[i].CompactRevision != first.CompactRevision {
+			// Hashes only cover the revisions since the last compaction.
+			return fmt.Errorf("members are compacted at different revisions, retrying later")
+		}
+		if hashes[i].Hash != first.Hash {
+			mismatch = true
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%d", member.Name, hashes[i].Hash))
+	}
+	if first == nil {
+		return fmt.Errorf("no members with client URLs")
+	}
+
+	failed := 0.0
+	if mismatch {
+		log.Printf("[ERROR] etcd members disagree on the data at revision %d: %s", revision, strings.Join(pairs, ", "))
+		failed = 1.0
+	} else {
+		log.Printf("[INFO] etcd members agree on the data at revision %d", revision)
+	}
+	metrics.Add(newMetricData("ConsistencyCheckFailed", failed, types.StandardUnitNone)...)
+
+	return nil
+}
+
+// getHashKV gets the hash of the key-value store of the etcd member at
+// endpoint up to revision through the v3 gRPC gateway.
+func getHashKV(ctx context.Context, endpoint string, revision int64) (*HashKVResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{"revision": revision})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v3/maintenance/hashkv", endpoint), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return nil, errHashKVUnsupported
+	default:
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(buff)))
+	}
+
+	var hash HashKVResponse
+	if err := json.Unmarshal(buff, &hash); err != nil {
+		return nil, fmt.Errorf("invalid hashkv response payload: %s", err)
+	}
+
+	return &hash, nil
+}
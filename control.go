@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultControlSocket is where the daemon serves its status by default.
+const defaultControlSocket = "/var/run/etcd-monitor.sock"
+
+// EndpointStatus is the last check result of a single endpoint.
+type EndpointStatus struct {
+	Endpoint string `json:"endpoint"`
+	// Healthy is false once the endpoint failed -failure-threshold checks in
+	// a row, State tells whether it is degraded before that.
+	// ConsecutiveFailures counts the raw failed checks.
+	Healthy             bool    `json:"healthy"`
+	State               string  `json:"state"`
+	LatencyMs           float64 `json:"latencyMs"`
+	ConsecutiveFailures int     `json:"consecutiveFailures"`
+	// Error is why the check failed, and ErrorType its CheckErrors error
+	// type. ErrorType is empty if etcd itself reported being unhealthy.
+	Error     string `json:"error,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+}
+
+// DaemonStatus is the document served on the control socket: what the running
+// monitor currently believes about the cluster and CloudWatch.
+type DaemonStatus struct {
+	PID                 int              `json:"pid"`
+	StartedAt           time.Time        `json:"startedAt"`
+	LastCheck           *time.Time       `json:"lastCheck,omitempty"`
+	Healthy             bool             `json:"healthy"`
+	State               string           `json:"state"`
+	UnhealthyCount      float64          `json:"unhealthyCount"`
+	RawUnhealthyCount   float64          `json:"rawUnhealthyCount"`
+	ConsecutiveFailures int              `json:"consecutiveFailures"`
+	MaintenanceWindow   string           `json:"maintenanceWindow,omitempty"`
+	Paused              bool             `json:"paused"`
+	PausedUntil         *time.Time       `json:"pausedUntil,omitempty"`
+	Endpoints           []EndpointStatus `json:"endpoints"`
+	LastPublish         *time.Time       `json:"lastPublish,omitempty"`
+	LastPublishError    string           `json:"lastPublishError,omitempty"`
+}
+
+// daemonState records the check results for the control socket. The checks
+// update it while the socket serves it.
+var daemonState = newMonitorState()
+
+// monitorState is the state behind DaemonStatus.
+type monitorState struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	lastCheck time.Time
+	count     float64
+	rawCount  float64
+	state     string
+	failures  int
+	// failingSince is the time of the first check of the running failure
+	// streak.
+	failingSince time.Time
+	endpoints    []EndpointStatus
+	// threshold debounces the cluster result, endpointThresholds the result
+	// of every endpoint. They are created at the first check, once the flags
+	// are parsed.
+	threshold          *healthThreshold
+	endpointThresholds map[string]*healthThreshold
+	// latency is the latest health check latency by endpoint, and
+	// endpointFailures the consecutive failed checks by endpoint.
+	latency          map[string]time.Duration
+	endpointFailures map[string]int
+	// errors are the failures of the running check by endpoint, taken by
+	// observeCheck.
+	errors map[string]checkError
+	// startupGrace is the startup grace period, nil without one.
+	startupGrace *startupGrace
+	// maintenance holds the maintenance windows, nil without any, and window
+	// is the name of the one in effect at the last check.
+	maintenance *maintenanceFile
+	window      string
+	// paused is set while paused with SIGUSR1, until pausedUntil if set.
+	paused      bool
+	pausedUntil time.Time
+}
+
+// checkError is why the health check of an endpoint failed.
+type checkError struct {
+	errorType string
+	message   string
+}
+
+func newMonitorState() *monitorState {
+	return &monitorState{
+		startedAt:          time.Now(),
+		state:              healthStateHealthy,
+		latency:            make(map[string]time.Duration),
+		endpointFailures:   make(map[string]int),
+		endpointThresholds: make(map[string]*healthThreshold),
+		errors:             make(map[string]checkError),
+	}
+}
+
+// observeLatency records how long the health check of endpoint took.
+func (s *monitorState) observeLatency(endpoint string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency[endpoint] = latency
+}
+
+// observeError records why the running health check of endpoint failed.
+func (s *monitorState) observeError(endpoint, errorType, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[endpoint] = checkError{errorType: errorType, message: message}
+}
+
+// observeCheck records the results of a check of the current endpoints started
+// at start and the unhealthy count derived from them, and returns them for the
+// reporters with the failure and recovery thresholds applied.
+func (s *monitorState) observeCheck(start time.Time, results []bool, count float64) CheckResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.threshold == nil {
+		s.threshold = newHealthThreshold(*failureThreshold, *recoveryThreshold)
+	}
+
+	s.lastCheck = start
+	s.rawCount = count
+	if count > 0 {
+		if s.failures == 0 {
+			s.failingSince = s.lastCheck
+		}
+		s.failures++
+	} else {
+		s.failures = 0
+	}
+	s.state = s.threshold.observe(count == 0)
+	s.count = 0
+	if s.state == healthStateUnhealthy {
+		s.count = 1
+	}
+	// The thresholds keep counting within the startup grace period, so that a
+	// cluster still failing when it is over is reported unhealthy right away.
+	starting := s.startupGrace.observe(start, count == 0)
+	if starting {
+		s.state, s.count = healthStateStarting, 0
+	}
+	// Passed checks are in maintenance too, so that the cluster does not
+	// flap between the two states.
+	s.window = s.maintenance.active(start)
+	if s.window != "" {
+		s.state, s.count = healthStateMaintenance, 0
+	}
+
+	// Endpoints removed by discovery are forgotten.
+	failures := make(map[string]int)
+	thresholds := make(map[string]*healthThreshold)
+	s.endpoints = nil
+	for i, endpoint := range endpoints {
+		if !results[i] {
+			failures[endpoint] = s.endpointFailures[endpoint] + 1
+		}
+		threshold := s.endpointThresholds[endpoint]
+		if threshold == nil {
+			threshold = newHealthThreshold(*failureThreshold, *recoveryThreshold)
+		}
+		thresholds[endpoint] = threshold
+		endpointState := threshold.observe(results[i])
+
+		status := EndpointStatus{
+			Endpoint:            endpoint,
+			Healthy:             endpointState != healthStateUnhealthy,
+			State:               endpointState,
+			LatencyMs:           float64(s.latency[endpoint]) / float64(time.Millisecond),
+			ConsecutiveFailures: failures[endpoint],
+		}
+		if e, ok := s.errors[endpoint]; ok && !results[i] {
+			status.Error, status.ErrorType = e.message, e.errorType
+		}
+		switch {
+		case results[i]:
+		case s.window != "":
+			status.Healthy, status.State = true, healthStateMaintenance
+		case starting:
+			status.Healthy, status.State = true, healthStateStarting
+		}
+		s.endpoints = append(s.endpoints, status)
+	}
+	s.endpointFailures = failures
+	s.endpointThresholds = thresholds
+	s.errors = make(map[string]checkError)
+
+	result := CheckResult{
+		Time:                s.lastCheck,
+		UnhealthyCount:      s.count,
+		RawUnhealthyCount:   count,
+		State:               s.state,
+		ConsecutiveFailures: s.failures,
+		Endpoints:           append([]EndpointStatus(nil), s.endpoints...),
+	}
+	if s.failures > 0 {
+		result.FailingSince = s.failingSince
+	}
+
+	return result
+}
+
+// setStartupGrace starts a startup grace period lasting until until.
+func (s *monitorState) setStartupGrace(until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.startupGrace = &startupGrace{until: until}
+}
+
+// setMaintenance applies the maintenance windows of m to the checks.
+func (s *monitorState) setMaintenance(m *maintenanceFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maintenance = m
+}
+
+// setThresholds changes the failure and recovery thresholds, keeping the
+// running streaks of failed and passed checks.
+func (s *monitorState) setThresholds(failureThreshold, recoveryThreshold int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	thresholds := []*healthThreshold{s.threshold}
+	for _, t := range s.endpointThresholds {
+		thresholds = append(thresholds, t)
+	}
+	for _, t := range thresholds {
+		if t != nil {
+			t.failureThreshold, t.recoveryThreshold = failureThreshold, recoveryThreshold
+		}
+	}
+}
+
+// snapshot returns the current status document.
+func (s *monitorState) snapshot() DaemonStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := DaemonStatus{
+		PID:                 os.Getpid(),
+		StartedAt:           s.startedAt,
+		Healthy:             s.count == 0,
+		State:               s.state,
+		UnhealthyCount:      s.count,
+		RawUnhealthyCount:   s.rawCount,
+		ConsecutiveFailures: s.failures,
+		MaintenanceWindow:   s.window,
+		Paused:              s.paused,
+		Endpoints:           append([]EndpointStatus(nil), s.endpoints...),
+	}
+	if !s.pausedUntil.IsZero() {
+		pausedUntil := s.pausedUntil
+		status.PausedUntil = &pausedUntil
+	}
+	if !s.lastCheck.IsZero() {
+		lastCheck := s.lastCheck
+		status.LastCheck = &lastCheck
+	}
+	if lastPublish, err := metrics.LastFlush(); !lastPublish.IsZero() {
+		status.LastPublish = &lastPublish
+		if err != nil {
+			status.LastPublishError = err.Error()
+		}
+	}
+
+	return status
+}
+
+// listenControlSocket serves the status document on the unix socket at path,
+// readable by the owner only. A socket left behind by a daemon that did not
+// shut down cleanly is replaced; closing the returned listener removes the
+// socket.
+func listenControlSocket(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another etcd-monitor is already listening on %s", path)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	mask := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(daemonState.snapshot())
+	})
+	go http.Serve(l, mux)
+
+	return l, nil
+}
+
+// fetchDaemonStatus queries the daemon listening on the control socket at
+// path.
+func fetchDaemonStatus(path string) (*DaemonStatus, error) {
+	c := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := c.Get("http://etcd-monitor/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var status DaemonStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid status payload: %s", err)
+	}
+
+	return &status, nil
+}
+
+// printDaemonStatus prints status in the layout of the startup banner.
+func printDaemonStatus(status *DaemonStatus) {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(*t).Round(time.Second))
+	}
+
+	result := "healthy"
+	switch status.State {
+	case healthStateDegraded:
+		result = "degraded (failing, below the failure threshold)"
+	case healthStateStarting:
+		result = "starting (failing, within the startup grace period)"
+	case healthStateMaintenance:
+		result = fmt.Sprintf("in maintenance window %q", status.MaintenanceWindow)
+	}
+	if !status.Healthy {
+		result = fmt.Sprintf("NOT healthy (unhealthy count %g)", status.UnhealthyCount)
+	}
+	if status.LastCheck == nil {
+		result = "no check yet"
+	}
+
+	fmt.Println("==> etcd Monitor Status:")
+	fmt.Println("")
+	fmt.Printf("\t                 PID: %d\n", status.PID)
+	fmt.Printf("\t             Started: %s\n", formatTime(&status.StartedAt))
+	fmt.Printf("\t          Last Check: %s\n", formatTime(status.LastCheck))
+	fmt.Printf("\t              Result: %s\n", result)
+	if status.Paused {
+		until := "SIGUSR2"
+		if status.PausedUntil != nil {
+			until = fmt.Sprintf("SIGUSR2 or %s", status.PausedUntil.Format(time.RFC3339))
+		}
+		fmt.Printf("\t              Paused: until %s, checks are not reported\n", until)
+	}
+	fmt.Printf("\tConsecutive Failures: %d\n", status.ConsecutiveFailures)
+	for i, e := range status.Endpoints {
+		label := ""
+		if i == 0 {
+			label = "Endpoints"
+		}
+		state := "healthy"
+		if e.State == healthStateDegraded || e.State == healthStateStarting || e.State == healthStateMaintenance {
+			state = fmt.Sprintf("%s, %d consecutive failures", e.State, e.ConsecutiveFailures)
+			if e.Error != "" {
+				state += ": " + e.Error
+			}
+		}
+		if !e.Healthy {
+			state = fmt.Sprintf("NOT healthy, %d consecutive failures", e.ConsecutiveFailures)
+			if e.Error != "" {
+				state += ": " + e.Error
+			}
+		}
+		fmt.Printf("\t%20s: %s %s (%.1f ms)\n", label, e.Endpoint, state, e.LatencyMs)
+	}
+	fmt.Printf("\t        Last Publish: %s\n", formatTime(status.LastPublish))
+	if status.LastPublishError != "" {
+		fmt.Printf("\t       Publish Error: %s\n", status.LastPublishError)
+	}
+}
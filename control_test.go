@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMain runs the monitor itself instead of the tests when the test binary
+// is started by startMonitor.
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("ETCD_MONITOR_TEST_ARGS"); ok {
+		os.Args = append([]string{"etcd-monitor"}, strings.Split(args, "\n")...)
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		main()
+		os.Exit(exitShutdownClean)
+	}
+	os.Exit(m.Run())
+}
+
+// monitorCommand returns the command running the monitor with args, through
+// the test binary.
+func monitorCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "ETCD_MONITOR_TEST_ARGS="+strings.Join(args, "\n"))
+
+	return cmd
+}
+
+// syncBuffer is a bytes.Buffer written by a process and read by the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// monitorProcess is a monitor running in its own process, checking a fake
+// etcd every second.
+type monitorProcess struct {
+	cmd    *exec.Cmd
+	socket string
+	logged *syncBuffer
+	exited chan struct{}
+}
+
+// startMonitor starts the monitor with args, the fake etcd, the control
+// socket and a one second interval added. It is killed when the test ends if
+// it is still running.
+func startMonitor(t *testing.T, args ...string) *monitorProcess {
+	t.Helper()
+
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"health":"true"}`))
+	}))
+	t.Cleanup(etcd.Close)
+
+	// Unix socket paths are short, too short for most test directories.
+	dir, err := ioutil.TempDir("", "etcd-monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	p := &monitorProcess{
+		socket: filepath.Join(dir, "control.sock"),
+		logged: &syncBuffer{},
+		exited: make(chan struct{}),
+	}
+	args = append([]string{"-address", etcd.URL, "-interval", "1s", "-no-cloudwatch",
+		"-control-socket", p.socket}, args...)
+	p.cmd = monitorCommand(args...)
+	p.cmd.Stdout = p.logged
+	p.cmd.Stderr = p.logged
+	if err := p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		p.cmd.Wait()
+		close(p.exited)
+	}()
+	t.Cleanup(func() {
+		select {
+		case <-p.exited:
+		default:
+			p.cmd.Process.Kill()
+			<-p.exited
+		}
+	})
+
+	p.waitStatus(t, "a first check", func(s *DaemonStatus) bool { return s.LastCheck != nil })
+
+	return p
+}
+
+// signal sends sig to the monitor.
+func (p *monitorProcess) signal(t *testing.T, sig syscall.Signal) {
+	t.Helper()
+
+	if err := p.cmd.Process.Signal(sig); err != nil {
+		t.Fatalf("failed to send %s: %s", sig, err)
+	}
+}
+
+// waitStatus waits for the status on the control socket to satisfy cond,
+// failing the test after 10 seconds.
+func (p *monitorProcess) waitStatus(t *testing.T, what string, cond func(*DaemonStatus) bool) *DaemonStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-p.exited:
+			t.Fatalf("the monitor exited waiting for %s:\n%s", what, p.logged)
+		default:
+		}
+		if status, err := fetchDaemonStatus(p.socket); err == nil && cond(status) {
+			return status
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no %s within 10s:\n%s", what, p.logged)
+	return nil
+}
+
+// waitLog waits for the monitor to log line, failing the test after 10
+// seconds.
+func (p *monitorProcess) waitLog(t *testing.T, line string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(p.logged.String(), line) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the monitor did not log %q within 10s:\n%s", line, p.logged)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// stop shuts the monitor down with SIGTERM and returns its exit code.
+func (p *monitorProcess) stop(t *testing.T) int {
+	t.Helper()
+
+	p.signal(t, syscall.SIGTERM)
+	select {
+	case <-p.exited:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the monitor did not exit on SIGTERM:\n%s", p.logged)
+	}
+
+	return p.cmd.ProcessState.ExitCode()
+}
+
+func TestPauseResumeSignals(t *testing.T) {
+	p := startMonitor(t)
+
+	// The signals without a meaning keep their default behaviour: ignored.
+	p.signal(t, syscall.SIGWINCH)
+
+	p.signal(t, syscall.SIGUSR1)
+	paused := p.waitStatus(t, "pause", func(s *DaemonStatus) bool { return s.Paused })
+	if paused.PausedUntil != nil {
+		t.Errorf("paused until %s, want until SIGUSR2 without -max-pause", paused.PausedUntil)
+	}
+	// The checks keep running while paused.
+	p.waitStatus(t, "check while paused", func(s *DaemonStatus) bool {
+		return s.Paused && s.LastCheck.After(*paused.LastCheck)
+	})
+
+	p.signal(t, syscall.SIGUSR2)
+	p.waitStatus(t, "resume", func(s *DaemonStatus) bool { return !s.Paused })
+
+	if code := p.stop(t); code != exitShutdownClean {
+		t.Errorf("exited with %d, want %d", code, exitShutdownClean)
+	}
+	logged := p.logged.String()
+	for _, line := range []string{
+		"[INFO] Paused until SIGUSR2",
+		"[INFO] Resumed, checks are reported again",
+		"[INFO] Shut down",
+	} {
+		if !strings.Contains(logged, line) {
+			t.Errorf("the monitor did not log %q:\n%s", line, logged)
+		}
+	}
+}
+
+func TestMaxPauseSignal(t *testing.T) {
+	p := startMonitor(t, "-max-pause", "2s")
+
+	p.signal(t, syscall.SIGUSR1)
+	paused := p.waitStatus(t, "pause", func(s *DaemonStatus) bool { return s.Paused })
+	if paused.PausedUntil == nil {
+		t.Fatal("paused until SIGUSR2 only, want at most the -max-pause")
+	}
+	p.waitStatus(t, "resume after -max-pause", func(s *DaemonStatus) bool { return !s.Paused })
+
+	// A resume while not paused changes nothing.
+	p.signal(t, syscall.SIGUSR2)
+	p.waitLog(t, "[INFO] Not paused, nothing to resume")
+	if code := p.stop(t); code != exitShutdownClean {
+		t.Errorf("exited with %d, want %d", code, exitShutdownClean)
+	}
+}
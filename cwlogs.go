@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+const (
+	// maxLogEventsPerRequest and maxLogRequestBytes are the PutLogEvents
+	// limits. Every event counts logEventOverhead bytes on top of its
+	// message.
+	maxLogEventsPerRequest = 10000
+	maxLogRequestBytes     = 1024 * 1024
+	logEventOverhead       = 26
+
+	// maxLogEventBytes is the limit of a single event.
+	maxLogEventBytes = 256 * 1024
+
+	// maxLogEventSpan is how far apart the events of a single PutLogEvents
+	// request may be at most.
+	maxLogEventSpan = 24 * time.Hour
+
+	// maxPendingLogEvents bounds the events kept while CloudWatch Logs is
+	// unreachable.
+	maxPendingLogEvents = 10000
+
+	// logsFlushInterval is how often the pending events are sent.
+	logsFlushInterval = 5 * time.Second
+
+	// logsTimeout bounds sending the pending events.
+	logsTimeout = 30 * time.Second
+)
+
+// CloudWatchLogsAPI is the part of the CloudWatch Logs API used by the
+// monitor.
+type CloudWatchLogsAPI interface {
+	PutLogEvents(ctx context.Context, params *cloudwatchlogs.PutLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error)
+	CreateLogGroup(ctx context.Context, params *cloudwatchlogs.CreateLogGroupInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogGroupOutput, error)
+	CreateLogStream(ctx context.Context, params *cloudwatchlogs.CreateLogStreamInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error)
+}
+
+// healthLogEvent is the message of a log event. Logs Insights queries use the
+// fields, so they are only ever added.
+type healthLogEvent struct {
+	// Event is "check", or "unhealthy" and "recovered" for the checks that
+	// change the cluster state.
+	Event               string    `json:"event"`
+	Cluster             string    `json:"cluster"`
+	Healthy             bool      `json:"healthy"`
+	Timestamp           time.Time `json:"timestamp"`
+	UnhealthyCount      float64   `json:"unhealthyCount"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	// Since is when the previous state began, on transitions.
+	Since     *time.Time       `json:"since,omitempty"`
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+// CloudWatchLogsSink writes the check results, or only the checks changing
+// the cluster state, as JSON events to a CloudWatch Logs stream. The events
+// are sent in the background every few seconds and kept while CloudWatch
+// Logs is unreachable, so that they never delay the metrics.
+type CloudWatchLogsSink struct {
+	client          CloudWatchLogsAPI
+	group           string
+	stream          string
+	create          bool
+	transitionsOnly bool
+
+	// detector and failures are only used by Report.
+	detector transitionDetector
+	failures int
+
+	mu      sync.Mutex
+	pending []types.InputLogEvent
+
+	// Only used by the sending goroutine. sequenceToken is the token
+	// returned by the last PutLogEvents call. No attempt is made before
+	// retryAt after a failure.
+	sequenceToken *string
+	attempt       int
+	retryAt       time.Time
+	errors        sinkErrorLog
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCloudWatchLogsSink returns a sink writing to stream in group. With create
+// the group and stream are created if missing. With transitionsOnly only the
+// checks changing the cluster state are written.
+func NewCloudWatchLogsSink(client CloudWatchLogsAPI, group, stream string, create, transitionsOnly bool) *CloudWatchLogsSink {
+	s := &CloudWatchLogsSink{
+		client:          client,
+		group:           group,
+		stream:          stream,
+		create:          create,
+		transitionsOnly: transitionsOnly,
+		errors:          sinkErrorLog{name: "CloudWatch Logs"},
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	go s.run()
+
+	return s
+}
+
+// newCloudWatchLogsReporter returns the sink of -cloudwatch-logs-group, which
+// only logs the events with -dry-run.
+func newCloudWatchLogsReporter(cfg aws.Config) (Reporter, error) {
+	var client CloudWatchLogsAPI = &dryRunClient{}
+	if !*dryRun {
+		client = newCloudWatchLogsClient(cfg)
+	}
+
+	return NewCloudWatchLogsSink(client, *logsGroup, *logsStream, *logsCreate, *logsEvents == "transitions"), nil
+}
+
+// newCloudWatchLogsClient returns the client the events are written with.
+// Retries are handled by the sink.
+func newCloudWatchLogsClient(cfg aws.Config) *cloudwatchlogs.Client {
+	return cloudwatchlogs.NewFromConfig(cfg, func(o *cloudwatchlogs.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+}
+
+// Report queues the event for a check.
+func (s *CloudWatchLogsSink) Report(ctx context.Context, result CheckResult) error {
+	if result.UnhealthyCount > 0 {
+		s.failures++
+	} else {
+		s.failures = 0
+	}
+	event := healthLogEvent{
+		Event:               "check",
+		Cluster:             *etcdName,
+		Healthy:             result.UnhealthyCount == 0,
+		Timestamp:           result.Time,
+		UnhealthyCount:      result.UnhealthyCount,
+		ConsecutiveFailures: s.failures,
+		Endpoints:           result.Endpoints,
+	}
+	if t, ok := s.detector.observe(result); ok {
+		event.Event = transitionState(t)
+		event.Since = &t.Since
+		event.ConsecutiveFailures = t.ConsecutiveFailures
+	} else if s.transitionsOnly {
+		return nil
+	}
+	if event.Endpoints == nil {
+		event.Endpoints = []EndpointStatus{}
+	}
+
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(message)+logEventOverhead > maxLogEventBytes {
+		return fmt.Errorf("the %s event is too large for CloudWatch Logs (%d bytes)", event.Event, len(message))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, types.InputLogEvent{
+		Message:   aws.String(string(message)),
+		Timestamp: aws.Int64(result.Time.UnixNano() / int64(time.Millisecond)),
+	})
+	if n := len(s.pending) - maxPendingLogEvents; n > 0 {
+		log.Printf("[WARN] Too many pending CloudWatch Logs events, dropping the %d oldest", n)
+		s.pending = s.pending[n:]
+	}
+
+	return nil
+}
+
+// Shutdown sends the pending events until ctx is done.
+func (s *CloudWatchLogsSink) Shutdown(ctx context.Context) error {
+	close(s.stop)
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.retryAt = time.Time{}
+	return s.send(ctx)
+}
+
+func (s *CloudWatchLogsSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(logsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), logsTimeout)
+			if err := s.send(ctx); err != nil {
+				s.errors.log(err)
+			}
+			cancel()
+		}
+	}
+}
+
+// send writes the pending events, in as few requests as the limits allow.
+// Events are only removed once written, so that failed ones are retried by
+// the next send, after a backoff.
+func (s *CloudWatchLogsSink) send(ctx context.Context) error {
+	if time.Now().Before(s.retryAt) {
+		return nil
+	}
+
+	s.mu.Lock()
+	events := append([]types.InputLogEvent(nil), s.pending...)
+	s.mu.Unlock()
+
+	for _, batch := range splitLogEvents(events) {
+		if err := s.putLogEvents(ctx, batch); err != nil {
+			s.retryAt = time.Now().Add(backoffDelay(s.attempt))
+			s.attempt++
+			return err
+		}
+		s.attempt = 0
+
+		// Report only ever appends, and drops the oldest events when
+		// too many are pending, which may include some of the batch.
+		s.mu.Lock()
+		for _, e := range batch {
+			if len(s.pending) > 0 && s.pending[0].Message == e.Message {
+				s.pending = s.pending[1:]
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// putLogEvents writes a single batch. A stale sequence token is replaced by
+// the expected one and the call repeated; a batch CloudWatch Logs already
+// has counts as written. Missing groups and streams are created if enabled.
+func (s *CloudWatchLogsSink) putLogEvents(ctx context.Context, batch []types.InputLogEvent) error {
+	for attempt := 0; ; attempt++ {
+		out, err := s.client.PutLogEvents(ctx, &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(s.group),
+			LogStreamName: aws.String(s.stream),
+			LogEvents:     batch,
+			SequenceToken: s.sequenceToken,
+		})
+
+		var invalidToken *types.InvalidSequenceTokenException
+		var alreadyAccepted *types.DataAlreadyAcceptedException
+		var notFound *types.ResourceNotFoundException
+		switch {
+		case err == nil:
+			s.sequenceToken = out.NextSequenceToken
+			if info := out.RejectedLogEventsInfo; info != nil {
+				log.Printf("[WARN] CloudWatch Logs rejected some events as too old, too new or expired: %s", logEventsRejection(info))
+			}
+			return nil
+		case errors.As(err, &alreadyAccepted):
+			s.sequenceToken = alreadyAccepted.ExpectedSequenceToken
+			return nil
+		case errors.As(err, &invalidToken) && attempt == 0:
+			s.sequenceToken = invalidToken.ExpectedSequenceToken
+		case errors.As(err, &notFound) && s.create && attempt == 0:
+			if err := s.createLogStream(ctx); err != nil {
+				return err
+			}
+			s.sequenceToken = nil
+		default:
+			return err
+		}
+	}
+}
+
+// createLogStream creates the group and the stream, either of which may
+// exist already.
+func (s *CloudWatchLogsSink) createLogStream(ctx context.Context) error {
+	var exists *types.ResourceAlreadyExistsException
+	_, err := s.client.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(s.group),
+	})
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("failed to create log group %s: %s", s.group, err)
+	}
+	_, err = s.client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(s.group),
+		LogStreamName: aws.String(s.stream),
+	})
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("failed to create log stream %s: %s", s.stream, err)
+	}
+	log.Printf("[INFO] Created the CloudWatch Logs stream %s in %s", s.stream, s.group)
+
+	return nil
+}
+
+// splitLogEvents groups events, which are in chronological order, into
+// batches that each fit into a single PutLogEvents request.
+func splitLogEvents(events []types.InputLogEvent) [][]types.InputLogEvent {
+	var batches [][]types.InputLogEvent
+	var batch []types.InputLogEvent
+	size := 0
+	for _, e := range events {
+		eventSize := len(*e.Message) + logEventOverhead
+		if len(batch) > 0 && (len(batch) == maxLogEventsPerRequest ||
+			size+eventSize > maxLogRequestBytes ||
+			time.Duration(*e.Timestamp-*batch[0].Timestamp)*time.Millisecond > maxLogEventSpan) {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, e)
+		size += eventSize
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+func logEventsRejection(info *types.RejectedLogEventsInfo) string {
+	payload, _ := json.Marshal(info)
+
+	return string(payload)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+const (
+	// dashboardColumns is the width of the CloudWatch dashboard grid.
+	dashboardColumns = 24
+
+	widgetHeight = 6
+)
+
+type dashboard struct {
+	Widgets []dashboardWidget `json:"widgets"`
+}
+
+type dashboardWidget struct {
+	Type       string           `json:"type"`
+	X          int              `json:"x"`
+	Y          int              `json:"y"`
+	Width      int              `json:"width"`
+	Height     int              `json:"height"`
+	Properties widgetProperties `json:"properties"`
+}
+
+type widgetProperties struct {
+	Title   string          `json:"title"`
+	Region  string          `json:"region"`
+	View    string          `json:"view"`
+	Stat    string          `json:"stat"`
+	Period  int32           `json:"period"`
+	Metrics [][]interface{} `json:"metrics"`
+}
+
+// dashboardBody builds the dashboard JSON for the cluster: an overview row
+// with the cluster-level metrics, followed by one widget per endpoint when
+// per-endpoint dimensions are enabled.
+func dashboardBody(endpoints []string) ([]byte, error) {
+	var widgets []dashboardWidget
+
+	health := [][]interface{}{
+		dashboardMetric(*metricName, metricDimensions(), nil),
+	}
+	if *healthyMetric {
+		health = append(health, dashboardMetric("Healthy", metricDimensions(), map[string]string{"stat": "Minimum"}))
+	}
+	widgets = append(widgets,
+		newWidget("etcd health: "+*etcdName, string(types.StatisticMaximum), health),
+		newWidget("Health check latency (ms): "+*etcdName, string(types.StatisticAverage), [][]interface{}{
+			dashboardMetric("HealthCheckLatency", metricDimensions(), nil),
+			dashboardMetric("HealthCheckLatency", metricDimensions(), map[string]string{"stat": "Maximum"}),
+		}),
+	)
+
+	if *endpointDimension {
+		for _, endpoint := range endpoints {
+			dims := append(metricDimensions(), types.Dimension{
+				Name:  aws.String("Endpoint"),
+				Value: aws.String(normalizeEndpoint(endpoint)),
+			})
+			widgets = append(widgets, newWidget(normalizeEndpoint(endpoint), string(types.StatisticMaximum), [][]interface{}{
+				dashboardMetric(*metricName, dims, nil),
+				dashboardMetric("HealthCheckLatency", dims, map[string]string{"stat": "Average", "yAxis": "right"}),
+			}))
+		}
+	}
+
+	layoutWidgets(widgets)
+
+	return json.Marshal(dashboard{Widgets: widgets})
+}
+
+func newWidget(title, stat string, metrics [][]interface{}) dashboardWidget {
+	return dashboardWidget{
+		Type:   "metric",
+		Height: widgetHeight,
+		Properties: widgetProperties{
+			Title:   title,
+			Region:  *awsRegion,
+			View:    "timeSeries",
+			Stat:    stat,
+			Period:  metricPeriod(),
+			Metrics: metrics,
+		},
+	}
+}
+
+// dashboardMetric returns a metric in the array notation used by dashboard
+// widgets: namespace, metric name, dimension name/value pairs and options.
+func dashboardMetric(name string, dims []types.Dimension, options map[string]string) []interface{} {
+	metric := []interface{}{*namespace, name}
+	for _, dim := range dims {
+		metric = append(metric, aws.ToString(dim.Name), aws.ToString(dim.Value))
+	}
+	if len(options) > 0 {
+		metric = append(metric, options)
+	}
+
+	return metric
+}
+
+// layoutWidgets places the overview widgets side by side in the first row and
+// the per-endpoint widgets three to a row below them.
+func layoutWidgets(widgets []dashboardWidget) {
+	const overview = 2
+
+	for i := range widgets {
+		if i < overview {
+			widgets[i].Width = dashboardColumns / overview
+			widgets[i].X = i * widgets[i].Width
+			continue
+		}
+
+		n := i - overview
+		widgets[i].Width = dashboardColumns / 3
+		widgets[i].X = (n % 3) * widgets[i].Width
+		widgets[i].Y = widgetHeight * (1 + n/3)
+	}
+}
+
+// putDashboard creates or replaces the dashboard.
+func putDashboard(ctx context.Context, client CloudWatchAPI, name string, body []byte) error {
+	_, err := client.PutDashboard(ctx, &cloudwatch.PutDashboardInput{
+		DashboardName: aws.String(name),
+		DashboardBody: aws.String(string(body)),
+	})
+
+	return err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// maxDimensions is the number of dimensions CloudWatch allows per metric.
+const maxDimensions = 30
+
+// maxDimensionNameLength is the longest dimension name CloudWatch accepts.
+const maxDimensionNameLength = 255
+
+// Dimension is an additional name/value pair attached to every metric.
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// DimensionList implements flag.Value for the repeatable -dimension flag.
+// Values taken from the environment are replaced as soon as the flag is used.
+type DimensionList struct {
+	dimensions []Dimension
+	fromFlag   bool
+}
+
+// ParseDimensionList parses a comma-separated list of key=value pairs.
+func ParseDimensionList(s string) (*DimensionList, error) {
+	l := &DimensionList{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		if err := l.add(pair); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+func (l *DimensionList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(l.dimensions))
+	for _, d := range l.dimensions {
+		pairs = append(pairs, d.Name+"="+d.Value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Set adds a single key=value pair.
+func (l *DimensionList) Set(s string) error {
+	if !l.fromFlag {
+		l.dimensions = nil
+		l.fromFlag = true
+	}
+
+	return l.add(s)
+}
+
+func (l *DimensionList) add(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid dimension %q, expected key=value", s)
+	}
+
+	name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if name == "" {
+		return fmt.Errorf("invalid dimension %q, name must not be empty", s)
+	}
+	if value == "" {
+		return fmt.Errorf("invalid dimension %q, value must not be empty", s)
+	}
+	for _, d := range l.dimensions {
+		if d.Name == name {
+			return fmt.Errorf("duplicate dimension %q", name)
+		}
+	}
+
+	l.dimensions = append(l.dimensions, Dimension{Name: name, Value: value})
+
+	return nil
+}
+
+// Validate checks that the extra dimensions can be combined with the reserved
+// dimensions the monitor sets itself without exceeding the CloudWatch limits.
+func (l *DimensionList) Validate(reserved ...string) error {
+	for _, d := range l.dimensions {
+		for _, name := range reserved {
+			if d.Name == name {
+				return fmt.Errorf("dimension %q conflicts with a dimension set by the monitor", d.Name)
+			}
+		}
+	}
+	if n := len(l.dimensions) + len(reserved); n > maxDimensions {
+		return fmt.Errorf("too many dimensions: %d configured, CloudWatch allows at most %d", n, maxDimensions)
+	}
+
+	return nil
+}
+
+// validateDimensionName checks that name can be used as the cluster dimension
+// name.
+func validateDimensionName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("the dimension name must not be empty")
+	}
+	if len(name) > maxDimensionNameLength {
+		return fmt.Errorf("dimension name %q is %d characters long, CloudWatch allows at most %d",
+			name, len(name), maxDimensionNameLength)
+	}
+
+	return nil
+}
+
+// metricDimensions returns the dimensions attached to every published metric:
+// the cluster dimension followed by any extra dimensions.
+func metricDimensions() []types.Dimension {
+	dims := []types.Dimension{
+		{
+			Name:  aws.String(*dimensionName),
+			Value: aws.String(*etcdName),
+		},
+	}
+	for _, d := range extraDimensions.dimensions {
+		dims = append(dims, types.Dimension{
+			Name:  aws.String(d.Name),
+			Value: aws.String(d.Value),
+		})
+	}
+
+	return dims
+}
+
+// metricDimensionSets returns every dimension set a datapoint is published
+// with. The first set is always the cluster-level one so aggregate alarms keep
+// working; per-instance and per-zone sets follow when enabled.
+func metricDimensionSets() [][]types.Dimension {
+	sets := [][]types.Dimension{metricDimensions()}
+	if instanceID != "" {
+		sets = append(sets, append(metricDimensions(), types.Dimension{
+			Name:  aws.String("InstanceId"),
+			Value: aws.String(instanceID),
+		}))
+	}
+	if availabilityZone != "" {
+		sets = append(sets, append(metricDimensions(), types.Dimension{
+			Name:  aws.String("AvailabilityZone"),
+			Value: aws.String(availabilityZone),
+		}))
+	}
+
+	return sets
+}
+
+// endpointDimensionSets returns the dimension sets for a datapoint observed on
+// a single etcd endpoint. These are the aggregate sets from
+// metricDimensionSets, plus the cluster set narrowed down to the endpoint when
+// per-endpoint dimensions are enabled.
+func endpointDimensionSets(endpoint string) [][]types.Dimension {
+	return append(metricDimensionSets(), endpointOnlyDimensionSets(endpoint)...)
+}
+
+// endpointOnlyDimensionSets returns just the cluster set narrowed down to the
+// endpoint, or nothing if per-endpoint dimensions are disabled. It is used for
+// values that differ between the endpoint and the cluster level.
+func endpointOnlyDimensionSets(endpoint string) [][]types.Dimension {
+	if !*endpointDimension {
+		return nil
+	}
+
+	return clusterDimensionSets("Endpoint", normalizeEndpoint(endpoint))
+}
+
+// memberDimensionSets returns the dimension sets for a datapoint describing a
+// single cluster member: the cluster set narrowed down to the member name.
+func memberDimensionSets(member string) [][]types.Dimension {
+	return clusterDimensionSets("Member", member)
+}
+
+// clusterDimensionSets returns the cluster dimension set with one additional
+// dimension, for metrics that only make sense broken down by it.
+func clusterDimensionSets(name, value string) [][]types.Dimension {
+	return [][]types.Dimension{
+		append(metricDimensions(), types.Dimension{
+			Name:  aws.String(name),
+			Value: aws.String(value),
+		}),
+	}
+}
+
+// normalizeEndpoint reduces an endpoint URL to host:port so that differently
+// formatted URLs of the same member map to the same dimension value.
+func normalizeEndpoint(endpoint string) string {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(endpoint)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// formatDimensions renders dims for the startup banner.
+func formatDimensions(dims []types.Dimension) string {
+	pairs := make([]string, 0, len(dims))
+	for _, d := range dims {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", aws.ToString(d.Name), aws.ToString(d.Value)))
+	}
+
+	return strings.Join(pairs, ", ")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestDimensionName(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "By cluster"},
+		{[]string{"-dimension-name", "ClusterName"}, "ClusterName"},
+		{[]string{"-dimension-name", "ClusterName", "-dimension", "Team=storage"}, "ClusterName"},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			setFlags(t, append(test.args, "-name", "etcd-a", "-endpoint-dimension")...)
+			if err := validateFlags(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, datum := range publishResult(t, testResult()) {
+				dim := datum.Dimensions[0]
+				if aws.ToString(dim.Name) != test.want || aws.ToString(dim.Value) != "etcd-a" {
+					t.Errorf("%s has the cluster dimension %s=%s, want %s=etcd-a", aws.ToString(datum.MetricName),
+						aws.ToString(dim.Name), aws.ToString(dim.Value), test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDimensionNameTooLong(t *testing.T) {
+	setFlags(t, "-dimension-name", strings.Repeat("x", maxDimensionNameLength+1))
+
+	err := validateFlags()
+	if err == nil || !strings.Contains(err.Error(), "CloudWatch allows at most 255") {
+		t.Errorf("validateFlags() = %v, want the 256 characters long name rejected", err)
+	}
+}
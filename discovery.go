@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"sort"
+	"time"
+)
+
+// EndpointDiscovery keeps the checked endpoints in line with the cluster
+// membership by listing the members through the seed addresses every few
+// checks, so that members added after deployment are monitored too.
+type EndpointDiscovery struct {
+	seeds     []string
+	every     int
+	timeout   time.Duration
+	tlsConfig *tls.Config
+
+	// checks counts the checks since the last discovery, which runs when it
+	// reaches every.
+	checks int
+}
+
+// NewEndpointDiscovery returns a discovery listing the members through seeds
+// every checks. tlsConfig is used for the gRPC clients of new endpoints.
+func NewEndpointDiscovery(seeds []string, every int, timeout time.Duration, tlsConfig *tls.Config) *EndpointDiscovery {
+	return &EndpointDiscovery{
+		seeds:     seeds,
+		every:     every,
+		timeout:   timeout,
+		tlsConfig: tlsConfig,
+		checks:    every,
+	}
+}
+
+// Run refreshes the endpoints if a discovery is due. A failed discovery keeps
+// the last known endpoints.
+func (d *EndpointDiscovery) Run() {
+	if d.checks++; d.checks < d.every {
+		return
+	}
+	d.checks = 0
+
+	members, err := d.listMembers()
+	if err != nil {
+		log.Printf("[ERROR] Failed to discover etcd endpoints, keeping the last known ones: %s", err)
+		return
+	}
+
+	var discovered []string
+	learners := make(map[string]bool)
+	for _, member := range members {
+		if len(member.ClientURLs) == 0 {
+			// Not started yet.
+			continue
+		}
+		urls, err := parseEndpoints(member.ClientURLs[0])
+		if err != nil {
+			log.Printf("[WARN] Skipping etcd member %q: %s", member.Name, err)
+			continue
+		}
+		discovered = append(discovered, urls[0])
+		if member.IsLearner {
+			learners[urls[0]] = true
+		}
+	}
+	if len(discovered) == 0 {
+		log.Printf("[WARN] Discovered no etcd members with client URLs, keeping the last known endpoints")
+		return
+	}
+	sort.Strings(discovered)
+
+	setEndpoints(discovered, d.tlsConfig)
+	learnerEndpoints = learners
+}
+
+// listMembers lists the members through the first seed that answers.
+func (d *EndpointDiscovery) listMembers() ([]Member, error) {
+	var err error
+	for _, seed := range d.seeds {
+		var members []Member
+		members, err = d.listMembersAt(seed)
+		if err == nil {
+			return members, nil
+		}
+		log.Printf("[DEBUG] Failed to list etcd members through %s: %s", seed, err)
+	}
+
+	return nil, err
+}
+
+func (d *EndpointDiscovery) listMembersAt(seed string) ([]Member, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	return getMembersContext(ctx, seed)
+}
+
+// setEndpoints replaces the checked endpoints with discovered, found by the
+// discovery or reloaded from the configuration, logging every change and
+// keeping the gRPC clients in step. tlsConfig is used for the clients of new
+// endpoints.
+func setEndpoints(discovered []string, tlsConfig *tls.Config) {
+	current := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		current[endpoint] = true
+	}
+
+	var next []string
+	for _, endpoint := range discovered {
+		if current[endpoint] {
+			delete(current, endpoint)
+			next = append(next, endpoint)
+			continue
+		}
+
+		if etcdClients != nil {
+			c, err := newEtcdClient(endpoint, tlsConfig)
+			if err != nil {
+				log.Printf("[ERROR] Failed to create the etcd client for %s, not checking it: %s", endpoint, err)
+				continue
+			}
+			etcdClients[endpoint] = c
+		}
+		log.Printf("[INFO] Now checking etcd endpoint %s", endpoint)
+		next = append(next, endpoint)
+	}
+	if len(next) == 0 {
+		return
+	}
+
+	for endpoint := range current {
+		log.Printf("[INFO] No longer checking etcd endpoint %s", endpoint)
+		if c, ok := etcdClients[endpoint]; ok {
+			c.Close()
+			delete(etcdClients, endpoint)
+		}
+	}
+
+	endpoints = next
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DogStatsDSink sends the health check results to a Datadog agent with tags
+// instead of dotted paths, over UDP or the agent's unix socket.
+type DogStatsDSink struct {
+	network string
+	address string
+
+	// conn is nil after a failed write, so that the next update redials,
+	// e.g. the new socket of a restarted agent.
+	conn net.Conn
+}
+
+// NewDogStatsDSink returns a sink sending to the agent at address: host:port
+// for UDP, or unix:///path for the unix datagram socket. The socket does not
+// need to exist yet, the agent may start after the monitor.
+func NewDogStatsDSink(address string) (*DogStatsDSink, error) {
+	s := &DogStatsDSink{
+		network: "udp",
+		address: address,
+	}
+	if strings.HasPrefix(address, "unix://") {
+		s.network, s.address = "unixgram", strings.TrimPrefix(address, "unix://")
+		if s.address == "" {
+			return nil, fmt.Errorf("no socket path in %q", address)
+		}
+		return s, nil
+	}
+
+	conn, err := net.Dial(s.network, s.address)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+
+	return s, nil
+}
+
+// newDogStatsDReporter returns the sink of -dogstatsd-address.
+func newDogStatsDReporter() (Reporter, error) {
+	sink, err := NewDogStatsDSink(*dogstatsdAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -dogstatsd-address: %s", err)
+	}
+
+	return sink, nil
+}
+
+// Report sends the result of a check: etcd.monitor.unhealthy as a gauge for
+// the cluster, and etcd.monitor.endpoint.unhealthy (gauge) and
+// etcd.monitor.check_latency (histogram, ms) with an endpoint tag.
+func (s *DogStatsDSink) Report(ctx context.Context, result CheckResult) error {
+	tags := dogStatsDTags()
+
+	lines := []string{fmt.Sprintf("etcd.monitor.unhealthy:%g|g|#%s", result.UnhealthyCount, strings.Join(tags, ","))}
+	for _, e := range result.Endpoints {
+		endpointTags := strings.Join(append(tags, dogStatsDTag("endpoint", normalizeEndpoint(e.Endpoint))), ",")
+		unhealthy := 0
+		if !e.Healthy {
+			unhealthy = 1
+		}
+		lines = append(lines,
+			fmt.Sprintf("etcd.monitor.endpoint.unhealthy:%d|g|#%s", unhealthy, endpointTags),
+			fmt.Sprintf("etcd.monitor.check_latency:%g|h|#%s", e.LatencyMs, endpointTags))
+	}
+
+	if s.conn == nil {
+		conn, err := net.Dial(s.network, s.address)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	for _, packet := range packLines(lines, maxStatsDPacket) {
+		if _, err := s.conn.Write([]byte(packet)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dogStatsDTags returns the tags attached to every metric, derived from the
+// CloudWatch dimensions: the cluster, the extra dimensions, and the instance
+// and availability zone if enabled.
+func dogStatsDTags() []string {
+	tags := []string{dogStatsDTag("cluster", *etcdName)}
+	for _, d := range extraDimensions.dimensions {
+		tags = append(tags, dogStatsDTag(d.Name, d.Value))
+	}
+	if instanceID != "" {
+		tags = append(tags, dogStatsDTag("instance_id", instanceID))
+	}
+	if availabilityZone != "" {
+		tags = append(tags, dogStatsDTag("az", availabilityZone))
+	}
+
+	return tags
+}
+
+// dogStatsDTag returns a name:value tag. Commas, pipes and hashes separate
+// the fields of a DogStatsD line and are replaced, as is a colon in the name.
+func dogStatsDTag(name, value string) string {
+	sanitize := func(s, special string) string {
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(special, r) {
+				return '_'
+			}
+			return r
+		}, s)
+	}
+
+	return sanitize(name, ",|#: \n") + ":" + sanitize(value, ",|#\n")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDogStatsDDatagrams(t *testing.T) {
+	setFlags(t, "-name", "etcd-a", "-dimension", "Team=storage,core")
+	listener := listenUDP(t)
+
+	sink, err := NewDogStatsDSink(listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Report(context.Background(), testEndpointsResult()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "etcd.monitor.unhealthy:0|g|#cluster:etcd-a,Team:storage_core\n" +
+		"etcd.monitor.endpoint.unhealthy:0|g|#cluster:etcd-a,Team:storage_core,endpoint:etcd-0:2379\n" +
+		"etcd.monitor.check_latency:12.5|h|#cluster:etcd-a,Team:storage_core,endpoint:etcd-0:2379\n" +
+		"etcd.monitor.endpoint.unhealthy:1|g|#cluster:etcd-a,Team:storage_core,endpoint:etcd-1:2379\n" +
+		"etcd.monitor.check_latency:5000|h|#cluster:etcd-a,Team:storage_core,endpoint:etcd-1:2379"
+	datagrams := readDatagrams(t, listener)
+	if len(datagrams) != 1 || datagrams[0] != want {
+		t.Errorf("got datagrams %q, want %q", datagrams, want)
+	}
+}
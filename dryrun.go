@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// dryRunClient is a CloudWatch, CloudWatch Logs, SNS, EventBridge, SQS and SES
+// client that logs PutMetricData payloads, alarm and dashboard changes, log
+// events, notifications, events, messages and emails instead of sending them.
+type dryRunClient struct{}
+
+func (c *dryRunClient) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Dry run, not publishing: %s", payload)
+
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func (c *dryRunClient) PutMetricAlarm(ctx context.Context, params *cloudwatch.PutMetricAlarmInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricAlarmOutput, error) {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Dry run, not creating alarm: %s", payload)
+
+	return &cloudwatch.PutMetricAlarmOutput{}, nil
+}
+
+func (c *dryRunClient) DeleteAlarms(ctx context.Context, params *cloudwatch.DeleteAlarmsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.DeleteAlarmsOutput, error) {
+	log.Printf("[INFO] Dry run, not deleting alarms: %s", params.AlarmNames)
+
+	return &cloudwatch.DeleteAlarmsOutput{}, nil
+}
+
+func (c *dryRunClient) PutDashboard(ctx context.Context, params *cloudwatch.PutDashboardInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutDashboardOutput, error) {
+	log.Printf("[INFO] Dry run, not creating dashboard: %s", *params.DashboardBody)
+
+	return &cloudwatch.PutDashboardOutput{}, nil
+}
+
+func (c *dryRunClient) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Dry run, not publishing to SNS: %s", payload)
+
+	return &sns.PublishOutput{}, nil
+}
+
+func (c *dryRunClient) PutLogEvents(ctx context.Context, params *cloudwatchlogs.PutLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	for _, e := range params.LogEvents {
+		log.Printf("[INFO] Dry run, not writing to CloudWatch Logs: %s", *e.Message)
+	}
+
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func (c *dryRunClient) CreateLogGroup(ctx context.Context, params *cloudwatchlogs.CreateLogGroupInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	log.Printf("[INFO] Dry run, not creating log group %s", *params.LogGroupName)
+
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func (c *dryRunClient) CreateLogStream(ctx context.Context, params *cloudwatchlogs.CreateLogStreamInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	log.Printf("[INFO] Dry run, not creating log stream %s", *params.LogStreamName)
+
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (c *dryRunClient) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Dry run, not putting events: %s", payload)
+
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+func (c *dryRunClient) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Dry run, not sending the message: %s", payload)
+
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (c *dryRunClient) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	content := params.Content.Simple
+	log.Printf("[INFO] Dry run, not sending the email to %s: %s\n%s", strings.Join(params.Destination.ToAddresses, ", "),
+		aws.ToString(content.Subject.Data), aws.ToString(content.Body.Text.Data))
+
+	return &sesv2.SendEmailOutput{}, nil
+}
+
+// discardClient drops PutMetricData payloads, for running without CloudWatch,
+// e.g. as a pure Prometheus exporter.
+type discardClient struct{}
+
+func (c *discardClient) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLog returns the buffer the log is written to until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	return &buf
+}
+
+func TestDryRunLogsPayload(t *testing.T) {
+	setFlags(t, "-dry-run", "-namespace", "etcd-test")
+	logged := captureLog(t)
+
+	batch := NewMetricBatch(&dryRunClient{}, *namespace, 0)
+	result := testResult()
+	if err := NewCloudWatchReporter(batch).Report(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+	batch.Flush(context.Background())
+
+	const prefix = "[INFO] Dry run, not publishing: "
+	var payloads []string
+	for _, line := range strings.Split(logged.String(), "\n") {
+		if i := strings.Index(line, prefix); i >= 0 {
+			payloads = append(payloads, line[i+len(prefix):])
+		}
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("logged %d payloads, want 1:\n%s", len(payloads), logged)
+	}
+
+	var payload struct {
+		Namespace  string
+		MetricData []struct {
+			MetricName string
+			Dimensions []struct{ Name, Value string }
+			Timestamp  time.Time
+			Unit       string
+		}
+	}
+	if err := json.Unmarshal([]byte(payloads[0]), &payload); err != nil {
+		t.Fatalf("the payload %s is not JSON: %s", payloads[0], err)
+	}
+	if payload.Namespace != "etcd-test" {
+		t.Errorf("Namespace = %q, want etcd-test", payload.Namespace)
+	}
+	if len(payload.MetricData) == 0 {
+		t.Fatal("the payload has no MetricData")
+	}
+	units := make(map[string]string)
+	for _, datum := range payload.MetricData {
+		units[datum.MetricName] = datum.Unit
+		if len(datum.Dimensions) == 0 || datum.Dimensions[0].Name != "By cluster" {
+			t.Errorf("%s has dimensions %v, want By cluster", datum.MetricName, datum.Dimensions)
+		}
+		if !datum.Timestamp.Equal(result.Time) {
+			t.Errorf("%s has Timestamp %s, want %s", datum.MetricName, datum.Timestamp, result.Time)
+		}
+	}
+	if units["UnhealthyCount"] != "Count" {
+		t.Errorf("the payload has metrics %v, want UnhealthyCount in Count", units)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// emfMaxValues is the number of values EMF accepts for a single metric in a
+// document.
+const emfMaxValues = 100
+
+// emfClient is a CloudWatch client that writes PutMetricData payloads as
+// CloudWatch Embedded Metric Format documents, one JSON document per line,
+// for the CloudWatch agent or FireLens to pick up.
+type emfClient struct {
+	w io.Writer
+}
+
+// emfMetadata is the "_aws" member of an EMF document.
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetric struct {
+	Name              string `json:"Name"`
+	Unit              string `json:"Unit,omitempty"`
+	StorageResolution int32  `json:"StorageResolution,omitempty"`
+}
+
+func (c *emfClient) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	for _, doc := range emfDocuments(params) {
+		buff, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.w.Write(append(buff, '\n')); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// emfDocuments converts input into EMF documents. Datums sharing a dimension
+// set and timestamp are combined into a single document; a metric with more
+// than emfMaxValues values is spread over as many documents as it takes.
+func emfDocuments(input *cloudwatch.PutMetricDataInput) []map[string]interface{} {
+	type document struct {
+		fields    map[string]interface{}
+		timestamp int64
+		directive *emfDirective
+		values    map[string][]float64
+	}
+
+	var order []*document
+	docs := make(map[string]*document)
+
+	for _, datum := range input.MetricData {
+		var names []string
+		timestamp := aws.ToTime(datum.Timestamp).UnixNano() / 1e6
+		parts := []string{strconv.FormatInt(timestamp, 10)}
+		for _, dim := range datum.Dimensions {
+			names = append(names, aws.ToString(dim.Name))
+			parts = append(parts, aws.ToString(dim.Name)+"="+aws.ToString(dim.Value))
+		}
+		key := strings.Join(parts, "|")
+
+		doc, ok := docs[key]
+		if !ok {
+			doc = &document{
+				fields:    make(map[string]interface{}),
+				timestamp: timestamp,
+				directive: &emfDirective{
+					Namespace:  aws.ToString(input.Namespace),
+					Dimensions: [][]string{names},
+				},
+				values: make(map[string][]float64),
+			}
+			for _, dim := range datum.Dimensions {
+				doc.fields[aws.ToString(dim.Name)] = aws.ToString(dim.Value)
+			}
+
+			docs[key] = doc
+			order = append(order, doc)
+		}
+
+		name := aws.ToString(datum.MetricName)
+		if _, ok := doc.values[name]; !ok {
+			metric := emfMetric{
+				Name: name,
+				Unit: string(datum.Unit),
+			}
+			if aws.ToInt32(datum.StorageResolution) == 1 {
+				metric.StorageResolution = 1
+			}
+			doc.directive.Metrics = append(doc.directive.Metrics, metric)
+		}
+		doc.values[name] = append(doc.values[name], emfValues(datum)...)
+	}
+
+	var result []map[string]interface{}
+	for _, doc := range order {
+		for chunk := 0; ; chunk++ {
+			fields := make(map[string]interface{}, len(doc.fields)+len(doc.values)+1)
+			for name, value := range doc.fields {
+				fields[name] = value
+			}
+			directive := emfDirective{Namespace: doc.directive.Namespace, Dimensions: doc.directive.Dimensions}
+			for _, metric := range doc.directive.Metrics {
+				values := doc.values[metric.Name]
+				if len(values) <= chunk*emfMaxValues {
+					continue
+				}
+				values = values[chunk*emfMaxValues:]
+				if len(values) > emfMaxValues {
+					values = values[:emfMaxValues]
+				}
+
+				directive.Metrics = append(directive.Metrics, metric)
+				if len(values) == 1 {
+					fields[metric.Name] = values[0]
+				} else {
+					fields[metric.Name] = values
+				}
+			}
+			if len(directive.Metrics) == 0 {
+				break
+			}
+
+			fields["_aws"] = emfMetadata{
+				Timestamp:         doc.timestamp,
+				CloudWatchMetrics: []emfDirective{directive},
+			}
+			result = append(result, fields)
+		}
+	}
+
+	return result
+}
+
+// emfValues returns the individual values of a datum. EMF has no notion of
+// statistic sets, so a set is expanded into SampleCount values that preserve
+// its Minimum, Maximum and Sum.
+func emfValues(datum types.MetricDatum) []float64 {
+	switch {
+	case datum.Value != nil:
+		return []float64{aws.ToFloat64(datum.Value)}
+
+	case datum.StatisticValues != nil:
+		s := datum.StatisticValues
+		n := int(aws.ToFloat64(s.SampleCount))
+		minimum, maximum, sum := aws.ToFloat64(s.Minimum), aws.ToFloat64(s.Maximum), aws.ToFloat64(s.Sum)
+		switch {
+		case n <= 1:
+			return []float64{sum}
+		case n == 2:
+			return []float64{minimum, maximum}
+		}
+
+		values := []float64{minimum, maximum}
+		rest := (sum - minimum - maximum) / float64(n-2)
+		for i := 2; i < n; i++ {
+			values = append(values, rest)
+		}
+		return values
+	}
+
+	var values []float64
+	for i, v := range datum.Values {
+		count := 1
+		if i < len(datum.Counts) {
+			count = int(datum.Counts[i])
+		}
+		for j := 0; j < count; j++ {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
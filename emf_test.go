@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// emfDocument is an EMF document as read by the CloudWatch agent.
+type emfDocument struct {
+	AWS struct {
+		Timestamp         int64
+		CloudWatchMetrics []struct {
+			Namespace  string
+			Dimensions [][]string
+			Metrics    []struct {
+				Name              string
+				Unit              string
+				StorageResolution int32
+			}
+		}
+	} `json:"_aws"`
+	fields map[string]json.RawMessage
+}
+
+// writeEMF writes data in EMF and returns the documents written.
+func writeEMF(t *testing.T, data ...types.MetricDatum) []emfDocument {
+	t.Helper()
+
+	var buf bytes.Buffer
+	client := &emfClient{w: &buf}
+	_, err := client.PutMetricData(context.Background(), &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String("etcd"),
+		MetricData: data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var docs []emfDocument
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var doc emfDocument
+		if err := json.Unmarshal([]byte(line), &doc); err != nil {
+			t.Fatalf("%s is not a JSON document: %s", line, err)
+		}
+		if err := json.Unmarshal([]byte(line), &doc.fields); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs
+}
+
+// emfValuesOf returns the values of the metric name in doc.
+func emfValuesOf(t *testing.T, doc emfDocument, name string) []float64 {
+	t.Helper()
+
+	raw, ok := doc.fields[name]
+	if !ok {
+		return nil
+	}
+	var value float64
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return []float64{value}
+	}
+	var values []float64
+	if err := json.Unmarshal(raw, &values); err != nil {
+		t.Fatalf("%s = %s, want a number or an array of numbers", name, raw)
+	}
+
+	return values
+}
+
+func testDatum(name string, stats *types.StatisticSet, dims ...types.Dimension) types.MetricDatum {
+	return types.MetricDatum{
+		MetricName:        aws.String(name),
+		Dimensions:        dims,
+		StatisticValues:   stats,
+		StorageResolution: aws.Int32(1),
+		Timestamp:         aws.Time(time.Unix(1700000000, 123e6)),
+		Unit:              types.StandardUnitCount,
+	}
+}
+
+func dimension(name, value string) types.Dimension {
+	return types.Dimension{Name: aws.String(name), Value: aws.String(value)}
+}
+
+func TestEMFMetadata(t *testing.T) {
+	cluster := dimension("ClusterName", "etcd")
+	docs := writeEMF(t,
+		testDatum("UnhealthyCount", &types.StatisticSet{Maximum: aws.Float64(1), Minimum: aws.Float64(1), SampleCount: aws.Float64(1), Sum: aws.Float64(1)}, cluster),
+		testDatum("Healthy", &types.StatisticSet{Maximum: aws.Float64(0), Minimum: aws.Float64(0), SampleCount: aws.Float64(1), Sum: aws.Float64(0)}, cluster),
+	)
+
+	if len(docs) != 1 {
+		t.Fatalf("wrote %d documents, want the metrics of one dimension set in one", len(docs))
+	}
+	doc := docs[0]
+	if doc.AWS.Timestamp != 1700000000123 {
+		t.Errorf("_aws.Timestamp = %d, want 1700000000123 milliseconds", doc.AWS.Timestamp)
+	}
+	if len(doc.AWS.CloudWatchMetrics) != 1 {
+		t.Fatalf("_aws.CloudWatchMetrics has %d directives, want 1", len(doc.AWS.CloudWatchMetrics))
+	}
+	directive := doc.AWS.CloudWatchMetrics[0]
+	if directive.Namespace != "etcd" {
+		t.Errorf("Namespace = %q, want etcd", directive.Namespace)
+	}
+	if len(directive.Dimensions) != 1 || strings.Join(directive.Dimensions[0], ",") != "ClusterName" {
+		t.Errorf("Dimensions = %v, want [[ClusterName]]", directive.Dimensions)
+	}
+	if len(directive.Metrics) != 2 {
+		t.Fatalf("Metrics = %v, want UnhealthyCount and Healthy", directive.Metrics)
+	}
+	for i, name := range []string{"UnhealthyCount", "Healthy"} {
+		m := directive.Metrics[i]
+		if m.Name != name || m.Unit != "Count" || m.StorageResolution != 1 {
+			t.Errorf("metric %d = %+v, want %s in Count at resolution 1", i, m, name)
+		}
+	}
+	if string(doc.fields["ClusterName"]) != `"etcd"` {
+		t.Errorf("ClusterName = %s, want the dimension value \"etcd\"", doc.fields["ClusterName"])
+	}
+	if got := emfValuesOf(t, doc, "UnhealthyCount"); len(got) != 1 || got[0] != 1 {
+		t.Errorf("UnhealthyCount = %v, want 1", got)
+	}
+}
+
+func TestEMFDimensionSets(t *testing.T) {
+	stats := &types.StatisticSet{Maximum: aws.Float64(1), Minimum: aws.Float64(1), SampleCount: aws.Float64(1), Sum: aws.Float64(1)}
+	docs := writeEMF(t,
+		testDatum("UnhealthyCount", stats, dimension("ClusterName", "etcd")),
+		testDatum("UnhealthyCount", stats, dimension("ClusterName", "etcd"), dimension("Endpoint", "etcd-0:2379")),
+		testDatum("UnhealthyCount", stats, dimension("ClusterName", "etcd"), dimension("Endpoint", "etcd-1:2379")),
+		testDatum("UnhealthyCount", stats),
+	)
+
+	want := []struct {
+		dimensions string
+		endpoint   string
+	}{
+		{"ClusterName", ""},
+		{"ClusterName,Endpoint", `"etcd-0:2379"`},
+		{"ClusterName,Endpoint", `"etcd-1:2379"`},
+		{"", ""},
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("wrote %d documents, want one per dimension set, %d", len(docs), len(want))
+	}
+	for i, doc := range docs {
+		dims := doc.AWS.CloudWatchMetrics[0].Dimensions
+		if len(dims) != 1 || strings.Join(dims[0], ",") != want[i].dimensions {
+			t.Errorf("document %d has Dimensions %v, want [[%s]]", i, dims, want[i].dimensions)
+		}
+		if string(doc.fields["Endpoint"]) != want[i].endpoint {
+			t.Errorf("document %d has Endpoint %s, want %q", i, doc.fields["Endpoint"], want[i].endpoint)
+		}
+	}
+}
+
+func TestEMFValueCap(t *testing.T) {
+	tests := []struct {
+		name   string
+		datum  types.MetricDatum
+		chunks []int
+		sum    float64
+	}{
+		{
+			"statistic set of 100",
+			testDatum("Latency", &types.StatisticSet{Maximum: aws.Float64(5), Minimum: aws.Float64(1), SampleCount: aws.Float64(100), Sum: aws.Float64(300)}),
+			[]int{100}, 300,
+		},
+		{
+			"statistic set of 250",
+			testDatum("Latency", &types.StatisticSet{Maximum: aws.Float64(5), Minimum: aws.Float64(1), SampleCount: aws.Float64(250), Sum: aws.Float64(750)}),
+			[]int{100, 100, 50}, 750,
+		},
+		{
+			"values and counts",
+			types.MetricDatum{
+				MetricName: aws.String("Latency"),
+				Timestamp:  aws.Time(time.Unix(1700000000, 0)),
+				Values:     []float64{1, 2},
+				Counts:     []float64{120, 30},
+			},
+			[]int{100, 50}, 180,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docs := writeEMF(t, test.datum)
+			if len(docs) != len(test.chunks) {
+				t.Fatalf("wrote %d documents, want %d", len(docs), len(test.chunks))
+			}
+			sum := 0.0
+			for i, doc := range docs {
+				values := emfValuesOf(t, doc, "Latency")
+				if len(values) != test.chunks[i] {
+					t.Errorf("document %d has %d values, want %d", i, len(values), test.chunks[i])
+				}
+				if metrics := doc.AWS.CloudWatchMetrics[0].Metrics; len(metrics) != 1 || metrics[0].Name != "Latency" {
+					t.Errorf("document %d has Metrics %v, want Latency", i, metrics)
+				}
+				for _, v := range values {
+					sum += v
+				}
+			}
+			if sum != test.sum {
+				t.Errorf("the values add up to %g, want %g", sum, test.sum)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Policies deriving the published unhealthy count from the health of the
+// individual endpoints.
+const (
+	unhealthyPolicyAny    = "any"
+	unhealthyPolicyAll    = "all"
+	unhealthyPolicyQuorum = "quorum"
+)
+
+// parseEndpoints splits a comma-separated list of etcd client URLs, as found
+// in ETCD_ADVERTISE_CLIENT_URLS, and validates every entry. A base path, e.g.
+// of a proxy in front of etcd, is kept.
+func parseEndpoints(s string) ([]string, error) {
+	var endpoints []string
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		u, err := url.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid etcd address %q: %s", entry, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("invalid etcd address %q, expected an http:// or https:// URL", entry)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid etcd address %q, host must not be empty", entry)
+		}
+		if u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid etcd address %q, must not have a query", entry)
+		}
+
+		endpoints = append(endpoints, strings.TrimSuffix(entry, "/"))
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no etcd address configured")
+	}
+
+	return endpoints, nil
+}
+
+// joinURL appends path, which may carry a query, to the endpoint URL without
+// doubling or dropping the slash between them.
+func joinURL(endpoint, path string) string {
+	return strings.TrimRight(endpoint, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// unhealthyByPolicy returns the unhealthy count, 1 or 0, for healthy out of
+// total endpoints passing their checks.
+func unhealthyByPolicy(policy string, healthy, total int) float64 {
+	var unhealthy bool
+	switch policy {
+	case unhealthyPolicyAll:
+		unhealthy = healthy == 0
+	case unhealthyPolicyQuorum:
+		unhealthy = healthy < total/2+1
+	default:
+		unhealthy = healthy < total
+	}
+
+	if unhealthy {
+		return 1.0
+	}
+
+	return 0.0
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envString returns the value of the environment variable key, or def if it
+// is not set.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return def
+}
+
+// envInt is like envString for integer values. An invalid value is fatal.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return i
+}
+
+// envInt64 is like envString for 64-bit integer values. An invalid value is
+// fatal.
+func envInt64(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return i
+}
+
+// envBool is like envString for boolean values. An invalid value is fatal.
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return b
+}
+
+// envFloat is like envString for floating point values. An invalid value is
+// fatal.
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return f
+}
+
+// envDuration is like envString for durations such as "500ms". An invalid
+// value is fatal.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return d
+}
@@ -1,230 +1,870 @@
 package main
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 var client *http.Client
-var cw *cloudwatch.CloudWatch
-var etcdName *string
-var address *string
-var awsRegion *string
-var namespace *string
+
+// clientTLS is the client certificate and CA bundle in use, for reporting
+// their expiry. It is nil for plain http.
+var clientTLS *tlsFiles
+
+var metrics *MetricBatch
+var endpoints []string
+var instanceID string
+var availabilityZone string
 var signalCh chan os.Signal
 
-type Health struct {
-	IsHealthy bool `json:"health,string"`
-}
+// version is the monitor release, set at build time with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
+// reporters receives the result of every check.
+var reporters *FanOutReporter
+
+// cloudWatchReporter adds the health metrics to the metric batch, and is one
+// of the reporters.
+var cloudWatchReporter *CloudWatchReporter
+
+// checksSincePublish counts the checks aggregated into the current publish
+// window.
+var checksSincePublish int
+
+// checkResults queues the check results for the reporters, nil if they run
+// inline with the checks.
+var checkResults *reportQueue
+
+// maintenance holds the maintenance windows, nil without any.
+var maintenance *maintenanceFile
+
+// jitter is the -jitter, a duration or a percentage of -interval, as a
+// duration.
+var jitter time.Duration
+
+const (
+	publishModeAlways  = "always"
+	publishModeChanges = "changes"
+)
 
 func main() {
 	signalCh = make(chan os.Signal, 1)
 
-	defaultInterval := 60
-	if i := os.Getenv("CHECK_INTERVAL"); i != "" {
-		defaultIntervalEnv, err := strconv.Atoi(i)
+	// "etcd-monitor dashboard [flags]" creates a dashboard,
+	// "etcd-monitor status [flags]" prints the status of the running monitor
+	// and "etcd-monitor check [flags]" runs a single check as a Nagios plugin
+	// instead of starting the monitor. They understand the same flags.
+	command := ""
+	if len(os.Args) > 1 && (os.Args[1] == "dashboard" || os.Args[1] == "status" || os.Args[1] == "check") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	if command == "check" {
+		log.SetFlags(0)
+		log.SetOutput(nagiosLogWriter{})
+	}
+
+	// The configuration file sets environment variables, so it is read
+	// before the flags take their defaults from them.
+	var configValues map[string]string
+	if path := configFileArg(os.Args[1:]); path != "" {
+		var err error
+		configValues, err = loadConfigFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read the configuration file: %s", err)
+		}
+	}
+	defineFlags()
+
+	if command == "check" {
+		// Usage errors are UNKNOWN, not the CRITICAL of exit code 2.
+		flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+		if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+			printNagiosStatus(nagiosUnknown, err.Error())
+			os.Exit(nagiosUnknown)
+		}
+	} else {
+		flag.Parse()
+	}
+
+	if command == "status" {
+		status, err := fetchDaemonStatus(*controlSocket)
+		if err != nil {
+			log.Fatalf("No etcd-monitor is running on the control socket %s, check -control-socket: %s", *controlSocket, err)
+		}
+		printDaemonStatus(status)
+		return
+	}
+
+	if err := validateFlags(); err != nil {
+		log.Fatal(err)
+	}
+
+	var err error
+	if *discoverySRV != "" {
+		endpoints, err = resolveSRVEndpoints(*discoverySRV, *discoverySRVName)
+	} else {
+		endpoints, err = parseEndpoints(*address)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *scrapeMetricsEnabled && *scrapeMetricsURL == "" {
+		*scrapeMetricsURL = endpoints[0] + "/metrics"
+	}
+	if *enableAppliedLagProbe && len(endpoints) < 2 && !*discover {
+		log.Printf("[WARN] -applied-lag-probe needs several addresses or -discover, it has nothing to compare")
+	}
+	if *maintenanceFilePath != "" && !*once {
+		maintenance, err = loadMaintenanceFile(*maintenanceFilePath)
+		if err != nil {
+			log.Fatalf("Invalid -maintenance-file: %s", err)
+		}
+	}
+
+	ctx := context.Background()
+	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(*awsRegion))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if command == "dashboard" {
+		if *dashboardName == "" {
+			*dashboardName = "etcd-" + *etcdName
+		}
+
+		body, err := dashboardBody(endpoints)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defaultInterval = defaultIntervalEnv
+		if *printOnly {
+			fmt.Println(string(body))
+			return
+		}
+
+		client := newCloudWatchClient(ctx, awsConfig, *assumeRoleARN, *externalID, *cloudwatchEndpoint)
+		if err := putDashboard(ctx, client, *dashboardName, body); err != nil {
+			log.Fatalf("Failed to create dashboard %s: %s", *dashboardName, err)
+		}
+		log.Printf("[INFO] Dashboard %s is in place", *dashboardName)
+		return
+	}
+
+	https := false
+	for _, endpoint := range endpoints {
+		if strings.HasPrefix(endpoint, "https://") {
+			https = true
+		}
+	}
+	var clients tlsClients
+	if *caSecretARN != "" || *certSecretARN != "" || *keySecretARN != "" {
+		clients.secrets = newSecretsManagerClient(awsConfig)
+	}
+	if *caSSMParam != "" || *certSSMParam != "" || *keySSMParam != "" {
+		clients.ssm = newSSMClient(awsConfig)
+	}
+	caSource, err := tlsSource(ctx, "ca", *caFile, *caSecretARN, *caSSMParam, clients)
+	if err != nil {
+		log.Fatal(err)
+	}
+	certSource, err := tlsSource(ctx, "cert", *certFile, *certSecretARN, *certSSMParam, clients)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keySource, err := tlsSource(ctx, "key", *keyFile, *keySecretARN, *keySSMParam, clients)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tlsConfig, files, err := newTLSConfig(certSource, keySource, caSource, https, *insecureSkipVerify)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientTLS = files
+	if err := checkTLSExpiry(files); err != nil {
+		log.Fatal(err)
+	}
+	policy, err := parseTLSPolicy(*tlsMinVersion, *tlsCipherSuites)
+	if err != nil {
+		log.Fatal(err)
+	}
+	policy.apply(tlsConfig)
+
+	switch {
+	case *tlsServerName != "":
+		tlsServerNames = &serverNames{all: *tlsServerName}
+	case *tlsServerNameFile != "":
+		tlsServerNames, err = loadServerNames(*tlsServerNameFile)
+		if err != nil {
+			log.Fatalf("Invalid -tls-server-name-file: %s", err)
+		}
+	}
+	if tlsConfig != nil && *insecureSkipVerify {
+		log.Printf("[WARN] The certificates of etcd are not verified, -insecure-skip-verify is for labs only")
 	}
-	interval := flag.Int("interval", defaultInterval,
-		"Time interval of how often to run the check (in seconds). "+
-			"Overrides the CHECK_INTERVAL environment variable if set.")
 
-	defaultAddress := "https://127.0.0.1:2379"
-	if a := os.Getenv("ETCD_ADVERTISE_CLIENT_URLS"); a != "" {
-		defaultAddress = a
+	// Peer ports usually have their own CA, the client TLS files are only
+	// used for them if no peer file is set.
+	peerTLSConfig, peerFiles := tlsConfig, files
+	if *peerCAFile != "" || *peerCertFile != "" || *peerKeyFile != "" {
+		peerTLSConfig, peerFiles, err = newTLSConfig(newFileSource(*peerCertFile), newFileSource(*peerKeyFile), newFileSource(*peerCAFile), true, false)
+		if err != nil {
+			log.Fatalf("Invalid peer TLS configuration: %s", err)
+		}
 	}
-	address = flag.String("address", defaultAddress,
-		"The address of the etcd server. "+
-			"Overrides the ETCD_ADVERTISE_CLIENT_URLS environment variable if set.")
 
-	defaultCaFile := ""
-	if caEnv := os.Getenv("ETCDMON_CA_FILE"); caEnv != "" {
-		defaultCaFile = caEnv
+	if *username != "" {
+		etcdAuth, err = newEtcdCredentials(*username, *passwordFile)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	caFile := flag.String("ca-file", defaultCaFile, "A PEM eoncoded CA's certificate file.")
 
-	defaultCertFile := ""
-	if certEnv := os.Getenv("ETCDMON_CERT_FILE"); certEnv != "" {
-		defaultCertFile = certEnv
+	var tr http.RoundTripper = newTransport(tlsConfig, transportOptions{
+		maxIdleConns:        *maxIdleConns,
+		idleConnTimeout:     *idleConnTimeout,
+		tlsHandshakeTimeout: *tlsHandshakeTimeout,
+		tcpKeepAlive:        *tcpKeepAlive,
+		noKeepAlive:         *noKeepAlive,
+		tlsFiles:            files,
+		serverNames:         tlsServerNames,
+	})
+	if etcdAuth != nil {
+		tr = &basicAuthTransport{base: tr, credentials: etcdAuth}
 	}
-	certFile := flag.String("cert-file", defaultCertFile, "A PEM eoncoded certificate file.")
 
-	defaultKeyFile := ""
-	if keyEnv := os.Getenv("ETCDMON_KEY_FILE"); keyEnv != "" {
-		defaultKeyFile = keyEnv
+	client = newHTTPClient(tr, *checkTimeout)
+
+	if *checkMode == checkModeGRPC {
+		etcdClients = make(map[string]*clientv3.Client)
+		for _, endpoint := range endpoints {
+			etcdClients[endpoint], err = newEtcdClient(endpoint, tlsConfig)
+			if err != nil {
+				log.Fatalf("Failed to create the etcd client for %s: %s", endpoint, err)
+			}
+		}
 	}
-	keyFile := flag.String("key-file", defaultKeyFile, "A PEM encoded private key file.")
 
-	defaultEtcdName := "etcd"
-	if n := os.Getenv("ETCD_NAME"); n != "" {
-		defaultEtcdName = n
+	// The check command only publishes if asked to, and nothing beyond the
+	// metrics of the check.
+	checkOnly := command == "check" && !*checkPublish
+
+	var cw CloudWatchAPI
+	var publisher MetricPublisher
+	switch {
+	case *noCloudWatch, checkOnly:
+		publisher = &discardClient{}
+	case *emf:
+		publisher = &emfClient{w: os.Stdout}
+	default:
+		cw = newCloudWatchClient(ctx, awsConfig, *assumeRoleARN, *externalID, *cloudwatchEndpoint)
+		if *dryRun {
+			cw = &dryRunClient{}
+		}
+		publisher = cw
 	}
-	etcdName = flag.String("name", defaultEtcdName,
-		"The name of the etcd cluster. This value will be used as CloudWatch dimension value. "+
-			"Overrides the ETCD_NAME environment variable if set.")
+	metrics = NewMetricBatch(publisher, *namespace, *maxRetries)
+	metrics.SetTimeout(*cloudwatchTimeout)
 
-	defaultNamespace := "etcd"
-	if r := os.Getenv("METRIC_NAMESPACE"); r != "" {
-		defaultNamespace = r
+	if *bufferSize > 0 && command != "check" {
+		buffer, err := NewMetricBuffer(*bufferSize, *bufferFile)
+		if err != nil {
+			log.Fatalf("Failed to load metric buffer: %s", err)
+		}
+		metrics.SetBuffer(buffer)
 	}
-	namespace = flag.String("namespace", defaultNamespace,
-		"AWS CloudWatch metric namespace. "+
-			"Overrides the METRIC_NAMESPACE environment variable if set.")
 
-	defaultRegion := "us-east-1"
-	if r := os.Getenv("AWS_REGION"); r != "" {
-		defaultRegion = r
+	if *addInstanceDimension && !checkOnly {
+		instanceID = lookupInstanceID(ctx, newMetadataClient(awsConfig))
 	}
-	awsRegion = flag.String("region", defaultRegion,
-		"AWS CloudWatch region. "+
-			"Overrides the AWS_REGION environment variable if set.")
 
-	flag.Parse()
+	if *availabilityZoneOverride != "" {
+		availabilityZone = *availabilityZoneOverride
+	} else if *addAZDimension && !checkOnly {
+		availabilityZone = lookupAvailabilityZone(ctx, newMetadataClient(awsConfig))
+	}
 
-	// Load client cert
-	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
-	if err != nil {
-		log.Fatal(err)
+	if command == "check" {
+		var publishTimeout time.Duration
+		if *checkPublish {
+			publishTimeout = *cloudwatchTimeout
+		}
+		os.Exit(runNagiosCheck(*checkWarning, *checkCritical, *stateFile, publishTimeout))
 	}
 
-	// Load CA cert
-	caCert, err := ioutil.ReadFile(*caFile)
-	if err != nil {
-		log.Fatal(err)
+	if *createAlarm {
+		config := AlarmConfig{
+			Name:              *alarmName,
+			Threshold:         *alarmThreshold,
+			EvaluationPeriods: int32(*alarmEvaluationPeriods),
+			TopicARN:          *alarmTopicARN,
+		}
+		if *publishMode == publishModeChanges {
+			// Keep the alarm state between heartbeats.
+			config.TreatMissingData = "ignore"
+		}
+		err := ensureAlarm(ctx, cw, config)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create alarm %s: %s", *alarmName, err)
+		} else {
+			log.Printf("[INFO] Alarm %s is in place", *alarmName)
+		}
+	}
+
+	printBanner(tlsConfig, policy)
+
+	// The destinations of the results, in the order they are reported to.
+	// The JSON lines output is reopened on SIGHUP.
+	reporters = NewFanOutReporter(*reporterTimeout)
+	cloudWatchReporter = NewCloudWatchReporter(metrics)
+	var jsonlSink *JSONLSink
+	for _, sink := range []struct {
+		name    string
+		enabled bool
+		new     func() (Reporter, error)
+	}{
+		{"syslog", *syslogEnabled || *syslogAddress != "", newSyslogReporter},
+		{"CloudWatch", true, func() (Reporter, error) { return cloudWatchReporter, nil }},
+		{"Prometheus", *prometheusListen != "", newPrometheusReporter},
+		{"StatsD", *statsdAddress != "", newStatsDReporter},
+		{"DogStatsD", *dogstatsd, newDogStatsDReporter},
+		{"InfluxDB", *influxURL != "", newInfluxReporter},
+		{"Graphite", *graphiteAddress != "", newGraphiteReporter},
+		{"the Pushgateway", *pushgatewayURL != "", newPushgatewayReporter},
+		{"the JSON lines output", *jsonlOutput != "", func() (Reporter, error) {
+			jsonlSink, err = newJSONLReporter()
+			return jsonlSink, err
+		}},
+		{"CloudWatch Logs", *logsGroup != "", func() (Reporter, error) { return newCloudWatchLogsReporter(awsConfig) }},
+		{"SNS", *snsTopicARN != "", func() (Reporter, error) { return newSNSReporter(awsConfig) }},
+		{"EventBridge", *eventBridgeBus != "", func() (Reporter, error) { return newEventBridgeReporter(awsConfig) }},
+		{"SQS", *sqsQueueURL != "", func() (Reporter, error) { return newSQSReporter(awsConfig) }},
+		{"SES", *sesFrom != "", func() (Reporter, error) { return newSESReporter(awsConfig) }},
+		{"Slack", *slackWebhookURL != "" || *slackWebhookURLFile != "", newSlackReporter},
+		{"PagerDuty", *pagerDutyRoutingKey != "" || *pagerDutyRoutingKeyFile != "", newPagerDutyReporter},
+		{"the webhook", *webhookURL != "", newWebhookReporter},
+		{"the state file", *stateFile != "", newStateFileReporter},
+	} {
+		if !sink.enabled {
+			continue
+		}
+		reporter, err := sink.new()
+		if err != nil {
+			log.Fatal(err)
+		}
+		reporters.Add(sink.name, reporter)
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
 
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      caCertPool,
+	var controlListener net.Listener
+	if *controlSocket != "" && !*once {
+		controlListener, err = listenControlSocket(*controlSocket)
+		if err != nil {
+			log.Printf("[WARN] Failed to listen on the control socket, the status command will not work: %s", err)
+		}
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: tlsConfig,
+	var liveness *LivenessServer
+	if *listen != "" && !*once {
+		liveness, err = NewLivenessServer(*listen, *interval)
+		if err != nil {
+			log.Fatalf("Failed to start the liveness endpoint: %s", err)
+		}
 	}
 
-	client = &http.Client{
-		Transport: tr,
-		Timeout:   time.Second * 5,
+	probes, err := newProbes(peerTLSConfig, peerFiles)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	awsSession := session.New()
-	awsSession.Config.WithRegion(*awsRegion)
-	cw = cloudwatch.New(awsSession)
+	if *consistencyCheckInterval > 0 {
+		NewConsistencyCheck(endpoints[0], *consistencyCheckInterval, *consistencyCheckTimeout).Start()
+	}
 
-	fmt.Println("==> etcd Monitor Configuration:")
-	fmt.Println("")
-	fmt.Printf("\t      Check interval: %d (seconds)\n", *interval)
-	fmt.Printf("\t        etcd Address: %s\n", *address)
-	fmt.Printf("\t           etcd Name: %s\n", *etcdName)
-	fmt.Printf("\tCloudWatch Namespace: %s\n", *namespace)
-	fmt.Printf("\t          AWS Region: %s\n", *awsRegion)
-	fmt.Println("")
+	var discovery Probe
+	switch {
+	case *discover:
+		discovery = NewEndpointDiscovery(endpoints, *discoverEvery, *discoverTimeout, tlsConfig)
+	case *discoverySRV != "":
+		discovery = NewSRVDiscovery(*discoverySRV, *discoverySRVName, *discoverySRVInterval, tlsConfig)
+	}
 
-	checkEtcdHealth()
+	if *once {
+		code := runOnce(discovery, probes)
+		if *createAlarm && *deleteAlarmOnExit {
+			if err := deleteAlarm(context.Background(), cw, *alarmName); err != nil {
+				log.Printf("[ERROR] Failed to delete alarm %s: %s", *alarmName, err)
+			}
+		}
+		os.Exit(code)
+	}
+
+	// A window in effect already applies to the first check.
+	daemonState.setMaintenance(maintenance)
+	// The grace period starts with the first check, due now.
+	if *startupGracePeriod > 0 {
+		daemonState.setStartupGrace(time.Now().Add(*startupGracePeriod))
+	}
+	schedule := newCheckSchedule(*interval, jitter)
+
+	var reloader *configReloader
+	if *configFile != "" {
+		reloader = newConfigReloader(*configFile, configValues, liveConfig{
+			interval:          *interval,
+			jitter:            *jitterValue,
+			address:           *address,
+			failureThreshold:  *failureThreshold,
+			recoveryThreshold: *recoveryThreshold,
+			checkRetries:      *checkRetries,
+			checkRetryDelay:   *checkRetryDelay,
+		}, schedule, tlsConfig, *discover || *discoverySRV != "")
+	}
 
-	ticker := time.NewTicker(time.Duration(*interval) * time.Second)
+	// The checks run on their own goroutine, so that a shutdown does not wait
+	// for the running one to notice the signal. No check is started once stop
+	// is closed; cancelling checkCtx cancels the running one. gaveUp is closed
+	// once -exit-after-failures checks failed in a row.
+	checkCtx, cancelChecks := context.WithCancel(context.Background())
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	gaveUp := make(chan struct{})
+	hangup := make(chan os.Signal, 1)
+	pauses := make(chan os.Signal, 1)
+	checkResults = newReportQueue(checkCtx)
+
+	// Only the signals with a meaning are trapped, the others keep their
+	// default behaviour: SIGINT and SIGTERM shut down, SIGHUP reloads,
+	// SIGUSR1 pauses and SIGUSR2 resumes.
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	// The remote TLS sources are refreshed between two checks.
+	var tlsRefresh <-chan time.Time
+	if clientTLS.remote() && *tlsRefreshInterval > 0 {
+		ticker := time.NewTicker(*tlsRefreshInterval)
+		defer ticker.Stop()
+		tlsRefresh = ticker.C
+	}
 
-	signal.Notify(signalCh)
+	go func() {
+		defer close(stopped)
+		defer checkResults.close()
+		for {
+			select {
+			case <-stop:
+				return
+
+			case <-tlsRefresh:
+				clientTLS.refresh(checkCtx)
+
+			case <-schedule.C():
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				result, ok := runCheckRecovered(checkCtx, discovery, probes)
+				schedule.next()
+				if liveness != nil {
+					liveness.ran(*interval)
+				}
+				if ok && *exitAfterFailures > 0 && result.ConsecutiveFailures >= *exitAfterFailures {
+					logFailureStreak(result)
+					close(gaveUp)
+					return
+				}
+
+			case s := <-pauses:
+				if s == syscall.SIGUSR1 {
+					daemonState.pause(*maxPause)
+				} else {
+					daemonState.resume()
+				}
+
+			case <-hangup:
+				if reloader != nil {
+					reloader.reload()
+				}
+				clientTLS.refresh(checkCtx)
+				if jsonlSink != nil {
+					if err := jsonlSink.Reopen(); err != nil {
+						log.Printf("[ERROR] Failed to reopen the JSON lines output, keeping the old file: %s", err)
+					}
+				}
+				if etcdAuth != nil {
+					if err := etcdAuth.load(); err != nil {
+						log.Printf("[ERROR] Failed to reload the etcd password, keeping the old one: %s", err)
+						continue
+					}
+					if etcdClients != nil {
+						reconnectEtcdClients(tlsConfig)
+					}
+					log.Printf("[INFO] Reloaded the etcd password")
+				}
+			}
+		}
+	}()
 
+	exitCode := exitShutdownClean
+signals:
 	for {
 		select {
-		case <-ticker.C:
-			checkEtcdHealth()
-
 		case s := <-signalCh:
 			log.Printf("[DEBUG] receiving signal: %q", s)
-			ticker.Stop()
-			os.Exit(0)
-			return
+			switch s {
+			case syscall.SIGHUP:
+				// A reload already pending covers this one.
+				select {
+				case hangup <- s:
+				default:
+				}
+			case syscall.SIGUSR1, syscall.SIGUSR2:
+				// Only the last of the pending pauses and resumes counts.
+				select {
+				case <-pauses:
+				default:
+				}
+				pauses <- s
+			case syscall.SIGINT, syscall.SIGTERM:
+				log.Printf("[INFO] Shutting down on %s, waiting up to %s for the running check", s, *shutdownGracePeriod)
+				break signals
+			}
+		case <-gaveUp:
+			log.Printf("[INFO] Shutting down after %d failed checks", *exitAfterFailures)
+			exitCode = exitTooManyFailures
+			break signals
+		}
+	}
+
+	// The running check and the queued results share the grace period. The
+	// queue is closed once the checks stopped.
+	close(stop)
+	schedule.Stop()
+	select {
+	case <-checkResults.done:
+	case <-time.After(*shutdownGracePeriod):
+		select {
+		case <-stopped:
+			log.Printf("[WARN] The queued results were not reported within the grace period of %s, cancelling them", *shutdownGracePeriod)
+		default:
+			log.Printf("[WARN] The running check did not finish within the grace period of %s, cancelling it", *shutdownGracePeriod)
+		}
+		exitCode = exitShutdownTimeout
+		cancelChecks()
+		select {
+		case <-checkResults.done:
+		case <-time.After(shutdownTimeout):
+			log.Printf("[WARN] The running check did not stop, exiting anyway")
+		}
+	}
+	cancelChecks()
+
+	if controlListener != nil {
+		controlListener.Close()
+	}
+	// The datapoints of an incomplete publish window are published too.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if liveness != nil {
+		liveness.Shutdown(shutdownCtx)
+	}
+	reporters.Flush(shutdownCtx)
+	if err := reporters.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[ERROR] %s", err)
+	}
+	cancel()
+	if *createAlarm && *deleteAlarmOnExit {
+		if err := deleteAlarm(context.Background(), cw, *alarmName); err != nil {
+			log.Printf("[ERROR] Failed to delete alarm %s: %s", *alarmName, err)
+		}
+	}
+	closeEtcdConnections()
+	log.Printf("[INFO] Shut down")
+	os.Exit(exitCode)
+}
+
+// runCheck performs one check and hands the result to the reporters, through
+// checkResults if set, publishing the collected metrics once the publish
+// window is complete. The endpoints are refreshed first if discovery is
+// enabled. The probes run after the health check and add their own metrics.
+// Cancelling ctx cancels the health check and an inline publish. The result of
+// the health check is returned, false if it was cancelled.
+func runCheck(ctx context.Context, discovery Probe, probes []Probe) (CheckResult, bool) {
+	if discovery != nil {
+		runProbe(discovery)
+	}
+	maintenance.reload()
+	result, ok := checkEtcdHealth(ctx)
+	if !ok {
+		return result, false
+	}
+	for _, probe := range probes {
+		runProbe(probe)
+	}
+
+	if result.State == healthStateStarting && *startupGraceMode == startupGraceModeSuppress {
+		log.Printf("[INFO] Not reporting the failed check within the startup grace period")
+		return result, true
+	}
+
+	r := queuedResult{result: result, paused: daemonState.checkPaused(time.Now())}
+	if r.paused {
+		reportPausedCheck(result)
+	}
+	checksSincePublish++
+	r.publish = time.Duration(checksSincePublish)*(*interval) >= *publishInterval
+	if r.publish {
+		checksSincePublish = 0
+	}
+	if checkResults != nil {
+		checkResults.push(r)
+	} else {
+		reportResult(ctx, r)
+	}
+
+	return result, true
+}
+
+// checkEtcdHealth checks every endpoint concurrently and returns the result,
+// with the unhealthy count derived from the endpoint results by the unhealthy
+// policy. False is returned if the check was cancelled through ctx.
+func checkEtcdHealth(ctx context.Context) (CheckResult, bool) {
+	// The results are stamped with the start of the check, not the time the
+	// slowest endpoint answered. Retries must not delay the next check.
+	start := time.Now()
+	deadline := start.Add(*interval)
+	results := make([]bool, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			defer func() {
+				if v := recover(); v != nil {
+					logPanic("The health check of "+endpoint, v)
+					checkFailed(endpoint, errorTypeOther, "The health check of %s panicked: %v", endpoint, v)
+					attemptMetrics.publish(endpoint)
+				}
+			}()
+			results[i] = checkEndpoint(ctx, endpoint, deadline)
+			if len(endpoints) > 1 {
+				if results[i] {
+					log.Printf("[INFO] etcd endpoint %s is healthy", endpoint)
+				} else {
+					log.Printf("[INFO] etcd endpoint %s IS NOT healthy", endpoint)
+				}
+			}
+		}(i, endpoint)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		log.Printf("[INFO] Health check cancelled by the shutdown, not reporting it")
+		return CheckResult{}, false
+	}
+
+	healthy := 0
+	for _, ok := range results {
+		if ok {
+			healthy++
 		}
 	}
 
+	count := unhealthyByPolicy(*unhealthyPolicy, healthy, len(endpoints))
+	if count > 0 {
+		log.Printf("[INFO] etcd IS NOT healthy")
+	} else {
+		log.Printf("[INFO] etcd is healthy")
+	}
+	reportQuorum(results)
+
+	return daemonState.observeCheck(start, results, count), true
 }
 
-func checkEtcdHealth() {
-	resp, err := client.Get(fmt.Sprintf("%s/health", *address))
+// checkEndpointHTTP checks the /health endpoint of the etcd member at
+// endpoint.
+func checkEndpointHTTP(ctx context.Context, endpoint string) bool {
+	var body io.Reader
+	if *healthMethod == http.MethodPost {
+		body = strings.NewReader("{}")
+	}
+	// The deadline covers reading the body too, a member can hang after
+	// sending the headers.
+	ctx, cancel := context.WithTimeout(ctx, *checkTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, *healthMethod, joinURL(endpoint, *healthPath), body)
 	if err != nil {
-		log.Printf("[ERROR] Failed to connect to etcd: %s", err)
-		reportUnhealtyCount(1.0)
-		return
+		checkFailed(endpoint, errorTypeOther, "Failed to build the health request for %s: %s", endpoint, err)
+		return false
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	var trace connTrace
+	req = withConnTrace(req, &trace)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if trace.reused {
+		log.Printf("[DEBUG] Health check of %s reused a connection idle for %s", endpoint, trace.idleTime.Round(time.Millisecond))
+	} else {
+		log.Printf("[DEBUG] Health check of %s opened a new connection", endpoint)
+	}
+	if err != nil {
+		reportHealthCheckLatency(endpoint, time.Since(start))
+		if hint := describeTLSError(err); hint != "" {
+			checkFailed(endpoint, classifyError(err), "Failed to connect to etcd at %s, %s: %s", endpoint, hint, err)
+		} else {
+			checkFailed(endpoint, classifyError(err), "Failed to connect to etcd at %s: %s", endpoint, err)
+		}
+		return false
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Only the start of the body, an auth proxy may answer with a whole
+		// HTML page.
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxLoggedBody))
+		reportHealthCheckLatency(endpoint, time.Since(start))
+		switch {
+		case resp.StatusCode >= 300 && resp.StatusCode <= 399:
+			checkFailed(endpoint, httpStatusErrorType(resp.StatusCode),
+				"Health check of %s was redirected to %q, the address must point at etcd itself: %s",
+				endpoint, resp.Header.Get("Location"), resp.Status)
+		case resp.StatusCode == http.StatusUnauthorized:
+			checkFailed(endpoint, errorTypeAuth, "Authentication on %s failed, check -username and the password file: %s: %s",
+				endpoint, resp.Status, strings.TrimSpace(string(body)))
+		default:
+			checkFailed(endpoint, httpStatusErrorType(resp.StatusCode), "Health check of %s returned %s: %s",
+				endpoint, resp.Status, strings.TrimSpace(string(body)))
+		}
+		return false
+	}
+
 	buff, err := ioutil.ReadAll(resp.Body)
+	reportHealthCheckLatency(endpoint, time.Since(start))
 	if err != nil {
-		log.Printf("[ERROR] Failed to get etcd health: %s", err)
-		reportUnhealtyCount(1.0)
-		return
+		checkFailed(endpoint, classifyError(err), "Failed to get etcd health from %s: %s", endpoint, err)
+		return false
 	}
 
 	var status Health
 	err = json.Unmarshal(buff, &status)
 	if err != nil {
-		log.Printf("[ERROR] Invalid health response payload: %s", err)
-		reportUnhealtyCount(1.0)
-		return
+		checkFailed(endpoint, errorTypeParse, "Invalid health response payload from %s: %s", endpoint, err)
+		return false
+	}
+	if !status.HasHealth {
+		checkFailed(endpoint, errorTypeParse, "Health response payload from %s has no health field: %s",
+			endpoint, strings.TrimSpace(string(buff)))
+		return false
+	}
+	if !status.IsHealthy {
+		reason := status.Reason
+		if reason == "" {
+			reason = "no reason given"
+		}
+		log.Printf("[INFO] etcd endpoint %s reports unhealthy: %s", endpoint, reason)
+		daemonState.observeError(endpoint, "", "etcd reports unhealthy: "+reason)
 	}
 
-	if status.IsHealthy {
-		reportUnhealtyCount(0.0)
-	} else {
-		reportUnhealtyCount(1.0)
+	return status.IsHealthy
+}
+
+// shouldPublishHeartbeat reports whether an unchanged health state observed at
+// now must be published, given when the health metrics were last published.
+func shouldPublishHeartbeat(last, now time.Time) bool {
+	if *publishMode != publishModeChanges {
+		return true
 	}
+
+	return now.Sub(last) >= *heartbeatInterval
 }
 
-func reportUnhealtyCount(count float64) {
-	if count > 0 {
-		log.Printf("[INFO] etcd IS NOT healthy")
-	} else {
-		log.Printf("[INFO] etcd is healthy")
+// reportHealthCheckLatency records how long the health request of an attempt
+// took, up to the point where it failed if it did.
+func reportHealthCheckLatency(endpoint string, latency time.Duration) {
+	daemonState.observeLatency(endpoint, latency)
+	ms := float64(latency) / float64(time.Millisecond)
+	attemptMetrics.add(endpoint, newEndpointMetricData(endpoint, "HealthCheckLatency", ms, types.StandardUnitMilliseconds)...)
+}
+
+// reportPublishFailures publishes how many PutMetricData requests were
+// throttled or timed out and how many datapoints were dropped since the last
+// cycle.
+func reportPublishFailures() {
+	throttled, timedOut, dropped := metrics.TakeStats()
+	if throttled == 0 && timedOut == 0 && dropped == 0 {
+		return
 	}
 
-	params := &cloudwatch.PutMetricDataInput{
-		MetricData: []*cloudwatch.MetricDatum{
-			{
-				MetricName: aws.String("UnhealthyCount"),
-				Dimensions: []*cloudwatch.Dimension{
-					{
-						Name:  aws.String("By cluster"),
-						Value: aws.String(*etcdName),
-					},
-				},
-				StatisticValues: &cloudwatch.StatisticSet{
-					Maximum:     aws.Float64(count),
-					Minimum:     aws.Float64(count),
-					SampleCount: aws.Float64(1.0),
-					Sum:         aws.Float64(count),
-				},
-				Timestamp: aws.Time(time.Now()),
-				Unit:      aws.String("Count"),
+	log.Printf("[WARN] %d throttled publishes, %d timed out publishes and %d dropped datapoints since the last publish",
+		throttled, timedOut, dropped)
+
+	for name, count := range map[string]int{
+		"ThrottledPublishes": throttled,
+		"PublishTimeouts":    timedOut,
+		"DroppedDatapoints":  dropped,
+	} {
+		metrics.Add(newMetricData(name, float64(count), types.StandardUnitCount)...)
+	}
+}
+
+// newMetricData builds the datums for a single observation of a metric made
+// now, one for every configured dimension set.
+func newMetricData(name string, value float64, unit types.StandardUnit) []types.MetricDatum {
+	return buildMetricData(metricDimensionSets(), name, value, unit, time.Now())
+}
+
+// newEndpointMetricData is like newMetricData for an observation made on a
+// single etcd endpoint, adding a per-endpoint datum if enabled.
+func newEndpointMetricData(endpoint, name string, value float64, unit types.StandardUnit) []types.MetricDatum {
+	return buildMetricData(endpointDimensionSets(endpoint), name, value, unit, time.Now())
+}
+
+// buildMetricData builds the datums for a single observation of a metric made
+// at t, one for every dimension set in sets. t is the time of the observation
+// rather than the time it is reported, e.g. the start of the check.
+func buildMetricData(sets [][]types.Dimension, name string, value float64, unit types.StandardUnit, t time.Time) []types.MetricDatum {
+	var data []types.MetricDatum
+	for _, dims := range sets {
+		data = append(data, types.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dims,
+			StatisticValues: &types.StatisticSet{
+				Maximum:     aws.Float64(value),
+				Minimum:     aws.Float64(value),
+				SampleCount: aws.Float64(1.0),
+				Sum:         aws.Float64(value),
 			},
-		},
-		Namespace: aws.String(*namespace),
+			StorageResolution: storageResolution(),
+			Timestamp:         aws.Time(t),
+			Unit:              unit,
+		})
 	}
 
-	_, err := cw.PutMetricData(params)
-	if err != nil {
-		log.Println(err.Error())
-		return
+	return data
+}
+
+// storageResolution returns the StorageResolution set on every datum.
+func storageResolution() *int32 {
+	if *highResolution {
+		return aws.Int32(1)
 	}
+
+	return aws.Int32(60)
 }
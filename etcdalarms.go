@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// etcdAlarmTypes are the etcd alarm types published as individual 0/1
+// metrics, e.g. AlarmNOSPACE.
+var etcdAlarmTypes = []string{"NOSPACE", "CORRUPT"}
+
+// EtcdAlarm is an alarm raised by an etcd member.
+type EtcdAlarm struct {
+	MemberID uint64 `json:"memberID,string"`
+	Alarm    string `json:"alarm"`
+}
+
+// AlarmListResponse is the response of the alarm list call.
+type AlarmListResponse struct {
+	Alarms []EtcdAlarm `json:"alarms"`
+}
+
+// EtcdAlarmProbe publishes the alarms active in the etcd cluster. Some etcd
+// versions still report healthy while a NOSPACE alarm makes the cluster
+// read-only.
+type EtcdAlarmProbe struct {
+	endpoint string
+}
+
+// NewEtcdAlarmProbe returns a probe listing the alarms through the etcd member
+// at endpoint.
+func NewEtcdAlarmProbe(endpoint string) *EtcdAlarmProbe {
+	return &EtcdAlarmProbe{endpoint: endpoint}
+}
+
+// getAlarms lists the active alarms through the v3 gRPC gateway of the etcd
+// member at endpoint.
+func getAlarms(endpoint string) ([]EtcdAlarm, error) {
+	resp, err := client.Post(fmt.Sprintf("%s/v3/maintenance/alarm", endpoint), "application/json", strings.NewReader(`{"action":"GET"}`))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(buff)))
+	}
+
+	var list AlarmListResponse
+	if err := json.Unmarshal(buff, &list); err != nil {
+		return nil, fmt.Errorf("invalid alarm list response payload: %s", err)
+	}
+
+	return list.Alarms, nil
+}
+
+// Run lists the alarms and publishes ActiveAlarms plus a 0/1 metric per alarm
+// type. Nothing but the failure is published if the alarms cannot be listed,
+// so that dashboards do not show a false all-clear.
+func (p *EtcdAlarmProbe) Run() {
+	alarms, err := getAlarms(p.endpoint)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list etcd alarms: %s", err)
+		metrics.Add(newEndpointMetricData(p.endpoint, "AlarmProbeFailures", 1, types.StandardUnitCount)...)
+		return
+	}
+
+	active := make(map[string]bool)
+	count := 0
+	for _, alarm := range alarms {
+		if alarm.Alarm == "" || alarm.Alarm == "NONE" {
+			continue
+		}
+		log.Printf("[WARN] etcd alarm %s is active on member %x", alarm.Alarm, alarm.MemberID)
+		active[alarm.Alarm] = true
+		count++
+	}
+
+	metrics.Add(newMetricData("ActiveAlarms", float64(count), types.StandardUnitCount)...)
+	for _, alarmType := range etcdAlarmTypes {
+		value := 0.0
+		if active[alarmType] {
+			value = 1.0
+		}
+		metrics.Add(newMetricData("Alarm"+alarmType, value, types.StandardUnitNone)...)
+	}
+}
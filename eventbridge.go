@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+const (
+	// eventBridgeSource and eventBridgeDetailType identify the events in
+	// EventBridge rules.
+	eventBridgeSource     = "etcd-monitor"
+	eventBridgeDetailType = "EtcdHealthStateChange"
+)
+
+// EventBridgeAPI is the part of the EventBridge API used by the monitor.
+type EventBridgeAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// eventBridgeDetail is the detail of an event. Rules match on it, so fields
+// are only ever added.
+type eventBridgeDetail struct {
+	Cluster  string `json:"cluster"`
+	Endpoint string `json:"endpoint"`
+	// OldState is "healthy", "unhealthy" or "unknown" for an endpoint
+	// checked for the first time. It equals NewState for reminders.
+	OldState            string `json:"oldState"`
+	NewState            string `json:"newState"`
+	Reason              string `json:"reason,omitempty"`
+	ErrorType           string `json:"errorType,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	ClusterHealthy      bool   `json:"clusterHealthy"`
+	// Reminder is set for the events about an endpoint that is still
+	// unhealthy.
+	Reminder bool `json:"reminder"`
+}
+
+// EventBridgeNotifier puts an event on an event bus when an endpoint becomes
+// unhealthy or recovers, and optionally a reminder while it stays unhealthy,
+// for rules triggering automation.
+type EventBridgeNotifier struct {
+	client   EventBridgeAPI
+	bus      string
+	reminder time.Duration
+	queue    *notificationQueue
+
+	states endpointStates
+	// lastSent is when the last event about each unhealthy endpoint was
+	// queued.
+	lastSent map[string]time.Time
+}
+
+// NewEventBridgeNotifier returns a notifier putting events on bus, a name or
+// ARN, with client. With a reminder interval, an event is repeated while the
+// endpoint stays unhealthy.
+func NewEventBridgeNotifier(client EventBridgeAPI, bus string, reminder time.Duration) *EventBridgeNotifier {
+	return &EventBridgeNotifier{
+		client:   client,
+		bus:      bus,
+		reminder: reminder,
+		queue:    newNotificationQueue("EventBridge"),
+		lastSent: make(map[string]time.Time),
+	}
+}
+
+// newEventBridgeReporter returns the notifier of -eventbridge-bus, which only
+// logs the events with -dry-run.
+func newEventBridgeReporter(cfg aws.Config) (Reporter, error) {
+	var client EventBridgeAPI = &dryRunClient{}
+	if !*dryRun {
+		client = newEventBridgeClient(cfg)
+	}
+
+	return NewEventBridgeNotifier(client, *eventBridgeBus, *eventBridgeReminder), nil
+}
+
+// newEventBridgeClient returns the client events are put with. Retries are
+// handled by the notification queue.
+func newEventBridgeClient(cfg aws.Config) *eventbridge.Client {
+	return eventbridge.NewFromConfig(cfg, func(o *eventbridge.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+}
+
+// Report queues an event for every endpoint that changed state, and a
+// reminder for every endpoint unhealthy for another reminder interval.
+func (n *EventBridgeNotifier) Report(ctx context.Context, result CheckResult) error {
+	if dropped := n.queue.takeDropped(); dropped > 0 {
+		metrics.Add(newMetricData("DroppedEventBridgeEvents", float64(dropped), cwtypes.StandardUnitCount)...)
+	}
+
+	lastSent := make(map[string]time.Time)
+	for _, c := range n.states.observe(result) {
+		reminder := false
+		if c.State == "unhealthy" {
+			last := n.lastSent[c.Endpoint]
+			reminder = !c.Changed && n.reminder > 0 && result.Time.Sub(last) >= n.reminder
+			if c.Changed || reminder {
+				last = result.Time
+			}
+			lastSent[c.Endpoint] = last
+		}
+		if !c.Changed && !reminder {
+			continue
+		}
+
+		detail := eventBridgeDetail{
+			Cluster:             *etcdName,
+			Endpoint:            c.Endpoint,
+			OldState:            c.Previous,
+			NewState:            c.State,
+			Reason:              c.Status.Error,
+			ErrorType:           c.Status.ErrorType,
+			ConsecutiveFailures: c.Status.ConsecutiveFailures,
+			ClusterHealthy:      result.UnhealthyCount == 0,
+			Reminder:            reminder,
+		}
+		what := c.State + " event for " + c.Endpoint
+		if reminder {
+			what = "still unhealthy event for " + c.Endpoint
+		}
+		n.queue.push(what, func(ctx context.Context) error {
+			return n.put(ctx, result.Time, detail)
+		})
+	}
+	n.lastSent = lastSent
+
+	return nil
+}
+
+// Shutdown delivers the pending notifications until ctx is done.
+func (n *EventBridgeNotifier) Shutdown(ctx context.Context) error {
+	return n.queue.Shutdown(ctx)
+}
+
+func (n *EventBridgeNotifier) put(ctx context.Context, t time.Time, detail eventBridgeDetail) error {
+	body, err := json.Marshal(detail)
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+
+	out, err := n.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			EventBusName: aws.String(n.bus),
+			Source:       aws.String(eventBridgeSource),
+			DetailType:   aws.String(eventBridgeDetailType),
+			Detail:       aws.String(string(body)),
+			Time:         aws.Time(t),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	// PutEvents succeeds even if the entries fail, e.g. when throttled.
+	if out.FailedEntryCount > 0 && len(out.Entries) > 0 {
+		entry := out.Entries[0]
+		return fmt.Errorf("%s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckResult is the outcome of one health check of every endpoint.
+type CheckResult struct {
+	// Time is when the check started, the timestamp of everything reported
+	// about it.
+	Time time.Time
+	// UnhealthyCount is derived from the endpoint results by the unhealthy
+	// policy, and only changes after -failure-threshold failed or
+	// -recovery-threshold passed checks in a row. RawUnhealthyCount is that
+	// of this check alone, and State the state of the cluster.
+	UnhealthyCount    float64
+	RawUnhealthyCount float64
+	State             string
+	// ConsecutiveFailures counts the failed checks in a row up to this one,
+	// regardless of the thresholds, since FailingSince.
+	ConsecutiveFailures int
+	FailingSince        time.Time
+	Endpoints           []EndpointStatus
+}
+
+// Reporter sends check results to a destination such as CloudWatch or
+// StatsD.
+type Reporter interface {
+	Report(ctx context.Context, result CheckResult) error
+}
+
+// flusher is implemented by reporters that batch the results and send them
+// once per publish window.
+type flusher interface {
+	Flush(ctx context.Context)
+}
+
+// shutdowner is implemented by reporters holding resources that must be
+// released on exit.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// FanOutReporter dispatches every check result to several reporters
+// concurrently. Each reporter has its own timeout and error log, so that a
+// failing or hanging destination does not hold up or hide the others.
+type FanOutReporter struct {
+	timeout   time.Duration
+	reporters []*fanOutEntry
+}
+
+// fanOutEntry is a reporter of a FanOutReporter. busy is set while a Report
+// call runs, which may outlive the timeout if the reporter ignores its
+// context; results arriving in the meantime are skipped rather than run
+// concurrently.
+type fanOutEntry struct {
+	name     string
+	reporter Reporter
+	errors   sinkErrorLog
+
+	mu   sync.Mutex
+	busy bool
+}
+
+// NewFanOutReporter returns a reporter without destinations, waiting up to
+// timeout for every destination.
+func NewFanOutReporter(timeout time.Duration) *FanOutReporter {
+	return &FanOutReporter{timeout: timeout}
+}
+
+// Add adds reporter under name, which is used in log messages.
+func (f *FanOutReporter) Add(name string, reporter Reporter) {
+	f.reporters = append(f.reporters, &fanOutEntry{
+		name:     name,
+		reporter: reporter,
+		errors:   sinkErrorLog{name: name},
+	})
+}
+
+// Report sends result to every reporter and waits until each one finished or
+// ran out of time. Failures are logged per reporter; the returned error names
+// the reporters that failed.
+func (f *FanOutReporter) Report(ctx context.Context, result CheckResult) error {
+	failed := make([]bool, len(f.reporters))
+	var wg sync.WaitGroup
+	for i, e := range f.reporters {
+		wg.Add(1)
+		go func(i int, e *fanOutEntry) {
+			defer wg.Done()
+			if err := f.report(ctx, e, result); err != nil {
+				e.errors.log(err)
+				failed[i] = true
+			}
+		}(i, e)
+	}
+	wg.Wait()
+
+	var names []string
+	for i, e := range f.reporters {
+		if failed[i] {
+			names = append(names, e.name)
+		}
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("failed to report to %s", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// report runs a single Report call, giving up waiting after the timeout.
+func (f *FanOutReporter) report(ctx context.Context, e *fanOutEntry, result CheckResult) error {
+	e.mu.Lock()
+	if e.busy {
+		e.mu.Unlock()
+		return fmt.Errorf("still busy with a previous result, skipping this one")
+	}
+	e.busy = true
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() {
+			if v := recover(); v != nil {
+				logPanic("The "+e.name+" reporter", v)
+				err = fmt.Errorf("panicked: %v", v)
+			}
+			e.mu.Lock()
+			e.busy = false
+			e.mu.Unlock()
+			done <- err
+		}()
+
+		err = e.reporter.Report(ctx, result)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", f.timeout)
+	}
+}
+
+// Flush flushes every reporter that batches its results, concurrently, until
+// ctx is done.
+func (f *FanOutReporter) Flush(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range f.reporters {
+		if r, ok := e.reporter.(flusher); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					if v := recover(); v != nil {
+						logPanic("The "+e.name+" reporter", v)
+					}
+				}()
+				r.Flush(ctx)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// Shutdown releases the resources of every reporter holding any.
+func (f *FanOutReporter) Shutdown(ctx context.Context) error {
+	var names []string
+	for _, e := range f.reporters {
+		if r, ok := e.reporter.(shutdowner); ok {
+			if err := r.Shutdown(ctx); err != nil {
+				names = append(names, fmt.Sprintf("%s (%s)", e.name, err))
+			}
+		}
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("failed to shut down %s", strings.Join(names, ", "))
+	}
+
+	return nil
+}
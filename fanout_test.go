@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingReporter keeps the results reported to it.
+type recordingReporter struct {
+	mu      sync.Mutex
+	results []CheckResult
+}
+
+func (r *recordingReporter) Report(ctx context.Context, result CheckResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.results = append(r.results, result)
+
+	return nil
+}
+
+// reported returns the results reported so far.
+func (r *recordingReporter) reported() []CheckResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]CheckResult(nil), r.results...)
+}
+
+// hangingReporter hangs in Report until release is closed, ignoring its
+// context like a client stuck on a transport without a deadline.
+type hangingReporter struct {
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (r *hangingReporter) Report(ctx context.Context, result CheckResult) error {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+
+	<-r.release
+
+	return nil
+}
+
+func (r *hangingReporter) reportCalls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.calls
+}
+
+func TestFanOutHangingReporter(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	hanging := &hangingReporter{release: make(chan struct{})}
+	recording := &recordingReporter{}
+	fanOut := NewFanOutReporter(timeout)
+	fanOut.Add("hanging", hanging)
+	fanOut.Add("recording", recording)
+
+	start := time.Now()
+	err := fanOut.Report(context.Background(), testResult())
+	if elapsed := time.Since(start); elapsed > 10*timeout {
+		t.Errorf("Report returned after %s, want about the %s timeout", elapsed, timeout)
+	}
+	if err == nil || !strings.Contains(err.Error(), "hanging") {
+		t.Errorf("Report() = %v, want the hanging reporter failed", err)
+	}
+
+	// The first Report call is still running, so the next result is skipped
+	// rather than piling up another call.
+	start = time.Now()
+	err = fanOut.Report(context.Background(), testResult())
+	if elapsed := time.Since(start); elapsed >= timeout {
+		t.Errorf("Report returned after %s, want right away while the reporter is busy", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "hanging") {
+		t.Errorf("Report() = %v, want the busy reporter failed", err)
+	}
+	if calls := hanging.reportCalls(); calls != 1 {
+		t.Errorf("the hanging reporter was called %d times, want once", calls)
+	}
+	if n := len(recording.reported()); n != 2 {
+		t.Errorf("the other reporter got %d results, want 2", n)
+	}
+
+	// Once the call returns, the reporter gets the next result again.
+	close(hanging.release)
+	deadline := time.Now().Add(time.Second)
+	for fanOut.Report(context.Background(), testResult()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the reporter is still busy after the hanging call returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls := hanging.reportCalls(); calls != 2 {
+		t.Errorf("the released reporter was called %d times, want twice", calls)
+	}
+}
@@ -0,0 +1,1220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// The flags of the monitor, defined by defineFlags.
+var (
+	configFile                *string
+	interval                  *time.Duration
+	jitterValue               *string
+	checkTimeout              *time.Duration
+	checkRetries              *int
+	checkRetryDelay           *time.Duration
+	failureThreshold          *int
+	recoveryThreshold         *int
+	publishInterval           *time.Duration
+	checkMode                 *string
+	address                   *string
+	healthPath                *string
+	healthMethod              *string
+	unhealthyPolicy           *string
+	discoverySRV              *string
+	discoverySRVName          *string
+	discoverySRVInterval      *time.Duration
+	discover                  *bool
+	discoverEvery             *int
+	discoverTimeout           *time.Duration
+	caFile                    *string
+	certFile                  *string
+	keyFile                   *string
+	caSecretARN               *string
+	certSecretARN             *string
+	keySecretARN              *string
+	caSSMParam                *string
+	certSSMParam              *string
+	keySSMParam               *string
+	tlsRefreshInterval        *time.Duration
+	insecureSkipVerify        *bool
+	maxIdleConns              *int
+	idleConnTimeout           *time.Duration
+	tcpKeepAlive              *time.Duration
+	tlsHandshakeTimeout       *time.Duration
+	tlsServerName             *string
+	tlsServerNameFile         *string
+	tlsMinVersion             *string
+	tlsCipherSuites           *string
+	noKeepAlive               *bool
+	username                  *string
+	passwordFile              *string
+	etcdName                  *string
+	dimensionName             *string
+	namespace                 *string
+	metricName                *string
+	healthyMetric             *bool
+	publishMode               *string
+	heartbeatInterval         *time.Duration
+	enableStatusProbe         *bool
+	leaderMissingThreshold    *time.Duration
+	backendQuotaBytes         *int64
+	dbQuotaWarnPercent        *float64
+	tlsExpiryWarnDays         *int
+	defragWarnPercent         *float64
+	enableLeaderProbe         *bool
+	leaderProbeTimeout        *time.Duration
+	enableMemberProbe         *bool
+	expectedMembers           *int
+	enableRevisionLagProbe    *bool
+	revisionLagThreshold      *int
+	revisionLagTolerance      *int
+	enableAppliedLagProbe     *bool
+	appliedLagTolerance       *int
+	appliedLagThreshold       *int
+	appliedLagIntervals       *int
+	checkPeerURLs             *bool
+	peerCAFile                *string
+	peerCertFile              *string
+	peerKeyFile               *string
+	consistencyCheckInterval  *time.Duration
+	consistencyCheckTimeout   *time.Duration
+	enableVersionProbe        *bool
+	strictVersionCheck        *bool
+	enableVersionInfo         *bool
+	versionInfoEvery          *int
+	enableEtcdAlarmProbe      *bool
+	enableReadProbe           *bool
+	readProbeKey              *string
+	readProbeAPI              *string
+	readProbeConsistency      *string
+	readProbeTimeout          *time.Duration
+	enableWriteProbe          *bool
+	writeProbeKey             *string
+	writeProbeAPI             *string
+	writeProbeTTL             *time.Duration
+	writeProbeTimeout         *time.Duration
+	scrapeMetricsEnabled      *bool
+	scrapeMetricsURL          *string
+	forwardMetrics            *StringList
+	awsRegion                 *string
+	extraDimensions           *DimensionList
+	assumeRoleARN             *string
+	externalID                *string
+	cloudwatchEndpoint        *string
+	dryRun                    *bool
+	emf                       *bool
+	noCloudWatch              *bool
+	prometheusListen          *string
+	listen                    *string
+	statsdAddress             *string
+	statsdPrefix              *string
+	dogstatsd                 *bool
+	dogstatsdAddress          *string
+	influxURL                 *string
+	influxOrg                 *string
+	influxBucket              *string
+	influxTokenFile           *string
+	graphiteAddress           *string
+	graphitePrefix            *string
+	graphiteProtocol          *string
+	jsonlOutput               *string
+	snsTopicARN               *string
+	eventBridgeBus            *string
+	eventBridgeReminder       *time.Duration
+	sqsQueueURL               *string
+	sesFrom                   *string
+	sesTo                     *StringList
+	sesAfter                  *time.Duration
+	sesReminder               *time.Duration
+	slackWebhookURL           *string
+	slackWebhookURLFile       *string
+	slackCooldown             *time.Duration
+	pagerDutyRoutingKey       *string
+	pagerDutyRoutingKeyFile   *string
+	pagerDutyFailureThreshold *int
+	pagerDutyEventsURL        *string
+	webhookURL                *string
+	webhookHeaders            *StringList
+	webhookSecretFile         *string
+	webhookEveryCheck         *bool
+	syslogEnabled             *bool
+	syslogAddress             *string
+	syslogTag                 *string
+	stateFile                 *string
+	stateFileMode             *string
+	pushgatewayURL            *string
+	pushgatewayUsername       *string
+	pushgatewayPasswordFile   *string
+	pushgatewayTokenFile      *string
+	pushgatewayDeleteOnExit   *bool
+	logsGroup                 *string
+	logsStream                *string
+	logsCreate                *bool
+	logsEvents                *string
+	reporterTimeout           *time.Duration
+	maxRetries                *int
+	cloudwatchTimeout         *time.Duration
+	addInstanceDimension      *bool
+	addAZDimension            *bool
+	availabilityZoneOverride  *string
+	highResolution            *bool
+	endpointDimension         *bool
+	bufferSize                *int
+	bufferFile                *string
+	createAlarm               *bool
+	alarmName                 *string
+	alarmThreshold            *float64
+	alarmEvaluationPeriods    *int
+	alarmTopicARN             *string
+	deleteAlarmOnExit         *bool
+	once                      *bool
+	exitAfterFailures         *int
+	maintenanceFilePath       *string
+	maintenanceReport         *string
+	maxPause                  *time.Duration
+	startupGracePeriod        *time.Duration
+	startupGraceMode          *string
+	shutdownGracePeriod       *time.Duration
+	dashboardName             *string
+	printOnly                 *bool
+	controlSocket             *string
+	checkWarning              *time.Duration
+	checkCritical             *time.Duration
+	checkPublish              *bool
+)
+
+// defineFlags defines the flags of the monitor. Their defaults are taken from
+// the environment, so the configuration file is loaded before.
+func defineFlags() {
+	configFile = flag.String("config-file", envString("CONFIG_FILE", ""),
+		"File of KEY=value lines setting the environment variables configuring the monitor, as a systemd "+
+			"EnvironmentFile. It takes precedence over the environment, flags over both. On SIGHUP it is read "+
+			"again and changes of the interval, jitter, address, thresholds and retries are applied; other "+
+			"changes need a restart. Overrides the CONFIG_FILE environment variable if set.")
+
+	interval = newIntervalFlag("interval", envInterval("CHECK_INTERVAL", time.Minute),
+		"Time interval of how often to run the check, e.g. 500ms, 30s or 5m. A bare number is in seconds. "+
+			"Overrides the CHECK_INTERVAL environment variable if set.")
+
+	jitterValue = flag.String("jitter", envString("JITTER", ""),
+		"Random delay of up to this duration, or percentage of -interval, e.g. 5s or 10%, added to every check and "+
+			"drawn again each time, so that the monitors of a cluster do not all check and publish in the same second. "+
+			"The first check is delayed the same way; checks still run once per interval on average. "+
+			"With -high-resolution, alarms on periods close to the interval can see a period with no check or two; "+
+			"use a period of at least the interval plus the jitter. "+
+			"Overrides the JITTER environment variable if set.")
+
+	checkTimeout = flag.Duration("timeout", envDuration("CHECK_TIMEOUT", 0),
+		"Deadline of every request to etcd, including reading the response, e.g. 2s. Must be less than -interval "+
+			"(default: 5s, or half the check interval for intervals of 5 seconds or less). "+
+			"Overrides the CHECK_TIMEOUT environment variable if set.")
+
+	checkRetries = flag.Int("check-retries", envInt("CHECK_RETRIES", 0),
+		"How often to retry a failed health check of an endpoint before it counts as unhealthy, so that a single "+
+			"dropped packet does not. Retries that could not finish before the next check are not made. "+
+			"Overrides the CHECK_RETRIES environment variable if set.")
+
+	checkRetryDelay = flag.Duration("check-retry-delay", envDuration("CHECK_RETRY_DELAY", time.Second),
+		"Time to wait before retrying a failed health check. "+
+			"Overrides the CHECK_RETRY_DELAY environment variable if set.")
+
+	failureThreshold = flag.Int("failure-threshold", envInt("FAILURE_THRESHOLD", 1),
+		"Number of consecutive failed checks after which the cluster, or an endpoint, is reported unhealthy, "+
+			"to suppress alarms and notifications on flapping. The raw result of every check is published as "+
+			"RawUnhealthyCount. Overrides the FAILURE_THRESHOLD environment variable if set.")
+
+	recoveryThreshold = flag.Int("recovery-threshold", envInt("RECOVERY_THRESHOLD", 1),
+		"Number of consecutive passed checks after which an unhealthy cluster, or endpoint, is reported healthy again. "+
+			"Overrides the RECOVERY_THRESHOLD environment variable if set.")
+
+	publishInterval = newIntervalFlag("publish-interval", envInterval("PUBLISH_INTERVAL", 0),
+		"Time interval of how often to publish the collected metrics, a multiple of -interval, e.g. 5m. A bare "+
+			"number is in seconds. "+
+			"The checks of each window are published as one statistic set per metric (default: the check interval). "+
+			"Overrides the PUBLISH_INTERVAL environment variable if set.")
+
+	checkMode = flag.String("mode", envString("CHECK_MODE", checkModeHTTP),
+		"How to check the health of etcd: \"http\" queries the /health endpoint, "+
+			"\"grpc\" performs a linearizable read through the gRPC API like \"etcdctl endpoint health\". "+
+			"Overrides the CHECK_MODE environment variable if set.")
+
+	address = flag.String("address", envString("ETCD_ADVERTISE_CLIENT_URLS", "https://127.0.0.1:2379"),
+		"The address of the etcd server, or a comma-separated list of addresses to check every endpoint. "+
+			"The probes query the first one. "+
+			"Overrides the ETCD_ADVERTISE_CLIENT_URLS environment variable if set.")
+
+	healthPath = flag.String("health-path", envString("HEALTH_PATH", "/health"),
+		"Path of the health endpoint, relative to the etcd address, with an optional query, e.g. /health?exclude=NOSPACE. "+
+			"Overrides the HEALTH_PATH environment variable if set.")
+
+	healthMethod = flag.String("health-method", envString("HEALTH_METHOD", http.MethodGet),
+		"HTTP method of the health check, GET or POST. POST sends an empty JSON object, for gateway-style endpoints. "+
+			"Overrides the HEALTH_METHOD environment variable if set.")
+
+	unhealthyPolicy = flag.String("unhealthy-policy", envString("UNHEALTHY_POLICY", unhealthyPolicyAny),
+		"When several addresses are checked, whether the cluster counts as unhealthy if \"any\" endpoint fails, "+
+			"only if \"all\" fail, or if a \"quorum\" of them fails. "+
+			"Overrides the UNHEALTHY_POLICY environment variable if set.")
+
+	discoverySRV = flag.String("discovery-srv", envString("DISCOVERY_SRV", ""),
+		"Domain whose _etcd-client-ssl._tcp and _etcd-client._tcp SRV records list the endpoints to check, "+
+			"like etcd's --discovery-srv. Replaces -address. "+
+			"Overrides the DISCOVERY_SRV environment variable if set.")
+
+	discoverySRVName = flag.String("discovery-srv-name", envString("DISCOVERY_SRV_NAME", ""),
+		"Suffix of the SRV service names, e.g. \"prod\" for _etcd-client-ssl-prod._tcp, like etcd's --discovery-srv-name. "+
+			"Overrides the DISCOVERY_SRV_NAME environment variable if set.")
+
+	discoverySRVInterval = flag.Duration("discovery-srv-interval", envDuration("DISCOVERY_SRV_INTERVAL", 5*time.Minute),
+		"How often to re-resolve the SRV records. "+
+			"Overrides the DISCOVERY_SRV_INTERVAL environment variable if set.")
+
+	discover = flag.Bool("discover", envBool("DISCOVER", false),
+		"Check the client URL of every cluster member instead of the configured addresses, which only seed "+
+			"the member list. "+
+			"Overrides the DISCOVER environment variable if set.")
+
+	discoverEvery = flag.Int("discover-every", envInt("DISCOVER_EVERY", 10),
+		"Number of check intervals between refreshes of the member list. "+
+			"Overrides the DISCOVER_EVERY environment variable if set.")
+
+	discoverTimeout = flag.Duration("discover-timeout", envDuration("DISCOVER_TIMEOUT", 5*time.Second),
+		"Timeout of the member list call made for discovery. "+
+			"Overrides the DISCOVER_TIMEOUT environment variable if set.")
+
+	caFile = flag.String("ca-file", envString("ETCDMON_CA_FILE", ""), "A PEM eoncoded CA's certificate file.")
+
+	certFile = flag.String("cert-file", envString("ETCDMON_CERT_FILE", ""), "A PEM eoncoded certificate file.")
+
+	keyFile = flag.String("key-file", envString("ETCDMON_KEY_FILE", ""), "A PEM encoded private key file.")
+
+	caSecretARN = flag.String("ca-secret-arn", envString("ETCDMON_CA_SECRET_ARN", ""),
+		"Secrets Manager secret holding the PEM encoded CA's certificate, in place of -ca-file. "+
+			"ARN#key reads it from a key of a JSON secret. "+
+			"Overrides the ETCDMON_CA_SECRET_ARN environment variable if set.")
+
+	certSecretARN = flag.String("cert-secret-arn", envString("ETCDMON_CERT_SECRET_ARN", ""),
+		"Secrets Manager secret holding the PEM encoded certificate, in place of -cert-file. "+
+			"ARN#key reads it from a key of a JSON secret. "+
+			"Overrides the ETCDMON_CERT_SECRET_ARN environment variable if set.")
+
+	keySecretARN = flag.String("key-secret-arn", envString("ETCDMON_KEY_SECRET_ARN", ""),
+		"Secrets Manager secret holding the PEM encoded private key, in place of -key-file. "+
+			"ARN#key reads it from a key of a JSON secret. "+
+			"Overrides the ETCDMON_KEY_SECRET_ARN environment variable if set.")
+
+	caSSMParam = flag.String("ca-ssm-param", envString("ETCDMON_CA_SSM_PARAM", ""),
+		"SSM parameter holding the PEM encoded CA's certificate, in place of -ca-file. "+
+			"Overrides the ETCDMON_CA_SSM_PARAM environment variable if set.")
+
+	certSSMParam = flag.String("cert-ssm-param", envString("ETCDMON_CERT_SSM_PARAM", ""),
+		"SSM parameter holding the PEM encoded certificate, in place of -cert-file. "+
+			"Overrides the ETCDMON_CERT_SSM_PARAM environment variable if set.")
+
+	keySSMParam = flag.String("key-ssm-param", envString("ETCDMON_KEY_SSM_PARAM", ""),
+		"SSM SecureString parameter holding the PEM encoded private key, in place of -key-file. "+
+			"Overrides the ETCDMON_KEY_SSM_PARAM environment variable if set.")
+
+	tlsRefreshInterval = flag.Duration("tls-refresh-interval", envDuration("TLS_REFRESH_INTERVAL", time.Hour),
+		"How often the TLS secrets and SSM parameters are fetched again, besides on SIGHUP. "+
+			"0 only fetches them on SIGHUP. "+
+			"Overrides the TLS_REFRESH_INTERVAL environment variable if set.")
+
+	insecureSkipVerify = flag.Bool("insecure-skip-verify", envBool("ETCDMON_INSECURE", false),
+		"Do not verify the certificates of etcd, e.g. for labs with self-signed certificates per node. "+
+			"Only affects etcd, never AWS. Cannot be combined with -ca-file. "+
+			"Overrides the ETCDMON_INSECURE environment variable if set.")
+
+	maxIdleConns = flag.Int("max-idle-conns", envInt("MAX_IDLE_CONNS", 100),
+		"Number of idle connections to etcd kept open for reuse, per endpoint and in total. "+
+			"Overrides the MAX_IDLE_CONNS environment variable if set.")
+
+	idleConnTimeout = flag.Duration("idle-conn-timeout", envDuration("IDLE_CONN_TIMEOUT", 90*time.Second),
+		"How long an idle connection to etcd is kept open, e.g. below the idle timeout of a NAT gateway "+
+			"that drops connections silently. "+
+			"Overrides the IDLE_CONN_TIMEOUT environment variable if set.")
+
+	tcpKeepAlive = flag.Duration("tcp-keepalive", envDuration("TCP_KEEPALIVE", 30*time.Second),
+		"Interval of the TCP keep-alive probes on connections to etcd, 0 disables them. "+
+			"Overrides the TCP_KEEPALIVE environment variable if set.")
+
+	tlsHandshakeTimeout = flag.Duration("tls-handshake-timeout", envDuration("TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		"Timeout of the TLS handshake with etcd. "+
+			"Overrides the TLS_HANDSHAKE_TIMEOUT environment variable if set.")
+
+	tlsServerName = flag.String("tls-server-name", envString("TLS_SERVER_NAME", ""),
+		"Name the certificates of etcd are verified for, and sent as SNI, in place of the host of the endpoints, "+
+			"e.g. when etcd is reached through a load balancer by IP. "+
+			"Overrides the TLS_SERVER_NAME environment variable if set.")
+
+	tlsServerNameFile = flag.String("tls-server-name-file", envString("TLS_SERVER_NAME_FILE", ""),
+		"File of server names by endpoint, one endpoint and its server name per line, like -tls-server-name "+
+			"for each endpoint. Cannot be combined with -tls-server-name. "+
+			"Overrides the TLS_SERVER_NAME_FILE environment variable if set.")
+
+	tlsMinVersion = flag.String("tls-min-version", envString("TLS_MIN_VERSION", ""),
+		"Minimum TLS version of the connections to etcd, 1.2 or 1.3. Empty keeps the Go default, 1.2. "+
+			"Overrides the TLS_MIN_VERSION environment variable if set.")
+
+	tlsCipherSuites = flag.String("tls-cipher-suites", envString("TLS_CIPHER_SUITES", ""),
+		"Comma separated IANA names of the cipher suites allowed with etcd up to TLS 1.2, "+
+			"e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Empty keeps the Go defaults. "+
+			"Overrides the TLS_CIPHER_SUITES environment variable if set.")
+
+	noKeepAlive = flag.Bool("no-keepalive", envBool("NO_KEEPALIVE", false),
+		"Open a fresh connection for every request instead of reusing idle ones, to exercise the full connect path "+
+			"on every check. "+
+			"Overrides the NO_KEEPALIVE environment variable if set.")
+
+	username = flag.String("username", envString("ETCD_USERNAME", ""),
+		"etcd RBAC username, sent as HTTP Basic auth or through the gRPC client with -mode=grpc. Requires -password-file. "+
+			"Overrides the ETCD_USERNAME environment variable if set.")
+
+	passwordFile = flag.String("password-file", envString("ETCD_PASSWORD_FILE", ""),
+		"File holding the password of -username. It is re-read on SIGHUP. "+
+			"Overrides the ETCD_PASSWORD_FILE environment variable if set.")
+
+	etcdName = flag.String("name", envString("ETCD_NAME", "etcd"),
+		"The name of the etcd cluster. This value will be used as CloudWatch dimension value. "+
+			"Overrides the ETCD_NAME environment variable if set.")
+
+	dimensionName = flag.String("dimension-name", envString("METRIC_DIMENSION_NAME", "By cluster"),
+		"Name of the CloudWatch dimension holding the cluster name. "+
+			"Overrides the METRIC_DIMENSION_NAME environment variable if set.")
+
+	namespace = flag.String("namespace", envString("METRIC_NAMESPACE", "etcd"),
+		"AWS CloudWatch metric namespace. "+
+			"Overrides the METRIC_NAMESPACE environment variable if set.")
+
+	metricName = flag.String("metric-name", envString("METRIC_NAME", "UnhealthyCount"),
+		"AWS CloudWatch metric name for the unhealthy count. "+
+			"Overrides the METRIC_NAME environment variable if set.")
+
+	healthyMetric = flag.Bool("healthy-metric", envBool("HEALTHY_METRIC", true),
+		"Publish a Healthy metric (1 when healthy, 0 otherwise) alongside the unhealthy count, "+
+			"for alarms that treat missing data as breaching. "+
+			"Overrides the HEALTHY_METRIC environment variable if set.")
+
+	publishMode = flag.String("publish-mode", envString("PUBLISH_MODE", publishModeAlways),
+		"When to publish the health metrics: \"always\" publishes every check, "+
+			"\"changes\" publishes healthy/unhealthy transitions immediately and otherwise only a heartbeat "+
+			"every -heartbeat-interval. Alarms on the health metrics then need a period of at least the "+
+			"heartbeat interval, or must treat missing data as \"ignore\" so they keep their state between heartbeats. "+
+			"Overrides the PUBLISH_MODE environment variable if set.")
+
+	heartbeatInterval = newIntervalFlag("heartbeat-interval", envInterval("HEARTBEAT_INTERVAL", 5*time.Minute),
+		"Time interval of how often to publish the unchanged health metrics in the \"changes\" publish mode, e.g. 5m. "+
+			"A bare number is in seconds. "+
+			"Overrides the HEARTBEAT_INTERVAL environment variable if set.")
+
+	enableStatusProbe = flag.Bool("status-probe", envBool("STATUS_PROBE", true),
+		"Query the etcd maintenance status every check and publish the database size, leader change and raft metrics. "+
+			"Disable for clusters where the status endpoint is not reachable with the client certificate. "+
+			"Overrides the STATUS_PROBE environment variable if set.")
+
+	leaderMissingThreshold = flag.Duration("leader-missing-threshold", envDuration("LEADER_MISSING_THRESHOLD", 30*time.Second),
+		"Log a warning when the status probe has seen no leader for longer than this, 0 disables the warning. "+
+			"Overrides the LEADER_MISSING_THRESHOLD environment variable if set.")
+
+	backendQuotaBytes = flag.Int64("backend-quota-bytes", envInt64("BACKEND_QUOTA_BYTES", 0),
+		"The etcd backend quota (--quota-backend-bytes) the DBQuotaUtilizationPercent metric is computed from. "+
+			"0 reads it from etcd_server_quota_backend_bytes in the etcd Prometheus metrics. "+
+			"Overrides the BACKEND_QUOTA_BYTES environment variable if set.")
+
+	dbQuotaWarnPercent = flag.Float64("db-quota-warn-percent", envFloat("DB_QUOTA_WARN_PERCENT", 80),
+		"Log a warning when the database uses more than this percentage of the backend quota. "+
+			"Overrides the DB_QUOTA_WARN_PERCENT environment variable if set.")
+
+	tlsExpiryWarnDays = flag.Int("tls-expiry-warn-days", envInt("TLS_EXPIRY_WARN_DAYS", 30),
+		"Log a warning daily once the client certificate or a CA certificate expires within this number of days, "+
+			"0 disables the warning. "+
+			"Overrides the TLS_EXPIRY_WARN_DAYS environment variable if set.")
+
+	defragWarnPercent = flag.Float64("defrag-warn-percent", envFloat("DEFRAG_WARN_PERCENT", 50),
+		"Log a warning when more than this percentage of a member's database file is fragmented, 0 disables the warning. "+
+			"Overrides the DEFRAG_WARN_PERCENT environment variable if set.")
+
+	enableLeaderProbe = flag.Bool("leader-probe", envBool("LEADER_PROBE", true),
+		"Query the status of every checked endpoint each check and publish whether they agree on a leader. "+
+			"Overrides the LEADER_PROBE environment variable if set.")
+
+	leaderProbeTimeout = flag.Duration("leader-probe-timeout", envDuration("LEADER_PROBE_TIMEOUT", 5*time.Second),
+		"Timeout of the status calls made by the leader probe. "+
+			"Overrides the LEADER_PROBE_TIMEOUT environment variable if set.")
+
+	enableMemberProbe = flag.Bool("member-probe", envBool("MEMBER_PROBE", true),
+		"List the cluster members every check and publish the MemberCount metric. "+
+			"Overrides the MEMBER_PROBE environment variable if set.")
+
+	expectedMembers = flag.Int("expected-members", envInt("EXPECTED_MEMBERS", 0),
+		"Expected number of cluster members. If set, a MemberCountMismatch metric is published "+
+			"that is 1 while the observed member count differs. "+
+			"Overrides the EXPECTED_MEMBERS environment variable if set.")
+
+	enableRevisionLagProbe = flag.Bool("revision-lag-probe", envBool("REVISION_LAG_PROBE", false),
+		"Query the status of every cluster member each check and publish how far each member's revision "+
+			"trails the highest one as RevisionLag (with a Member dimension) and MaxRevisionLag. "+
+			"Overrides the REVISION_LAG_PROBE environment variable if set.")
+
+	revisionLagThreshold = flag.Int("revision-lag-threshold", envInt("REVISION_LAG_THRESHOLD", 1000),
+		"Log a warning when a member trails by more than this many revisions, 0 disables the warning. "+
+			"Overrides the REVISION_LAG_THRESHOLD environment variable if set.")
+
+	revisionLagTolerance = flag.Int("revision-lag-tolerance", envInt("REVISION_LAG_TOLERANCE", 0),
+		"Report a lag up to this many revisions as zero, to allow for writes applied between the status calls. "+
+			"Overrides the REVISION_LAG_TOLERANCE environment variable if set.")
+
+	enableAppliedLagProbe = flag.Bool("applied-lag-probe", envBool("APPLIED_LAG_PROBE", false),
+		"Query the status of every checked endpoint each check and publish how far each member's applied raft index "+
+			"trails the leader's as AppliedIndexLag (with a Member dimension) and MaxAppliedIndexLag. "+
+			"Needs several addresses or -discover. "+
+			"Overrides the APPLIED_LAG_PROBE environment variable if set.")
+
+	appliedLagTolerance = flag.Int("applied-lag-tolerance", envInt("APPLIED_LAG_TOLERANCE", 500),
+		"Report an applied index lag up to this many entries as zero, to allow for entries applied between the status calls. "+
+			"Overrides the APPLIED_LAG_TOLERANCE environment variable if set.")
+
+	appliedLagThreshold = flag.Int("applied-lag-threshold", envInt("APPLIED_LAG_THRESHOLD", 10000),
+		"Log a warning when a member trails the leader by more than this many entries for -applied-lag-intervals "+
+			"consecutive checks, 0 disables the warning. "+
+			"Overrides the APPLIED_LAG_THRESHOLD environment variable if set.")
+
+	appliedLagIntervals = flag.Int("applied-lag-intervals", envInt("APPLIED_LAG_INTERVALS", 3),
+		"Number of consecutive checks a member must be above -applied-lag-threshold before a warning is logged. "+
+			"Overrides the APPLIED_LAG_INTERVALS environment variable if set.")
+
+	checkPeerURLs = flag.Bool("check-peer-urls", envBool("CHECK_PEER_URLS", false),
+		"Connect to the advertised peer URLs of every cluster member each check and publish PeerUnreachable "+
+			"(with a Member dimension), for peer ports that are firewalled off while the client port is healthy. "+
+			"Overrides the CHECK_PEER_URLS environment variable if set.")
+
+	peerCAFile = flag.String("peer-ca-file", envString("ETCDMON_PEER_CA_FILE", ""),
+		"A PEM encoded CA's certificate file for the peer URLs (default: the -ca-file, -cert-file and -key-file "+
+			"if no peer file is set). "+
+			"Overrides the ETCDMON_PEER_CA_FILE environment variable if set.")
+
+	peerCertFile = flag.String("peer-cert-file", envString("ETCDMON_PEER_CERT_FILE", ""),
+		"A PEM encoded certificate file for the peer URLs. "+
+			"Overrides the ETCDMON_PEER_CERT_FILE environment variable if set.")
+
+	peerKeyFile = flag.String("peer-key-file", envString("ETCDMON_PEER_KEY_FILE", ""),
+		"A PEM encoded private key file for the peer URLs. "+
+			"Overrides the ETCDMON_PEER_KEY_FILE environment variable if set.")
+
+	consistencyCheckInterval = flag.Duration("consistency-check-interval", envDuration("CONSISTENCY_CHECK_INTERVAL", 0),
+		"How often to compare the HashKV of all members and publish ConsistencyCheckFailed, e.g. 1h. "+
+			"0 disables the check. "+
+			"Overrides the CONSISTENCY_CHECK_INTERVAL environment variable if set.")
+
+	consistencyCheckTimeout = flag.Duration("consistency-check-timeout", envDuration("CONSISTENCY_CHECK_TIMEOUT", 30*time.Second),
+		"Timeout of one consistency check round. "+
+			"Overrides the CONSISTENCY_CHECK_TIMEOUT environment variable if set.")
+
+	enableVersionProbe = flag.Bool("version-probe", envBool("VERSION_PROBE", false),
+		"Query the version of every cluster member each check and publish VersionSkew, "+
+			"1 while the members run different versions. "+
+			"Overrides the VERSION_PROBE environment variable if set.")
+
+	strictVersionCheck = flag.Bool("strict-version-check", envBool("STRICT_VERSION_CHECK", false),
+		"Also treat versions that only differ in the patch level as skew. "+
+			"Overrides the STRICT_VERSION_CHECK environment variable if set.")
+
+	enableVersionInfo = flag.Bool("version-info", envBool("VERSION_INFO", false),
+		"Query the version of every checked endpoint and publish EtcdVersionInfo with the version as the EtcdVersion "+
+			"dimension, for a fleet-wide view of the versions running. "+
+			"Overrides the VERSION_INFO environment variable if set.")
+
+	versionInfoEvery = flag.Int("version-info-every", envInt("VERSION_INFO_EVERY", 1),
+		"Number of check intervals between version queries of -version-info. "+
+			"Overrides the VERSION_INFO_EVERY environment variable if set.")
+
+	enableEtcdAlarmProbe = flag.Bool("etcd-alarm-probe", envBool("ETCD_ALARM_PROBE", true),
+		"List the active etcd alarms every check and publish the ActiveAlarms, AlarmNOSPACE and AlarmCORRUPT metrics. "+
+			"Overrides the ETCD_ALARM_PROBE environment variable if set.")
+
+	enableReadProbe = flag.Bool("read-probe", envBool("READ_PROBE", false),
+		"Read a key every check and publish how long it took as ReadLatencyMs. "+
+			"Overrides the READ_PROBE environment variable if set.")
+
+	readProbeKey = flag.String("read-probe-key", envString("READ_PROBE_KEY", "/etcd-monitor/probe"),
+		"Key read by the read probe. It does not need to exist. "+
+			"Overrides the READ_PROBE_KEY environment variable if set.")
+
+	readProbeAPI = flag.String("read-probe-api", envString("READ_PROBE_API", readAPIV3),
+		"etcd API used by the read probe: \"v3\" (gRPC gateway) or \"v2\" (keys API). "+
+			"Overrides the READ_PROBE_API environment variable if set.")
+
+	readProbeConsistency = flag.String("read-probe-consistency", envString("READ_PROBE_CONSISTENCY", readConsistencyLinearizable),
+		"Consistency of the probe read: \"linearizable\" goes through the raft quorum, "+
+			"\"serializable\" is served by the member alone. "+
+			"Overrides the READ_PROBE_CONSISTENCY environment variable if set.")
+
+	readProbeTimeout = flag.Duration("read-probe-timeout", envDuration("READ_PROBE_TIMEOUT", 5*time.Second),
+		"Timeout of the probe read. "+
+			"Overrides the READ_PROBE_TIMEOUT environment variable if set.")
+
+	enableWriteProbe = flag.Bool("write-probe", envBool("WRITE_PROBE", false),
+		"Write a canary key every check, read it back and publish the round trip latency. "+
+			"Overrides the WRITE_PROBE environment variable if set.")
+
+	writeProbeKey = flag.String("write-probe-key", envString("WRITE_PROBE_KEY", "/etcd-monitor/canary"),
+		"Key written by the write probe. The client certificate user needs readwrite access to it. "+
+			"Overrides the WRITE_PROBE_KEY environment variable if set.")
+
+	writeProbeAPI = flag.String("write-probe-api", envString("WRITE_PROBE_API", readAPIV3),
+		"etcd API used by the write probe: \"v3\" (gRPC gateway, or the gRPC client with -mode=grpc) or \"v2\" (keys API). "+
+			"Overrides the WRITE_PROBE_API environment variable if set.")
+
+	writeProbeTTL = flag.Duration("write-probe-ttl", envDuration("WRITE_PROBE_TTL", time.Minute),
+		"Time to live of the canary key, so that it cleans itself up. "+
+			"Overrides the WRITE_PROBE_TTL environment variable if set.")
+
+	writeProbeTimeout = flag.Duration("write-probe-timeout", envDuration("WRITE_PROBE_TIMEOUT", 5*time.Second),
+		"Timeout of the whole write and read round trip. "+
+			"Overrides the WRITE_PROBE_TIMEOUT environment variable if set.")
+
+	scrapeMetricsEnabled = flag.Bool("scrape-metrics", envBool("SCRAPE_METRICS", false),
+		"Scrape the etcd Prometheus metrics every check and publish the WAL fsync and backend commit latencies. "+
+			"Overrides the SCRAPE_METRICS environment variable if set.")
+
+	scrapeMetricsURL = flag.String("scrape-metrics-url", envString("SCRAPE_METRICS_URL", ""),
+		"URL of the etcd Prometheus metrics, e.g. when --listen-metrics-urls puts them on a different port "+
+			"(default: the /metrics path of the etcd address). Implies -scrape-metrics. "+
+			"Overrides the SCRAPE_METRICS_URL environment variable if set.")
+
+	forwardMetrics = ParseStringList(os.Getenv("FORWARD_METRICS"))
+	flag.Var(forwardMetrics, "forward-metric",
+		"Name of an etcd Prometheus counter or gauge to scrape and publish, e.g. etcd_server_proposals_failed_total. "+
+			"Counters are published as the increase since the previous check, labels become dimensions. "+
+			"May be repeated. "+
+			"Overrides the FORWARD_METRICS environment variable (comma-separated names) if set.")
+
+	awsRegion = flag.String("region", envString("AWS_REGION", "us-east-1"),
+		"AWS CloudWatch region. "+
+			"Overrides the AWS_REGION environment variable if set.")
+
+	extraDimensions = &DimensionList{}
+	if d := os.Getenv("METRIC_DIMENSIONS"); d != "" {
+		defaultDimensions, err := ParseDimensionList(d)
+		if err != nil {
+			log.Fatal(err)
+		}
+		extraDimensions = defaultDimensions
+	}
+	flag.Var(extraDimensions, "dimension",
+		"Additional CloudWatch dimension in key=value form, attached to every metric. "+
+			"May be repeated. "+
+			"Overrides the METRIC_DIMENSIONS environment variable (comma-separated pairs) if set.")
+
+	assumeRoleARN = flag.String("assume-role-arn", envString("ASSUME_ROLE_ARN", ""),
+		"ARN of an IAM role to assume for publishing metrics, e.g. in a central monitoring account. "+
+			"Overrides the ASSUME_ROLE_ARN environment variable if set.")
+
+	externalID = flag.String("external-id", envString("ASSUME_ROLE_EXTERNAL_ID", ""),
+		"External ID to pass when assuming the role given by -assume-role-arn. "+
+			"Overrides the ASSUME_ROLE_EXTERNAL_ID environment variable if set.")
+
+	cloudwatchEndpoint = flag.String("cloudwatch-endpoint", envString("CLOUDWATCH_ENDPOINT", ""),
+		"Custom CloudWatch endpoint URL, e.g. http://localhost:4566 for LocalStack. "+
+			"Overrides the CLOUDWATCH_ENDPOINT environment variable if set.")
+
+	dryRun = flag.Bool("dry-run", envBool("DRY_RUN", false),
+		"Log the metric payloads instead of publishing them to CloudWatch. "+
+			"Overrides the DRY_RUN environment variable if set.")
+
+	emf = flag.Bool("emf", envBool("EMF", false),
+		"Write metrics to stdout in CloudWatch Embedded Metric Format instead of calling the CloudWatch API. "+
+			"Overrides the EMF environment variable if set.")
+
+	noCloudWatch = flag.Bool("no-cloudwatch", envBool("NO_CLOUDWATCH", false),
+		"Do not publish metrics to CloudWatch, e.g. when only the Prometheus exporter is used. "+
+			"Overrides the NO_CLOUDWATCH environment variable if set.")
+
+	prometheusListen = flag.String("prometheus-listen", envString("PROMETHEUS_LISTEN", ""),
+		"Address to serve the health check results on as a Prometheus scrape target at /metrics, e.g. :9100. "+
+			"Overrides the PROMETHEUS_LISTEN environment variable if set.")
+
+	listen = flag.String("listen", envString("LISTEN", ""),
+		"Address to serve the liveness of the monitor itself on at /healthz, e.g. :8080: 200 while checks run "+
+			"every interval, 503 otherwise, whatever the health of etcd. "+
+			"Overrides the LISTEN environment variable if set.")
+
+	statsdAddress = flag.String("statsd-address", envString("STATSD_ADDRESS", ""),
+		"host:port of a StatsD daemon to send the health check results to over UDP, e.g. 127.0.0.1:8125. "+
+			"Overrides the STATSD_ADDRESS environment variable if set.")
+
+	statsdPrefix = flag.String("statsd-prefix", envString("STATSD_PREFIX", "etcd"),
+		"Prefix of the StatsD metric names, followed by the cluster name. "+
+			"Overrides the STATSD_PREFIX environment variable if set.")
+
+	dogstatsd = flag.Bool("dogstatsd", envBool("DOGSTATSD", false),
+		"Send the health check results to a Datadog agent as DogStatsD metrics tagged with the cluster, "+
+			"the extra dimensions and the endpoint. "+
+			"Overrides the DOGSTATSD environment variable if set.")
+
+	dogstatsdAddress = flag.String("dogstatsd-address", envString("DOGSTATSD_ADDRESS", "127.0.0.1:8125"),
+		"Address of the Datadog agent: host:port for UDP, or unix:///path for its unix socket. "+
+			"Overrides the DOGSTATSD_ADDRESS environment variable if set.")
+
+	influxURL = flag.String("influx-url", envString("INFLUX_URL", ""),
+		"URL of an InfluxDB v2 server to write the health check results to, e.g. http://influxdb:8086. "+
+			"Overrides the INFLUX_URL environment variable if set.")
+
+	influxOrg = flag.String("influx-org", envString("INFLUX_ORG", ""),
+		"InfluxDB organization of -influx-bucket. "+
+			"Overrides the INFLUX_ORG environment variable if set.")
+
+	influxBucket = flag.String("influx-bucket", envString("INFLUX_BUCKET", ""),
+		"InfluxDB bucket to write the health check results to. "+
+			"Overrides the INFLUX_BUCKET environment variable if set.")
+
+	influxTokenFile = flag.String("influx-token-file", envString("INFLUX_TOKEN_FILE", ""),
+		"File holding the InfluxDB API token. Without it, the token is read from the INFLUX_TOKEN environment variable. "+
+			"Overrides the INFLUX_TOKEN_FILE environment variable if set.")
+
+	graphiteAddress = flag.String("graphite-address", envString("GRAPHITE_ADDRESS", ""),
+		"host:port of a carbon daemon to send the health check results to in the Graphite plaintext protocol, e.g. carbon:2003. "+
+			"Overrides the GRAPHITE_ADDRESS environment variable if set.")
+
+	graphitePrefix = flag.String("graphite-prefix", envString("GRAPHITE_PREFIX", "etcd"),
+		"Prefix of the Graphite metric paths, followed by the cluster name. "+
+			"Overrides the GRAPHITE_PREFIX environment variable if set.")
+
+	graphiteProtocol = flag.String("graphite-protocol", envString("GRAPHITE_PROTOCOL", "tcp"),
+		"Protocol to send to carbon with: tcp, queueing the metrics while carbon is unreachable, or fire-and-forget udp. "+
+			"Overrides the GRAPHITE_PROTOCOL environment variable if set.")
+
+	jsonlOutput = flag.String("jsonl-output", envString("JSONL_OUTPUT", ""),
+		"File to append the health check results to as JSON lines, or - for stdout. "+
+			"Every check writes one object per endpoint with the fields "+
+			"timestamp (RFC 3339), endpoint, healthy (bool), latency_ms (number), error and error_type (empty if healthy). "+
+			"The file is reopened on SIGHUP for logrotate. "+
+			"Overrides the JSONL_OUTPUT environment variable if set.")
+
+	snsTopicARN = flag.String("sns-topic-arn", envString("SNS_TOPIC_ARN", ""),
+		"ARN of an SNS topic to publish a message to when the cluster becomes unhealthy and when it recovers, "+
+			"with a severity message attribute (critical or ok) to filter on. "+
+			"Overrides the SNS_TOPIC_ARN environment variable if set.")
+
+	eventBridgeBus = flag.String("eventbridge-bus", envString("EVENTBRIDGE_BUS", ""),
+		"Name or ARN of an EventBridge event bus, e.g. default, to put an EtcdHealthStateChange event on when an "+
+			"endpoint becomes unhealthy or recovers. "+
+			"Overrides the EVENTBRIDGE_BUS environment variable if set.")
+
+	eventBridgeReminder = flag.Duration("eventbridge-reminder-interval", envDuration("EVENTBRIDGE_REMINDER_INTERVAL", 0),
+		"Repeat the event for an endpoint that is still unhealthy this often, with reminder set (default: never). "+
+			"Overrides the EVENTBRIDGE_REMINDER_INTERVAL environment variable if set.")
+
+	sqsQueueURL = flag.String("sqs-queue-url", envString("SQS_QUEUE_URL", ""),
+		"URL of an SQS queue to send a message to when an endpoint becomes unhealthy or recovers, with the webhook "+
+			"document as body and cluster and severity message attributes. "+
+			"Overrides the SQS_QUEUE_URL environment variable if set.")
+
+	sesFrom = flag.String("ses-from", envString("SES_FROM", ""),
+		"Address to email from through SES when the cluster has been unhealthy for -ses-after and when it recovers, "+
+			"verified in SES. "+
+			"Overrides the SES_FROM environment variable if set.")
+
+	sesTo = ParseStringList(os.Getenv("SES_TO"))
+	flag.Var(sesTo, "ses-to",
+		"Address to send the SES emails to. May be repeated. "+
+			"Overrides the SES_TO environment variable (comma-separated addresses) if set.")
+
+	sesAfter = flag.Duration("ses-after", envDuration("SES_AFTER", 5*time.Minute),
+		"How long the cluster must be unhealthy before the first email is sent, 0 sends it on the first failed check. "+
+			"Overrides the SES_AFTER environment variable if set.")
+
+	sesReminder = flag.Duration("ses-reminder-interval", envDuration("SES_REMINDER_INTERVAL", 0),
+		"Repeat the email this often while the cluster stays unhealthy (default: never). "+
+			"Overrides the SES_REMINDER_INTERVAL environment variable if set.")
+
+	slackWebhookURL = flag.String("slack-webhook-url", envString("SLACK_WEBHOOK_URL", ""),
+		"Slack incoming webhook URL to post a message to when the cluster becomes unhealthy and when it recovers. "+
+			"The URL is a secret, prefer the environment variable or -slack-webhook-url-file to keep it out of ps. "+
+			"Overrides the SLACK_WEBHOOK_URL environment variable if set.")
+
+	slackWebhookURLFile = flag.String("slack-webhook-url-file", envString("SLACK_WEBHOOK_URL_FILE", ""),
+		"File holding the Slack incoming webhook URL, instead of -slack-webhook-url. "+
+			"Overrides the SLACK_WEBHOOK_URL_FILE environment variable if set.")
+
+	slackCooldown = flag.Duration("slack-cooldown", envDuration("SLACK_COOLDOWN", 5*time.Minute),
+		"Minimum time between two Slack messages about the same state. A cluster flapping back within it is not notified. "+
+			"Overrides the SLACK_COOLDOWN environment variable if set.")
+
+	pagerDutyRoutingKey = flag.String("pagerduty-routing-key", envString("PAGERDUTY_ROUTING_KEY", ""),
+		"Routing key of a PagerDuty Events API v2 integration to open an incident with per endpoint failing "+
+			"-pagerduty-failure-threshold checks in a row, resolved once it recovers. "+
+			"The key is a secret, prefer the environment variable or -pagerduty-routing-key-file to keep it out of ps. "+
+			"Overrides the PAGERDUTY_ROUTING_KEY environment variable if set.")
+
+	pagerDutyRoutingKeyFile = flag.String("pagerduty-routing-key-file", envString("PAGERDUTY_ROUTING_KEY_FILE", ""),
+		"File holding the PagerDuty routing key, instead of -pagerduty-routing-key. "+
+			"Overrides the PAGERDUTY_ROUTING_KEY_FILE environment variable if set.")
+
+	pagerDutyFailureThreshold = flag.Int("pagerduty-failure-threshold", envInt("PAGERDUTY_FAILURE_THRESHOLD", 3),
+		"Number of checks in a row an endpoint must fail before a PagerDuty incident is opened for it. "+
+			"Overrides the PAGERDUTY_FAILURE_THRESHOLD environment variable if set.")
+
+	pagerDutyEventsURL = flag.String("pagerduty-events-url", envString("PAGERDUTY_EVENTS_URL", defaultPagerDutyEventsURL),
+		"PagerDuty Events API v2 endpoint, e.g. https://events.eu.pagerduty.com/v2/enqueue for the EU service region. "+
+			"Overrides the PAGERDUTY_EVENTS_URL environment variable if set.")
+
+	webhookURL = flag.String("webhook-url", envString("WEBHOOK_URL", ""),
+		"URL to POST a JSON document to when an endpoint becomes unhealthy or recovers. "+
+			"Overrides the WEBHOOK_URL environment variable if set.")
+
+	webhookHeaders = ParseStringList(os.Getenv("WEBHOOK_HEADERS"))
+	flag.Var(webhookHeaders, "webhook-header",
+		"Header in \"Name: value\" form added to every webhook request, e.g. for authentication. "+
+			"May be repeated. "+
+			"Overrides the WEBHOOK_HEADERS environment variable (comma-separated headers) if set.")
+
+	webhookSecretFile = flag.String("webhook-secret-file", envString("WEBHOOK_SECRET_FILE", ""),
+		"File holding a shared secret to sign the webhook requests with. The X-Etcd-Monitor-Signature header "+
+			"holds sha256=<hex HMAC-SHA256 of the body>. "+
+			"Overrides the WEBHOOK_SECRET_FILE environment variable if set.")
+
+	webhookEveryCheck = flag.Bool("webhook-every-check", envBool("WEBHOOK_EVERY_CHECK", false),
+		"POST a document for every endpoint on every check, not only when it changes state. "+
+			"Overrides the WEBHOOK_EVERY_CHECK environment variable if set.")
+
+	syslogEnabled = flag.Bool("syslog", envBool("SYSLOG", false),
+		"Write health state changes, with severity err when the cluster becomes unhealthy and info when it recovers, "+
+			"and the errors logged to syslog, in addition to stderr. "+
+			"Overrides the SYSLOG environment variable if set.")
+
+	syslogAddress = flag.String("syslog-address", envString("SYSLOG_ADDRESS", ""),
+		"Remote syslog daemon to write to in RFC 5424 format instead of the local one, udp://host:port or "+
+			"tcp://host:port. Implies -syslog. "+
+			"Overrides the SYSLOG_ADDRESS environment variable if set.")
+
+	syslogTag = flag.String("syslog-tag", envString("SYSLOG_TAG", "etcd-monitor"),
+		"Tag (application name) of the syslog messages. "+
+			"Overrides the SYSLOG_TAG environment variable if set.")
+
+	stateFile = flag.String("state-file", envString("STATE_FILE", ""),
+		"File to rewrite with the result of the last check after every check, e.g. /var/run/etcd-monitor/status.json, "+
+			"for local consumers. The file is replaced atomically and marked stopped on a clean exit. "+
+			"Overrides the STATE_FILE environment variable if set.")
+
+	stateFileMode = flag.String("state-file-mode", envString("STATE_FILE_MODE", "0644"),
+		"Permissions of the state file, in octal. "+
+			"Overrides the STATE_FILE_MODE environment variable if set.")
+
+	pushgatewayURL = flag.String("pushgateway-url", envString("PUSHGATEWAY_URL", ""),
+		"URL of a Prometheus Pushgateway to push the health check results to after every check, "+
+			"grouped by job etcd-monitor-<cluster> and the host name as instance. "+
+			"Overrides the PUSHGATEWAY_URL environment variable if set.")
+
+	pushgatewayUsername = flag.String("pushgateway-username", envString("PUSHGATEWAY_USERNAME", ""),
+		"Username to push with HTTP Basic auth. Requires -pushgateway-password-file. "+
+			"Overrides the PUSHGATEWAY_USERNAME environment variable if set.")
+
+	pushgatewayPasswordFile = flag.String("pushgateway-password-file", envString("PUSHGATEWAY_PASSWORD_FILE", ""),
+		"File holding the password of -pushgateway-username. "+
+			"Overrides the PUSHGATEWAY_PASSWORD_FILE environment variable if set.")
+
+	pushgatewayTokenFile = flag.String("pushgateway-bearer-token-file", envString("PUSHGATEWAY_BEARER_TOKEN_FILE", ""),
+		"File holding a bearer token to push with, instead of basic auth. "+
+			"Overrides the PUSHGATEWAY_BEARER_TOKEN_FILE environment variable if set.")
+
+	pushgatewayDeleteOnExit = flag.Bool("pushgateway-delete-on-exit", envBool("PUSHGATEWAY_DELETE_ON_EXIT", false),
+		"Delete the group from the Pushgateway on a clean exit. "+
+			"Overrides the PUSHGATEWAY_DELETE_ON_EXIT environment variable if set.")
+
+	logsGroup = flag.String("cloudwatch-logs-group", envString("CLOUDWATCH_LOGS_GROUP", ""),
+		"CloudWatch Logs group to write the check results to as JSON events, for an event history to query with "+
+			"Logs Insights. "+
+			"Overrides the CLOUDWATCH_LOGS_GROUP environment variable if set.")
+
+	logsStream = flag.String("cloudwatch-logs-stream", envString("CLOUDWATCH_LOGS_STREAM", ""),
+		"CloudWatch Logs stream to write to (default: the host name). "+
+			"Overrides the CLOUDWATCH_LOGS_STREAM environment variable if set.")
+
+	logsCreate = flag.Bool("cloudwatch-logs-create", envBool("CLOUDWATCH_LOGS_CREATE", false),
+		"Create the CloudWatch Logs group and stream if missing. "+
+			"Overrides the CLOUDWATCH_LOGS_CREATE environment variable if set.")
+
+	logsEvents = flag.String("cloudwatch-logs-events", envString("CLOUDWATCH_LOGS_EVENTS", "transitions"),
+		"Which checks to write to CloudWatch Logs: \"all\" or only the \"transitions\" changing the cluster state. "+
+			"Overrides the CLOUDWATCH_LOGS_EVENTS environment variable if set.")
+
+	reporterTimeout = flag.Duration("reporter-timeout", envDuration("REPORTER_TIMEOUT", 0),
+		"How long every check waits for each destination of the results (CloudWatch batch, Prometheus, StatsD, ...) "+
+			"before giving up on it, so that a hanging one does not hold up the others. Must be less than -interval "+
+			"(default: the -timeout). "+
+			"Overrides the REPORTER_TIMEOUT environment variable if set.")
+
+	maxRetries = flag.Int("cw-max-retries", envInt("CW_MAX_RETRIES", 3),
+		"Maximum number of times a failed PutMetricData call is retried. "+
+			"Retries never extend past the current check interval. "+
+			"Overrides the CW_MAX_RETRIES environment variable if set.")
+
+	cloudwatchTimeout = flag.Duration("cloudwatch-timeout", envDuration("CLOUDWATCH_TIMEOUT", 10*time.Second),
+		"Timeout of every single PutMetricData call, independent of the etcd request timeout. "+
+			"A timed out call is not retried; its datapoints are buffered. "+
+			"Overrides the CLOUDWATCH_TIMEOUT environment variable if set.")
+
+	addInstanceDimension = flag.Bool("add-instance-dimension", envBool("ADD_INSTANCE_DIMENSION", false),
+		"Additionally publish every datapoint with an InstanceId dimension taken from the EC2 instance metadata. "+
+			"Overrides the ADD_INSTANCE_DIMENSION environment variable if set.")
+
+	addAZDimension = flag.Bool("add-az-dimension", envBool("ADD_AZ_DIMENSION", false),
+		"Additionally publish every datapoint with an AvailabilityZone dimension taken from the EC2 instance metadata. "+
+			"Overrides the ADD_AZ_DIMENSION environment variable if set.")
+
+	availabilityZoneOverride = flag.String("availability-zone", envString("AVAILABILITY_ZONE", ""),
+		"Availability zone to publish in the AvailabilityZone dimension instead of looking it up, e.g. for on-prem clusters. "+
+			"Implies -add-az-dimension. "+
+			"Overrides the AVAILABILITY_ZONE environment variable if set.")
+
+	highResolution = flag.Bool("high-resolution", envBool("HIGH_RESOLUTION", false),
+		"Publish high-resolution metrics with a storage resolution of 1 second. "+
+			"Only useful with check intervals below 60 seconds. "+
+			"Overrides the HIGH_RESOLUTION environment variable if set.")
+
+	endpointDimension = flag.Bool("endpoint-dimension", envBool("ENDPOINT_DIMENSION", false),
+		"Additionally publish per-endpoint datapoints with an Endpoint dimension (host:port). "+
+			"The cluster-level datapoints are published either way. "+
+			"Overrides the ENDPOINT_DIMENSION environment variable if set.")
+
+	bufferSize = flag.Int("buffer-size", envInt("METRIC_BUFFER_SIZE", 10000),
+		"Maximum number of datapoints buffered while CloudWatch is unreachable, 0 disables buffering. "+
+			"Buffered datapoints are backfilled with their original timestamps. "+
+			"Overrides the METRIC_BUFFER_SIZE environment variable if set.")
+
+	bufferFile = flag.String("buffer-file", envString("METRIC_BUFFER_FILE", ""),
+		"File to persist buffered datapoints in so they survive restarts. "+
+			"Overrides the METRIC_BUFFER_FILE environment variable if set.")
+
+	createAlarm = flag.Bool("create-alarm", envBool("CREATE_ALARM", false),
+		"Create or update a CloudWatch alarm on the unhealthy count at startup. "+
+			"Overrides the CREATE_ALARM environment variable if set.")
+
+	alarmName = flag.String("alarm-name", envString("ALARM_NAME", ""),
+		"Name of the alarm created with -create-alarm (default \"etcd-monitor-<name>-unhealthy\"). "+
+			"Overrides the ALARM_NAME environment variable if set.")
+
+	alarmThreshold = flag.Float64("alarm-threshold", envFloat("ALARM_THRESHOLD", 1),
+		"The alarm fires when the unhealthy count is greater than or equal to this value. "+
+			"Overrides the ALARM_THRESHOLD environment variable if set.")
+
+	alarmEvaluationPeriods = flag.Int("alarm-evaluation-periods", envInt("ALARM_EVALUATION_PERIODS", 3),
+		"Number of consecutive periods the threshold must be breached before the alarm fires. "+
+			"Overrides the ALARM_EVALUATION_PERIODS environment variable if set.")
+
+	alarmTopicARN = flag.String("alarm-sns-topic-arn", envString("ALARM_SNS_TOPIC_ARN", ""),
+		"SNS topic notified when the alarm changes state. "+
+			"Overrides the ALARM_SNS_TOPIC_ARN environment variable if set.")
+
+	deleteAlarmOnExit = flag.Bool("delete-alarm-on-exit", envBool("DELETE_ALARM_ON_EXIT", false),
+		"Delete the alarm created with -create-alarm when the monitor exits, e.g. for ephemeral test clusters. "+
+			"Overrides the DELETE_ALARM_ON_EXIT environment variable if set.")
+
+	once = flag.Bool("once", envBool("RUN_ONCE", false),
+		"Check the cluster once, publish the results, print a summary line and exit: with 0 if it is healthy, "+
+			"3 if it is not and 1 if the check itself failed, e.g. on a TLS or authentication error. "+
+			"While the cluster fails for fewer checks than -failure-threshold it is checked again every interval. "+
+			"Overrides the RUN_ONCE environment variable if set.")
+
+	exitAfterFailures = flag.Int("exit-after-failures", envInt("EXIT_AFTER_FAILURES", 0),
+		"Exit with code 4 once this many checks in a row failed, after publishing, e.g. for a systemd "+
+			"ExecStopPost remediation hook. Counts every failed check, so it must be at least -failure-threshold "+
+			"(default: 0, never exit). Overrides the EXIT_AFTER_FAILURES environment variable if set.")
+
+	maintenanceFilePath = flag.String("maintenance-file", envString("MAINTENANCE_FILE", ""),
+		"YAML or JSON file of maintenance windows, read again before every check. Within a window the checks "+
+			"still run and are logged, but etcd is not reported unhealthy and no notification is sent. "+
+			"Not applied with -once. Overrides the MAINTENANCE_FILE environment variable if set.")
+
+	maintenanceReport = flag.String("maintenance-report", envString("MAINTENANCE_REPORT", maintenanceReportHealthy),
+		"How checks within a maintenance window are reported to CloudWatch: \"healthy\" publishes an "+
+			"UnhealthyCount of 0, \"metric\" publishes an InMaintenance metric instead of the health metrics. "+
+			"Overrides the MAINTENANCE_REPORT environment variable if set.")
+
+	maxPause = flag.Duration("max-pause", envDuration("MAX_PAUSE", 0),
+		"How long a pause on SIGUSR1 lasts at most before the monitor resumes on its own, so that nobody forgets "+
+			"to send SIGUSR2 (default: 0, until SIGUSR2). Overrides the MAX_PAUSE environment variable if set.")
+
+	startupGracePeriod = flag.Duration("startup-grace-period", envDuration("STARTUP_GRACE_PERIOD", 0),
+		"Time after startup during which failed checks are not reported as unhealthy, e.g. 5m for a node that "+
+			"boots the monitor before etcd joined the cluster. The first passed check ends it early; once it is "+
+			"over, failed checks are reported as usual. Not applied with -once. "+
+			"Overrides the STARTUP_GRACE_PERIOD environment variable if set.")
+
+	startupGraceMode = flag.String("startup-grace-mode", envString("STARTUP_GRACE_MODE", startupGraceModeStarting),
+		"How failed checks within the startup grace period are reported: \"starting\" reports the cluster as "+
+			"starting, publishing a Starting metric instead of the health metrics, \"suppress\" does not report them "+
+			"at all. Overrides the STARTUP_GRACE_MODE environment variable if set.")
+
+	shutdownGracePeriod = flag.Duration("shutdown-grace-period", envDuration("SHUTDOWN_GRACE_PERIOD", 10*time.Second),
+		"Time to wait on SIGTERM or SIGINT for the running check and publish to finish before cancelling them. "+
+			"The monitor exits with 0 if they finished and 2 if they had to be cancelled. "+
+			"Overrides the SHUTDOWN_GRACE_PERIOD environment variable if set.")
+
+	dashboardName = flag.String("dashboard-name", envString("DASHBOARD_NAME", ""),
+		"Name of the dashboard created by the dashboard command (default \"etcd-<name>\"). "+
+			"Overrides the DASHBOARD_NAME environment variable if set.")
+
+	printOnly = flag.Bool("print-only", false,
+		"Print the dashboard JSON to stdout instead of creating the dashboard.")
+
+	controlSocket = flag.String("control-socket", envString("CONTROL_SOCKET", defaultControlSocket),
+		"Unix socket the monitor serves its status on for the status command, empty disables it. "+
+			"Overrides the CONTROL_SOCKET environment variable if set.")
+
+	checkWarning = flag.Duration("w", 0,
+		"Latency of the slowest endpoint from which the check command reports WARNING, e.g. 200ms, 0 disables it.")
+	checkCritical = flag.Duration("c", 0,
+		"Latency of the slowest endpoint from which the check command reports CRITICAL, e.g. 1s, 0 disables it.")
+	checkPublish = flag.Bool("publish", false,
+		"Publish the metrics of the check command to CloudWatch, which it does not by default.")
+}
+
+// validateFlags checks the parsed flags, as far as it can without the network
+// or the files they name, and fills in the defaults derived from other flags,
+// such as -timeout from -interval.
+func validateFlags() error {
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+	if *checkTimeout == 0 {
+		*checkTimeout = 5 * time.Second
+		if *interval <= 5*time.Second {
+			*checkTimeout = *interval / 2
+		}
+	}
+	if *checkTimeout < 0 || *checkTimeout >= *interval {
+		return fmt.Errorf("-timeout %s must be positive and less than the check interval of %s", *checkTimeout, *interval)
+	}
+	if *checkRetries < 0 || *checkRetryDelay < 0 {
+		return fmt.Errorf("-check-retries and -check-retry-delay must not be negative")
+	}
+	var err error
+	jitter, err = parseJitter(*jitterValue, *interval)
+	if err != nil {
+		return err
+	}
+	if jitter < 0 || jitter >= *interval {
+		return fmt.Errorf("-jitter %s must be at least 0 and less than the check interval of %s", jitter, *interval)
+	}
+	if *shutdownGracePeriod <= 0 {
+		return fmt.Errorf("-shutdown-grace-period must be positive")
+	}
+	if *maxPause < 0 {
+		return fmt.Errorf("-max-pause must not be negative")
+	}
+	if *startupGracePeriod < 0 {
+		return fmt.Errorf("-startup-grace-period must not be negative")
+	}
+	switch *maintenanceReport {
+	case maintenanceReportHealthy, maintenanceReportMetric:
+	default:
+		return fmt.Errorf("invalid -maintenance-report %q, expected %q or %q", *maintenanceReport, maintenanceReportHealthy, maintenanceReportMetric)
+	}
+	switch *startupGraceMode {
+	case startupGraceModeStarting, startupGraceModeSuppress:
+	default:
+		return fmt.Errorf("invalid -startup-grace-mode %q, expected %q or %q", *startupGraceMode, startupGraceModeStarting, startupGraceModeSuppress)
+	}
+	if *failureThreshold < 1 || *recoveryThreshold < 1 {
+		return fmt.Errorf("-failure-threshold and -recovery-threshold must be at least 1")
+	}
+	if *exitAfterFailures < 0 || (*exitAfterFailures > 0 && *exitAfterFailures < *failureThreshold) {
+		return fmt.Errorf("-exit-after-failures must be 0 or at least -failure-threshold (%d)", *failureThreshold)
+	}
+	if *reporterTimeout == 0 {
+		*reporterTimeout = *checkTimeout
+	}
+	if *reporterTimeout < 0 || *reporterTimeout >= *interval {
+		return fmt.Errorf("-reporter-timeout %s must be positive and less than the check interval of %s", *reporterTimeout, *interval)
+	}
+	if *publishInterval == 0 {
+		*publishInterval = *interval
+	}
+	if *publishInterval < *interval || *publishInterval%*interval != 0 {
+		return fmt.Errorf("-publish-interval must be a multiple of -interval (%s)", *interval)
+	}
+
+	switch *publishMode {
+	case publishModeAlways, publishModeChanges:
+	default:
+		return fmt.Errorf("invalid -publish-mode %q, expected %q or %q", *publishMode, publishModeAlways, publishModeChanges)
+	}
+	if *publishMode == publishModeChanges && *heartbeatInterval < *interval {
+		return fmt.Errorf("-heartbeat-interval must be at least -interval (%s)", *interval)
+	}
+
+	if *expectedMembers < 0 {
+		return fmt.Errorf("-expected-members must not be negative")
+	}
+	if *expectedMembers > 0 && !*enableMemberProbe {
+		return fmt.Errorf("-expected-members requires -member-probe")
+	}
+
+	// The SRV records are only resolved once the flags are valid.
+	if *discoverySRV == "" {
+		if _, err := parseEndpoints(*address); err != nil {
+			return err
+		}
+	}
+	switch *healthMethod {
+	case http.MethodGet, http.MethodPost:
+	default:
+		return fmt.Errorf("invalid -health-method %q, expected GET or POST", *healthMethod)
+	}
+	if *discoverySRV != "" && *discover {
+		return fmt.Errorf("-discovery-srv and -discover are mutually exclusive")
+	}
+
+	if *backendQuotaBytes < 0 {
+		return fmt.Errorf("-backend-quota-bytes must not be negative")
+	}
+	switch *unhealthyPolicy {
+	case unhealthyPolicyAny, unhealthyPolicyAll, unhealthyPolicyQuorum:
+	default:
+		return fmt.Errorf("invalid -unhealthy-policy %q, expected %q, %q or %q",
+			*unhealthyPolicy, unhealthyPolicyAny, unhealthyPolicyAll, unhealthyPolicyQuorum)
+	}
+
+	if *eventBridgeReminder < 0 {
+		return fmt.Errorf("-eventbridge-reminder-interval must not be negative")
+	}
+	if *sesAfter < 0 || *sesReminder < 0 {
+		return fmt.Errorf("-ses-after and -ses-reminder-interval must not be negative")
+	}
+	if *sesFrom == "" && len(sesTo.values) > 0 {
+		return fmt.Errorf("-ses-to requires -ses-from")
+	}
+	switch *logsEvents {
+	case "all", "transitions":
+	default:
+		return fmt.Errorf("invalid -cloudwatch-logs-events %q, expected \"all\" or \"transitions\"", *logsEvents)
+	}
+	if *logsGroup != "" && *logsStream == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get the host name for -cloudwatch-logs-stream: %s", err)
+		}
+		*logsStream = hostname
+	}
+
+	if *appliedLagTolerance < 0 || *appliedLagThreshold < 0 || *appliedLagIntervals < 1 {
+		return fmt.Errorf("-applied-lag-tolerance and -applied-lag-threshold must not be negative, -applied-lag-intervals must be at least 1")
+	}
+	if *consistencyCheckInterval < 0 {
+		return fmt.Errorf("-consistency-check-interval must not be negative")
+	}
+	if *versionInfoEvery < 1 {
+		return fmt.Errorf("invalid -version-info-every %d, must be at least 1", *versionInfoEvery)
+	}
+	if *maxIdleConns < 0 || *idleConnTimeout < 0 || *tcpKeepAlive < 0 || *tlsHandshakeTimeout < 0 {
+		return fmt.Errorf("-max-idle-conns, -idle-conn-timeout, -tcp-keepalive and -tls-handshake-timeout must not be negative")
+	}
+	if *discoverEvery < 1 {
+		return fmt.Errorf("invalid -discover-every %d, must be at least 1", *discoverEvery)
+	}
+
+	switch *checkMode {
+	case checkModeHTTP, checkModeGRPC:
+	default:
+		return fmt.Errorf("invalid -mode %q, expected %q or %q", *checkMode, checkModeHTTP, checkModeGRPC)
+	}
+
+	switch {
+	case *tlsServerName != "" && *tlsServerNameFile != "":
+		return fmt.Errorf("-tls-server-name cannot be combined with -tls-server-name-file")
+	case *username != "" && *passwordFile == "":
+		return fmt.Errorf("-username requires -password-file")
+	case *username == "" && *passwordFile != "":
+		return fmt.Errorf("-password-file requires -username")
+	}
+
+	if *emf && *dryRun {
+		return fmt.Errorf("-emf and -dry-run are mutually exclusive")
+	}
+
+	if *noCloudWatch && (*emf || *dryRun || *createAlarm) {
+		return fmt.Errorf("-no-cloudwatch cannot be used with -emf, -dry-run or -create-alarm")
+	}
+	if *noCloudWatch && *prometheusListen == "" && *statsdAddress == "" && !*dogstatsd && *influxURL == "" &&
+		*graphiteAddress == "" && *jsonlOutput == "" {
+		log.Printf("[WARN] -no-cloudwatch is set without -prometheus-listen, -statsd-address, -dogstatsd, " +
+			"-influx-url, -graphite-address or -jsonl-output, the health check results are only logged")
+	}
+
+	if *externalID != "" && *assumeRoleARN == "" {
+		return fmt.Errorf("-external-id requires -assume-role-arn")
+	}
+
+	switch {
+	case *pushgatewayUsername != "" && *pushgatewayPasswordFile == "":
+		return fmt.Errorf("-pushgateway-username requires -pushgateway-password-file")
+	case *pushgatewayUsername == "" && *pushgatewayPasswordFile != "":
+		return fmt.Errorf("-pushgateway-password-file requires -pushgateway-username")
+	case *pushgatewayUsername != "" && *pushgatewayTokenFile != "":
+		return fmt.Errorf("-pushgateway-username and -pushgateway-bearer-token-file are mutually exclusive")
+	case *slackWebhookURL != "" && *slackWebhookURLFile != "":
+		return fmt.Errorf("-slack-webhook-url and -slack-webhook-url-file are mutually exclusive")
+	case *pagerDutyRoutingKey != "" && *pagerDutyRoutingKeyFile != "":
+		return fmt.Errorf("-pagerduty-routing-key and -pagerduty-routing-key-file are mutually exclusive")
+	}
+
+	if *checkWarning < 0 || *checkCritical < 0 {
+		return fmt.Errorf("-w and -c must not be negative")
+	}
+	if *checkWarning > 0 && *checkCritical > 0 && *checkWarning > *checkCritical {
+		return fmt.Errorf("-w must not be greater than -c")
+	}
+
+	if *alarmName == "" {
+		*alarmName = "etcd-monitor-" + *etcdName + "-unhealthy"
+	}
+	if *alarmEvaluationPeriods < 1 {
+		return fmt.Errorf("-alarm-evaluation-periods must be at least 1")
+	}
+	if *emf && *createAlarm {
+		return fmt.Errorf("-create-alarm requires the CloudWatch API and cannot be used with -emf")
+	}
+
+	if *metricName == "" {
+		return fmt.Errorf("the metric name must not be empty")
+	}
+
+	if err := validateDimensionName(*dimensionName); err != nil {
+		return err
+	}
+
+	if *highResolution && *publishInterval > time.Minute {
+		log.Printf("[WARN] High-resolution metrics requested with a %s publish interval, "+
+			"this costs more without adding any detail", *publishInterval)
+	}
+
+	reservedDimensions := []string{*dimensionName}
+	if *addInstanceDimension {
+		reservedDimensions = append(reservedDimensions, "InstanceId")
+	}
+	if *addAZDimension || *availabilityZoneOverride != "" {
+		reservedDimensions = append(reservedDimensions, "AvailabilityZone")
+	}
+	if *endpointDimension {
+		reservedDimensions = append(reservedDimensions, "Endpoint")
+	}
+	if *enableStatusProbe || *enableRevisionLagProbe || *enableAppliedLagProbe || *checkPeerURLs {
+		reservedDimensions = append(reservedDimensions, "Member")
+	}
+	if *enableVersionInfo {
+		reservedDimensions = append(reservedDimensions, "EtcdVersion")
+	}
+	reservedDimensions = append(reservedDimensions, "ErrorType")
+	if err := extraDimensions.Validate(reservedDimensions...); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setFlags defines the flags anew and parses args, as main does, so that
+// every flag holds its default unless set in args.
+func setFlags(t *testing.T, args ...string) {
+	t.Helper()
+
+	flag.CommandLine = flag.NewFlagSet("etcd-monitor", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(ioutil.Discard)
+	defineFlags()
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateFlagsDefaults(t *testing.T) {
+	tests := []struct {
+		args            []string
+		timeout         time.Duration
+		reporterTimeout time.Duration
+		publishInterval time.Duration
+	}{
+		{nil, 5 * time.Second, 5 * time.Second, time.Minute},
+		{[]string{"-interval", "4s"}, 2 * time.Second, 2 * time.Second, 4 * time.Second},
+		{[]string{"-interval", "10", "-reporter-timeout", "3s"}, 5 * time.Second, 3 * time.Second, 10 * time.Second},
+		{[]string{"-interval", "30s", "-publish-interval", "5m"}, 5 * time.Second, 5 * time.Second, 5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			setFlags(t, test.args...)
+			if err := validateFlags(); err != nil {
+				t.Fatalf("validateFlags() = %s, want no error", err)
+			}
+			if *checkTimeout != test.timeout {
+				t.Errorf("-timeout = %s, want %s", *checkTimeout, test.timeout)
+			}
+			if *reporterTimeout != test.reporterTimeout {
+				t.Errorf("-reporter-timeout = %s, want %s", *reporterTimeout, test.reporterTimeout)
+			}
+			if *publishInterval != test.publishInterval {
+				t.Errorf("-publish-interval = %s, want %s", *publishInterval, test.publishInterval)
+			}
+			if *alarmName != "etcd-monitor-etcd-unhealthy" {
+				t.Errorf("-alarm-name = %q, want etcd-monitor-etcd-unhealthy", *alarmName)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-interval", "0"}, "-interval must be positive"},
+		{[]string{"-interval", "10s", "-timeout", "10s"}, "-timeout 10s must be positive and less than"},
+		{[]string{"-jitter", "2m"}, "-jitter 2m0s must be at least 0"},
+		{[]string{"-interval", "30s", "-publish-interval", "45s"}, "-publish-interval must be a multiple"},
+		{[]string{"-failure-threshold", "0"}, "-failure-threshold and -recovery-threshold"},
+		{[]string{"-failure-threshold", "3", "-exit-after-failures", "2"}, "-exit-after-failures must be 0 or at least"},
+		{[]string{"-publish-mode", "sometimes"}, "invalid -publish-mode"},
+		{[]string{"-mode", "ssh"}, "invalid -mode"},
+		{[]string{"-address", "ftp://etcd:2379"}, "invalid etcd address"},
+		{[]string{"-health-method", "PUT"}, "invalid -health-method"},
+		{[]string{"-unhealthy-policy", "most"}, "invalid -unhealthy-policy"},
+		{[]string{"-emf", "-dry-run"}, "-emf and -dry-run are mutually exclusive"},
+		{[]string{"-no-cloudwatch", "-create-alarm"}, "-no-cloudwatch cannot be used"},
+		{[]string{"-external-id", "x"}, "-external-id requires -assume-role-arn"},
+		{[]string{"-username", "root"}, "-username requires -password-file"},
+		{[]string{"-password-file", "/etc/etcd/password"}, "-password-file requires -username"},
+		{[]string{"-tls-server-name", "etcd", "-tls-server-name-file", "names"}, "-tls-server-name cannot be combined"},
+		{[]string{"-pushgateway-username", "u"}, "-pushgateway-username requires -pushgateway-password-file"},
+		{[]string{"-slack-webhook-url", "https://hooks.slack.com/x", "-slack-webhook-url-file", "url"},
+			"-slack-webhook-url and -slack-webhook-url-file are mutually exclusive"},
+		{[]string{"-pagerduty-routing-key", "k", "-pagerduty-routing-key-file", "key"},
+			"-pagerduty-routing-key and -pagerduty-routing-key-file are mutually exclusive"},
+		{[]string{"-ses-to", "ops@example.com"}, "-ses-to requires -ses-from"},
+		{[]string{"-w", "2s", "-c", "1s"}, "-w must not be greater than -c"},
+		{[]string{"-metric-name", ""}, "the metric name must not be empty"},
+		{[]string{"-dimension", "Endpoint=x", "-endpoint-dimension"}, "Endpoint"},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			setFlags(t, test.args...)
+			err := validateFlags()
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validateFlags() = %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// StringList implements flag.Value for repeatable string flags. Values taken
+// from the environment are replaced as soon as the flag is used.
+type StringList struct {
+	values   []string
+	fromFlag bool
+}
+
+// ParseStringList parses a comma-separated list, ignoring empty entries.
+func ParseStringList(s string) *StringList {
+	l := &StringList{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l.values = append(l.values, v)
+		}
+	}
+
+	return l
+}
+
+func (l *StringList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(l.values, ",")
+}
+
+// Set adds a single value.
+func (l *StringList) Set(s string) error {
+	if !l.fromFlag {
+		l.values = nil
+		l.fromFlag = true
+	}
+	l.values = append(l.values, strings.TrimSpace(s))
+
+	return nil
+}
+
+// ForwardProbe scrapes the etcd Prometheus endpoint and publishes selected
+// counter and gauge series as they are. Counters are published as the increase
+// since the previous scrape.
+type ForwardProbe struct {
+	url   string
+	names []string
+
+	// previous holds the counter values of the previous successful scrape by
+	// series key.
+	previous map[string]float64
+	// checked is set once the configured names were compared against a
+	// successful scrape, so that unknown names are only logged once.
+	checked bool
+}
+
+// NewForwardProbe returns a probe forwarding the metric families names scraped
+// from url.
+func NewForwardProbe(url string, names []string) *ForwardProbe {
+	return &ForwardProbe{
+		url:      url,
+		names:    names,
+		previous: make(map[string]float64),
+	}
+}
+
+// Run scrapes the metrics endpoint and publishes the forwarded series.
+func (p *ForwardProbe) Run() {
+	families, err := scrapeMetrics(p.url)
+	if err != nil {
+		log.Printf("[ERROR] Failed to scrape %s: %s", p.url, err)
+		metrics.Add(newMetricData("MetricsScrapeFailures", 1, types.StandardUnitCount)...)
+		return
+	}
+
+	current := make(map[string]float64)
+	for _, name := range p.names {
+		family := families[name]
+		if family == nil {
+			if !p.checked {
+				log.Printf("[WARN] %s not found in %s, not forwarding it", name, p.url)
+			}
+			continue
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		default:
+			if !p.checked {
+				log.Printf("[WARN] %s is a %s, only counters and gauges can be forwarded",
+					name, strings.ToLower(family.GetType().String()))
+			}
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			dims := forwardDimensions(m.GetLabel())
+			if family.GetType() != dto.MetricType_COUNTER {
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				metrics.Add(buildMetricData([][]types.Dimension{dims}, name, value, forwardUnit(name), time.Now())...)
+				continue
+			}
+
+			key := promSeriesKey(name, m.GetLabel())
+			value := m.GetCounter().GetValue()
+			current[key] = value
+			previous, ok := p.previous[key]
+			if !ok || value < previous {
+				// First scrape, or etcd restarted and reset its counters.
+				continue
+			}
+			metrics.Add(buildMetricData([][]types.Dimension{dims}, name, value-previous, types.StandardUnitCount, time.Now())...)
+		}
+	}
+	p.previous = current
+	p.checked = true
+}
+
+// forwardDimensions returns the cluster dimensions followed by the Prometheus
+// labels, sorted by name, as long as CloudWatch allows more dimensions. Labels
+// with empty values or clashing with a cluster dimension are skipped as
+// CloudWatch rejects them.
+func forwardDimensions(labels []*dto.LabelPair) []types.Dimension {
+	sorted := append([]*dto.LabelPair(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+
+	dims := metricDimensions()
+	taken := make(map[string]bool)
+	for _, d := range dims {
+		taken[aws.ToString(d.Name)] = true
+	}
+	for _, l := range sorted {
+		if len(dims) >= maxDimensions {
+			break
+		}
+		if l.GetValue() == "" || taken[l.GetName()] {
+			continue
+		}
+		dims = append(dims, types.Dimension{
+			Name:  aws.String(l.GetName()),
+			Value: aws.String(l.GetValue()),
+		})
+	}
+
+	return dims
+}
+
+// promSeriesKey identifies a single series of the family name by its labels.
+func promSeriesKey(name string, labels []*dto.LabelPair) string {
+	parts := []string{name}
+	for _, l := range labels {
+		parts = append(parts, l.GetName()+"="+l.GetValue())
+	}
+	sort.Strings(parts[1:])
+
+	return strings.Join(parts, "|")
+}
+
+// forwardUnit guesses the CloudWatch unit of a gauge from the Prometheus
+// naming conventions.
+func forwardUnit(name string) types.StandardUnit {
+	switch {
+	case strings.HasSuffix(name, "_bytes"):
+		return types.StandardUnitBytes
+	case strings.HasSuffix(name, "_seconds"):
+		return types.StandardUnitSeconds
+	}
+
+	return types.StandardUnitNone
+}
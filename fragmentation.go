@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// FragmentationProbe publishes how much of each member's database file is free
+// space left behind by deletions and compactions, which only a defrag
+// returns.
+type FragmentationProbe struct {
+	warnPercent float64
+
+	// warned holds the members logged above the warning threshold, so that
+	// each crossing is only logged once.
+	warned map[string]bool
+	// unsupported holds the endpoints logged as not reporting dbSizeInUse.
+	unsupported map[string]bool
+}
+
+// NewFragmentationProbe returns a probe logging a warning when a member's
+// fragmentation crosses warnPercent, 0 disables the warning.
+func NewFragmentationProbe(warnPercent float64) *FragmentationProbe {
+	return &FragmentationProbe{
+		warnPercent: warnPercent,
+		warned:      make(map[string]bool),
+		unsupported: make(map[string]bool),
+	}
+}
+
+// Run publishes FragmentationPercent for every checked endpoint, with a Member
+// dimension and at the cluster level, where the Maximum statistic is the worst
+// member. Members too old to report dbSizeInUse are skipped.
+func (p *FragmentationProbe) Run() {
+	current := endpoints
+	statuses := make([]*StatusResponse, len(current))
+	queryEndpoints(current, func(i int, endpoint string) {
+		status, err := getStatus(endpoint)
+		if err != nil {
+			log.Printf("[WARN] Failed to get the status of %s for the fragmentation: %s", endpoint, err)
+			return
+		}
+		statuses[i] = status
+	})
+
+	var names map[uint64]string
+	for i, status := range statuses {
+		if status == nil || status.DBSize <= 0 {
+			continue
+		}
+		if status.DBSizeInUse == nil {
+			if !p.unsupported[current[i]] {
+				log.Printf("[INFO] etcd %s at %s does not report dbSizeInUse, skipping FragmentationPercent",
+					status.Version, current[i])
+				p.unsupported[current[i]] = true
+			}
+			continue
+		}
+
+		if names == nil {
+			names = memberNames(current[0])
+		}
+		name := names[status.Header.MemberID]
+		if name == "" {
+			name = fmt.Sprintf("%x", status.Header.MemberID)
+		}
+
+		percent := float64(status.DBSize-*status.DBSizeInUse) / float64(status.DBSize) * 100
+		if percent < 0 {
+			percent = 0
+		}
+		p.observe(name, percent)
+
+		metrics.Add(buildMetricData(memberDimensionSets(name), "FragmentationPercent", percent, types.StandardUnitPercent, time.Now())...)
+		metrics.Add(newMetricData("FragmentationPercent", percent, types.StandardUnitPercent)...)
+	}
+}
+
+// observe logs a warning when the member's fragmentation crosses the
+// threshold, and again only after it went back below.
+func (p *FragmentationProbe) observe(name string, percent float64) {
+	if p.warnPercent <= 0 || percent <= p.warnPercent {
+		delete(p.warned, name)
+		return
+	}
+	if p.warned[name] {
+		return
+	}
+
+	log.Printf("[WARN] etcd member %s is %.1f%% fragmented, above %.1f%%; consider a defrag", name, percent, p.warnPercent)
+	p.warned[name] = true
+}
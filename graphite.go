@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// maxGraphiteQueue bounds the lines kept while carbon is unreachable, a
+	// few minutes of checks for a handful of endpoints.
+	maxGraphiteQueue = 1000
+
+	// graphiteTimeout bounds connecting to carbon and every write, so that an
+	// unreachable carbon does not delay the checks.
+	graphiteTimeout = 2 * time.Second
+)
+
+// GraphiteSink sends the health check results to carbon in the plaintext
+// protocol. Over TCP, the lines are queued until they are written, so that a
+// carbon restart does not lose samples; over UDP they are fire-and-forget.
+type GraphiteSink struct {
+	network string
+	address string
+	prefix  string
+
+	// conn is nil before the first write and after a failed one, so that
+	// the next update reconnects.
+	conn  net.Conn
+	queue []string
+}
+
+// NewGraphiteSink returns a sink sending to carbon at address (host:port) over
+// network, tcp or udp, with metric paths starting with prefix. carbon does not
+// need to be up yet.
+func NewGraphiteSink(network, address, prefix string) (*GraphiteSink, error) {
+	switch network {
+	case "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("invalid protocol %q, expected tcp or udp", network)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, err
+	}
+
+	return &GraphiteSink{
+		network: network,
+		address: address,
+		prefix:  strings.TrimSuffix(prefix, "."),
+	}, nil
+}
+
+// newGraphiteReporter returns the sink of -graphite-address.
+func newGraphiteReporter() (Reporter, error) {
+	sink, err := NewGraphiteSink(*graphiteProtocol, *graphiteAddress, *graphitePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -graphite-address or -graphite-protocol: %s", err)
+	}
+
+	return sink, nil
+}
+
+// Report sends the result of a check, with the check timestamp:
+//
+//	<prefix>.<cluster>.unhealthy
+//	<prefix>.<cluster>.<endpoint>.unhealthy
+//	<prefix>.<cluster>.<endpoint>.check_latency (ms)
+func (s *GraphiteSink) Report(ctx context.Context, result CheckResult) error {
+	timestamp := result.Time.Unix()
+	base := s.prefix + "." + sanitizeMetricPath(*etcdName)
+
+	lines := []string{fmt.Sprintf("%s.unhealthy %g %d", base, result.UnhealthyCount, timestamp)}
+	for _, e := range result.Endpoints {
+		path := base + "." + sanitizeMetricPath(normalizeEndpoint(e.Endpoint))
+		unhealthy := 0
+		if !e.Healthy {
+			unhealthy = 1
+		}
+		lines = append(lines,
+			fmt.Sprintf("%s.unhealthy %d %d", path, unhealthy, timestamp),
+			fmt.Sprintf("%s.check_latency %g %d", path, e.LatencyMs, timestamp))
+	}
+
+	if s.network == "udp" {
+		return s.sendUDP(lines)
+	}
+
+	s.queue = append(s.queue, lines...)
+	if n := len(s.queue) - maxGraphiteQueue; n > 0 {
+		log.Printf("[WARN] Graphite send queue is full, dropping the %d oldest lines", n)
+		s.queue = s.queue[n:]
+	}
+
+	return s.flushQueue()
+}
+
+// flushQueue writes the queued lines over TCP, reconnecting first if needed.
+// On failure the lines stay queued for the next update; carbon keeps the last
+// value of a timestamp, so lines written twice do no harm.
+func (s *GraphiteSink) flushQueue() error {
+	if s.conn != nil && connClosed(s.conn) {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, graphiteTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+	if _, err := s.conn.Write([]byte(strings.Join(s.queue, "\n") + "\n")); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	s.queue = nil
+
+	return nil
+}
+
+// connClosed reports whether carbon closed conn, e.g. when it restarted.
+// carbon never sends anything, so a read only returns once the connection is
+// closed. Checking before writing keeps the first write after a restart from
+// being lost in a half-closed connection. The deadline is in the future since
+// Go does not even try to read past an expired one.
+func connClosed(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+
+	return true
+}
+
+// sendUDP writes lines in as few packets as possible, dropping them on
+// failure. The first error is returned.
+func (s *GraphiteSink) sendUDP(lines []string) error {
+	if s.conn == nil {
+		conn, err := net.Dial(s.network, s.address)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	// Every packet ends with a newline, which packLines does not count.
+	var firstErr error
+	for _, packet := range packLines(lines, maxStatsDPacket-1) {
+		if _, err := s.conn.Write([]byte(packet + "\n")); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	checkModeHTTP = "http"
+	checkModeGRPC = "grpc"
+
+	// grpcHealthKey is the key read by the gRPC health check, like
+	// "etcdctl endpoint health" does. It does not need to exist.
+	grpcHealthKey = "health"
+)
+
+// etcdClients are the gRPC clients by endpoint used in the "grpc" check mode,
+// nil otherwise. Every endpoint has its own client so that the balancer does
+// not hide a failing member.
+var etcdClients map[string]*clientv3.Client
+
+// newEtcdClient returns a gRPC client for the etcd member at endpoint.
+func newEtcdClient(endpoint string, tlsConfig *tls.Config) (*clientv3.Client, error) {
+	config := clientv3.Config{
+		Endpoints:   []string{endpoint},
+		TLS:         tlsServerNames.config(clientTLS.withRootCAs(tlsConfig), endpoint),
+		DialTimeout: *checkTimeout,
+		// Failures are logged by the health check itself.
+		Logger: zap.NewNop(),
+	}
+	if etcdAuth != nil {
+		config.Username, config.Password = etcdAuth.get()
+	}
+
+	return clientv3.New(config)
+}
+
+// reconnectEtcdClients replaces the gRPC clients, e.g. to authenticate with a
+// new password. A client that cannot be created keeps the old one.
+func reconnectEtcdClients(tlsConfig *tls.Config) {
+	for endpoint, old := range etcdClients {
+		c, err := newEtcdClient(endpoint, tlsConfig)
+		if err != nil {
+			log.Printf("[ERROR] Failed to recreate the etcd client for %s: %s", endpoint, err)
+			continue
+		}
+		old.Close()
+		etcdClients[endpoint] = c
+	}
+}
+
+// checkEndpointGRPC checks the health of the etcd member at endpoint through
+// the gRPC API with a linearizable read, which needs a working raft quorum.
+func checkEndpointGRPC(ctx context.Context, endpoint string) bool {
+	ctx, cancel := context.WithTimeout(ctx, *checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := etcdClients[endpoint].Get(ctx, grpcHealthKey)
+	reportHealthCheckLatency(endpoint, time.Since(start))
+	if err == nil {
+		return true
+	}
+
+	switch {
+	case isAuthError(err):
+		checkFailed(endpoint, errorTypeAuth, "Authentication on %s failed, check -username and the password file: %s", endpoint, err)
+	case errors.Is(err, rpctypes.ErrPermissionDenied):
+		checkFailed(endpoint, errorTypePermissionDenied,
+			"Health read on %s denied, check the permissions of the client certificate user: %s", endpoint, err)
+	case errors.Is(err, context.DeadlineExceeded), status.Code(err) == codes.DeadlineExceeded:
+		checkFailed(endpoint, errorTypeTimeout, "Health read on %s timed out after %s: %s", endpoint, *checkTimeout, err)
+	case status.Code(err) == codes.Unavailable:
+		checkFailed(endpoint, errorTypeUnavailable, "Failed to connect to etcd at %s: %s", endpoint, err)
+	default:
+		checkFailed(endpoint, classifyError(err), "Health read on %s failed: %s", endpoint, err)
+	}
+
+	return false
+}
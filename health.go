@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Health is the payload of the etcd /health endpoint. etcd 2.x and 3.x before
+// 3.4 quote the flag ({"health": "true"}), later versions add a reason and some
+// builds send a real boolean; both forms are accepted.
+type Health struct {
+	IsHealthy bool
+	Reason    string
+
+	// HasHealth is false if the payload has no health field at all.
+	HasHealth bool
+}
+
+// UnmarshalJSON decodes the payload, ignoring unknown fields.
+func (h *Health) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Health json.RawMessage `json:"health"`
+		Reason string          `json:"reason"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*h = Health{Reason: raw.Reason}
+	if len(raw.Health) == 0 || string(raw.Health) == "null" {
+		return nil
+	}
+	h.HasHealth = true
+
+	if err := json.Unmarshal(raw.Health, &h.IsHealthy); err == nil {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(raw.Health, &s); err != nil {
+		return fmt.Errorf("invalid health value %s", raw.Health)
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid health value %q", s)
+	}
+	h.IsHealthy = b
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useHTTPClient makes the health checks use a client with the transport tr,
+// and record their errors in a fresh state, until the test ends.
+func useHTTPClient(t *testing.T, tr http.RoundTripper) {
+	t.Helper()
+
+	savedClient, savedState := client, daemonState
+	client, daemonState = newHTTPClient(tr, 5*time.Second), newMonitorState()
+	t.Cleanup(func() { client, daemonState = savedClient, savedState })
+}
+
+// lastCheckError returns why the last check of endpoint failed.
+func lastCheckError(endpoint string) checkError {
+	daemonState.mu.Lock()
+	defer daemonState.mu.Unlock()
+
+	return daemonState.errors[endpoint]
+}
+
+func TestCheckEndpointHTTP(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		location  string
+		body      string
+		healthy   bool
+		errorType string
+		message   string
+	}{
+		{"etcd 2.3 healthy", http.StatusOK, "", `{"health": "true"}`, true, "", ""},
+		{"etcd 2.3 unhealthy", http.StatusOK, "", `{"health": "false"}`, false, "", "etcd reports unhealthy: no reason given"},
+		{"etcd 3.2 healthy", http.StatusOK, "", `{"health":"true"}`, true, "", ""},
+		{"etcd 3.2 unhealthy", http.StatusOK, "", `{"health":"false"}`, false, "", "etcd reports unhealthy: no reason given"},
+		{"etcd 3.4 healthy", http.StatusOK, "", `{"health":"true","reason":""}`, true, "", ""},
+		{"etcd 3.4 unhealthy", http.StatusOK, "", `{"health":"false","reason":"RAFT NO LEADER"}`, false, "", "etcd reports unhealthy: RAFT NO LEADER"},
+		{"etcd 3.5 healthy", http.StatusOK, "", `{"health":"true","reason":""}`, true, "", ""},
+		{"etcd 3.5 unhealthy", http.StatusServiceUnavailable, "", `{"health":"false","reason":"ALARM NOSPACE"}`, false, "HTTP5xx", "ALARM NOSPACE"},
+		{"boolean healthy", http.StatusOK, "", `{"health":true}`, true, "", ""},
+		{"boolean unhealthy", http.StatusOK, "", `{"health":false,"reason":"RAFT NO LEADER"}`, false, "", "etcd reports unhealthy: RAFT NO LEADER"},
+		{"unknown fields", http.StatusOK, "", `{"health":"true","reason":"","revision":42}`, true, "", ""},
+		{"no health field", http.StatusOK, "", `{"reason":""}`, false, errorTypeParse, "has no health field"},
+		{"invalid health value", http.StatusOK, "", `{"health":"maybe"}`, false, errorTypeParse, "invalid health value"},
+		{"not JSON", http.StatusOK, "", `<html>`, false, errorTypeParse, "Invalid health response payload"},
+		{"redirect", http.StatusTemporaryRedirect, "https://login.example.com/", "", false, "HTTP3xx", `redirected to "https://login.example.com/"`},
+		{"unauthorized", http.StatusUnauthorized, "", "unauthorized", false, errorTypeAuth, "Authentication on"},
+		{"server error", http.StatusInternalServerError, "", "internal error", false, "HTTP5xx", "returned 500 Internal Server Error: internal error"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t)
+			if err := validateFlags(); err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/health" {
+					http.NotFound(w, r)
+					return
+				}
+				if test.location != "" {
+					w.Header().Set("Location", test.location)
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+			useHTTPClient(t, http.DefaultTransport)
+
+			if healthy := checkEndpointHTTP(context.Background(), server.URL); healthy != test.healthy {
+				t.Errorf("checkEndpointHTTP() = %t, want %t", healthy, test.healthy)
+			}
+			got := lastCheckError(server.URL)
+			if got.errorType != test.errorType || !strings.Contains(got.message, test.message) {
+				t.Errorf("the check failed with %q (%s), want %q (%s)", got.message, got.errorType, test.message, test.errorType)
+			}
+		})
+	}
+}
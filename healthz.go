@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LivenessServer serves /healthz, telling whether the monitor itself is alive,
+// for Kubernetes liveness probes and fleet tooling: it is if the check loop
+// finished a check within the last two intervals. It says nothing about the
+// health of etcd, a monitor checking a failed cluster is alive.
+type LivenessServer struct {
+	server *http.Server
+
+	mu sync.Mutex
+	// last is when the check loop last finished a check, or started.
+	last     time.Time
+	checked  bool
+	interval time.Duration
+}
+
+// livenessResponse is the body of /healthz. It holds nothing about etcd or the
+// configuration beyond the check interval.
+type livenessResponse struct {
+	Status               string     `json:"status"`
+	Reason               string     `json:"reason,omitempty"`
+	LastCheck            *time.Time `json:"lastCheck,omitempty"`
+	CheckIntervalSeconds float64    `json:"checkIntervalSeconds"`
+}
+
+// NewLivenessServer starts serving /healthz on addr, e.g. ":8080". The first
+// check is due within interval.
+func NewLivenessServer(addr string, interval time.Duration) (*LivenessServer, error) {
+	s := &LivenessServer{
+		last:     time.Now(),
+		interval: interval,
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.serveHealthz)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := s.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("[ERROR] Liveness endpoint stopped: %s", err)
+		}
+	}()
+
+	return s, nil
+}
+
+// ran records that the check loop finished a check, with interval the check
+// interval from now on.
+func (s *LivenessServer) ran(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last = time.Now()
+	s.checked = true
+	s.interval = interval
+}
+
+// serveHealthz answers 200 if the check loop is alive and 503 with the reason
+// otherwise.
+func (s *LivenessServer) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	last, checked, interval := s.last, s.checked, s.interval
+	s.mu.Unlock()
+
+	response := livenessResponse{Status: "ok", CheckIntervalSeconds: interval.Seconds()}
+	if checked {
+		lastCheck := last.UTC()
+		response.LastCheck = &lastCheck
+	}
+	status := http.StatusOK
+	if age := time.Since(last); age > 2*interval {
+		status = http.StatusServiceUnavailable
+		response.Status = "unavailable"
+		if checked {
+			response.Reason = fmt.Sprintf("no check finished for %s, more than twice the check interval", age.Round(time.Second))
+		} else {
+			response.Reason = fmt.Sprintf("no check finished since the start %s ago, more than twice the check interval", age.Round(time.Second))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// Shutdown stops serving, waiting for running requests until ctx is done.
+func (s *LivenessServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
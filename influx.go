@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// influxMeasurement is the measurement the check results are written to.
+	influxMeasurement = "etcd_monitor"
+
+	// maxInfluxPending bounds the lines kept while InfluxDB is unreachable.
+	maxInfluxPending = 10000
+
+	// influxMaxRetries is how often a failed write is retried.
+	influxMaxRetries = 3
+)
+
+// InfluxSink writes the health check results to InfluxDB v2 in the line
+// protocol. The lines of a publish window are written in one gzipped request
+// in the background, so that a slow InfluxDB cannot delay the checks.
+type InfluxSink struct {
+	writeURL string
+	token    string
+	client   *http.Client
+	// ctx bounds the background writes. It is cancelled once Shutdown
+	// returns.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	pending []string
+	// done is closed when the running write finishes, nil if none is.
+	done   chan struct{}
+	failed int
+}
+
+// NewInfluxSink returns a sink writing to bucket of org on the InfluxDB server
+// at baseURL, authenticating with token.
+func NewInfluxSink(baseURL, org, bucket, token string) (*InfluxSink, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/api/v2/write")
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q", baseURL)
+	}
+	if org == "" || bucket == "" {
+		return nil, fmt.Errorf("the InfluxDB org and bucket must be set")
+	}
+	u.RawQuery = url.Values{"org": {org}, "bucket": {bucket}, "precision": {"ns"}}.Encode()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &InfluxSink{
+		writeURL: u.String(),
+		token:    token,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// newInfluxReporter returns the sink of -influx-url, with the token of
+// -influx-token-file or INFLUX_TOKEN.
+func newInfluxReporter() (Reporter, error) {
+	token, err := readInfluxToken(*influxTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := NewInfluxSink(*influxURL, *influxOrg, *influxBucket, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return sink, nil
+}
+
+// Report queues the lines for the result of a check: one for the cluster with
+// the unhealthy count, and one per endpoint with its unhealthy state,
+// latency_ms and error_type.
+func (s *InfluxSink) Report(ctx context.Context, result CheckResult) error {
+	timestamp := strconv.FormatInt(result.Time.UnixNano(), 10)
+	tags := influxTags()
+
+	lines := []string{fmt.Sprintf("%s%s unhealthy=%gi %s",
+		influxMeasurement, tags, result.UnhealthyCount, timestamp)}
+	for _, e := range result.Endpoints {
+		unhealthy := 0
+		if !e.Healthy {
+			unhealthy = 1
+		}
+		lines = append(lines, fmt.Sprintf("%s%s,endpoint=%s unhealthy=%di,latency_ms=%g,error_type=%s %s",
+			influxMeasurement, tags, escapeInfluxTag(normalizeEndpoint(e.Endpoint)),
+			unhealthy, e.LatencyMs, quoteInfluxString(e.ErrorType), timestamp))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = append(s.pending, lines...)
+	if n := len(s.pending) - maxInfluxPending; n > 0 {
+		log.Printf("[WARN] InfluxDB write queue is full, dropping the %d oldest lines", n)
+		s.pending = s.pending[n:]
+	}
+
+	return nil
+}
+
+// Flush starts writing the queued lines unless the previous write is still
+// running, in which case they are written with the next flush. A failed write
+// is retried with backoff and then put back into the queue. The write is not
+// bound to ctx, so that it can outlast the publish window; Shutdown waits for
+// it.
+func (s *InfluxSink) Flush(ctx context.Context) {
+	s.mu.Lock()
+	if s.done != nil || len(s.pending) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	lines := s.pending
+	s.pending = nil
+	done := make(chan struct{})
+	s.done = done
+	s.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		err := s.write(s.ctx, lines)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.done = nil
+		if err != nil {
+			s.failed++
+			log.Printf("[ERROR] Failed to write %d lines to InfluxDB, keeping them for the next flush (%d failed writes): %s",
+				len(lines), s.failed, err)
+			s.pending = append(lines, s.pending...)
+		}
+	}()
+}
+
+// Shutdown waits for the running write and then writes the remaining lines,
+// until ctx is done. A write still running then is cancelled.
+func (s *InfluxSink) Shutdown(ctx context.Context) error {
+	defer s.cancel()
+
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	lines := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(lines) == 0 {
+		return nil
+	}
+	if err := s.write(ctx, lines); err != nil {
+		return fmt.Errorf("failed to write %d lines to InfluxDB: %s", len(lines), err)
+	}
+
+	return nil
+}
+
+// write sends lines in one request, retrying rate limiting and server errors
+// until ctx is done.
+func (s *InfluxSink) write(ctx context.Context, lines []string) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	io.WriteString(zw, strings.Join(lines, "\n"))
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		code, err := s.post(ctx, body.Bytes())
+		retryable := err != nil || code == http.StatusTooManyRequests || code >= 500
+		if err == nil && code < 300 {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected status %d", code)
+		}
+		if !retryable || attempt >= influxMaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoffDelay(attempt)
+		log.Printf("[WARN] InfluxDB write failed (attempt %d of %d), retrying in %s: %s",
+			attempt+1, influxMaxRetries+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// post makes a single write request and returns its status code.
+func (s *InfluxSink) post(ctx context.Context, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Token "+s.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
+
+// readInfluxToken returns the InfluxDB API token from tokenFile, or from the
+// INFLUX_TOKEN environment variable if no file is given. A trailing newline is
+// not part of the token.
+func readInfluxToken(tokenFile string) (string, error) {
+	if tokenFile == "" {
+		token := os.Getenv("INFLUX_TOKEN")
+		if token == "" {
+			return "", fmt.Errorf("-influx-url requires -influx-token-file or the INFLUX_TOKEN environment variable")
+		}
+		return token, nil
+	}
+
+	buff, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the InfluxDB token file: %s", err)
+	}
+	token := strings.TrimRight(string(buff), "\r\n")
+	if token == "" {
+		return "", fmt.Errorf("the InfluxDB token file %s is empty", tokenFile)
+	}
+
+	return token, nil
+}
+
+// influxTags returns the tags of every line, derived from the CloudWatch
+// dimensions: the cluster and the extra dimensions, with a leading comma.
+func influxTags() string {
+	var b strings.Builder
+	b.WriteString(",cluster=" + escapeInfluxTag(*etcdName))
+	for _, d := range extraDimensions.dimensions {
+		b.WriteString("," + escapeInfluxTag(d.Name) + "=" + escapeInfluxTag(d.Value))
+	}
+
+	return b.String()
+}
+
+// escapeInfluxTag escapes a tag key or value for the line protocol.
+func escapeInfluxTag(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`).Replace(s)
+}
+
+// quoteInfluxString quotes a string field value for the line protocol.
+func quoteInfluxString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
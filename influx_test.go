@@ -0,0 +1,168 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// influxWrite is a write request received by the fake InfluxDB.
+type influxWrite struct {
+	query, authorization, encoding string
+	body                           string
+}
+
+func TestInfluxLineProtocol(t *testing.T) {
+	setFlags(t, "-name", "etcd a,b=c", "-dimension", "Team Name=storage=core")
+	writes := make(chan influxWrite, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(zr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writes <- influxWrite{
+			query:         r.URL.Path + "?" + r.URL.RawQuery,
+			authorization: r.Header.Get("Authorization"),
+			encoding:      r.Header.Get("Content-Encoding"),
+			body:          string(body),
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewInfluxSink(server.URL, "ops", "etcd", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := testEndpointsResult()
+	result.Time = time.Unix(1700000000, 5)
+	if err := sink.Report(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+	sink.Flush(context.Background())
+
+	var write influxWrite
+	select {
+	case write = <-writes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was written to InfluxDB")
+	}
+
+	if write.query != "/api/v2/write?bucket=etcd&org=ops&precision=ns" {
+		t.Errorf("wrote to %s, want the etcd bucket of ops in ns precision", write.query)
+	}
+	if write.authorization != "Token secret" || write.encoding != "gzip" {
+		t.Errorf("wrote with Authorization %q and Content-Encoding %q, want the token gzipped",
+			write.authorization, write.encoding)
+	}
+	want := `etcd_monitor,cluster=etcd\ a\,b\=c,Team\ Name=storage\=core unhealthy=0i 1700000000000000005` + "\n" +
+		`etcd_monitor,cluster=etcd\ a\,b\=c,Team\ Name=storage\=core,endpoint=etcd-0:2379 unhealthy=0i,latency_ms=12.5,error_type="" 1700000000000000005` + "\n" +
+		`etcd_monitor,cluster=etcd\ a\,b\=c,Team\ Name=storage\=core,endpoint=etcd-1:2379 unhealthy=1i,latency_ms=5000,error_type="Timeout" 1700000000000000005`
+	if write.body != want {
+		t.Errorf("wrote\n%s\nwant\n%s", write.body, want)
+	}
+}
+
+func TestInfluxShutdown(t *testing.T) {
+	tests := []struct {
+		name  string
+		hang  bool
+		err   error
+		lines int
+	}{
+		// Both the write running on shutdown and the lines queued since are
+		// written.
+		{"drained", false, nil, 6},
+		{"grace period expired", true, context.DeadlineExceeded, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t, "-name", "etcd-a")
+			captureLog(t)
+			release := make(chan struct{})
+			var mu sync.Mutex
+			lines := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				body, err := ioutil.ReadAll(zr)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				select {
+				case <-release:
+				case <-r.Context().Done():
+					return
+				}
+				mu.Lock()
+				lines += len(strings.Split(string(body), "\n"))
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+			if !test.hang {
+				time.AfterFunc(100*time.Millisecond, func() { close(release) })
+			}
+
+			sink, err := NewInfluxSink(server.URL, "ops", "etcd", "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sink.Report(context.Background(), testEndpointsResult())
+			sink.Flush(context.Background())
+			sink.Report(context.Background(), testEndpointsResult())
+
+			const grace = 500 * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), grace)
+			defer cancel()
+			start := time.Now()
+			err = sink.Shutdown(ctx)
+			if elapsed := time.Since(start); elapsed > 2*grace {
+				t.Errorf("Shutdown took %s, longer than the %s grace period", elapsed, grace)
+			}
+			if err != test.err {
+				t.Errorf("Shutdown() = %v, want %v", err, test.err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if lines != test.lines {
+				t.Errorf("%d lines were written, want %d", lines, test.lines)
+			}
+		})
+	}
+}
+
+func TestQuoteInfluxString(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", `""`},
+		{"Timeout", `"Timeout"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\etcd`, `"C:\\etcd"`},
+		{"two\nlines", `"two\nlines"`},
+	}
+
+	for _, test := range tests {
+		if got := quoteInfluxString(test.value); got != test.want {
+			t.Errorf("quoteInfluxString(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
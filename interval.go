@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// intervalValue is a flag.Value for the intervals, a duration such as "30s"
+// or "5m". A bare integer is a number of seconds, as the intervals were
+// before they took durations.
+type intervalValue time.Duration
+
+// newIntervalFlag defines an interval flag with the given default, like
+// flag.Duration.
+func newIntervalFlag(name string, def time.Duration, usage string) *time.Duration {
+	d := def
+	flag.Var((*intervalValue)(&d), name, usage)
+
+	return &d
+}
+
+func (v *intervalValue) Set(s string) error {
+	d, err := parseInterval(s)
+	if err != nil {
+		return err
+	}
+	*v = intervalValue(d)
+
+	return nil
+}
+
+func (v *intervalValue) String() string {
+	return time.Duration(*v).String()
+}
+
+// parseInterval parses an interval: a duration, or a bare integer of seconds.
+func parseInterval(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q, expected a duration such as 30s or a number of seconds", s)
+	}
+
+	return d, nil
+}
+
+// envInterval is like envString for intervals. An invalid value is fatal.
+func envInterval(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	d, err := parseInterval(v)
+	if err != nil {
+		log.Fatalf("%s: %s", key, err)
+	}
+
+	return d
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"60", time.Minute, false},
+		{"0", 0, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"30s", 30 * time.Second, false},
+		{"5m", 5 * time.Minute, false},
+		{"1.5", 0, true},
+		{"1 minute", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseInterval(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("parseInterval(%q) error = %v, want error %t", test.value, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseInterval(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestIntervalFlagsTakeSeconds(t *testing.T) {
+	setFlags(t, "-interval", "30", "-publish-interval", "300", "-heartbeat-interval", "600")
+
+	for _, test := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"-interval", *interval, 30 * time.Second},
+		{"-publish-interval", *publishInterval, 5 * time.Minute},
+		{"-heartbeat-interval", *heartbeatInterval, 10 * time.Minute},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s = %s, want %s", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestIntervalEnvironmentTakesSeconds(t *testing.T) {
+	t.Setenv("CHECK_INTERVAL", "15")
+	t.Setenv("HEARTBEAT_INTERVAL", "120")
+	setFlags(t)
+
+	if *interval != 15*time.Second {
+		t.Errorf("CHECK_INTERVAL=15 gives -interval %s, want 15s", *interval)
+	}
+	if *heartbeatInterval != 2*time.Minute {
+		t.Errorf("HEARTBEAT_INTERVAL=120 gives -heartbeat-interval %s, want 2m", *heartbeatInterval)
+	}
+}
+
+func TestHeartbeatIntervalBelowInterval(t *testing.T) {
+	setFlags(t, "-publish-mode", "changes", "-interval", "1m", "-heartbeat-interval", "30")
+
+	err := validateFlags()
+	if err == nil || !strings.Contains(err.Error(), "-heartbeat-interval must be at least -interval") {
+		t.Errorf("validateFlags() = %v, want -heartbeat-interval rejected", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseJitter parses the -jitter value, a duration such as "5s" or a
+// percentage of interval such as "10%".
+func parseJitter(value string, interval time.Duration) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid jitter %q: %s", value, err)
+		}
+		return time.Duration(p / 100 * float64(interval)), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid jitter %q: %s", value, err)
+	}
+
+	return d, nil
+}
+
+// checkSchedule times the checks. They are due every interval from the start,
+// each delayed by a random offset of up to jitter that is drawn again for
+// every check, so that monitors started together spread out while their
+// checks still average one per interval. The first check is delayed the same
+// way. Checks that fall due while one is still running are skipped, as with a
+// time.Ticker.
+type checkSchedule struct {
+	interval time.Duration
+	jitter   time.Duration
+	// due is when the next check is due before the offset.
+	due   time.Time
+	timer *time.Timer
+}
+
+// newCheckSchedule returns a schedule with its first check due now, plus the
+// offset.
+func newCheckSchedule(interval, jitter time.Duration) *checkSchedule {
+	s := &checkSchedule{
+		interval: interval,
+		jitter:   jitter,
+		due:      time.Now(),
+	}
+	s.timer = time.NewTimer(s.offset())
+
+	return s
+}
+
+// C delivers when the next check is to run.
+func (s *checkSchedule) C() <-chan time.Time {
+	return s.timer.C
+}
+
+// next schedules the check after the one that just ran.
+func (s *checkSchedule) next() {
+	now := time.Now()
+	s.due = s.due.Add(s.interval)
+	for s.due.Before(now) {
+		s.due = s.due.Add(s.interval)
+	}
+	s.timer.Reset(s.due.Sub(now) + s.offset())
+}
+
+// reset restarts the schedule with a new interval and jitter, the next check
+// due an interval from now. A check that was due already is skipped.
+func (s *checkSchedule) reset(interval, jitter time.Duration) {
+	if !s.timer.Stop() {
+		select {
+		case <-s.timer.C:
+		default:
+		}
+	}
+	s.interval, s.jitter = interval, jitter
+	s.due = time.Now().Add(interval)
+	s.timer.Reset(interval + s.offset())
+}
+
+// Stop stops the schedule.
+func (s *checkSchedule) Stop() {
+	s.timer.Stop()
+}
+
+// offset returns a random delay between zero and the jitter.
+func (s *checkSchedule) offset() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
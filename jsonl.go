@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// jsonlRecord is the result of checking one endpoint, written as one line by
+// JSONLSink. The fields are part of the documented output format; add new ones
+// rather than renaming or removing them.
+type jsonlRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Endpoint  string    `json:"endpoint"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error"`
+	ErrorType string    `json:"error_type"`
+}
+
+// JSONLSink appends the health check results as JSON lines to stdout or a
+// file, for log shippers in environments without a metrics backend.
+type JSONLSink struct {
+	path string
+
+	// mu guards the output, which is replaced on SIGHUP while a check may
+	// be writing.
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+// NewJSONLSink returns a sink appending to the file at path, created if
+// needed, or writing to stdout if path is "-".
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	s := &JSONLSink{path: path, w: os.Stdout}
+	if path == "-" {
+		return s, nil
+	}
+
+	f, err := openJSONLFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s.w, s.file = f, f
+
+	return s, nil
+}
+
+// newJSONLReporter returns the sink of -jsonl-output.
+func newJSONLReporter() (*JSONLSink, error) {
+	return NewJSONLSink(*jsonlOutput)
+}
+
+// Report writes a line per endpoint for the result of a check. The lines of a
+// check are written at once, so that they are not interleaved with other
+// output.
+func (s *JSONLSink) Report(ctx context.Context, result CheckResult) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range result.Endpoints {
+		enc.Encode(jsonlRecord{
+			Timestamp: result.Time,
+			Endpoint:  e.Endpoint,
+			Healthy:   e.Healthy,
+			LatencyMs: e.LatencyMs,
+			Error:     e.Error,
+			ErrorType: e.ErrorType,
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(buf.Bytes())
+
+	return err
+}
+
+// Reopen reopens the output file, e.g. after logrotate moved it away. The old
+// file is kept if the new one cannot be opened.
+func (s *JSONLSink) Reopen() error {
+	if s.file == nil {
+		return nil
+	}
+
+	f, err := openJSONLFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file.Close()
+	s.w, s.file = f, f
+
+	return nil
+}
+
+func openJSONLFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the JSON lines output: %s", err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readJSONL returns the lines of the JSON lines file at path, decoded.
+func readJSONL(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(buff), "\n"), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("%s is not a JSON object: %s", line, err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestJSONLFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	sink, err := NewJSONLSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := testEndpointsResult()
+	result.Time = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := sink.Report(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readJSONL(t, path)
+	if len(records) != 2 {
+		t.Fatalf("wrote %d lines, want one per endpoint", len(records))
+	}
+	want := []map[string]interface{}{
+		{
+			"timestamp":  "2024-05-01T12:00:00Z",
+			"endpoint":   "https://etcd-0:2379",
+			"healthy":    true,
+			"latency_ms": 12.5,
+			"error":      "",
+			"error_type": "",
+		},
+		{
+			"timestamp":  "2024-05-01T12:00:00Z",
+			"endpoint":   "https://etcd-1:2379",
+			"healthy":    false,
+			"latency_ms": 5000.0,
+			"error":      "Failed to connect",
+			"error_type": "Timeout",
+		},
+	}
+	for i, record := range records {
+		var fields []string
+		for name := range record {
+			fields = append(fields, name)
+		}
+		sort.Strings(fields)
+		if got := strings.Join(fields, ","); got != "endpoint,error,error_type,healthy,latency_ms,timestamp" {
+			t.Errorf("line %d has the fields %s, want endpoint, error, error_type, healthy, latency_ms and timestamp", i+1, got)
+		}
+		for name, value := range want[i] {
+			if record[name] != value {
+				t.Errorf("line %d has %s %v, want %v", i+1, name, record[name], value)
+			}
+		}
+	}
+}
+
+func TestJSONLReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	sink, err := NewJSONLSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Report(context.Background(), testEndpointsResult()); err != nil {
+		t.Fatal(err)
+	}
+
+	// logrotate moves the file away and signals the monitor.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Report(context.Background(), testEndpointsResult()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(readJSONL(t, path+".1")); n != 2 {
+		t.Errorf("the rotated file has %d lines, want the 2 of the first check", n)
+	}
+	if n := len(readJSONL(t, path)); n != 2 {
+		t.Errorf("the new file has %d lines, want the 2 of the second check", n)
+	}
+}
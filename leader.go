@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// LeaderProbe publishes whether the cluster has a leader that all checked
+// endpoints agree on. /health can pass on a follower while elections thrash,
+// which this catches.
+type LeaderProbe struct {
+	timeout time.Duration
+}
+
+// NewLeaderProbe returns a probe querying the status of every checked endpoint
+// with timeout.
+func NewLeaderProbe(timeout time.Duration) *LeaderProbe {
+	return &LeaderProbe{timeout: timeout}
+}
+
+// Run queries the status of the checked endpoints and publishes HasLeader. It
+// is 0 if any endpoint reports no leader, the endpoints disagree on the
+// leader, or none of them answered.
+func (p *LeaderProbe) Run() {
+	current := endpoints
+	leaders := make([]uint64, len(current))
+	answered := make([]bool, len(current))
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	queryEndpoints(current, func(i int, endpoint string) {
+		status, err := getStatusContext(ctx, endpoint)
+		if err != nil {
+			log.Printf("[ERROR] Failed to get the leader from %s: %s", endpoint, err)
+			metrics.Add(newEndpointMetricData(endpoint, "LeaderProbeFailures", 1, types.StandardUnitCount)...)
+			return
+		}
+		leaders[i], answered[i] = status.Leader, true
+	})
+
+	seen := make(map[uint64][]string)
+	for i, endpoint := range current {
+		if !answered[i] {
+			continue
+		}
+		seen[leaders[i]] = append(seen[leaders[i]], endpoint)
+
+		hasLeader := 0.0
+		if leaders[i] != 0 {
+			hasLeader = 1.0
+		}
+		metrics.Add(buildMetricData(endpointOnlyDimensionSets(endpoint), "HasLeader", hasLeader, types.StandardUnitNone, time.Now())...)
+	}
+
+	_, noLeader := seen[0]
+	hasLeader := 0.0
+	switch {
+	case len(seen) > 1:
+		log.Printf("[WARN] etcd endpoints disagree on the leader: %s", formatLeaders(seen))
+	case len(seen) == 1 && !noLeader:
+		hasLeader = 1.0
+	}
+	metrics.Add(newMetricData("HasLeader", hasLeader, types.StandardUnitNone)...)
+}
+
+// formatLeaders renders the endpoints by reported leader ID for the log, with
+// 0 meaning no leader.
+func formatLeaders(seen map[uint64][]string) string {
+	ids := make([]uint64, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%x (%s)", id, strings.Join(seen[id], ", ")))
+	}
+
+	return strings.Join(parts, ", ")
+}
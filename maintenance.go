@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// healthStateMaintenance is the state of the cluster, and of its failing
+// endpoints, while a maintenance window is in effect.
+const healthStateMaintenance = "maintenance"
+
+// The -maintenance-report values: how the checks within a maintenance window
+// are reported.
+const (
+	maintenanceReportHealthy = "healthy"
+	maintenanceReportMetric  = "metric"
+)
+
+// The recurrences of a maintenance window.
+var maintenanceRecurrences = map[string]time.Duration{
+	"":       0,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// maintenanceWindow is a planned maintenance of the cluster, e.g. an etcd
+// upgrade, from start to end and, if period is set, again every period.
+type maintenanceWindow struct {
+	name       string
+	start, end time.Time
+	period     time.Duration
+}
+
+// occurrence returns the end of the occurrence of w in effect at t, false if
+// none is.
+func (w maintenanceWindow) occurrence(t time.Time) (time.Time, bool) {
+	if t.Before(w.start) {
+		return time.Time{}, false
+	}
+
+	start := w.start
+	if w.period > 0 {
+		start = t.Add(-t.Sub(w.start) % w.period)
+	}
+	end := start.Add(w.end.Sub(w.start))
+
+	return end, t.Before(end)
+}
+
+// maintenanceFile is the file the maintenance windows are read from. It is
+// read again before every check, so that windows can be added and removed
+// without a restart.
+type maintenanceFile struct {
+	path    string
+	data    []byte
+	windows []maintenanceWindow
+	// current is the name of the window in effect at the last check.
+	current string
+}
+
+// loadMaintenanceFile reads the maintenance windows in the file at path. An
+// invalid window is an error.
+func loadMaintenanceFile(path string) (*maintenanceFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	windows, err := parseMaintenanceWindows(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return &maintenanceFile{path: path, data: data, windows: windows}, nil
+}
+
+// reload reads the file again if it changed. If it cannot be read or holds an
+// invalid window, the windows read before are kept; a removed file has none.
+func (m *maintenanceFile) reload() {
+	if m == nil {
+		return
+	}
+
+	data, err := os.ReadFile(m.path)
+	switch {
+	case os.IsNotExist(err):
+		data = nil
+	case err != nil:
+		log.Printf("[ERROR] Failed to read the maintenance windows, keeping the old ones: %s", err)
+		return
+	}
+	if bytes.Equal(data, m.data) {
+		return
+	}
+
+	windows, err := parseMaintenanceWindows(data)
+	if err != nil {
+		log.Printf("[ERROR] Invalid maintenance windows in %s, keeping the old ones: %s", m.path, err)
+		return
+	}
+	m.data, m.windows = data, windows
+	log.Printf("[INFO] Reloaded %d maintenance windows from %s", len(windows), m.path)
+}
+
+// active returns the name of the window in effect at t, empty if none is,
+// logging the windows starting and ending. A nil file has no windows.
+func (m *maintenanceFile) active(t time.Time) string {
+	if m == nil {
+		return ""
+	}
+
+	name := ""
+	var end time.Time
+	for _, w := range m.windows {
+		if e, ok := w.occurrence(t); ok {
+			name, end = w.name, e
+			break
+		}
+	}
+
+	switch {
+	case name != "":
+		log.Printf("[INFO] Maintenance window %q in effect until %s, not reporting etcd unhealthy", name, end.Format(time.RFC3339))
+	case m.current != "":
+		log.Printf("[INFO] Maintenance window %q is over", m.current)
+	}
+	m.current = name
+
+	return name
+}
+
+// maintenanceWindowSpec is a maintenance window as written in the file.
+type maintenanceWindowSpec struct {
+	Name       string `yaml:"name"`
+	Start      string `yaml:"start"`
+	End        string `yaml:"end"`
+	Recurrence string `yaml:"recurrence"`
+}
+
+// parseMaintenanceWindows parses a maintenance file, YAML or JSON:
+//
+//	windows:
+//	  - name: etcd 3.5 upgrade
+//	    start: 2026-10-20T22:00:00Z
+//	    end: 2026-10-20T23:00:00Z
+//	  - name: weekly patching
+//	    start: 2026-10-18T02:00:00+02:00
+//	    end: 2026-10-18T02:30:00+02:00
+//	    recurrence: weekly
+func parseMaintenanceWindows(data []byte) ([]maintenanceWindow, error) {
+	var doc struct {
+		Windows []maintenanceWindowSpec `yaml:"windows"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&doc); err != nil && len(bytes.TrimSpace(data)) > 0 {
+		return nil, err
+	}
+
+	var windows []maintenanceWindow
+	for i, spec := range doc.Windows {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("window %d has no name", i+1)
+		}
+		start, err := time.Parse(time.RFC3339, spec.Start)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: invalid start, expected RFC 3339 such as 2026-10-20T22:00:00Z: %s", spec.Name, err)
+		}
+		end, err := time.Parse(time.RFC3339, spec.End)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: invalid end, expected RFC 3339 such as 2026-10-20T23:00:00Z: %s", spec.Name, err)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("window %q ends before it starts", spec.Name)
+		}
+		period, ok := maintenanceRecurrences[spec.Recurrence]
+		if !ok {
+			return nil, fmt.Errorf("window %q: invalid recurrence %q, expected \"daily\" or \"weekly\"", spec.Name, spec.Recurrence)
+		}
+		if period > 0 && end.Sub(start) >= period {
+			return nil, fmt.Errorf("window %q lasts longer than its %s recurrence", spec.Name, spec.Recurrence)
+		}
+		windows = append(windows, maintenanceWindow{name: spec.Name, start: start, end: end, period: period})
+	}
+
+	return windows, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// Member is a member of the etcd cluster as reported by the v3 gRPC gateway.
+type Member struct {
+	ID         uint64   `json:"ID,string"`
+	Name       string   `json:"name"`
+	PeerURLs   []string `json:"peerURLs"`
+	ClientURLs []string `json:"clientURLs"`
+	IsLearner  bool     `json:"isLearner"`
+}
+
+// MemberListResponse is the response of the member list call.
+type MemberListResponse struct {
+	Members []Member `json:"members"`
+}
+
+// MemberProbe publishes the number of cluster members and logs when it
+// changes.
+type MemberProbe struct {
+	endpoint string
+	expected int
+
+	// count is the member count seen by the previous successful check, or
+	// -1 before the first one.
+	count int
+}
+
+// NewMemberProbe returns a probe listing the members through the etcd member
+// at endpoint. If expected is positive, a mismatch metric is published too.
+func NewMemberProbe(endpoint string, expected int) *MemberProbe {
+	return &MemberProbe{
+		endpoint: endpoint,
+		expected: expected,
+		count:    -1,
+	}
+}
+
+// getMembers lists the cluster members through the v3 gRPC gateway of the etcd
+// member at endpoint.
+func getMembers(endpoint string) ([]Member, error) {
+	return getMembersContext(context.Background(), endpoint)
+}
+
+// getMembersContext is like getMembers, but gives up once ctx is done.
+func getMembersContext(ctx context.Context, endpoint string) ([]Member, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v3/cluster/member/list", endpoint), strings.NewReader("{}"))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(buff)))
+	}
+
+	var list MemberListResponse
+	if err := json.Unmarshal(buff, &list); err != nil {
+		return nil, fmt.Errorf("invalid member list response payload: %s", err)
+	}
+
+	return list.Members, nil
+}
+
+// queryMembers calls fn concurrently with the index and first client URL of
+// every member that has one, so that all members are sampled at about the same
+// time. It returns once all calls are done.
+func queryMembers(members []Member, fn func(i int, endpoint string)) {
+	var wg sync.WaitGroup
+	for i, member := range members {
+		if len(member.ClientURLs) == 0 {
+			// Not started yet, or a learner without client URLs.
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			fn(i, endpoint)
+		}(i, member.ClientURLs[0])
+	}
+	wg.Wait()
+}
+
+// queryEndpoints calls fn concurrently with the index of every endpoint, like
+// queryMembers. It returns once all calls are done.
+func queryEndpoints(endpoints []string, fn func(i int, endpoint string)) {
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			fn(i, endpoint)
+		}(i, endpoint)
+	}
+	wg.Wait()
+}
+
+// Run lists the members and publishes MemberCount, and MemberCountMismatch if
+// an expected count is configured.
+func (p *MemberProbe) Run() {
+	members, err := getMembers(p.endpoint)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list etcd members: %s", err)
+		metrics.Add(newEndpointMetricData(p.endpoint, "MemberProbeFailures", 1, types.StandardUnitCount)...)
+		return
+	}
+
+	count := len(members)
+	if p.count >= 0 && count != p.count {
+		log.Printf("[INFO] etcd member count changed from %d to %d", p.count, count)
+	}
+	p.count = count
+
+	metrics.Add(newMetricData("MemberCount", float64(count), types.StandardUnitCount)...)
+	if p.expected > 0 {
+		mismatch := 0.0
+		if count != p.expected {
+			log.Printf("[WARN] etcd has %d members, expected %d", count, p.expected)
+			mismatch = 1.0
+		}
+		metrics.Add(newMetricData("MemberCountMismatch", mismatch, types.StandardUnitNone)...)
+	}
+}
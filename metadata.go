@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// metadataTimeout bounds every instance metadata request so that startup
+// outside of EC2 is not blocked by the SDK's default timeouts and retries.
+const metadataTimeout = 2 * time.Second
+
+// newMetadataClient returns an EC2 instance metadata client that fails fast.
+func newMetadataClient(cfg aws.Config) *imds.Client {
+	return imds.NewFromConfig(cfg, func(o *imds.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+}
+
+// getMetadata returns the instance metadata value at path.
+func getMetadata(ctx context.Context, c *imds.Client, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+
+	out, err := c.GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+	if err != nil {
+		return "", err
+	}
+	defer out.Content.Close()
+
+	buff, err := ioutil.ReadAll(out.Content)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(buff)), nil
+}
+
+// lookupInstanceID returns the EC2 instance ID of the host, or an empty
+// string if the instance metadata service is not reachable.
+func lookupInstanceID(ctx context.Context, c *imds.Client) string {
+	id, err := getMetadata(ctx, c, "instance-id")
+	if err != nil {
+		log.Printf("[WARN] Unable to determine the EC2 instance ID, publishing without the InstanceId dimension: %s", err)
+		return ""
+	}
+
+	return id
+}
+
+// lookupAvailabilityZone returns the availability zone of the host, or an
+// empty string if the instance metadata service is not reachable.
+func lookupAvailabilityZone(ctx context.Context, c *imds.Client) string {
+	az, err := getMetadata(ctx, c, "placement/availability-zone")
+	if err != nil {
+		log.Printf("[WARN] Unable to determine the availability zone, publishing without the AvailabilityZone dimension: %s", err)
+		return ""
+	}
+
+	return az
+}
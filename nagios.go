@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The exit codes of the check command, as understood by Nagios and compatible
+// monitoring systems.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// runNagiosCheck runs a single health check for the check command, prints the
+// status line and returns the exit code. The latency of the slowest endpoint
+// is compared to the warning and critical thresholds, each disabled if zero.
+// The consecutive failures are those of the monitor writing the state file at
+// stateFile, if any. With a publish timeout the metrics of the check are
+// published, within that time.
+func runNagiosCheck(warning, critical time.Duration, stateFile string, publishTimeout time.Duration) int {
+	reporters = NewFanOutReporter(*checkTimeout)
+	cloudWatchReporter = NewCloudWatchReporter(metrics)
+	reporters.Add("CloudWatch", cloudWatchReporter)
+
+	result, _ := checkEtcdHealth(context.Background())
+	reporters.Report(context.Background(), result)
+	if publishTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		reporters.Flush(ctx)
+		cancel()
+	}
+
+	healthy, latency, reason := summarizeCheck(result)
+
+	code := nagiosOK
+	message := fmt.Sprintf("etcd %s is healthy, %d of %d endpoints healthy, latency %s",
+		*etcdName, healthy, len(result.Endpoints), latency.Round(time.Millisecond))
+	switch {
+	case result.RawUnhealthyCount > 0:
+		code = nagiosCritical
+		message = fmt.Sprintf("etcd %s IS NOT healthy, %d of %d endpoints healthy: %s",
+			*etcdName, healthy, len(result.Endpoints), reason)
+	case critical > 0 && latency >= critical:
+		code = nagiosCritical
+		message += fmt.Sprintf(" (critical at %s)", critical)
+	case warning > 0 && latency >= warning:
+		code = nagiosWarning
+		message += fmt.Sprintf(" (warning at %s)", warning)
+	}
+
+	perfdata := fmt.Sprintf("latency=%.3fms;%s;%s;0", float64(latency)/float64(time.Millisecond), nagiosThreshold(warning), nagiosThreshold(critical))
+	if stateFile != "" {
+		if failures, ok := readStateFileFailures(stateFile); ok {
+			perfdata += fmt.Sprintf(" consecutive_failures=%d;;;0", failures)
+		}
+	}
+	printNagiosStatus(code, message+" | "+perfdata)
+
+	return code
+}
+
+// summarizeCheck returns how many endpoints passed the check in result, the
+// latency of the slowest one and why the first failed one did. A single check
+// is not debounced by the failure threshold, so the raw results count.
+func summarizeCheck(result CheckResult) (int, time.Duration, string) {
+	var latencyMs float64
+	healthy := 0
+	reason := ""
+	for _, e := range result.Endpoints {
+		if e.LatencyMs > latencyMs {
+			latencyMs = e.LatencyMs
+		}
+		if e.ConsecutiveFailures == 0 {
+			healthy++
+		} else if reason == "" {
+			reason = e.Error
+			if reason == "" {
+				reason = normalizeEndpoint(e.Endpoint) + " IS NOT healthy"
+			}
+		}
+	}
+
+	return healthy, time.Duration(latencyMs * float64(time.Millisecond)), reason
+}
+
+// printNagiosStatus prints the single status line of the check command.
+func printNagiosStatus(code int, message string) {
+	// Only the first line of the output is the status.
+	message = strings.Join(strings.Fields(strings.Replace(message, "\n", " ", -1)), " ")
+	fmt.Printf("ETCD %s - %s\n", nagiosStates[code], message)
+}
+
+// nagiosThreshold formats a latency threshold for the perfdata, in
+// milliseconds, or empty if disabled.
+func nagiosThreshold(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
+
+// readStateFileFailures returns the consecutive failed checks recorded in the
+// state file at path by a running monitor.
+func readStateFileFailures(path string) (int, bool) {
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	var doc stateFileDocument
+	if err := json.Unmarshal(buff, &doc); err != nil {
+		return 0, false
+	}
+
+	return doc.ConsecutiveFailures, true
+}
+
+// nagiosLogWriter keeps the check command quiet, its output is the status
+// line only. Every message logged by the monitor has a level except those of
+// log.Fatal, the configuration errors, which are printed as an UNKNOWN status
+// instead: exiting with 1 would be a WARNING.
+type nagiosLogWriter struct{}
+
+func (nagiosLogWriter) Write(p []byte) (int, error) {
+	if line := string(p); !strings.HasPrefix(line, "[") {
+		printNagiosStatus(nagiosUnknown, line)
+		os.Exit(nagiosUnknown)
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// notifyMaxRetries is how often a failed notification is retried.
+	notifyMaxRetries = 5
+
+	// notifyTimeout bounds every single delivery attempt.
+	notifyTimeout = 10 * time.Second
+
+	// notifyQueueSize is how many transitions wait for delivery at most.
+	notifyQueueSize = 100
+)
+
+// Transition is a change of the cluster health between two checks.
+type Transition struct {
+	// Healthy is the new state.
+	Healthy bool
+	Time    time.Time
+	// Since is when the previous state began, i.e. the start of the outage
+	// on recovery.
+	Since time.Time
+	// ConsecutiveFailures is the number of failed checks: 1 when the
+	// cluster became unhealthy, the length of the outage on recovery.
+	ConsecutiveFailures int
+	Result              CheckResult
+}
+
+// Reason summarizes why the endpoints of the check are unhealthy, or is empty
+// if all are healthy.
+func (t Transition) Reason() string {
+	var reasons []string
+	for _, e := range t.Result.Endpoints {
+		if e.Healthy {
+			continue
+		}
+		reason := e.Error
+		if reason == "" {
+			reason = "unhealthy"
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s", normalizeEndpoint(e.Endpoint), reason))
+	}
+
+	return strings.Join(reasons, "; ")
+}
+
+// FailedEndpoints returns the endpoints that failed the check.
+func (t Transition) FailedEndpoints() []string {
+	var failed []string
+	for _, e := range t.Result.Endpoints {
+		if !e.Healthy {
+			failed = append(failed, e.Endpoint)
+		}
+	}
+
+	return failed
+}
+
+// transitionDetector turns check results into transitions. The cluster counts
+// as healthy before the first check, so that a monitor started during an
+// outage notifies about it.
+type transitionDetector struct {
+	unhealthy bool
+	since     time.Time
+	failures  int
+}
+
+// observe returns the transition result makes, if any.
+func (d *transitionDetector) observe(result CheckResult) (Transition, bool) {
+	unhealthy := result.UnhealthyCount > 0
+	if d.since.IsZero() {
+		d.since = result.Time
+	}
+	if unhealthy {
+		d.failures++
+	}
+	if unhealthy == d.unhealthy {
+		return Transition{}, false
+	}
+
+	t := Transition{
+		Healthy:             !unhealthy,
+		Time:                result.Time,
+		Since:               d.since,
+		ConsecutiveFailures: d.failures,
+		Result:              result,
+	}
+	d.unhealthy, d.since = unhealthy, result.Time
+	if !unhealthy {
+		d.failures = 0
+	}
+
+	return t, true
+}
+
+// endpointChange is the state of an endpoint after a check. Previous is
+// "unknown" for an endpoint checked for the first time.
+type endpointChange struct {
+	Endpoint string
+	Previous string
+	State    string
+	// Changed is set if the state differs from the previous check. An
+	// endpoint checked for the first time only counts as changed if it is
+	// unhealthy, so that a restart does not notify about every endpoint.
+	Changed bool
+	Status  EndpointStatus
+}
+
+// endpointStates tracks the state, "healthy" or "unhealthy", of every endpoint
+// between checks, for notifications about single endpoints.
+type endpointStates map[string]string
+
+// observe returns the state of every endpoint of result, by host:port, and
+// forgets the endpoints no longer checked.
+func (s *endpointStates) observe(result CheckResult) []endpointChange {
+	changes := make([]endpointChange, 0, len(result.Endpoints))
+	states := make(endpointStates, len(result.Endpoints))
+	for _, e := range result.Endpoints {
+		endpoint := normalizeEndpoint(e.Endpoint)
+		state := "unhealthy"
+		if e.Healthy {
+			state = "healthy"
+		}
+		states[endpoint] = state
+
+		previous, ok := (*s)[endpoint]
+		if !ok {
+			previous = "unknown"
+		}
+		changes = append(changes, endpointChange{
+			Endpoint: endpoint,
+			Previous: previous,
+			State:    state,
+			Changed:  previous != state && (ok || !e.Healthy),
+			Status:   e,
+		})
+	}
+	*s = states
+
+	return changes
+}
+
+// notifyCooldown suppresses notifications while the cluster flaps: a state
+// notified less than period ago is held back. If the cluster flaps back to
+// the last notified state in the meantime, the held back notification is
+// dropped; otherwise it is sent once the period is over.
+type notifyCooldown struct {
+	period time.Duration
+
+	lastSent map[bool]time.Time
+	notified bool
+	pending  *Transition
+}
+
+func newNotifyCooldown(period time.Duration) *notifyCooldown {
+	return &notifyCooldown{period: period, lastSent: make(map[bool]time.Time), notified: true}
+}
+
+// take returns the transition to notify about after a check at now, given
+// the transition that check made, if any.
+func (c *notifyCooldown) take(now time.Time, t Transition, changed bool) (Transition, bool) {
+	if changed {
+		c.pending = &t
+	}
+	if c.pending == nil {
+		return Transition{}, false
+	}
+	if c.pending.Healthy == c.notified {
+		log.Printf("[INFO] Cluster flapped back to %s within the notification cooldown, not notifying", transitionState(*c.pending))
+		c.pending = nil
+		return Transition{}, false
+	}
+	if last, ok := c.lastSent[c.pending.Healthy]; ok && now.Sub(last) < c.period {
+		if changed {
+			log.Printf("[INFO] Holding back the %s notification, the last one was sent %s ago",
+				transitionState(t), now.Sub(last).Round(time.Second))
+		}
+		return Transition{}, false
+	}
+
+	t = *c.pending
+	c.pending = nil
+	c.lastSent[t.Healthy] = now
+	c.notified = t.Healthy
+
+	return t, true
+}
+
+// notifyError is a failed delivery that tells the notification queue how to
+// go on.
+type notifyError struct {
+	err error
+	// retryAfter is the delay the destination asked for, or 0 to back off
+	// as usual.
+	retryAfter time.Duration
+	// permanent is set if the delivery will fail again no matter how often
+	// it is retried.
+	permanent bool
+}
+
+func (e *notifyError) Error() string {
+	return e.err.Error()
+}
+
+// notificationQueue delivers notifications in the background and in order,
+// retrying failed deliveries with backoff, so that a slow destination never
+// delays the checks.
+type notificationQueue struct {
+	name  string
+	queue chan notification
+	// ctx bounds the deliveries. It is cancelled once Shutdown returns.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done is closed once the queue is shut down and drained.
+	done chan struct{}
+
+	// dropped counts the notifications given up on since the last
+	// takeDropped. queue is closed once closed is set.
+	mu      sync.Mutex
+	dropped int
+	closed  bool
+}
+
+// notification is a message waiting for delivery. what describes it in log
+// messages, e.g. "unhealthy notification".
+type notification struct {
+	what string
+	send func(ctx context.Context) error
+}
+
+// newNotificationQueue starts delivering the notifications pushed to it. name
+// is the destination in log messages.
+func newNotificationQueue(name string) *notificationQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &notificationQueue{
+		name:   name,
+		queue:  make(chan notification, notifyQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(q.done)
+		for n := range q.queue {
+			q.deliver(q.ctx, n)
+		}
+	}()
+
+	return q
+}
+
+// push queues a notification sent with send. If the queue is full or shut
+// down, it is dropped.
+func (q *notificationQueue) push(what string, send func(ctx context.Context) error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		log.Printf("[ERROR] Notifications to %s are shut down, dropping the %s", q.name, what)
+		q.dropped++
+		return
+	}
+	select {
+	case q.queue <- notification{what: what, send: send}:
+	default:
+		log.Printf("[ERROR] Too many pending notifications to %s, dropping the %s", q.name, what)
+		q.dropped++
+	}
+}
+
+// Shutdown stops accepting notifications and delivers the pending ones until
+// ctx is done, when the delivery still running is cancelled.
+func (q *notificationQueue) Shutdown(ctx context.Context) error {
+	defer q.cancel()
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// takeDropped returns how many notifications were dropped, because they
+// failed or did not fit into the queue, and resets the count.
+func (q *notificationQueue) takeDropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := q.dropped
+	q.dropped = 0
+
+	return dropped
+}
+
+func (q *notificationQueue) drop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dropped++
+}
+
+// deliver sends n, retrying failures with backoff until ctx is done.
+func (q *notificationQueue) deliver(ctx context.Context, n notification) {
+	for attempt := 0; ; attempt++ {
+		sendCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		err := n.send(sendCtx)
+		cancel()
+		if err == nil {
+			log.Printf("[INFO] Sent the %s to %s", n.what, q.name)
+			return
+		}
+		var nerr *notifyError
+		isNotifyError := errors.As(err, &nerr)
+		if attempt >= notifyMaxRetries || isPermanentError(err) || isNotifyError && nerr.permanent || ctx.Err() != nil {
+			log.Printf("[ERROR] Failed to send the %s to %s: %s", n.what, q.name, err)
+			q.drop()
+			return
+		}
+
+		delay := backoffDelay(attempt)
+		if isNotifyError && nerr.retryAfter > 0 {
+			delay = nerr.retryAfter
+		}
+		log.Printf("[WARN] Failed to send the %s to %s (attempt %d of %d), retrying in %s: %s",
+			n.what, q.name, attempt+1, notifyMaxRetries+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			log.Printf("[ERROR] Failed to send the %s to %s: %s", n.what, q.name, ctx.Err())
+			q.drop()
+			return
+		}
+	}
+}
+
+// transitionState names the state the cluster changed to, e.g. to describe
+// its notification.
+func transitionState(t Transition) string {
+	if t.Healthy {
+		return "recovered"
+	}
+
+	return "unhealthy"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotificationQueueShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		hang bool
+		err  error
+		sent int
+	}{
+		{"drained", false, nil, 3},
+		{"grace period expired", true, context.DeadlineExceeded, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captureLog(t)
+			q := newNotificationQueue("the test")
+			var mu sync.Mutex
+			sent := 0
+			for i := 0; i < 3; i++ {
+				q.push("notification", func(ctx context.Context) error {
+					if test.hang {
+						<-ctx.Done()
+						return ctx.Err()
+					}
+					time.Sleep(50 * time.Millisecond)
+					mu.Lock()
+					defer mu.Unlock()
+					sent++
+					return nil
+				})
+			}
+
+			const grace = 500 * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), grace)
+			defer cancel()
+			start := time.Now()
+			err := q.Shutdown(ctx)
+			if elapsed := time.Since(start); elapsed > 2*grace {
+				t.Errorf("Shutdown took %s, longer than the %s grace period", elapsed, grace)
+			}
+			if err != test.err {
+				t.Errorf("Shutdown() = %v, want %v", err, test.err)
+			}
+			mu.Lock()
+			if sent != test.sent {
+				t.Errorf("%d notifications were sent, want %d", sent, test.sent)
+			}
+			mu.Unlock()
+
+			// The deliveries still pending are cancelled, not retried.
+			select {
+			case <-q.done:
+			case <-time.After(time.Second):
+				t.Fatal("the queue is still delivering after Shutdown")
+			}
+			q.push("late notification", func(ctx context.Context) error { return nil })
+			if dropped := q.takeDropped(); dropped != 3-test.sent+1 {
+				t.Errorf("%d notifications were dropped, want %d", dropped, 3-test.sent+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The exit codes of -once. Invalid flags exit with 1 too.
+const (
+	exitOnceHealthy    = 0
+	exitOnceCheckError = 1
+	exitOnceUnhealthy  = 3
+)
+
+// monitorErrorTypes are the CheckErrors error types of checks that failed on
+// the side of the monitor, e.g. because of a wrong certificate or password,
+// rather than because etcd is unhealthy or unreachable.
+var monitorErrorTypes = map[string]bool{
+	errorTypeDNS:              true,
+	errorTypeTLS:              true,
+	errorTypeAuth:             true,
+	errorTypePermissionDenied: true,
+	httpStatusErrorType(300):  true,
+	httpStatusErrorType(400):  true,
+}
+
+// runOnce checks the cluster with runCheck, as the scheduled checks do, and
+// returns the exit code of -once after printing a summary line. While the
+// cluster is degraded, failing for fewer checks than -failure-threshold, it is
+// checked again every interval until it passes or reaches the threshold. The
+// collected metrics are published before returning.
+func runOnce(discovery Probe, probes []Probe) int {
+	var result CheckResult
+	for {
+		result, _ = runCheck(context.Background(), discovery, probes)
+		if result.State != healthStateDegraded {
+			break
+		}
+		log.Printf("[INFO] etcd is degraded, checking again in %s", *interval)
+		time.Sleep(*interval)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	reporters.Flush(ctx)
+	if err := reporters.Shutdown(ctx); err != nil {
+		log.Printf("[ERROR] %s", err)
+	}
+	cancel()
+	closeEtcdConnections()
+
+	healthy, latency, reason := summarizeCheck(result)
+	code := exitOnceHealthy
+	message := fmt.Sprintf("etcd %s is healthy, %d of %d endpoints healthy, latency %s",
+		*etcdName, healthy, len(result.Endpoints), latency.Round(time.Millisecond))
+	switch {
+	case result.UnhealthyCount == 0:
+	case checkErrored(result):
+		code = exitOnceCheckError
+		message = fmt.Sprintf("etcd %s could not be checked, %d of %d endpoints healthy: %s",
+			*etcdName, healthy, len(result.Endpoints), reason)
+	default:
+		code = exitOnceUnhealthy
+		message = fmt.Sprintf("etcd %s IS NOT healthy, %d of %d endpoints healthy: %s",
+			*etcdName, healthy, len(result.Endpoints), reason)
+	}
+	fmt.Println(message)
+
+	return code
+}
+
+// checkErrored reports whether every endpoint that failed the check in result
+// failed on the side of the monitor, so that the health of etcd is unknown.
+func checkErrored(result CheckResult) bool {
+	failed := 0
+	for _, e := range result.Endpoints {
+		if e.ConsecutiveFailures == 0 {
+			continue
+		}
+		if !monitorErrorTypes[e.ErrorType] {
+			return false
+		}
+		failed++
+	}
+
+	return failed > 0
+}
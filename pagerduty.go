@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPagerDutyEventsURL is the Events API v2 endpoint of the US service region.
+const defaultPagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyEvent is an Events API v2 event. Resolve events carry no payload.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Component     string                 `json:"component"`
+	Group         string                 `json:"group"`
+	Class         string                 `json:"class,omitempty"`
+	CustomDetails pagerDutyCustomDetails `json:"custom_details"`
+}
+
+type pagerDutyCustomDetails struct {
+	Endpoint            string  `json:"endpoint"`
+	LatencyMs           float64 `json:"latency_ms"`
+	Error               string  `json:"error,omitempty"`
+	ErrorType           string  `json:"error_type,omitempty"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	UnhealthyCount      float64 `json:"unhealthy_count"`
+}
+
+// PagerDutyNotifier opens a PagerDuty incident per endpoint that failed
+// threshold checks in a row, and resolves it once the endpoint is healthy
+// again. The incidents are keyed by cluster and endpoint, so a restarted
+// monitor resolves the incidents of the one before.
+type PagerDutyNotifier struct {
+	routingKey string
+	eventsURL  string
+	threshold  int
+	source     string
+	dryRun     bool
+	queue      *notificationQueue
+
+	// triggered holds the endpoints with an open incident. started is set
+	// after the first check.
+	triggered map[string]bool
+	started   bool
+}
+
+// NewPagerDutyNotifier returns a notifier sending events with routingKey to
+// eventsURL. With dryRun the events are only logged.
+func NewPagerDutyNotifier(routingKey, eventsURL string, threshold int, dryRun bool) (*PagerDutyNotifier, error) {
+	if threshold < 1 {
+		return nil, fmt.Errorf("the PagerDuty failure threshold must be at least 1")
+	}
+	source := instanceID
+	if source == "" {
+		source, _ = os.Hostname()
+	}
+
+	return &PagerDutyNotifier{
+		routingKey: routingKey,
+		eventsURL:  eventsURL,
+		threshold:  threshold,
+		source:     source,
+		dryRun:     dryRun,
+		queue:      newNotificationQueue("PagerDuty"),
+		triggered:  make(map[string]bool),
+	}, nil
+}
+
+// newPagerDutyReporter returns the notifier of -pagerduty-routing-key, or of
+// the key in -pagerduty-routing-key-file.
+func newPagerDutyReporter() (Reporter, error) {
+	routingKey := *pagerDutyRoutingKey
+	if *pagerDutyRoutingKeyFile != "" {
+		var err error
+		routingKey, err = readPagerDutyRoutingKey(*pagerDutyRoutingKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	notifier, err := NewPagerDutyNotifier(routingKey, *pagerDutyEventsURL, *pagerDutyFailureThreshold, *dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifier, nil
+}
+
+// Report queues a trigger event for every endpoint that just reached the
+// failure threshold and a resolve event for every endpoint that recovered.
+func (n *PagerDutyNotifier) Report(ctx context.Context, result CheckResult) error {
+	seen := make(map[string]bool, len(result.Endpoints))
+	for _, e := range result.Endpoints {
+		endpoint := normalizeEndpoint(e.Endpoint)
+		seen[endpoint] = true
+
+		switch {
+		case !e.Healthy && !n.triggered[endpoint] && e.ConsecutiveFailures >= n.threshold:
+			n.triggered[endpoint] = true
+			n.push(endpoint, n.triggerEvent(endpoint, e, result))
+		case e.Healthy && (n.triggered[endpoint] || !n.started):
+			// On the first check, the incident may have been opened
+			// by a monitor that stopped before the endpoint
+			// recovered. Resolving an incident that is not open does
+			// nothing.
+			n.push(endpoint, n.resolveEvent(endpoint))
+			delete(n.triggered, endpoint)
+		}
+	}
+	// Endpoints removed from the cluster do not recover.
+	for endpoint := range n.triggered {
+		if !seen[endpoint] {
+			n.push(endpoint, n.resolveEvent(endpoint))
+			delete(n.triggered, endpoint)
+		}
+	}
+	n.started = true
+
+	return nil
+}
+
+// Shutdown delivers the pending notifications until ctx is done.
+func (n *PagerDutyNotifier) Shutdown(ctx context.Context) error {
+	return n.queue.Shutdown(ctx)
+}
+
+func (n *PagerDutyNotifier) triggerEvent(endpoint string, e EndpointStatus, result CheckResult) pagerDutyEvent {
+	reason := e.Error
+	if reason == "" {
+		reason = "unhealthy"
+	}
+	severity := "error"
+	if result.UnhealthyCount > 0 {
+		severity = "critical"
+	}
+
+	return pagerDutyEvent{
+		RoutingKey:  n.routingKey,
+		EventAction: "trigger",
+		DedupKey:    pagerDutyDedupKey(endpoint),
+		Payload: &pagerDutyPayload{
+			Summary:   fmt.Sprintf("etcd endpoint %s of cluster %s is unhealthy: %s", endpoint, *etcdName, reason),
+			Source:    n.source,
+			Severity:  severity,
+			Timestamp: result.Time,
+			Component: endpoint,
+			Group:     *etcdName,
+			Class:     e.ErrorType,
+			CustomDetails: pagerDutyCustomDetails{
+				Endpoint:            endpoint,
+				LatencyMs:           e.LatencyMs,
+				Error:               e.Error,
+				ErrorType:           e.ErrorType,
+				ConsecutiveFailures: e.ConsecutiveFailures,
+				UnhealthyCount:      result.UnhealthyCount,
+			},
+		},
+	}
+}
+
+func (n *PagerDutyNotifier) resolveEvent(endpoint string) pagerDutyEvent {
+	return pagerDutyEvent{
+		RoutingKey:  n.routingKey,
+		EventAction: "resolve",
+		DedupKey:    pagerDutyDedupKey(endpoint),
+	}
+}
+
+func (n *PagerDutyNotifier) push(endpoint string, event pagerDutyEvent) {
+	n.queue.push(event.EventAction+" event for "+endpoint, func(ctx context.Context) error {
+		return n.send(ctx, event)
+	})
+}
+
+func (n *PagerDutyNotifier) send(ctx context.Context, event pagerDutyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+	if n.dryRun {
+		// The routing key is a secret.
+		log.Printf("[INFO] Dry run, not sending the %s event %s to PagerDuty", event.EventAction, event.DedupKey)
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.eventsURL, bytes.NewReader(body))
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reply, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &notifyError{
+			err:        fmt.Errorf("rate limited by PagerDuty"),
+			retryAfter: time.Duration(seconds) * time.Second,
+		}
+	default:
+		// The reply is a JSON document with the status and errors, e.g.
+		// for an invalid routing key.
+		return &notifyError{
+			err:       fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(reply))),
+			permanent: resp.StatusCode < 500,
+		}
+	}
+}
+
+// pagerDutyDedupKey returns the key of the incident about endpoint. It must
+// not change between releases, or the incidents open during an upgrade are
+// never resolved.
+func pagerDutyDedupKey(endpoint string) string {
+	return "etcd-monitor/" + *etcdName + "/" + endpoint
+}
+
+// readPagerDutyRoutingKey returns the routing key held in file.
+func readPagerDutyRoutingKey(file string) (string, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the PagerDuty routing key file: %s", err)
+	}
+	key := strings.TrimSpace(string(buff))
+	if key == "" {
+		return "", fmt.Errorf("the PagerDuty routing key file %s is empty", file)
+	}
+
+	return key, nil
+}
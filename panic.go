@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// logPanic logs the panic v of what, recovered in a deferred function, with
+// the stack of the panicking goroutine and counts it in the MonitorPanics
+// metric. A bug in a probe or a reporter, or a response it does not expect,
+// costs the check it happened in rather than the whole monitor.
+func logPanic(what string, v interface{}) {
+	log.Printf("[ERROR] %s panicked: %v\n%s", what, v, debug.Stack())
+	metrics.Add(newMetricData("MonitorPanics", 1, types.StandardUnitCount)...)
+}
+
+// runProbe runs probe, recovering a panic in it.
+func runProbe(probe Probe) {
+	defer func() {
+		if v := recover(); v != nil {
+			logPanic(fmt.Sprintf("The %T", probe), v)
+		}
+	}()
+
+	probe.Run()
+}
+
+// runCheckRecovered runs runCheck for the check loop, recovering a panic in
+// it. A check that panicked counts as errored: it is not reported, and false
+// is returned as for a cancelled one.
+func runCheckRecovered(ctx context.Context, discovery Probe, probes []Probe) (result CheckResult, ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			logPanic("The check", v)
+			result, ok = CheckResult{}, false
+		}
+	}()
+
+	return runCheck(ctx, discovery, probes)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// panickingProbe panics in every run, like a probe with a bug.
+type panickingProbe struct{}
+
+func (panickingProbe) Run() {
+	panic("probe bug")
+}
+
+// panickingTransport panics in its first panics round trips, then forwards to
+// http.DefaultTransport.
+type panickingTransport struct {
+	panics int32
+}
+
+func (tr *panickingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.AddInt32(&tr.panics, -1) >= 0 {
+		panic("transport bug")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// useCheckLoop points the checks at a healthy fake etcd through tr, and
+// queues their results for recording until the test ends.
+func useCheckLoop(t *testing.T, tr http.RoundTripper) (*recordingReporter, *fakePublisher) {
+	t.Helper()
+
+	setFlags(t, "-name", "etcd-a")
+	if err := validateFlags(); err != nil {
+		t.Fatal(err)
+	}
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"health":"true"}`))
+	}))
+	t.Cleanup(etcd.Close)
+
+	useHTTPClient(t, tr)
+	recording, publisher := &recordingReporter{}, &fakePublisher{}
+	useReporters(t, recording, publisher)
+	savedEndpoints, savedResults := endpoints, checkResults
+	t.Cleanup(func() { endpoints, checkResults = savedEndpoints, savedResults })
+	endpoints = []string{etcd.URL}
+	checkResults = newReportQueue(context.Background())
+
+	return recording, publisher
+}
+
+// drainCheckResults waits for the queued results to be reported.
+func drainCheckResults(t *testing.T) {
+	t.Helper()
+
+	checkResults.close()
+	select {
+	case <-checkResults.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued results were not reported")
+	}
+}
+
+// panicCount returns the sum of the MonitorPanics published.
+func panicCount(publisher *fakePublisher) float64 {
+	metrics.Flush(context.Background())
+	count := 0.0
+	for _, call := range publisher.published() {
+		for _, datum := range call {
+			if *datum.MetricName == "MonitorPanics" {
+				count += *statisticSet(datum).Sum
+			}
+		}
+	}
+
+	return count
+}
+
+func TestPanickingProbe(t *testing.T) {
+	recording, publisher := useCheckLoop(t, http.DefaultTransport)
+	logged := captureLog(t)
+
+	for i := 0; i < 2; i++ {
+		result, ok := runCheckRecovered(context.Background(), panickingProbe{}, []Probe{panickingProbe{}})
+		if !ok || result.UnhealthyCount != 0 {
+			t.Errorf("check %d returned %v with unhealthy count %g, want the healthy check kept", i+1, ok, result.UnhealthyCount)
+		}
+	}
+	drainCheckResults(t)
+
+	if n := len(recording.reported()); n != 2 {
+		t.Errorf("reported %d checks, want both", n)
+	}
+	if n := strings.Count(logged.String(), "[ERROR] The main.panickingProbe panicked: probe bug"); n != 4 {
+		t.Errorf("logged %d probe panics, want the discovery and the probe of both checks:\n%s", n, logged)
+	}
+	if count := panicCount(publisher); count != 4 {
+		t.Errorf("MonitorPanics = %g, want 4", count)
+	}
+}
+
+func TestPanickingHealthCheck(t *testing.T) {
+	recording, publisher := useCheckLoop(t, &panickingTransport{panics: 1})
+	captureLog(t)
+
+	// The endpoint whose check panicked failed, the next check is healthy.
+	for i, want := range []float64{1, 0} {
+		result, ok := runCheckRecovered(context.Background(), nil, nil)
+		if !ok || result.UnhealthyCount != want {
+			t.Errorf("check %d returned %v with unhealthy count %g, want %g", i+1, ok, result.UnhealthyCount, want)
+		}
+		if want > 0 && (len(result.Endpoints) != 1 || !strings.Contains(result.Endpoints[0].Error, "panicked: transport bug")) {
+			t.Errorf("check %d returned the endpoints %+v, want the panic as the error", i+1, result.Endpoints)
+		}
+	}
+	drainCheckResults(t)
+
+	if n := len(recording.reported()); n != 2 {
+		t.Errorf("reported %d checks, want both", n)
+	}
+	if count := panicCount(publisher); count != 1 {
+		t.Errorf("MonitorPanics = %g, want 1", count)
+	}
+}
+
+func TestPanickingReport(t *testing.T) {
+	recording, _ := useCheckLoop(t, http.DefaultTransport)
+	logged := captureLog(t)
+
+	// A panic while reporting on the queue costs that result only.
+	saved := reporters
+	reporters = nil
+	checkResults.report(context.Background(), queuedResult{result: testResult()})
+	reporters = saved
+	if !strings.Contains(logged.String(), "[ERROR] Reporting the check panicked") {
+		t.Errorf("the panic was not logged:\n%s", logged)
+	}
+
+	// A panic in a check reporting inline costs that check only.
+	checkResults.close()
+	<-checkResults.done
+	checkResults = nil
+	reporters = nil
+	if _, ok := runCheckRecovered(context.Background(), nil, nil); ok {
+		t.Error("the check that panicked returned true, want false as for a cancelled one")
+	}
+	reporters = saved
+	if _, ok := runCheckRecovered(context.Background(), nil, nil); !ok {
+		t.Error("the check after the panic returned false, want true")
+	}
+	if n := len(recording.reported()); n != 1 {
+		t.Errorf("reported %d checks, want the one after the panic", n)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// pause stops reporting the checks, on SIGUSR1, for at most maxPause if it is
+// positive. The checks keep running and being logged. Pausing again restarts
+// the maximum pause.
+func (s *monitorState) pause(maxPause time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paused = true
+	s.pausedUntil = time.Time{}
+	if maxPause <= 0 {
+		log.Printf("[INFO] Paused until SIGUSR2: checks are logged, but not published or notified")
+		return
+	}
+	s.pausedUntil = time.Now().Add(maxPause)
+	log.Printf("[INFO] Paused until SIGUSR2 or %s: checks are logged, but not published or notified", s.pausedUntil.Format(time.RFC3339))
+}
+
+// resume reports the checks again, on SIGUSR2.
+func (s *monitorState) resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.paused {
+		log.Printf("[INFO] Not paused, nothing to resume")
+		return
+	}
+	s.paused, s.pausedUntil = false, time.Time{}
+	log.Printf("[INFO] Resumed, checks are reported again")
+}
+
+// checkPaused reports whether a check made at now is paused, resuming once the
+// maximum pause is over.
+func (s *monitorState) checkPaused(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paused && !s.pausedUntil.IsZero() && !now.Before(s.pausedUntil) {
+		s.paused, s.pausedUntil = false, time.Time{}
+		log.Printf("[INFO] Resumed after the maximum pause of %s, checks are reported again", *maxPause)
+	}
+
+	return s.paused
+}
+
+// reportPausedCheck logs the result of a check made while paused, and adds the
+// MonitorPaused metric in place of its health metrics.
+func reportPausedCheck(result CheckResult) {
+	healthy := "healthy"
+	if result.RawUnhealthyCount > 0 {
+		healthy = "NOT healthy"
+	}
+	log.Printf("[INFO] PAUSED: etcd is %s, not reporting it", healthy)
+	metrics.Add(buildMetricData(metricDimensionSets(), "MonitorPaused", 1, types.StandardUnitCount, result.Time)...)
+}
+
+// pauseReporter is implemented by reporters that still record the checks made
+// while paused, such as the state file, so that consumers see the pause.
+type pauseReporter interface {
+	ReportPaused(ctx context.Context, result CheckResult) error
+}
+
+// ReportPaused sends result, of a check made while paused, to the reporters
+// recording those. Failures are logged per reporter.
+func (f *FanOutReporter) ReportPaused(ctx context.Context, result CheckResult) {
+	for _, e := range f.reporters {
+		r, ok := e.reporter.(pauseReporter)
+		if !ok {
+			continue
+		}
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					logPanic("The "+e.name+" reporter", v)
+				}
+			}()
+			if err := r.ReportPaused(ctx, result); err != nil {
+				e.errors.log(err)
+			}
+		}()
+	}
+}
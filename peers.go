@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// peerDialTimeout bounds the connection attempt to a single peer URL,
+// including the TLS handshake.
+const peerDialTimeout = 5 * time.Second
+
+// PeerProbe detects members whose peer port is not reachable while their
+// client port is, e.g. because of a firewall rule, which degrades the cluster
+// through failed heartbeats long before /health notices. It connects to the
+// advertised peer URLs of every member.
+type PeerProbe struct {
+	endpoint  string
+	tlsConfig *tls.Config
+	tlsFiles  *tlsFiles
+}
+
+// NewPeerProbe returns a probe listing the members through the etcd member at
+// endpoint. https peer URLs are connected to with tlsConfig and the CA bundle
+// of files currently in use, which may both be nil if no member uses TLS on
+// its peer port.
+func NewPeerProbe(endpoint string, tlsConfig *tls.Config, files *tlsFiles) *PeerProbe {
+	return &PeerProbe{
+		endpoint:  endpoint,
+		tlsConfig: tlsConfig,
+		tlsFiles:  files,
+	}
+}
+
+// Run publishes PeerUnreachable per member, 1 if none of its peer URLs
+// accepted a connection. Members without peer URLs are skipped.
+func (p *PeerProbe) Run() {
+	members, err := getMembers(p.endpoint)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list etcd members for the peer check: %s", err)
+		metrics.Add(newEndpointMetricData(p.endpoint, "PeerProbeFailures", 1, types.StandardUnitCount)...)
+		return
+	}
+
+	unreachable := make([]bool, len(members))
+	queryPeers(members, func(i int, member Member) {
+		reachable := false
+		for _, peerURL := range member.PeerURLs {
+			if p.dial(memberName(member), peerURL) {
+				reachable = true
+			}
+		}
+		unreachable[i] = !reachable
+	})
+
+	for i, member := range members {
+		if len(member.PeerURLs) == 0 {
+			continue
+		}
+		value := 0.0
+		if unreachable[i] {
+			value = 1.0
+		}
+		metrics.Add(buildMetricData(memberDimensionSets(memberName(member)), "PeerUnreachable", value, types.StandardUnitNone, time.Now())...)
+	}
+}
+
+// dial connects to peerURL of the member name and reports whether the peer
+// port accepted the connection. A failed TLS handshake still counts as
+// reachable, but is logged apart from a refused connection as it points at
+// the peer certificates rather than the network.
+func (p *PeerProbe) dial(name, peerURL string) bool {
+	u, err := url.Parse(peerURL)
+	if err != nil || u.Host == "" {
+		log.Printf("[WARN] Invalid peer URL %q of etcd member %s", peerURL, name)
+		return false
+	}
+	addr := normalizeEndpoint(peerURL)
+
+	dialer := &net.Dialer{Timeout: peerDialTimeout}
+	var conn net.Conn
+	if u.Scheme == "https" {
+		config := p.tlsFiles.withRootCAs(p.tlsConfig)
+		if config == nil {
+			config = &tls.Config{}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, config)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err == nil {
+		conn.Close()
+		return true
+	}
+
+	if isTLSError(err) {
+		log.Printf("[WARN] Peer URL %s of etcd member %s is reachable, but the TLS handshake failed, "+
+			"check the member's peer certificate and -peer-ca-file: %s", peerURL, name, err)
+		return true
+	}
+	log.Printf("[WARN] Peer URL %s of etcd member %s is unreachable (%s): %s", peerURL, name, classifyError(err), err)
+
+	return false
+}
+
+// queryPeers calls fn concurrently with the index of every member that has
+// peer URLs, like queryMembers. It returns once all calls are done.
+func queryPeers(members []Member, fn func(i int, member Member)) {
+	var wg sync.WaitGroup
+	for i, member := range members {
+		if len(member.PeerURLs) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, member Member) {
+			defer wg.Done()
+			fn(i, member)
+		}(i, member)
+	}
+	wg.Wait()
+}
+
+// memberName returns the name of member, or its ID for members that have not
+// started yet and so have no name.
+func memberName(member Member) string {
+	if member.Name == "" {
+		return fmt.Sprintf("%x", member.ID)
+	}
+
+	return member.Name
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// PrometheusExporter serves the health check results as a Prometheus scrape
+// target, for environments without CloudWatch. The gauges are updated by the
+// check loop.
+type PrometheusExporter struct {
+	server *http.Server
+	gauges *prometheusGauges
+}
+
+// prometheusGauges are the health check results as Prometheus gauges, shared
+// by the exporter and the Pushgateway reporter.
+type prometheusGauges struct {
+	registry *prometheus.Registry
+
+	unhealthy        *prometheus.GaugeVec
+	latency          *prometheus.GaugeVec
+	clusterUnhealthy *prometheus.GaugeVec
+	lastCheck        *prometheus.GaugeVec
+
+	// endpoints are the endpoint label values of the previous update, so
+	// that the series of endpoints removed by discovery are deleted.
+	endpoints map[string]bool
+}
+
+// newPrometheusGauges returns the gauges, registered with a registry of their
+// own.
+func newPrometheusGauges() *prometheusGauges {
+	g := &prometheusGauges{
+		registry: prometheus.NewRegistry(),
+		unhealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "etcd_monitor_unhealthy",
+			Help: "1 if the last health check of the endpoint failed, 0 otherwise.",
+		}, []string{"cluster", "endpoint"}),
+		latency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "etcd_monitor_check_latency_seconds",
+			Help: "Duration of the last health check of the endpoint.",
+		}, []string{"cluster", "endpoint"}),
+		clusterUnhealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "etcd_monitor_cluster_unhealthy",
+			Help: "1 if the cluster is unhealthy according to the unhealthy policy, 0 otherwise.",
+		}, []string{"cluster"}),
+		lastCheck: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "etcd_monitor_last_check_timestamp",
+			Help: "Unix time of the last health check.",
+		}, []string{"cluster"}),
+		endpoints: make(map[string]bool),
+	}
+	g.registry.MustRegister(g.unhealthy, g.latency, g.clusterUnhealthy, g.lastCheck)
+
+	return g
+}
+
+// NewPrometheusExporter starts serving /metrics on addr, e.g. ":9100".
+func NewPrometheusExporter(addr string) (*PrometheusExporter, error) {
+	e := &PrometheusExporter{gauges: newPrometheusGauges()}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(e.gauges.registry, promhttp.HandlerOpts{}))
+	e.server = &http.Server{Handler: mux}
+	go func() {
+		if err := e.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("[ERROR] Prometheus exporter stopped: %s", err)
+		}
+	}()
+
+	return e, nil
+}
+
+// newPrometheusReporter returns the exporter serving on -prometheus-listen.
+func newPrometheusReporter() (Reporter, error) {
+	exporter, err := NewPrometheusExporter(*prometheusListen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the Prometheus exporter: %s", err)
+	}
+
+	return exporter, nil
+}
+
+// Report sets the gauges from the result of a check.
+func (e *PrometheusExporter) Report(ctx context.Context, result CheckResult) error {
+	e.gauges.set(result)
+
+	return nil
+}
+
+// set sets the gauges from the result of a check.
+func (g *prometheusGauges) set(result CheckResult) {
+	seen := make(map[string]bool)
+	for _, s := range result.Endpoints {
+		endpoint := normalizeEndpoint(s.Endpoint)
+		seen[endpoint] = true
+
+		unhealthy := 0.0
+		if !s.Healthy {
+			unhealthy = 1.0
+		}
+		g.unhealthy.WithLabelValues(*etcdName, endpoint).Set(unhealthy)
+		g.latency.WithLabelValues(*etcdName, endpoint).Set(s.LatencyMs / 1000)
+	}
+	for endpoint := range g.endpoints {
+		if !seen[endpoint] {
+			g.unhealthy.DeleteLabelValues(*etcdName, endpoint)
+			g.latency.DeleteLabelValues(*etcdName, endpoint)
+		}
+	}
+	g.endpoints = seen
+
+	g.clusterUnhealthy.WithLabelValues(*etcdName).Set(result.UnhealthyCount)
+	g.lastCheck.WithLabelValues(*etcdName).Set(float64(result.Time.UnixNano()) / 1e9)
+}
+
+// Shutdown stops serving, waiting for running scrapes until ctx is done.
+func (e *PrometheusExporter) Shutdown(ctx context.Context) error {
+	return e.server.Shutdown(ctx)
+}
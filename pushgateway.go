@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// PushgatewaySink pushes the health check results to a Prometheus Pushgateway
+// after every check, for environments that cannot be scraped. Every push
+// replaces the metrics of the group, which is the same for every push of a
+// monitor, so the results do not accumulate.
+type PushgatewaySink struct {
+	pusher       *push.Pusher
+	gauges       *prometheusGauges
+	deleteOnExit bool
+}
+
+// pushgatewayAuth are the credentials for a Pushgateway behind a proxy: basic
+// auth if username is set, a bearer token if token is set.
+type pushgatewayAuth struct {
+	username string
+	password string
+	token    string
+}
+
+// NewPushgatewaySink returns a sink pushing to the Pushgateway at url. The
+// metrics are grouped by job etcd-monitor-<cluster> and the host name as
+// instance. With deleteOnExit the group is deleted on shutdown.
+func NewPushgatewaySink(url string, auth pushgatewayAuth, deleteOnExit bool) (*PushgatewaySink, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("the Pushgateway URL must be an http(s) URL")
+	}
+	instance, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PushgatewaySink{gauges: newPrometheusGauges(), deleteOnExit: deleteOnExit}
+	s.pusher = push.New(url, "etcd-monitor-"+*etcdName).Gatherer(s.gauges.registry).Grouping("instance", instance)
+	switch {
+	case auth.username != "":
+		s.pusher.BasicAuth(auth.username, auth.password)
+	case auth.token != "":
+		s.pusher.Client(&http.Client{Transport: &bearerTokenTransport{
+			base:  http.DefaultTransport,
+			token: auth.token,
+		}})
+	}
+
+	return s, nil
+}
+
+// newPushgatewayReporter returns the sink of -pushgateway-url, authenticated
+// with the basic auth or bearer token flags if set.
+func newPushgatewayReporter() (Reporter, error) {
+	var auth pushgatewayAuth
+	var err error
+	switch {
+	case *pushgatewayUsername != "":
+		auth.username = *pushgatewayUsername
+		auth.password, err = readPushgatewaySecret(*pushgatewayPasswordFile)
+	case *pushgatewayTokenFile != "":
+		auth.token, err = readPushgatewaySecret(*pushgatewayTokenFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sink, err := NewPushgatewaySink(*pushgatewayURL, auth, *pushgatewayDeleteOnExit)
+	if err != nil {
+		return nil, err
+	}
+
+	return sink, nil
+}
+
+// Report pushes the result of a check, replacing the previous one.
+func (s *PushgatewaySink) Report(ctx context.Context, result CheckResult) error {
+	s.gauges.set(result)
+
+	return s.pusher.PushContext(ctx)
+}
+
+// Shutdown deletes the group if configured to, so that the Pushgateway does
+// not serve the last result of a monitor that is gone forever.
+func (s *PushgatewaySink) Shutdown(ctx context.Context) error {
+	if !s.deleteOnExit {
+		return nil
+	}
+
+	// Delete does not take a context.
+	done := make(chan error, 1)
+	go func() {
+		done <- s.pusher.Delete()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bearerTokenTransport adds a bearer token to every request.
+type bearerTokenTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *bearerTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+
+	return t.base.RoundTrip(req)
+}
+
+// readPushgatewaySecret returns the password or token held in file.
+func readPushgatewaySecret(file string) (string, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the Pushgateway credentials file: %s", err)
+	}
+	secret := strings.TrimRight(string(buff), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("the Pushgateway credentials file %s is empty", file)
+	}
+
+	return secret, nil
+}
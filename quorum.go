@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// learnerEndpoints are the checked endpoints of learner members, which do not
+// vote and so do not count towards quorum. Only discovery knows them; configured
+// addresses all count as voting members.
+var learnerEndpoints map[string]bool
+
+// reportQuorum publishes whether the healthy voting members still form a
+// quorum, given the health check results by endpoint. Nothing is published
+// while a single endpoint is checked, as one member cannot tell.
+func reportQuorum(results []bool) {
+	if len(endpoints) < 2 {
+		return
+	}
+
+	voting, healthy := 0, 0
+	for i, endpoint := range endpoints {
+		if learnerEndpoints[endpoint] {
+			continue
+		}
+		voting++
+		if results[i] {
+			healthy++
+		}
+	}
+	if voting == 0 {
+		return
+	}
+
+	quorum := voting/2 + 1
+	margin := healthy - quorum
+	switch {
+	case margin < 0:
+		log.Printf("[ERROR] etcd has lost quorum: %d/%d healthy, %d needed", healthy, voting, quorum)
+	case margin == 0:
+		log.Printf("[WARN] etcd is one failure away from losing quorum: %d/%d healthy, quorum margin 0", healthy, voting)
+	}
+
+	quorumHealthy := 0.0
+	if margin >= 0 {
+		quorumHealthy = 1.0
+	}
+	metrics.Add(newMetricData("QuorumHealthy", quorumHealthy, types.StandardUnitNone)...)
+	metrics.Add(newMetricData("HealthyMembers", float64(healthy), types.StandardUnitCount)...)
+}
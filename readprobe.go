@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+const (
+	readAPIV2 = "v2"
+	readAPIV3 = "v3"
+
+	readConsistencyLinearizable = "linearizable"
+	readConsistencySerializable = "serializable"
+)
+
+// ReadProbe times a read of a single key to measure the real read path, which
+// the /health endpoint barely exercises. The key does not need to exist.
+type ReadProbe struct {
+	endpoint     string
+	key          string
+	api          string
+	serializable bool
+	timeout      time.Duration
+}
+
+// NewReadProbe returns a probe reading key from the etcd member at endpoint
+// through the given API ("v2" or "v3") and consistency ("linearizable" or
+// "serializable").
+func NewReadProbe(endpoint, key, api, consistency string, timeout time.Duration) (*ReadProbe, error) {
+	switch api {
+	case readAPIV2, readAPIV3:
+	default:
+		return nil, fmt.Errorf("invalid read probe API %q, expected %q or %q", api, readAPIV2, readAPIV3)
+	}
+	switch consistency {
+	case readConsistencyLinearizable, readConsistencySerializable:
+	default:
+		return nil, fmt.Errorf("invalid read probe consistency %q, expected %q or %q",
+			consistency, readConsistencyLinearizable, readConsistencySerializable)
+	}
+	if !strings.HasPrefix(key, "/") {
+		return nil, fmt.Errorf("invalid read probe key %q, must start with /", key)
+	}
+
+	return &ReadProbe{
+		endpoint:     endpoint,
+		key:          key,
+		api:          api,
+		serializable: consistency == readConsistencySerializable,
+		timeout:      timeout,
+	}, nil
+}
+
+// Run reads the key and publishes ReadLatencyMs, or ReadProbeFailures if the
+// read failed.
+func (p *ReadProbe) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	req, err := p.newRequest(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed to build read probe request: %s", err)
+		return
+	}
+
+	start := time.Now()
+	err = p.do(req)
+	latency := time.Since(start)
+	if err != nil {
+		log.Printf("[ERROR] Read probe of %s failed: %s", p.key, err)
+		metrics.Add(newEndpointMetricData(p.endpoint, "ReadProbeFailures", 1, types.StandardUnitCount)...)
+		return
+	}
+
+	ms := float64(latency) / float64(time.Millisecond)
+	metrics.Add(newEndpointMetricData(p.endpoint, "ReadLatencyMs", ms, types.StandardUnitMilliseconds)...)
+}
+
+// newRequest builds the read request for the configured API.
+func (p *ReadProbe) newRequest(ctx context.Context) (*http.Request, error) {
+	if p.api == readAPIV2 {
+		query := url.Values{}
+		if !p.serializable {
+			query.Set("quorum", "true")
+		}
+		return http.NewRequestWithContext(ctx, http.MethodGet,
+			fmt.Sprintf("%s/v2/keys%s?%s", p.endpoint, p.key, query.Encode()), nil)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"key":          base64.StdEncoding.EncodeToString([]byte(p.key)),
+		"serializable": p.serializable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v3/kv/range", p.endpoint), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// do sends req and reads the whole response. A missing key is a successful
+// read.
+func (p *ReadProbe) do(req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if p.api == readAPIV2 && resp.StatusCode == http.StatusNotFound {
+		// errorCode 100 is "Key not found".
+		var v2Err struct {
+			ErrorCode int `json:"errorCode"`
+		}
+		if json.Unmarshal(buff, &v2Err) == nil && v2Err.ErrorCode == 100 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(buff)))
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reloadableSettings are the environment variables a reload applies to the
+// running monitor, with the flag overriding each. Changes to any other
+// variable need a restart.
+var reloadableSettings = map[string]string{
+	"CHECK_INTERVAL":             "interval",
+	"JITTER":                     "jitter",
+	"ETCD_ADVERTISE_CLIENT_URLS": "address",
+	"FAILURE_THRESHOLD":          "failure-threshold",
+	"RECOVERY_THRESHOLD":         "recovery-threshold",
+	"CHECK_RETRIES":              "check-retries",
+	"CHECK_RETRY_DELAY":          "check-retry-delay",
+}
+
+// configFileArg returns the configuration file given with -config-file in
+// args, or else in the CONFIG_FILE environment variable. It is needed before
+// the flags are parsed, as the file sets the defaults of the other flags.
+func configFileArg(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if value, ok := strings.CutPrefix(name, "config-file="); ok {
+			return value
+		}
+		if name == "config-file" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return os.Getenv("CONFIG_FILE")
+}
+
+// readConfigFile reads the environment variables in the file at path, one
+// KEY=value per line as in a systemd EnvironmentFile. Empty lines and lines
+// starting with # are skipped, quotes around a value removed.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// loadConfigFile sets the environment variables in the file at path, taking
+// precedence over the inherited environment, and returns them.
+func loadConfigFile(path string) (map[string]string, error) {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		os.Setenv(key, value)
+	}
+
+	return values, nil
+}
+
+// liveConfig is the part of the configuration a reload applies.
+type liveConfig struct {
+	interval          time.Duration
+	jitter            string
+	address           string
+	failureThreshold  int
+	recoveryThreshold int
+	checkRetries      int
+	checkRetryDelay   time.Duration
+}
+
+// configReloader applies the changes made to the configuration file on SIGHUP.
+// It runs on the goroutine of the checks, between two of them, so that no
+// check sees a half-applied configuration.
+type configReloader struct {
+	path      string
+	values    map[string]string
+	config    liveConfig
+	schedule  *checkSchedule
+	tlsConfig *tls.Config
+	// discovery is set if the endpoints are discovered rather than taken from
+	// -address.
+	discovery bool
+	// flags are the flags given on the command line, which a change of their
+	// environment variable does not override.
+	flags map[string]bool
+}
+
+// newConfigReloader returns a reloader of the file at path, last loaded with
+// values, for the running configuration config.
+func newConfigReloader(path string, values map[string]string, config liveConfig, schedule *checkSchedule, tlsConfig *tls.Config, discovery bool) *configReloader {
+	flags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = true
+	})
+
+	return &configReloader{
+		path:      path,
+		values:    values,
+		config:    config,
+		schedule:  schedule,
+		tlsConfig: tlsConfig,
+		discovery: discovery,
+		flags:     flags,
+	}
+}
+
+// reload reads the configuration file again and applies what changed, all of
+// it or, if a new value is invalid, nothing. Changes that need a restart are
+// logged.
+func (r *configReloader) reload() {
+	values, err := readConfigFile(r.path)
+	if err != nil {
+		log.Printf("[ERROR] Failed to reload the configuration, keeping the old one: %s", err)
+		return
+	}
+
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	for key := range r.values {
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	next := r.config
+	var changes []string
+	for _, key := range keys {
+		old, value := r.values[key], values[key]
+		if old == value {
+			continue
+		}
+		if _, ok := values[key]; !ok {
+			log.Printf("[WARN] %s was removed from %s, keeping its value until etcd-monitor is restarted", key, r.path)
+			continue
+		}
+		name, ok := reloadableSettings[key]
+		if !ok || (key == "ETCD_ADVERTISE_CLIENT_URLS" && r.discovery) {
+			log.Printf("[WARN] %s changed, restart etcd-monitor to apply it", key)
+			continue
+		}
+		if r.flags[name] {
+			log.Printf("[WARN] %s changed, but is overridden by -%s", key, name)
+			continue
+		}
+		if err := next.set(key, value); err != nil {
+			log.Printf("[ERROR] Failed to reload the configuration, keeping the old one: invalid %s: %s", key, err)
+			return
+		}
+		changes = append(changes, fmt.Sprintf("%s %q -> %q", key, old, value))
+	}
+
+	jitter, err := next.validate()
+	if err != nil {
+		log.Printf("[ERROR] Failed to reload the configuration, keeping the old one: %s", err)
+		return
+	}
+	r.apply(next, jitter)
+	r.values = values
+
+	if len(changes) == 0 {
+		log.Printf("[INFO] Reloaded the configuration from %s, nothing to apply", r.path)
+		return
+	}
+	log.Printf("[INFO] Reloaded the configuration from %s: %s", r.path, strings.Join(changes, ", "))
+}
+
+// set parses the value of the environment variable key into c.
+func (c *liveConfig) set(key, value string) error {
+	var err error
+	switch key {
+	case "CHECK_INTERVAL":
+		c.interval, err = parseInterval(value)
+	case "JITTER":
+		c.jitter = value
+	case "ETCD_ADVERTISE_CLIENT_URLS":
+		c.address = value
+	case "FAILURE_THRESHOLD":
+		c.failureThreshold, err = strconv.Atoi(value)
+	case "RECOVERY_THRESHOLD":
+		c.recoveryThreshold, err = strconv.Atoi(value)
+	case "CHECK_RETRIES":
+		c.checkRetries, err = strconv.Atoi(value)
+	case "CHECK_RETRY_DELAY":
+		c.checkRetryDelay, err = time.ParseDuration(value)
+	}
+
+	return err
+}
+
+// validate checks c against the rest of the configuration, as at startup, and
+// returns its jitter.
+func (c *liveConfig) validate() (time.Duration, error) {
+	if c.interval <= 0 {
+		return 0, fmt.Errorf("-interval must be positive")
+	}
+	if *checkTimeout >= c.interval {
+		return 0, fmt.Errorf("-timeout %s must be less than the check interval of %s", *checkTimeout, c.interval)
+	}
+	if reporters.timeout >= c.interval {
+		return 0, fmt.Errorf("-reporter-timeout %s must be less than the check interval of %s", reporters.timeout, c.interval)
+	}
+	if *publishInterval != *interval && *publishInterval%c.interval != 0 {
+		return 0, fmt.Errorf("-publish-interval must be a multiple of -interval (%s)", c.interval)
+	}
+	jitter, err := parseJitter(c.jitter, c.interval)
+	if err != nil {
+		return 0, err
+	}
+	if jitter < 0 || jitter >= c.interval {
+		return 0, fmt.Errorf("-jitter %s must be at least 0 and less than the check interval of %s", jitter, c.interval)
+	}
+	if _, err := parseEndpoints(c.address); err != nil {
+		return 0, err
+	}
+	if c.failureThreshold < 1 || c.recoveryThreshold < 1 {
+		return 0, fmt.Errorf("-failure-threshold and -recovery-threshold must be at least 1")
+	}
+	if *exitAfterFailures > 0 && *exitAfterFailures < c.failureThreshold {
+		return 0, fmt.Errorf("-failure-threshold must not be more than -exit-after-failures (%d)", *exitAfterFailures)
+	}
+	if c.checkRetries < 0 || c.checkRetryDelay < 0 {
+		return 0, fmt.Errorf("-check-retries and -check-retry-delay must not be negative")
+	}
+
+	return jitter, nil
+}
+
+// apply makes next, validated, the running configuration.
+func (r *configReloader) apply(next liveConfig, jitter time.Duration) {
+	old := r.config
+	r.config = next
+
+	if next.interval != old.interval || next.jitter != old.jitter {
+		// Publishing on every check keeps doing so.
+		if *publishInterval == *interval {
+			*publishInterval = next.interval
+		}
+		*interval = next.interval
+		r.schedule.reset(next.interval, jitter)
+	}
+	if next.address != old.address {
+		// Validated before.
+		addresses, _ := parseEndpoints(next.address)
+		first := endpoints[0]
+		*address = next.address
+		setEndpoints(addresses, r.tlsConfig)
+		if endpoints[0] != first {
+			log.Printf("[WARN] The probes keep using %s until etcd-monitor is restarted", first)
+		}
+	}
+	*failureThreshold, *recoveryThreshold = next.failureThreshold, next.recoveryThreshold
+	daemonState.setThresholds(next.failureThreshold, next.recoveryThreshold)
+	*checkRetries, *checkRetryDelay = next.checkRetries, next.checkRetryDelay
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const testConfigFile = `# etcd-monitor
+CHECK_INTERVAL=10s
+FAILURE_THRESHOLD=1
+RECOVERY_THRESHOLD=1
+CHECK_RETRIES=0
+CHECK_RETRY_DELAY=100ms
+`
+
+// newTestReloader loads the configuration file config as the monitor does at
+// startup, and returns its reloader and path.
+func newTestReloader(t *testing.T, config string) (*configReloader, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "etcd-monitor.env")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	values, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		t.Setenv(key, value)
+	}
+	setFlags(t)
+	if err := validateFlags(); err != nil {
+		t.Fatal(err)
+	}
+
+	oldReporters, oldState := reporters, daemonState
+	t.Cleanup(func() { reporters, daemonState = oldReporters, oldState })
+	reporters = NewFanOutReporter(*reporterTimeout)
+	daemonState = newMonitorState()
+	daemonState.threshold = newHealthThreshold(*failureThreshold, *recoveryThreshold)
+
+	schedule := newCheckSchedule(*interval, jitter)
+	t.Cleanup(schedule.Stop)
+
+	return newConfigReloader(path, values, liveConfig{
+		interval:          *interval,
+		jitter:            *jitterValue,
+		address:           *address,
+		failureThreshold:  *failureThreshold,
+		recoveryThreshold: *recoveryThreshold,
+		checkRetries:      *checkRetries,
+		checkRetryDelay:   *checkRetryDelay,
+	}, schedule, nil, false), path
+}
+
+// sendSIGHUP sends SIGHUP to the test process and reloads r once it arrived,
+// as the monitor does.
+func sendSIGHUP(t *testing.T, r *configReloader) {
+	t.Helper()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-hangup:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGHUP did not arrive")
+	}
+	r.reload()
+}
+
+// liveSettings returns the running configuration, as the checks see it.
+func liveSettings() liveConfig {
+	return liveConfig{
+		interval:          *interval,
+		jitter:            *jitterValue,
+		address:           *address,
+		failureThreshold:  daemonState.threshold.failureThreshold,
+		recoveryThreshold: daemonState.threshold.recoveryThreshold,
+		checkRetries:      *checkRetries,
+		checkRetryDelay:   *checkRetryDelay,
+	}
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	r, path := newTestReloader(t, testConfigFile)
+	logged := captureLog(t)
+
+	changed := strings.NewReplacer(
+		"CHECK_INTERVAL=10s", "CHECK_INTERVAL=20s",
+		"FAILURE_THRESHOLD=1", "FAILURE_THRESHOLD=3",
+		"RECOVERY_THRESHOLD=1", "RECOVERY_THRESHOLD=2",
+		"CHECK_RETRIES=0", "CHECK_RETRIES=2",
+		"CHECK_RETRY_DELAY=100ms", "CHECK_RETRY_DELAY=500ms",
+	).Replace(testConfigFile)
+	if err := ioutil.WriteFile(path, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sendSIGHUP(t, r)
+
+	want := liveConfig{
+		interval:          20 * time.Second,
+		jitter:            *jitterValue,
+		address:           *address,
+		failureThreshold:  3,
+		recoveryThreshold: 2,
+		checkRetries:      2,
+		checkRetryDelay:   500 * time.Millisecond,
+	}
+	if got := liveSettings(); got != want {
+		t.Errorf("the running configuration is %+v after SIGHUP, want %+v", got, want)
+	}
+	if *failureThreshold != 3 || *recoveryThreshold != 2 {
+		t.Errorf("-failure-threshold %d and -recovery-threshold %d, want 3 and 2", *failureThreshold, *recoveryThreshold)
+	}
+	if r.schedule.interval != 20*time.Second || *publishInterval != 20*time.Second {
+		t.Errorf("checks every %s and publishes every %s, want 20s", r.schedule.interval, *publishInterval)
+	}
+	if !strings.Contains(logged.String(), `[INFO] Reloaded the configuration from `+path+`: CHECK_INTERVAL "10s" -> "20s"`) {
+		t.Errorf("the reload was not logged:\n%s", logged)
+	}
+}
+
+func TestReloadInvalidKeepsConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"not a number", strings.Replace(testConfigFile, "FAILURE_THRESHOLD=1", "FAILURE_THRESHOLD=many", 1)},
+		{"zero threshold", strings.Replace(testConfigFile, "RECOVERY_THRESHOLD=1", "RECOVERY_THRESHOLD=0", 1)},
+		{"interval below the timeout", strings.Replace(testConfigFile, "CHECK_INTERVAL=10s", "CHECK_INTERVAL=2s", 1)},
+		{"negative retries", strings.Replace(testConfigFile, "CHECK_RETRIES=0", "CHECK_RETRIES=-1", 1)},
+		{"not KEY=value", testConfigFile + "CHECK_RETRIES\n"},
+		// The file was removed.
+		{"missing", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, path := newTestReloader(t, testConfigFile)
+			logged := captureLog(t)
+			before := liveSettings()
+
+			// Valid changes in the same file are not applied either.
+			config := strings.Replace(test.config, "CHECK_RETRY_DELAY=100ms", "CHECK_RETRY_DELAY=1s", 1)
+			if test.config == "" {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sendSIGHUP(t, r)
+
+			if got := liveSettings(); got != before {
+				t.Errorf("the running configuration is %+v after SIGHUP, want the old %+v", got, before)
+			}
+			if r.schedule.interval != 10*time.Second {
+				t.Errorf("checks every %s, want the old 10s", r.schedule.interval)
+			}
+			if !strings.Contains(logged.String(), "[ERROR] Failed to reload the configuration, keeping the old one") {
+				t.Errorf("the failed reload was not logged:\n%s", logged)
+			}
+		})
+	}
+}
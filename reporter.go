@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+const (
+	// maxDatumsPerRequest is the number of MetricDatum values CloudWatch
+	// accepts in a single PutMetricData call.
+	maxDatumsPerRequest = 20
+
+	// maxRequestBytes is the PutMetricData payload limit.
+	maxRequestBytes = 1024 * 1024
+
+	// fieldOverhead approximates the encoded key that prefixes every field
+	// of a datum, e.g. "&MetricData.member.20.Dimensions.member.1.Name=".
+	fieldOverhead = 64
+
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff applied
+	// between PutMetricData attempts.
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+
+	// maxThrottleLevel caps adaptive slow-down: while throttled, only every
+	// 2^level-th flush publishes steady-state data.
+	maxThrottleLevel = 4
+)
+
+// MetricPublisher is implemented by the CloudWatch client and the
+// alternatives used for dry runs and Embedded Metric Format output.
+type MetricPublisher interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// MetricBatch collects the metric data produced during one check cycle so it
+// can be published with as few PutMetricData calls as possible.
+type MetricBatch struct {
+	client     MetricPublisher
+	namespace  string
+	maxRetries int
+
+	// timeout bounds every single PutMetricData call. Zero means the calls
+	// are only bounded by the context passed to Flush.
+	timeout time.Duration
+
+	// buffer keeps datapoints that failed to publish for backfilling. It
+	// is nil if buffering is disabled.
+	buffer *MetricBuffer
+
+	mu       sync.Mutex
+	data     []types.MetricDatum
+	priority []types.MetricDatum
+
+	// throttleLevel is raised every time CloudWatch throttles a flush and
+	// lowered again after each unthrottled one.
+	throttleLevel int
+	flushes       int
+	throttled     int
+	dropped       int
+	timedOut      int
+
+	// lastFlush and lastFlushErr are when the last Flush finished and the
+	// first error it ran into, for the control socket.
+	lastFlush    time.Time
+	lastFlushErr error
+}
+
+// NewMetricBatch returns an empty batch publishing to namespace. Failed
+// requests are retried up to maxRetries times.
+func NewMetricBatch(client MetricPublisher, namespace string, maxRetries int) *MetricBatch {
+	return &MetricBatch{
+		client:     client,
+		namespace:  namespace,
+		maxRetries: maxRetries,
+	}
+}
+
+// SetTimeout bounds every PutMetricData call to timeout, so that a hanging
+// CloudWatch endpoint cannot stall the check loop.
+func (b *MetricBatch) SetTimeout(timeout time.Duration) {
+	b.timeout = timeout
+}
+
+// SetBuffer enables backfilling of datapoints that failed to publish.
+func (b *MetricBatch) SetBuffer(buffer *MetricBuffer) {
+	b.buffer = buffer
+}
+
+// Add appends data to the batch. Nothing is sent until Flush is called.
+func (b *MetricBatch) Add(data ...types.MetricDatum) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, data...)
+}
+
+// AddPriority appends data that must be published on the next Flush even
+// while publishing is slowed down because of throttling, e.g. health state
+// transitions.
+func (b *MetricBatch) AddPriority(data ...types.MetricDatum) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.priority = append(b.priority, data...)
+}
+
+// TakeStats returns the number of throttled and timed out publish requests and
+// dropped datapoints since the previous call.
+func (b *MetricBatch) TakeStats() (throttled, timedOut, dropped int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	throttled, timedOut, dropped = b.throttled, b.timedOut, b.dropped
+	b.throttled, b.timedOut, b.dropped = 0, 0, 0
+
+	return throttled, timedOut, dropped
+}
+
+// Flush publishes everything collected since the previous Flush, splitting
+// the data into several requests when it exceeds the PutMetricData limits.
+// Observations of the same metric series are aggregated into a single
+// statistic set, so a window with several checks costs one datapoint per
+// series. Transient failures are retried until ctx is done.
+//
+// While CloudWatch is throttling, steady-state data is held back and
+// coalesced in later flushes so that fewer requests are made; priority data
+// is still published on every Flush.
+func (b *MetricBatch) Flush(ctx context.Context) {
+	b.mu.Lock()
+	b.flushes++
+	data := b.priority
+	b.priority = nil
+	// prioritySeries are the series with priority data, which stays ahead of
+	// the held back data if the request carrying it is throttled.
+	prioritySeries := make(map[string]bool, len(data))
+	for _, datum := range data {
+		prioritySeries[seriesKey(datum)] = true
+	}
+	if b.flushes%(1<<uint(b.throttleLevel)) == 0 {
+		data = append(b.data, data...)
+		b.data = nil
+	} else {
+		log.Printf("[INFO] Throttled by CloudWatch, holding back %d datapoints", len(b.data))
+	}
+	b.mu.Unlock()
+	data = coalesceMetricData(data)
+
+	var firstErr error
+	failed, throttled := false, false
+	for _, chunk := range splitMetricData(data) {
+		params := &cloudwatch.PutMetricDataInput{
+			MetricData: chunk,
+			Namespace:  aws.String(b.namespace),
+		}
+
+		err := b.putMetricData(ctx, params)
+		if err == nil {
+			continue
+		}
+		failed = true
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if isThrottleError(err) {
+			throttled = true
+			b.throttle(chunk, prioritySeries)
+			continue
+		}
+
+		if b.buffer != nil && !isPermanentError(err) {
+			log.Printf("[ERROR] Failed to publish %d datapoints, buffering them for later: %s", len(chunk), err)
+			b.drop(b.buffer.Push(chunk...))
+			continue
+		}
+
+		log.Printf("[ERROR] Failed to publish %d datapoints: %s", len(chunk), err)
+		b.drop(len(chunk))
+	}
+
+	if !throttled && len(data) > 0 {
+		b.mu.Lock()
+		if b.throttleLevel > 0 {
+			b.throttleLevel--
+			log.Printf("[INFO] CloudWatch throttling eased, publish rate level now %d", b.throttleLevel)
+		}
+		b.mu.Unlock()
+	}
+
+	if !failed && b.buffer != nil {
+		b.backfill(ctx)
+	}
+
+	b.mu.Lock()
+	b.lastFlush, b.lastFlushErr = time.Now(), firstErr
+	b.mu.Unlock()
+}
+
+// LastFlush returns when the last Flush finished and the first error it ran
+// into, or a zero time before the first Flush.
+func (b *MetricBatch) LastFlush() (time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.lastFlush, b.lastFlushErr
+}
+
+// backfill publishes buffered datapoints, oldest first, until the buffer is
+// empty, a request fails or ctx is done.
+func (b *MetricBatch) backfill(ctx context.Context) {
+	flushed := 0
+	for ctx.Err() == nil {
+		chunk := b.buffer.Take(maxDatumsPerRequest)
+		if len(chunk) == 0 {
+			break
+		}
+
+		params := &cloudwatch.PutMetricDataInput{
+			MetricData: chunk,
+			Namespace:  aws.String(b.namespace),
+		}
+		if err := b.putMetricData(ctx, params); err != nil {
+			if isPermanentError(err) {
+				log.Printf("[ERROR] Failed to publish %d buffered datapoints, discarding them: %s", len(chunk), err)
+				b.drop(len(chunk))
+				continue
+			}
+			log.Printf("[WARN] Failed to publish buffered datapoints: %s", err)
+			b.drop(b.buffer.Requeue(chunk...))
+			break
+		}
+		flushed += len(chunk)
+	}
+
+	if flushed > 0 {
+		log.Printf("[INFO] Flushed %d buffered datapoints, %d remaining", flushed, b.buffer.Len())
+	}
+}
+
+// drop records n datapoints that could not be published.
+func (b *MetricBatch) drop(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dropped += n
+}
+
+// throttle slows publishing down and keeps the throttled data so it can be
+// coalesced into a later request. Data of prioritySeries goes back into the
+// priority data, published on the next Flush, the rest is held back.
+func (b *MetricBatch) throttle(data []types.MetricDatum, prioritySeries map[string]bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.throttled++
+	var priority, steady []types.MetricDatum
+	for _, datum := range data {
+		if prioritySeries[seriesKey(datum)] {
+			priority = append(priority, datum)
+		} else {
+			steady = append(steady, datum)
+		}
+	}
+	b.priority = append(priority, b.priority...)
+	b.data = append(steady, b.data...)
+	if b.throttleLevel < maxThrottleLevel {
+		b.throttleLevel++
+	}
+	b.flushes = 0
+
+	log.Printf("[WARN] PutMetricData throttled by CloudWatch, publishing every %d intervals",
+		1<<uint(b.throttleLevel))
+}
+
+// putMetricData sends params, retrying transient errors with exponential
+// backoff and full jitter. It gives up once maxRetries is exhausted, the next
+// attempt would start after the deadline of ctx, a call times out or ctx is
+// done.
+func (b *MetricBatch) putMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput) error {
+	for attempt := 0; ; attempt++ {
+		err := b.callPutMetricData(ctx, params)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("[ERROR] PutMetricData timed out after %s, not retrying", b.timeout)
+			b.mu.Lock()
+			b.timedOut++
+			b.mu.Unlock()
+			return err
+		}
+		if !isRetryableError(err) {
+			log.Printf("[ERROR] PutMetricData failed with a non-retryable error, not retrying")
+			return err
+		}
+		if attempt >= b.maxRetries {
+			return err
+		}
+
+		delay := backoffDelay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			log.Printf("[WARN] PutMetricData retry would overrun the check interval, giving up")
+			return err
+		}
+
+		log.Printf("[WARN] PutMetricData failed (attempt %d of %d), retrying in %s: %s",
+			attempt+1, b.maxRetries+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// callPutMetricData makes a single PutMetricData call bounded by the timeout.
+func (b *MetricBatch) callPutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput) error {
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+
+	_, err := b.client.PutMetricData(ctx, params)
+
+	return err
+}
+
+// isThrottleError reports whether err means the request was throttled.
+func isThrottleError(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// isRetryableError reports whether err is a throttling, server-side or
+// network error that may succeed when retried.
+func isRetryableError(err error) bool {
+	if isThrottleError(err) || retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary {
+		return true
+	}
+
+	return httpStatusCode(err) >= 500
+}
+
+// isPermanentError reports whether err is a client error that will fail again
+// no matter how often the same request is repeated.
+func isPermanentError(err error) bool {
+	if isRetryableError(err) {
+		return false
+	}
+	code := httpStatusCode(err)
+
+	return code >= 400 && code < 500
+}
+
+// httpStatusCode returns the HTTP status code of a failed AWS API call, or 0
+// if err did not come from an HTTP response.
+func httpStatusCode(err error) int {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode()
+	}
+
+	return 0
+}
+
+// backoffDelay returns a random delay between zero and the exponential
+// backoff ceiling for the given attempt ("full jitter").
+func backoffDelay(attempt int) time.Duration {
+	ceiling := retryMaxDelay
+	if attempt < 16 {
+		if d := retryBaseDelay << uint(attempt); d < ceiling {
+			ceiling = d
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// splitMetricData groups data into chunks that each fit into a single
+// PutMetricData request.
+func splitMetricData(data []types.MetricDatum) [][]types.MetricDatum {
+	var chunks [][]types.MetricDatum
+	var chunk []types.MetricDatum
+	size := 0
+
+	for _, datum := range data {
+		n := datumSize(datum)
+		if len(chunk) == maxDatumsPerRequest || (len(chunk) > 0 && size+n > maxRequestBytes) {
+			chunks = append(chunks, chunk)
+			chunk = nil
+			size = 0
+		}
+		chunk = append(chunk, datum)
+		size += n
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// datumSize estimates the encoded size of a datum in the request body. String
+// values are counted three times over to allow for percent-encoding.
+func datumSize(datum types.MetricDatum) int {
+	size := fieldOverhead + 3*len(aws.ToString(datum.MetricName))
+	for _, dim := range datum.Dimensions {
+		size += 2*fieldOverhead + 3*len(aws.ToString(dim.Name)) + 3*len(aws.ToString(dim.Value))
+	}
+	size += fieldOverhead * (len(datum.Values) + len(datum.Counts))
+
+	// Value, Timestamp, Unit, StorageResolution and the four StatisticSet
+	// fields all fit comfortably into a fixed allowance.
+	return size + 8*fieldOverhead
+}
+
+// coalesceMetricData merges data points that belong to the same metric
+// series into a single statistic set stamped with the earliest timestamp, the
+// start of the window it covers. Datums carrying Values/Counts arrays are
+// passed through unchanged.
+func coalesceMetricData(data []types.MetricDatum) []types.MetricDatum {
+	var coalesced []types.MetricDatum
+	series := make(map[string]int)
+
+	for _, datum := range data {
+		stats := statisticSet(datum)
+		if stats == nil {
+			coalesced = append(coalesced, datum)
+			continue
+		}
+
+		key := seriesKey(datum)
+		i, ok := series[key]
+		if !ok {
+			series[key] = len(coalesced)
+			coalesced = append(coalesced, types.MetricDatum{
+				MetricName:        datum.MetricName,
+				Dimensions:        datum.Dimensions,
+				StatisticValues:   stats,
+				StorageResolution: datum.StorageResolution,
+				Timestamp:         datum.Timestamp,
+				Unit:              datum.Unit,
+			})
+			continue
+		}
+
+		merged := &coalesced[i]
+		s := merged.StatisticValues
+		s.Maximum = aws.Float64(math.Max(aws.ToFloat64(s.Maximum), aws.ToFloat64(stats.Maximum)))
+		s.Minimum = aws.Float64(math.Min(aws.ToFloat64(s.Minimum), aws.ToFloat64(stats.Minimum)))
+		s.SampleCount = aws.Float64(aws.ToFloat64(s.SampleCount) + aws.ToFloat64(stats.SampleCount))
+		s.Sum = aws.Float64(aws.ToFloat64(s.Sum) + aws.ToFloat64(stats.Sum))
+		if datum.Timestamp != nil && (merged.Timestamp == nil || datum.Timestamp.Before(*merged.Timestamp)) {
+			merged.Timestamp = datum.Timestamp
+		}
+	}
+
+	return coalesced
+}
+
+// statisticSet returns a copy of the datum's value as a statistic set, or nil
+// if the datum cannot be merged.
+func statisticSet(datum types.MetricDatum) *types.StatisticSet {
+	switch {
+	case datum.StatisticValues != nil:
+		s := *datum.StatisticValues
+		return &s
+	case datum.Value != nil:
+		return &types.StatisticSet{
+			Maximum:     datum.Value,
+			Minimum:     datum.Value,
+			SampleCount: aws.Float64(1.0),
+			Sum:         datum.Value,
+		}
+	}
+
+	return nil
+}
+
+// seriesKey identifies the metric series a datum belongs to.
+func seriesKey(datum types.MetricDatum) string {
+	var key strings.Builder
+	key.WriteString(aws.ToString(datum.MetricName))
+	key.WriteString("|" + string(datum.Unit))
+	key.WriteString("|" + strconv.FormatInt(int64(aws.ToInt32(datum.StorageResolution)), 10))
+	for _, dim := range datum.Dimensions {
+		key.WriteString("|" + aws.ToString(dim.Name) + "=" + aws.ToString(dim.Value))
+	}
+
+	return key.String()
+}
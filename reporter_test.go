@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// fakePublisher records the PutMetricData calls that succeeded. The first
+// failures calls fail with err.
+type fakePublisher struct {
+	err      error
+	failures int
+
+	mu       sync.Mutex
+	attempts int
+	calls    []*cloudwatch.PutMetricDataInput
+}
+
+func (p *fakePublisher) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.attempts++
+	if p.attempts <= p.failures {
+		return nil, p.err
+	}
+	p.calls = append(p.calls, params)
+
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// published returns the data of every successful call, in order.
+func (p *fakePublisher) published() [][]types.MetricDatum {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var data [][]types.MetricDatum
+	for _, c := range p.calls {
+		data = append(data, c.MetricData)
+	}
+
+	return data
+}
+
+// hangingPublisher blocks every PutMetricData call until its ctx is done,
+// like an unresponsive CloudWatch endpoint.
+type hangingPublisher struct{}
+
+func (hangingPublisher) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	<-ctx.Done()
+
+	return nil, ctx.Err()
+}
+
+// responseError returns the error of an API call answered with the HTTP status
+// and the error code.
+func responseError(status int, code string) error {
+	return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+		Err:      &smithy.GenericAPIError{Code: code, Message: code},
+	}}
+}
+
+// testDatums returns n datums of distinct series, so that none are coalesced,
+// each with the dimension value repeated to size bytes.
+func testDatums(n, size int) []types.MetricDatum {
+	data := make([]types.MetricDatum, n)
+	for i := range data {
+		data[i] = types.MetricDatum{
+			MetricName: aws.String(fmt.Sprintf("Metric%d", i)),
+			Dimensions: []types.Dimension{{
+				Name:  aws.String("ClusterName"),
+				Value: aws.String(strings.Repeat("x", size)),
+			}},
+			Unit:  types.StandardUnitCount,
+			Value: aws.Float64(1),
+		}
+	}
+
+	return data
+}
+
+func TestFlushSplitsRequests(t *testing.T) {
+	// big is the size of a dimension value of which three datums fit into
+	// a request, but not four, as datumSize counts it three times over.
+	big := maxRequestBytes / 10
+
+	tests := []struct {
+		name  string
+		data  []types.MetricDatum
+		calls []int
+	}{
+		{"19 datums", testDatums(19, 10), []int{19}},
+		{"20 datums", testDatums(20, 10), []int{20}},
+		{"21 datums", testDatums(21, 10), []int{20, 1}},
+		{"41 datums", testDatums(41, 10), []int{20, 20, 1}},
+		{"payload size", testDatums(7, big), []int{3, 3, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakePublisher{}
+			batch := NewMetricBatch(client, "etcd", 0)
+			batch.Add(test.data...)
+			batch.Flush(context.Background())
+
+			published := client.published()
+			if len(published) != len(test.calls) {
+				t.Fatalf("Flush made %d PutMetricData calls, want %d", len(published), len(test.calls))
+			}
+			for i, data := range published {
+				if len(data) != test.calls[i] {
+					t.Errorf("call %d published %d datums, want %d", i+1, len(data), test.calls[i])
+				}
+				size := 0
+				for _, datum := range data {
+					size += datumSize(datum)
+				}
+				if size > maxRequestBytes {
+					t.Errorf("call %d is %d bytes, over the %d bytes limit", i+1, size, maxRequestBytes)
+				}
+			}
+		})
+	}
+}
+
+func TestFlushRetries(t *testing.T) {
+	const interval = 10 * time.Second
+
+	tests := []struct {
+		name      string
+		err       error
+		failures  int
+		attempts  int
+		published int
+		dropped   int
+	}{
+		{"retryable error", responseError(http.StatusServiceUnavailable, "ServiceUnavailable"), 3, 4, 1, 0},
+		{"access denied", responseError(http.StatusForbidden, "AccessDenied"), 1, 1, 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &fakePublisher{err: test.err, failures: test.failures}
+			buffer, err := NewMetricBuffer(100, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			batch := NewMetricBatch(client, "etcd", 5)
+			batch.SetBuffer(buffer)
+			batch.Add(testDatums(1, 10)...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			defer cancel()
+			start := time.Now()
+			batch.Flush(ctx)
+			if elapsed := time.Since(start); elapsed >= interval {
+				t.Errorf("Flush took %s, longer than the %s interval", elapsed, interval)
+			}
+
+			if client.attempts != test.attempts {
+				t.Errorf("Flush made %d attempts, want %d", client.attempts, test.attempts)
+			}
+			if n := len(client.published()); n != test.published {
+				t.Errorf("the batch was published %d times, want %d", n, test.published)
+			}
+			if buffer.Len() != 0 {
+				t.Errorf("%d datapoints were buffered, want none", buffer.Len())
+			}
+			if _, _, dropped := batch.TakeStats(); dropped != test.dropped {
+				t.Errorf("%d datapoints were dropped, want %d", dropped, test.dropped)
+			}
+		})
+	}
+}
+
+func TestFlushThrottledPriority(t *testing.T) {
+	client := &fakePublisher{err: responseError(http.StatusBadRequest, "Throttling"), failures: 1}
+	batch := NewMetricBatch(client, "etcd", 0)
+	data := testDatums(2, 10)
+	transition, heartbeat := data[0], data[1]
+	batch.AddPriority(transition)
+	batch.Add(heartbeat)
+	captureLog(t)
+
+	batch.Flush(context.Background())
+	if throttled, _, _ := batch.TakeStats(); throttled != 1 {
+		t.Fatalf("%d requests were throttled, want 1", throttled)
+	}
+
+	// Publishing slows down, but the transition is not held back with the
+	// heartbeat.
+	batch.Flush(context.Background())
+	published := client.published()
+	if len(published) != 1 {
+		t.Fatalf("the batch was published %d times after the throttled flush, want 1", len(published))
+	}
+	if len(published[0]) != 1 || aws.ToString(published[0][0].MetricName) != aws.ToString(transition.MetricName) {
+		t.Errorf("the next flush published %d datums, want only the transition", len(published[0]))
+	}
+
+	batch.Flush(context.Background())
+	if published := client.published(); len(published) != 2 || aws.ToString(published[1][0].MetricName) != aws.ToString(heartbeat.MetricName) {
+		t.Errorf("the heartbeat was not published after being held back for one flush")
+	}
+}
+
+func TestFlushCancelledDuringBackoff(t *testing.T) {
+	client := &fakePublisher{err: responseError(http.StatusServiceUnavailable, "ServiceUnavailable"), failures: 100}
+	batch := NewMetricBatch(client, "etcd", 10)
+	batch.Add(testDatums(1, 10)...)
+	captureLog(t)
+
+	// Without a deadline every retry is attempted, unless the backoff stops
+	// on cancellation.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	batch.Flush(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Flush took %s after being cancelled at 100ms", elapsed)
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	batch := NewMetricBatch(hangingPublisher{}, "etcd", 5)
+	batch.SetTimeout(timeout)
+	batch.Add(testDatums(1, 10)...)
+	captureLog(t)
+
+	start := time.Now()
+	batch.Flush(context.Background())
+	if elapsed := time.Since(start); elapsed > 2*timeout {
+		t.Errorf("Flush took %s, longer than the %s timeout", elapsed, timeout)
+	}
+	if _, timedOut, _ := batch.TakeStats(); timedOut != 1 {
+		t.Errorf("%d requests timed out, want 1", timedOut)
+	}
+}
+
+func TestCoalesceMetricData(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	datum := func(value float64, timestamp time.Time) types.MetricDatum {
+		d := testDatums(1, 10)[0]
+		d.Value = aws.Float64(value)
+		d.Timestamp = aws.Time(timestamp)
+		return d
+	}
+
+	// Datums added with priority come before the earlier steady-state
+	// ones.
+	data := []types.MetricDatum{
+		datum(1, start.Add(20*time.Second)),
+		datum(0, start),
+		datum(3, start.Add(10*time.Second)),
+	}
+	coalesced := coalesceMetricData(data)
+	if len(coalesced) != 1 {
+		t.Fatalf("coalesced into %d datums, want 1", len(coalesced))
+	}
+	got := coalesced[0]
+	if ts := aws.ToTime(got.Timestamp); !ts.Equal(start) {
+		t.Errorf("Timestamp = %v, want the earliest %v", ts, start)
+	}
+	s := got.StatisticValues
+	if aws.ToFloat64(s.SampleCount) != 3 || aws.ToFloat64(s.Sum) != 4 || aws.ToFloat64(s.Minimum) != 0 || aws.ToFloat64(s.Maximum) != 3 {
+		t.Errorf("StatisticValues = {SampleCount %g, Sum %g, Minimum %g, Maximum %g}, want {3, 4, 0, 3}",
+			aws.ToFloat64(s.SampleCount), aws.ToFloat64(s.Sum), aws.ToFloat64(s.Minimum), aws.ToFloat64(s.Maximum))
+	}
+}
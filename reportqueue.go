@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// reportQueueSize is how many check results wait for the reporters before the
+// oldest is dropped.
+const reportQueueSize = 10
+
+// reportQueue hands the check results to the reporters on a goroutine of its
+// own, so that a slow destination, e.g. CloudWatch taking the whole interval
+// to publish, does not delay the next check. If the reporters fall behind by
+// more than the queue holds, the oldest results are dropped.
+type reportQueue struct {
+	queue chan queuedResult
+	done  chan struct{}
+
+	// dropped counts the results dropped since the last report.
+	mu      sync.Mutex
+	dropped int
+}
+
+// queuedResult is a check result waiting for the reporters. publish is set if
+// it completes the publish window, paused if it was made while paused.
+type queuedResult struct {
+	result  CheckResult
+	publish bool
+	paused  bool
+}
+
+// newReportQueue starts reporting the results pushed to it until it is
+// closed. Cancelling ctx cancels the running report and publish.
+func newReportQueue(ctx context.Context) *reportQueue {
+	q := &reportQueue{
+		queue: make(chan queuedResult, reportQueueSize),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(q.done)
+		for r := range q.queue {
+			if dropped := q.takeDropped(); dropped > 0 {
+				metrics.Add(newMetricData("DroppedCheckResults", float64(dropped), types.StandardUnitCount)...)
+			}
+			q.report(ctx, r)
+		}
+	}()
+
+	return q
+}
+
+// report reports r, recovering a panic so that the next results are still
+// reported.
+func (q *reportQueue) report(ctx context.Context, r queuedResult) {
+	defer func() {
+		if v := recover(); v != nil {
+			logPanic("Reporting the check", v)
+		}
+	}()
+
+	reportResult(ctx, r)
+}
+
+// push queues r, making room by dropping the oldest queued result if needed.
+// The publish of a dropped result is left to the new one.
+func (q *reportQueue) push(r queuedResult) {
+	for {
+		select {
+		case q.queue <- r:
+			return
+		default:
+		}
+
+		select {
+		case old := <-q.queue:
+			log.Printf("[WARN] The reporters are falling behind, dropping the result of the check at %s", old.result.Time.Format(time.RFC3339))
+			r.publish = r.publish || old.publish
+			q.mu.Lock()
+			q.dropped++
+			q.mu.Unlock()
+		default:
+		}
+	}
+}
+
+// takeDropped returns how many results were dropped and resets the count.
+func (q *reportQueue) takeDropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := q.dropped
+	q.dropped = 0
+
+	return dropped
+}
+
+// close stops accepting results. done is closed once the queued ones are
+// reported.
+func (q *reportQueue) close() {
+	close(q.queue)
+}
+
+// reportResult sends the result of r to the reporters, or only to those
+// recording paused checks if it was made while paused, and publishes the
+// collected metrics if the publish window is complete or a reporter asked to
+// publish right away.
+func reportResult(ctx context.Context, r queuedResult) {
+	// Every failure was logged by the reporter it happened in.
+	if r.paused {
+		reporters.ReportPaused(ctx, r.result)
+	} else {
+		reporters.Report(ctx, r.result)
+	}
+	if !r.publish && !cloudWatchReporter.takePublishNow() {
+		return
+	}
+
+	// Publishing must be done before the next check is due.
+	ctx, cancel := context.WithTimeout(ctx, *interval)
+	defer cancel()
+
+	reporters.Flush(ctx)
+}
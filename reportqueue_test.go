@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedReporter records the results reported to it, and hangs in Report until
+// release is closed. flushes are the number of results reported at each of
+// its flushes.
+type gatedReporter struct {
+	release  chan struct{}
+	reported chan struct{}
+
+	mu      sync.Mutex
+	results []CheckResult
+	flushes []int
+}
+
+func newGatedReporter() *gatedReporter {
+	return &gatedReporter{
+		release:  make(chan struct{}),
+		reported: make(chan struct{}, 100),
+	}
+}
+
+func (r *gatedReporter) Report(ctx context.Context, result CheckResult) error {
+	r.mu.Lock()
+	r.results = append(r.results, result)
+	r.mu.Unlock()
+	r.reported <- struct{}{}
+
+	<-r.release
+
+	return nil
+}
+
+func (r *gatedReporter) Flush(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.flushes = append(r.flushes, len(r.results))
+}
+
+// useReporters makes reporter the only reporter of the queued results, with
+// CloudWatch publishing to publisher, until the test ends.
+func useReporters(t *testing.T, reporter Reporter, publisher MetricPublisher) {
+	t.Helper()
+
+	oldMetrics, oldReporters, oldCloudWatch := metrics, reporters, cloudWatchReporter
+	t.Cleanup(func() { metrics, reporters, cloudWatchReporter = oldMetrics, oldReporters, oldCloudWatch })
+	metrics = NewMetricBatch(publisher, *namespace, 0)
+	cloudWatchReporter = NewCloudWatchReporter(metrics)
+	reporters = NewFanOutReporter(time.Minute)
+	reporters.Add("test", reporter)
+}
+
+func TestReportQueueSlowReporter(t *testing.T) {
+	setFlags(t, "-name", "etcd-a")
+	if err := validateFlags(); err != nil {
+		t.Fatal(err)
+	}
+	logged := captureLog(t)
+	reporter := newGatedReporter()
+	publisher := &fakePublisher{}
+	useReporters(t, reporter, publisher)
+
+	// The first result keeps the reporter busy while the next ones queue up.
+	start := time.Unix(1700000000, 0)
+	result := func(i int) queuedResult {
+		r := testResult()
+		r.Time = start.Add(time.Duration(i) * time.Minute)
+		return queuedResult{result: r, publish: i == 1}
+	}
+	q := newReportQueue(context.Background())
+	q.push(result(0))
+	<-reporter.reported
+
+	const pushed = reportQueueSize + 2
+	began := time.Now()
+	for i := 1; i <= pushed; i++ {
+		q.push(result(i))
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Errorf("pushing %d results took %s, want no wait for the reporter", pushed, elapsed)
+	}
+
+	close(reporter.release)
+	q.close()
+	select {
+	case <-q.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued results were not reported")
+	}
+
+	// The two oldest queued results made room for the last two.
+	var got []int
+	for _, r := range reporter.results {
+		got = append(got, int(r.Time.Sub(start)/time.Minute))
+	}
+	want := []int{0, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	if len(got) != len(want) {
+		t.Fatalf("reported the checks %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("reported the checks %v, want %v", got, want)
+		}
+	}
+
+	// The publish of the first dropped result is left to the result that
+	// replaced it, the 11th.
+	if len(reporter.flushes) != 1 || reporter.flushes[0] != 10 {
+		t.Errorf("published after %v reported results, want once after the 11th check, the 10th reported", reporter.flushes)
+	}
+
+	if n := strings.Count(logged.String(), "[WARN] The reporters are falling behind, dropping the result of the check at"); n != 2 {
+		t.Errorf("logged %d dropped results, want 2:\n%s", n, logged)
+	}
+
+	metrics.Flush(context.Background())
+	var dropped []float64
+	for _, call := range publisher.published() {
+		for _, datum := range call {
+			if *datum.MetricName == "DroppedCheckResults" {
+				dropped = append(dropped, *statisticSet(datum).Sum)
+			}
+		}
+	}
+	if len(dropped) != 1 || dropped[0] != 2 {
+		t.Errorf("DroppedCheckResults = %v, want 2 once", dropped)
+	}
+}
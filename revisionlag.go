@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// RevisionLagProbe detects members that are alive but fall behind in applying
+// entries. It queries the status of every member at the same time and
+// publishes how far each member's revision trails the highest one.
+type RevisionLagProbe struct {
+	endpoint  string
+	threshold int64
+	tolerance int64
+}
+
+// NewRevisionLagProbe returns a probe discovering the members through the etcd
+// member at endpoint. Lag up to tolerance revisions is reported as zero, to
+// allow for writes applied between the status calls; lag above threshold is
+// logged.
+func NewRevisionLagProbe(endpoint string, threshold, tolerance int64) *RevisionLagProbe {
+	return &RevisionLagProbe{
+		endpoint:  endpoint,
+		threshold: threshold,
+		tolerance: tolerance,
+	}
+}
+
+// Run publishes RevisionLag per member and MaxRevisionLag for the cluster.
+// Members whose status cannot be retrieved are skipped.
+func (p *RevisionLagProbe) Run() {
+	members, err := getMembers(p.endpoint)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list etcd members for the revision lag: %s", err)
+		metrics.Add(newEndpointMetricData(p.endpoint, "RevisionLagProbeFailures", 1, types.StandardUnitCount)...)
+		return
+	}
+
+	revisions := make([]int64, len(members))
+	ok := make([]bool, len(members))
+	queryMembers(members, func(i int, endpoint string) {
+		status, err := getStatus(endpoint)
+		if err != nil {
+			log.Printf("[WARN] Failed to get the status of %s for the revision lag: %s", endpoint, err)
+			return
+		}
+		revisions[i], ok[i] = status.Header.Revision, true
+	})
+
+	var latest int64
+	responded := 0
+	for i := range members {
+		if ok[i] {
+			responded++
+			if revisions[i] > latest {
+				latest = revisions[i]
+			}
+		}
+	}
+	if responded < 2 {
+		log.Printf("[WARN] Not enough members responded to compute the revision lag")
+		return
+	}
+
+	var maxLag int64
+	for i, member := range members {
+		if !ok[i] {
+			continue
+		}
+
+		lag := latest - revisions[i]
+		if lag <= p.tolerance {
+			lag = 0
+		}
+		if p.threshold > 0 && lag > p.threshold {
+			log.Printf("[WARN] etcd member %s is %d revisions behind", member.Name, lag)
+		}
+		if lag > maxLag {
+			maxLag = lag
+		}
+
+		metrics.Add(buildMetricData(memberDimensionSets(member.Name), "RevisionLag", float64(lag), types.StandardUnitCount, time.Now())...)
+	}
+
+	metrics.Add(newMetricData("MaxRevisionLag", float64(maxLag), types.StandardUnitCount)...)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// scrapedHistograms maps the etcd histogram families the scrape probe reads to
+// the CloudWatch metrics their average and 99th percentile are published as.
+var scrapedHistograms = []struct {
+	family  string
+	average string
+	p99     string
+}{
+	{"etcd_disk_wal_fsync_duration_seconds", "WalFsyncLatencyMs", "WalFsyncLatencyP99Ms"},
+	{"etcd_disk_backend_commit_duration_seconds", "BackendCommitLatencyMs", "BackendCommitLatencyP99Ms"},
+}
+
+// histogram is a cumulative Prometheus histogram: the number and sum of all
+// observations and the cumulative count per bucket upper bound.
+type histogram struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+// ScrapeProbe reads disk latency histograms from the etcd Prometheus endpoint
+// and publishes the average and 99th percentile of the observations made since
+// the previous scrape.
+type ScrapeProbe struct {
+	url      string
+	endpoint string
+
+	// previous holds the histograms of the previous successful scrape by
+	// family name.
+	previous map[string]*histogram
+	scraped  bool
+}
+
+// NewScrapeProbe returns a probe scraping url. endpoint is the etcd member the
+// metrics belong to, for the endpoint dimension.
+func NewScrapeProbe(url, endpoint string) *ScrapeProbe {
+	return &ScrapeProbe{
+		url:      url,
+		endpoint: endpoint,
+		previous: make(map[string]*histogram),
+	}
+}
+
+// Run scrapes the metrics endpoint and publishes the latency metrics. Families
+// missing from the scrape are skipped; only a failed scrape is reported.
+func (p *ScrapeProbe) Run() {
+	families, err := scrapeMetrics(p.url)
+	if err != nil {
+		log.Printf("[ERROR] Failed to scrape %s: %s", p.url, err)
+		metrics.Add(newEndpointMetricData(p.endpoint, "MetricsScrapeFailures", 1, types.StandardUnitCount)...)
+		return
+	}
+
+	for _, h := range scrapedHistograms {
+		current := parseHistogram(families[h.family])
+		if current == nil {
+			// Only log when the family goes missing, not on every check.
+			if _, ok := p.previous[h.family]; ok || !p.scraped {
+				log.Printf("[WARN] %s not found in %s, skipping %s", h.family, p.url, h.average)
+			}
+			delete(p.previous, h.family)
+			continue
+		}
+
+		previous := p.previous[h.family]
+		p.previous[h.family] = current
+		if previous == nil || current.count < previous.count {
+			// First scrape, or etcd restarted and reset its counters.
+			continue
+		}
+
+		delta := current.sub(previous)
+		if delta.count == 0 {
+			continue
+		}
+
+		metrics.Add(newEndpointMetricData(p.endpoint, h.average,
+			delta.sum/float64(delta.count)*1000, types.StandardUnitMilliseconds)...)
+		metrics.Add(newEndpointMetricData(p.endpoint, h.p99,
+			delta.quantile(0.99)*1000, types.StandardUnitMilliseconds)...)
+	}
+	p.scraped = true
+}
+
+// scrapeMetrics fetches and parses the Prometheus text exposition at url.
+func scrapeMetrics(url string) (map[string]*dto.MetricFamily, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// parseHistogram sums all series of a histogram family, or returns nil if
+// family is missing or not a histogram.
+func parseHistogram(family *dto.MetricFamily) *histogram {
+	if family == nil || family.GetType() != dto.MetricType_HISTOGRAM {
+		return nil
+	}
+
+	h := &histogram{buckets: make(map[float64]uint64)}
+	for _, m := range family.GetMetric() {
+		hist := m.GetHistogram()
+		h.count += hist.GetSampleCount()
+		h.sum += hist.GetSampleSum()
+		for _, b := range hist.GetBucket() {
+			h.buckets[b.GetUpperBound()] += b.GetCumulativeCount()
+		}
+	}
+
+	return h
+}
+
+// sub returns the observations made between previous and h.
+func (h *histogram) sub(previous *histogram) *histogram {
+	delta := &histogram{
+		count:   h.count - previous.count,
+		sum:     h.sum - previous.sum,
+		buckets: make(map[float64]uint64),
+	}
+	for bound, count := range h.buckets {
+		if count >= previous.buckets[bound] {
+			delta.buckets[bound] = count - previous.buckets[bound]
+		}
+	}
+
+	return delta
+}
+
+// quantile estimates the q-quantile by linear interpolation within the bucket
+// it falls into, like Prometheus' histogram_quantile.
+func (h *histogram) quantile(q float64) float64 {
+	bounds := make([]float64, 0, len(h.buckets))
+	for bound := range h.buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+
+	rank := q * float64(h.count)
+	lower, below := 0.0, uint64(0)
+	for _, bound := range bounds {
+		count := h.buckets[bound]
+		if float64(count) >= rank {
+			if math.IsInf(bound, 1) || count == below {
+				return lower
+			}
+			return lower + (bound-lower)*(rank-float64(below))/float64(count-below)
+		}
+		lower, below = bound, count
+	}
+
+	return lower
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+)
+
+// SecretsManagerAPI is the part of the Secrets Manager API used by the monitor.
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// newSecretsManagerClient returns the client the TLS material is fetched with.
+func newSecretsManagerClient(cfg aws.Config) *secretsmanager.Client {
+	return secretsmanager.NewFromConfig(cfg)
+}
+
+// secretSource is PEM content held in Secrets Manager, as the whole
+// SecretString, or under a key of the JSON object in it with the
+// "arn#key" syntax. It is only kept in memory, never written to disk.
+type secretSource struct {
+	client SecretsManagerAPI
+	arn    string
+	key    string
+
+	mu      sync.Mutex
+	data    []byte
+	version string
+}
+
+// newSecretSource fetches the secret ref, an ARN or name, optionally followed
+// by #key.
+func newSecretSource(ctx context.Context, client SecretsManagerAPI, ref string) (*secretSource, error) {
+	arn, key, _ := strings.Cut(ref, "#")
+	s := &secretSource{client: client, arn: arn, key: key}
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// refresh fetches the secret again. If it cannot be, the previous content is
+// kept.
+func (s *secretSource) refresh(ctx context.Context) error {
+	out, err := s.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(s.arn)})
+	if err != nil {
+		return describeSecretError(s.String(), err)
+	}
+
+	data := out.SecretBinary
+	if out.SecretString != nil {
+		data = []byte(*out.SecretString)
+	}
+	if s.key != "" {
+		var values map[string]string
+		if err := json.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("%s is not a JSON object of strings: %s", s, err)
+		}
+		v, ok := values[s.key]
+		if !ok {
+			return fmt.Errorf("%s has no key %q", s, s.key)
+		}
+		data = []byte(v)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data, s.version = data, aws.ToString(out.VersionId)
+
+	return nil
+}
+
+func (s *secretSource) read() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.data, nil
+}
+
+// stamp returns the version of the secret last fetched.
+func (s *secretSource) stamp() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.version
+}
+
+func (s *secretSource) String() string {
+	if s.key != "" {
+		return "secret " + s.arn + "#" + s.key
+	}
+
+	return "secret " + s.arn
+}
+
+// describeSecretError returns err of fetching secret with a hint on what to
+// fix, so that missing permissions stand apart from a secret that does not
+// exist or holds invalid PEM.
+func describeSecretError(secret string, err error) error {
+	var notFound *types.ResourceNotFoundException
+	var decryption *types.DecryptionFailure
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &notFound):
+		return fmt.Errorf("%s not found: %s", secret, err)
+	case errors.As(err, &decryption):
+		return fmt.Errorf("%s cannot be decrypted, the monitor needs kms:Decrypt on its KMS key: %s", secret, err)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException":
+		return fmt.Errorf("access to %s denied, the monitor needs secretsmanager:GetSecretValue on it: %s", secret, err)
+	}
+
+	return fmt.Errorf("failed to fetch %s: %s", secret, err)
+}
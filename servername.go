@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// tlsServerNames are the names the certificates of etcd are verified for, and
+// sent as SNI, in place of the dialed host, e.g. when etcd is reached through
+// a TCP load balancer by IP while its certificates only hold the member
+// hostnames. It is nil if none are configured.
+var tlsServerNames *serverNames
+
+// serverNames is either a single server name for all endpoints, or the server
+// names of some endpoints by their host:port.
+type serverNames struct {
+	all    string
+	byAddr map[string]string
+}
+
+// loadServerNames reads a file of server names by endpoint, one endpoint and
+// its server name per line, separated by whitespace:
+//
+//	# etcd behind the load balancer
+//	https://10.0.0.10:2379 etcd-1.example.com
+//	https://10.0.0.11:2379 etcd-2.example.com
+//
+// Empty lines and lines starting with # are skipped.
+func loadServerNames(path string) (*serverNames, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := &serverNames{byAddr: map[string]string{}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected an endpoint and its server name", path, n)
+		}
+		addr, err := endpointAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		names.byAddr[addr] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// endpointAddr returns the host:port dialed for endpoint.
+func endpointAddr(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid endpoint %q, expected e.g. https://10.0.0.10:2379", endpoint)
+	}
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+
+	return u.Host, nil
+}
+
+// lookup returns the server name of addr, a host:port, or an empty string to
+// keep the host.
+func (n *serverNames) lookup(addr string) string {
+	if n == nil {
+		return ""
+	}
+	if n.all != "" {
+		return n.all
+	}
+
+	return n.byAddr[addr]
+}
+
+// config returns tlsConfig with the server name of endpoint, tlsConfig itself
+// if it has none.
+func (n *serverNames) config(tlsConfig *tls.Config, endpoint string) *tls.Config {
+	if n == nil || tlsConfig == nil {
+		return tlsConfig
+	}
+	addr, err := endpointAddr(endpoint)
+	if err != nil {
+		return tlsConfig
+	}
+	name := n.lookup(addr)
+	if name == "" {
+		return tlsConfig
+	}
+
+	c := tlsConfig.Clone()
+	c.ServerName = name
+
+	return c
+}
+
+// String describes the server names for the banner.
+func (n *serverNames) String() string {
+	if n.all != "" {
+		return n.all
+	}
+
+	return fmt.Sprintf("by endpoint, %d in the file", len(n.byAddr))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerName(t *testing.T) {
+	ca := newTestCA(t, "etcd-ca")
+	serverCert := ca.issue(t, "etcd-a", []string{"etcd-a.example.com"}, time.Now().Add(24*time.Hour))
+	caFile := writeTestFile(t, t.TempDir(), "ca.pem", ca.certPEM)
+	// The certificate has no IP address, the server is reached by one.
+	server := startTLSServer(t, serverCert, nil, nil)
+
+	tests := []struct {
+		name  string
+		names *serverNames
+		err   string
+	}{
+		{"without -tls-server-name", nil, "cannot validate certificate for 127.0.0.1"},
+		{"-tls-server-name", &serverNames{all: "etcd-a.example.com"}, ""},
+		{"another -tls-server-name", &serverNames{all: "etcd-b.example.com"}, "certificate is valid for etcd-a.example.com, not etcd-b.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsConfig, files, err := newTLSConfig(nil, nil, newFileSource(caFile), true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := newTransport(tlsConfig, transportOptions{tlsFiles: files, serverNames: test.names})
+			_, err = getHealth(tr, server.URL)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("the health request failed: %s", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("the health request returned %v, want an error with %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestServerNameFile(t *testing.T) {
+	ca := newTestCA(t, "etcd-ca")
+	dir := t.TempDir()
+	caFile := writeTestFile(t, dir, "ca.pem", ca.certPEM)
+
+	// Two members behind addresses their certificates do not name, and one
+	// missing from the file.
+	var servers []string
+	for _, name := range []string{"etcd-a.example.com", "etcd-b.example.com", "etcd-c.example.com"} {
+		cert := ca.issue(t, name, []string{name}, time.Now().Add(24*time.Hour))
+		servers = append(servers, startTLSServer(t, cert, nil, nil).URL)
+	}
+	path := writeTestFile(t, dir, "server-names", []byte(fmt.Sprintf(
+		"# etcd behind the load balancer\n\n%s etcd-a.example.com\n  %s   etcd-b.example.com\n", servers[0], servers[1])))
+	names, err := loadServerNames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, files, err := newTLSConfig(nil, nil, newFileSource(caFile), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTransport(tlsConfig, transportOptions{tlsFiles: files, serverNames: names})
+	for i, server := range servers[:2] {
+		if _, err := getHealth(tr, server); err != nil {
+			t.Errorf("the health request to member %d failed: %s", i, err)
+		}
+	}
+	if _, err := getHealth(tr, servers[2]); err == nil || !strings.Contains(err.Error(), "cannot validate certificate for 127.0.0.1") {
+		t.Errorf("the health request to the member missing from the file returned %v, want its IP address verified", err)
+	}
+}
+
+func TestLoadServerNames(t *testing.T) {
+	tests := []struct {
+		name, content string
+		addr, want    string
+		err           string
+	}{
+		{name: "default port", content: "https://10.0.0.10 etcd-1.example.com\n", addr: "10.0.0.10:443", want: "etcd-1.example.com"},
+		{name: "IPv6", content: "https://[fd00::10]:2379 etcd-1.example.com\n", addr: "[fd00::10]:2379", want: "etcd-1.example.com"},
+		{name: "missing server name", content: "https://10.0.0.10:2379\n", err: ":1: expected an endpoint and its server name"},
+		{name: "not an endpoint", content: "# etcd\n10.0.0.10:2379 etcd-1.example.com\n", err: `:2: invalid endpoint "10.0.0.10:2379"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names, err := loadServerNames(writeTestFile(t, t.TempDir(), "server-names", []byte(test.content)))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("loadServerNames() = %v, want an error with %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names.lookup(test.addr); got != test.want {
+				t.Errorf("lookup(%q) = %q, want %q", test.addr, got, test.want)
+			}
+		})
+	}
+}
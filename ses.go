@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// maxEmailErrors is how many of the most recent distinct errors of an outage
+// an email lists.
+const maxEmailErrors = 10
+
+// SESAPI is the part of the SES API used by the monitor.
+type SESAPI interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+}
+
+// emailTemplate renders the body of an email from emailMessage.
+const emailTemplate = `{{if .Recovered -}}
+The etcd cluster {{.Cluster}} recovered at {{.Time.Format "2006-01-02 15:04:05 MST"}} after being unhealthy for {{.Duration}} ({{.ConsecutiveFailures}} failed checks).
+{{- else -}}
+The etcd cluster {{.Cluster}} has been unhealthy since {{.Since.Format "2006-01-02 15:04:05 MST"}}, for {{.Duration}} ({{.ConsecutiveFailures}} failed checks).
+{{- end}}
+{{if .Endpoints}}
+Endpoints at the last check:
+{{- range .Endpoints}}
+  {{.Endpoint}}: {{if .Healthy}}healthy{{else}}NOT healthy{{end}}
+{{- end}}
+{{end}}
+{{- if .Errors}}
+Recent errors:
+{{- range .Errors}}
+  {{.Last.Format "15:04:05"}} {{.Endpoint}}: {{.Error}}{{if gt .Count 1}} ({{.Count}} times since {{.First.Format "15:04:05"}}){{end}}
+{{- end}}
+{{end}}
+--
+Sent by etcd-monitor {{.Version}} on {{.Host}}.
+`
+
+// emailMessage is the data emailTemplate is rendered with.
+type emailMessage struct {
+	Cluster             string
+	Recovered           bool
+	Time                time.Time
+	Since               time.Time
+	Duration            time.Duration
+	ConsecutiveFailures int
+	Endpoints           []EndpointStatus
+	Errors              []emailError
+	Version             string
+	Host                string
+}
+
+// emailError is an error of an outage, seen Count times between First and
+// Last.
+type emailError struct {
+	Endpoint string
+	Error    string
+	First    time.Time
+	Last     time.Time
+	Count    int
+}
+
+// SESNotifier emails a list of recipients through SES once the cluster has
+// been unhealthy for a while, optionally reminds them while it stays
+// unhealthy, and emails again when it recovers. Outages shorter than the
+// delay send nothing.
+type SESNotifier struct {
+	client   SESAPI
+	from     string
+	to       []string
+	after    time.Duration
+	reminder time.Duration
+	host     string
+	template *template.Template
+	queue    *notificationQueue
+
+	// since is when the current outage began, zero while healthy. failures
+	// counts its checks, errors are its most recent distinct errors, oldest
+	// first. lastSent is when the last email about it was queued, zero if
+	// none was.
+	since    time.Time
+	failures int
+	errors   []emailError
+	lastSent time.Time
+}
+
+// NewSESNotifier returns a notifier sending from the address from to the
+// addresses to with client, once the cluster has been unhealthy for after.
+// With a reminder interval, the email is repeated while the cluster stays
+// unhealthy.
+func NewSESNotifier(client SESAPI, from string, to []string, after, reminder time.Duration) (*SESNotifier, error) {
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid SES sender %q: %s", from, err)
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("-ses-from requires -ses-to")
+	}
+	for _, address := range to {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return nil, fmt.Errorf("invalid SES recipient %q: %s", address, err)
+		}
+	}
+	tmpl, err := template.New("email").Parse(emailTemplate)
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SESNotifier{
+		client:   client,
+		from:     from,
+		to:       to,
+		after:    after,
+		reminder: reminder,
+		host:     host,
+		template: tmpl,
+		queue:    newNotificationQueue("SES"),
+	}, nil
+}
+
+// newSESReporter returns the notifier of -ses-from and -ses-to, which only
+// logs the emails with -dry-run.
+func newSESReporter(cfg aws.Config) (Reporter, error) {
+	var client SESAPI = &dryRunClient{}
+	if !*dryRun {
+		client = newSESClient(cfg)
+	}
+	notifier, err := NewSESNotifier(client, *sesFrom, sesTo.values, *sesAfter, *sesReminder)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifier, nil
+}
+
+// newSESClient returns the client emails are sent with. Retries are handled by
+// the notification queue.
+func newSESClient(cfg aws.Config) *sesv2.Client {
+	return sesv2.NewFromConfig(cfg, func(o *sesv2.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+}
+
+// Report queues the first email once the outage lasts for the delay, a
+// reminder every reminder interval after that, and a recovery email at its end
+// if the first one was sent.
+func (n *SESNotifier) Report(ctx context.Context, result CheckResult) error {
+	if dropped := n.queue.takeDropped(); dropped > 0 {
+		metrics.Add(newMetricData("DroppedEmails", float64(dropped), cwtypes.StandardUnitCount)...)
+	}
+
+	if result.UnhealthyCount == 0 {
+		if !n.lastSent.IsZero() {
+			n.send("recovery email", "recovered", n.message(result, true))
+		}
+		n.since, n.failures, n.errors, n.lastSent = time.Time{}, 0, nil, time.Time{}
+		return nil
+	}
+
+	if n.since.IsZero() {
+		n.since = result.Time
+	}
+	n.failures++
+	for _, e := range result.Endpoints {
+		if !e.Healthy {
+			n.observeError(result.Time, e)
+		}
+	}
+
+	switch {
+	case n.lastSent.IsZero() && result.Time.Sub(n.since) >= n.after:
+		n.send("unhealthy email", "is unhealthy", n.message(result, false))
+	case !n.lastSent.IsZero() && n.reminder > 0 && result.Time.Sub(n.lastSent) >= n.reminder:
+		n.send("still unhealthy email", "is still unhealthy", n.message(result, false))
+	default:
+		return nil
+	}
+	n.lastSent = result.Time
+
+	return nil
+}
+
+// Shutdown delivers the pending notifications until ctx is done.
+func (n *SESNotifier) Shutdown(ctx context.Context) error {
+	return n.queue.Shutdown(ctx)
+}
+
+// observeError records the error of an endpoint that failed the check at t.
+func (n *SESNotifier) observeError(t time.Time, e EndpointStatus) {
+	endpoint := normalizeEndpoint(e.Endpoint)
+	reason := e.Error
+	if reason == "" {
+		reason = "unhealthy"
+	}
+
+	seen := emailError{Endpoint: endpoint, Error: reason, First: t}
+	for i, old := range n.errors {
+		if old.Endpoint == endpoint && old.Error == reason {
+			seen = old
+			n.errors = append(n.errors[:i], n.errors[i+1:]...)
+			break
+		}
+	}
+	seen.Last = t
+	seen.Count++
+
+	n.errors = append(n.errors, seen)
+	if len(n.errors) > maxEmailErrors {
+		n.errors = n.errors[len(n.errors)-maxEmailErrors:]
+	}
+}
+
+// message returns the data of the email about the outage, observed in result.
+func (n *SESNotifier) message(result CheckResult, recovered bool) emailMessage {
+	message := emailMessage{
+		Cluster:             *etcdName,
+		Recovered:           recovered,
+		Time:                result.Time,
+		Since:               n.since,
+		Duration:            result.Time.Sub(n.since).Round(time.Second),
+		ConsecutiveFailures: n.failures,
+		Errors:              append([]emailError(nil), n.errors...),
+		Version:             version,
+		Host:                n.host,
+	}
+	for _, e := range result.Endpoints {
+		e.Endpoint = normalizeEndpoint(e.Endpoint)
+		message.Endpoints = append(message.Endpoints, e)
+	}
+
+	return message
+}
+
+// send queues the email rendered from message, with a subject saying the
+// cluster is in state.
+func (n *SESNotifier) send(what, state string, message emailMessage) {
+	subject := fmt.Sprintf("[etcd-monitor] etcd cluster %s %s", message.Cluster, state)
+	n.queue.push(what, func(ctx context.Context) error {
+		var body bytes.Buffer
+		if err := n.template.Execute(&body, message); err != nil {
+			return &notifyError{err: err, permanent: true}
+		}
+		_, err := n.client.SendEmail(ctx, &sesv2.SendEmailInput{
+			FromEmailAddress: aws.String(n.from),
+			Destination:      &types.Destination{ToAddresses: n.to},
+			Content: &types.EmailContent{
+				Simple: &types.Message{
+					Subject: &types.Content{Data: aws.String(subject), Charset: aws.String("UTF-8")},
+					Body: &types.Body{
+						Text: &types.Content{Data: aws.String(body.String()), Charset: aws.String("UTF-8")},
+					},
+				},
+			},
+		})
+
+		return err
+	})
+}
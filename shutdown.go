@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// The exit codes of a shutdown on SIGTERM or SIGINT.
+const (
+	// exitShutdownClean is returned when the running check and publish
+	// finished within the grace period.
+	exitShutdownClean = 0
+	// exitShutdownTimeout is returned when they had to be cancelled.
+	exitShutdownTimeout = 2
+	// exitTooManyFailures is returned after -exit-after-failures failed
+	// checks in a row.
+	exitTooManyFailures = 4
+)
+
+// shutdownTimeout bounds every step of the shutdown after the grace period:
+// the cancelled check stopping, the last publish and releasing the reporters.
+const shutdownTimeout = 5 * time.Second
+
+// closeEtcdConnections closes the connections to etcd on exit, so that the
+// members do not log them as dropped.
+func closeEtcdConnections() {
+	if client != nil {
+		client.CloseIdleConnections()
+	}
+	for _, c := range etcdClients {
+		c.Close()
+	}
+}
+
+// logFailureStreak logs why the monitor gives up after the failed check in
+// result: how long the cluster has been failing and the errors of the last
+// check.
+func logFailureStreak(result CheckResult) {
+	var errors []string
+	for _, e := range result.Endpoints {
+		if e.ConsecutiveFailures > 0 && e.Error != "" {
+			errors = append(errors, normalizeEndpoint(e.Endpoint)+": "+e.Error)
+		}
+	}
+	if len(errors) == 0 {
+		errors = append(errors, "etcd IS NOT healthy")
+	}
+
+	log.Printf("[ERROR] etcd failed %d checks in a row since %s (%s), giving up: %s",
+		result.ConsecutiveFailures, result.FailingSince.Format(time.RFC3339),
+		result.Time.Sub(result.FailingSince).Round(time.Second), strings.Join(errors, "; "))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// slackTemplate renders the text of a Slack notification from slackMessage.
+// Slack's mrkdwn is used for formatting.
+const slackTemplate = `{{if .Healthy -}}
+:large_green_circle: etcd cluster *{{.Cluster}}* recovered after being unhealthy for {{.Duration}} ({{.ConsecutiveFailures}} failed checks).
+{{- else -}}
+:red_circle: etcd cluster *{{.Cluster}}* is unhealthy ({{.UnhealthyCount}} of {{.Endpoints}} endpoints failed).
+{{- range .Failures}}
+• ` + "`{{.Endpoint}}`" + `: {{.Error}}
+{{- end}}
+{{- end}}`
+
+// slackMessage is the data slackTemplate is rendered with.
+type slackMessage struct {
+	Cluster             string
+	Healthy             bool
+	Time                time.Time
+	Duration            time.Duration
+	ConsecutiveFailures int
+	UnhealthyCount      float64
+	Endpoints           int
+	Failures            []slackFailure
+}
+
+// slackFailure is an endpoint that failed the check.
+type slackFailure struct {
+	Endpoint string
+	Error    string
+}
+
+// SlackNotifier posts a message to a Slack incoming webhook when the cluster
+// becomes unhealthy and when it recovers. Notifications for a cluster that
+// flaps are suppressed for a cooldown.
+type SlackNotifier struct {
+	webhookURL string
+	dryRun     bool
+	template   *template.Template
+	detector   transitionDetector
+	cooldown   *notifyCooldown
+	queue      *notificationQueue
+}
+
+// NewSlackNotifier returns a notifier posting to webhookURL, notifying about a
+// state at most once per cooldown. With dryRun the messages are only logged.
+func NewSlackNotifier(webhookURL string, cooldown time.Duration, dryRun bool) (*SlackNotifier, error) {
+	if !strings.HasPrefix(webhookURL, "https://") && !strings.HasPrefix(webhookURL, "http://") {
+		return nil, fmt.Errorf("the Slack webhook URL must be an http(s) URL")
+	}
+	tmpl, err := template.New("slack").Parse(slackTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SlackNotifier{
+		webhookURL: webhookURL,
+		dryRun:     dryRun,
+		template:   tmpl,
+		cooldown:   newNotifyCooldown(cooldown),
+		queue:      newNotificationQueue("Slack"),
+	}, nil
+}
+
+// newSlackReporter returns the notifier of -slack-webhook-url, or of the URL
+// in -slack-webhook-url-file.
+func newSlackReporter() (Reporter, error) {
+	webhookURL := *slackWebhookURL
+	if *slackWebhookURLFile != "" {
+		var err error
+		webhookURL, err = readSlackWebhookURL(*slackWebhookURLFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	notifier, err := NewSlackNotifier(webhookURL, *slackCooldown, *dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifier, nil
+}
+
+// Report queues a notification if result changes the cluster state and the
+// cooldown allows it.
+func (n *SlackNotifier) Report(ctx context.Context, result CheckResult) error {
+	t, changed := n.detector.observe(result)
+	if t, ok := n.cooldown.take(result.Time, t, changed); ok {
+		n.queue.push(transitionState(t)+" notification", func(ctx context.Context) error {
+			return n.post(ctx, t)
+		})
+	}
+
+	return nil
+}
+
+// Shutdown delivers the pending notifications until ctx is done.
+func (n *SlackNotifier) Shutdown(ctx context.Context) error {
+	return n.queue.Shutdown(ctx)
+}
+
+// text renders the message for t.
+func (n *SlackNotifier) text(t Transition) (string, error) {
+	message := slackMessage{
+		Cluster:             slackEscape(*etcdName),
+		Healthy:             t.Healthy,
+		Time:                t.Time,
+		Duration:            t.Time.Sub(t.Since).Round(time.Second),
+		ConsecutiveFailures: t.ConsecutiveFailures,
+		UnhealthyCount:      t.Result.UnhealthyCount,
+		Endpoints:           len(t.Result.Endpoints),
+	}
+	for _, e := range t.Result.Endpoints {
+		if e.Healthy {
+			continue
+		}
+		reason := e.Error
+		if reason == "" {
+			reason = "unhealthy"
+		}
+		message.Failures = append(message.Failures, slackFailure{
+			Endpoint: slackEscape(normalizeEndpoint(e.Endpoint)),
+			Error:    slackEscape(reason),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := n.template.Execute(&buf, message); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (n *SlackNotifier) post(ctx context.Context, t Transition) error {
+	text, err := n.text(t)
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+	if n.dryRun {
+		log.Printf("[INFO] Dry run, not posting to Slack: %s", text)
+		return nil
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The error would include the secret webhook URL.
+		if uerr, ok := err.(*url.Error); ok {
+			err = fmt.Errorf("%s to the webhook: %s", uerr.Op, uerr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	reply, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &notifyError{
+			err:        fmt.Errorf("rate limited by Slack"),
+			retryAfter: time.Duration(seconds) * time.Second,
+		}
+	default:
+		// The reply is a short error code like "invalid_token".
+		return &notifyError{
+			err:       fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(reply))),
+			permanent: resp.StatusCode < 500,
+		}
+	}
+}
+
+// readSlackWebhookURL returns the webhook URL held in file.
+func readSlackWebhookURL(file string) (string, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the Slack webhook URL file: %s", err)
+	}
+	webhookURL := strings.TrimSpace(string(buff))
+	if webhookURL == "" {
+		return "", fmt.Errorf("the Slack webhook URL file %s is empty", file)
+	}
+
+	return webhookURL, nil
+}
+
+// slackEscape escapes the characters Slack treats as control characters in
+// message text.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
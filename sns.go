@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// SNSAPI is the part of the SNS API used by the monitor.
+type SNSAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// snsMessage is the message published on a transition. Subscribers parse it,
+// so fields are only ever added.
+type snsMessage struct {
+	Cluster             string    `json:"cluster"`
+	State               string    `json:"state"`
+	Healthy             bool      `json:"healthy"`
+	Timestamp           time.Time `json:"timestamp"`
+	Since               time.Time `json:"since"`
+	UnhealthyCount      float64   `json:"unhealthyCount"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Endpoints           []string  `json:"endpoints"`
+	Reason              string    `json:"reason,omitempty"`
+}
+
+// SNSNotifier publishes a message to an SNS topic when the cluster becomes
+// unhealthy and when it recovers. Checks that do not change the state publish
+// nothing.
+type SNSNotifier struct {
+	client   SNSAPI
+	topicARN string
+	detector transitionDetector
+	queue    *notificationQueue
+}
+
+// NewSNSNotifier returns a notifier publishing to topicARN with client.
+func NewSNSNotifier(client SNSAPI, topicARN string) *SNSNotifier {
+	return &SNSNotifier{
+		client:   client,
+		topicARN: topicARN,
+		queue:    newNotificationQueue("SNS"),
+	}
+}
+
+// newSNSReporter returns the notifier of -sns-topic-arn, which only logs the
+// messages with -dry-run.
+func newSNSReporter(cfg aws.Config) (Reporter, error) {
+	var client SNSAPI = &dryRunClient{}
+	if !*dryRun {
+		client = newSNSClient(cfg)
+	}
+
+	return NewSNSNotifier(client, *snsTopicARN), nil
+}
+
+// newSNSClient returns the client notifications are published with. Retries
+// are handled by the notification queue.
+func newSNSClient(cfg aws.Config) *sns.Client {
+	return sns.NewFromConfig(cfg, func(o *sns.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+}
+
+// Report queues a notification if result changes the cluster state.
+func (n *SNSNotifier) Report(ctx context.Context, result CheckResult) error {
+	if t, ok := n.detector.observe(result); ok {
+		n.queue.push(transitionState(t)+" notification", func(ctx context.Context) error {
+			return n.publish(ctx, t)
+		})
+	}
+
+	return nil
+}
+
+// Shutdown delivers the pending notifications until ctx is done.
+func (n *SNSNotifier) Shutdown(ctx context.Context) error {
+	return n.queue.Shutdown(ctx)
+}
+
+func (n *SNSNotifier) publish(ctx context.Context, t Transition) error {
+	message := snsMessage{
+		Cluster:             *etcdName,
+		State:               "UNHEALTHY",
+		Healthy:             t.Healthy,
+		Timestamp:           t.Time,
+		Since:               t.Since,
+		UnhealthyCount:      t.Result.UnhealthyCount,
+		ConsecutiveFailures: t.ConsecutiveFailures,
+		Endpoints:           t.FailedEndpoints(),
+		Reason:              t.Reason(),
+	}
+	subject := "etcd cluster " + *etcdName + " is unhealthy"
+	severity := "critical"
+	if t.Healthy {
+		message.State = "RECOVERED"
+		subject = "etcd cluster " + *etcdName + " recovered"
+		severity = "ok"
+	}
+	if message.Endpoints == nil {
+		message.Endpoints = []string{}
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	// SNS subjects are limited to 100 characters.
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+
+	_, err = n.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(n.topicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"severity": {DataType: aws.String("String"), StringValue: aws.String(severity)},
+			"cluster":  {DataType: aws.String("String"), StringValue: aws.String(*etcdName)},
+		},
+	})
+
+	return err
+}
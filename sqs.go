@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// SQSAPI is the part of the SQS API used by the monitor.
+type SQSAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// SQSNotifier sends a message to an SQS queue when an endpoint changes state,
+// for pipelines remediating the cluster. The message body is the document
+// posted by the webhook, so that consumers can be shared.
+type SQSNotifier struct {
+	client   SQSAPI
+	queueURL string
+	fifo     bool
+	queue    *notificationQueue
+
+	states endpointStates
+
+	// failures counts the failed SendMessage calls since the last Report.
+	mu       sync.Mutex
+	failures int
+}
+
+// NewSQSNotifier returns a notifier sending to the queue at queueURL with
+// client. FIFO queues, whose name ends in .fifo, get a message group per
+// cluster.
+func NewSQSNotifier(client SQSAPI, queueURL string) (*SQSNotifier, error) {
+	if !strings.HasPrefix(queueURL, "https://") && !strings.HasPrefix(queueURL, "http://") {
+		return nil, fmt.Errorf("the SQS queue URL must be an http(s) URL")
+	}
+
+	return &SQSNotifier{
+		client:   client,
+		queueURL: queueURL,
+		fifo:     strings.HasSuffix(queueURL, ".fifo"),
+		queue:    newNotificationQueue("SQS"),
+	}, nil
+}
+
+// newSQSReporter returns the notifier of -sqs-queue-url, which only logs the
+// messages with -dry-run.
+func newSQSReporter(cfg aws.Config) (Reporter, error) {
+	var client SQSAPI = &dryRunClient{}
+	if !*dryRun {
+		client = newSQSClient(cfg)
+	}
+	notifier, err := NewSQSNotifier(client, *sqsQueueURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifier, nil
+}
+
+// newSQSClient returns the client messages are sent with. Retries are handled
+// by the notification queue.
+func newSQSClient(cfg aws.Config) *sqs.Client {
+	return sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+}
+
+// Report queues a message for every endpoint that changed state.
+func (n *SQSNotifier) Report(ctx context.Context, result CheckResult) error {
+	n.mu.Lock()
+	failures := n.failures
+	n.failures = 0
+	n.mu.Unlock()
+	if failures > 0 {
+		metrics.Add(newMetricData("SQSSendFailures", float64(failures), cwtypes.StandardUnitCount)...)
+	}
+	if dropped := n.queue.takeDropped(); dropped > 0 {
+		metrics.Add(newMetricData("DroppedSQSMessages", float64(dropped), cwtypes.StandardUnitCount)...)
+	}
+
+	for _, c := range n.states.observe(result) {
+		if !c.Changed {
+			continue
+		}
+
+		event := newWebhookEvent(c, result)
+		n.queue.push(c.State+" message for "+c.Endpoint, func(ctx context.Context) error {
+			err := n.send(ctx, event)
+			if err != nil {
+				n.mu.Lock()
+				n.failures++
+				n.mu.Unlock()
+			}
+			return err
+		})
+	}
+
+	return nil
+}
+
+// Shutdown delivers the pending notifications until ctx is done.
+func (n *SQSNotifier) Shutdown(ctx context.Context) error {
+	return n.queue.Shutdown(ctx)
+}
+
+func (n *SQSNotifier) send(ctx context.Context, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+
+	severity := "ok"
+	if event.State == "unhealthy" {
+		severity = "critical"
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(n.queueURL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"cluster":  {DataType: aws.String("String"), StringValue: aws.String(event.Cluster)},
+			"severity": {DataType: aws.String("String"), StringValue: aws.String(severity)},
+		},
+	}
+	if n.fifo {
+		input.MessageGroupId = aws.String(event.Cluster)
+		input.MessageDeduplicationId = aws.String(sqsDeduplicationID(event))
+	}
+	_, err = n.client.SendMessage(ctx, input)
+
+	return err
+}
+
+// sqsDeduplicationID identifies the message about a state change by the
+// cluster, the endpoint, the new state and the check interval it happened in.
+// Retries of a message, and the same message sent by several monitors of the
+// cluster, are delivered once.
+func sqsDeduplicationID(event webhookEvent) string {
+	bucket := event.Timestamp.Truncate(*interval).Unix()
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n%d", event.Cluster, event.Endpoint, event.State, bucket)))
+
+	return hex.EncodeToString(sum[:])
+}
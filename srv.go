@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resolveSRVEndpoints looks up the etcd client SRV records of domain the way
+// etcd's --discovery-srv does: _etcd-client-ssl for https and _etcd-client for
+// http endpoints, with the optional --discovery-srv-name suffix. The URLs use
+// the SRV target names, so the server certificates are verified against them.
+func resolveSRVEndpoints(domain, name string) ([]string, error) {
+	suffix := ""
+	if name != "" {
+		suffix = "-" + name
+	}
+
+	var endpoints []string
+	var errs []string
+	for _, srv := range []struct{ service, scheme string }{
+		{"etcd-client-ssl", "https"},
+		{"etcd-client", "http"},
+	} {
+		_, records, err := net.LookupSRV(srv.service+suffix, "tcp", domain)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, r := range records {
+			host := strings.TrimSuffix(r.Target, ".")
+			endpoints = append(endpoints, fmt.Sprintf("%s://%s", srv.scheme, net.JoinHostPort(host, strconv.Itoa(int(r.Port)))))
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no etcd client SRV records found for %s: %s", domain, strings.Join(errs, "; "))
+	}
+	sort.Strings(endpoints)
+
+	return endpoints, nil
+}
+
+// SRVDiscovery keeps the checked endpoints in line with the DNS SRV records
+// the cluster was bootstrapped with by resolving them every interval.
+type SRVDiscovery struct {
+	domain    string
+	name      string
+	interval  time.Duration
+	tlsConfig *tls.Config
+
+	// resolved is when the records were last resolved.
+	resolved time.Time
+}
+
+// NewSRVDiscovery returns a discovery for the endpoints already resolved at
+// startup. tlsConfig is used for the gRPC clients of new endpoints.
+func NewSRVDiscovery(domain, name string, interval time.Duration, tlsConfig *tls.Config) *SRVDiscovery {
+	return &SRVDiscovery{
+		domain:    domain,
+		name:      name,
+		interval:  interval,
+		tlsConfig: tlsConfig,
+		resolved:  time.Now(),
+	}
+}
+
+// Run re-resolves the records if the interval has passed. A failed resolution
+// keeps the last known endpoints.
+func (d *SRVDiscovery) Run() {
+	if time.Since(d.resolved) < d.interval {
+		return
+	}
+	d.resolved = time.Now()
+
+	discovered, err := resolveSRVEndpoints(d.domain, d.name)
+	if err != nil {
+		log.Printf("[WARN] Failed to resolve the etcd SRV records, keeping the last known endpoints: %s", err)
+		return
+	}
+
+	setEndpoints(discovered, d.tlsConfig)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+)
+
+// SSMAPI is the part of the SSM API used by the monitor.
+type SSMAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// newSSMClient returns the client the TLS material is fetched with.
+func newSSMClient(cfg aws.Config) *ssm.Client {
+	return ssm.NewFromConfig(cfg)
+}
+
+// ssmSource is PEM content held in an SSM parameter, usually a SecureString.
+// It is only kept in memory, never written to disk.
+type ssmSource struct {
+	client SSMAPI
+	name   string
+
+	mu      sync.Mutex
+	data    []byte
+	version int64
+}
+
+// newSSMSource fetches the parameter name, a name or ARN.
+func newSSMSource(ctx context.Context, client SSMAPI, name string) (*ssmSource, error) {
+	s := &ssmSource{client: client, name: name}
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// refresh fetches the parameter again, decrypted. If it cannot be, the
+// previous content is kept.
+func (s *ssmSource) refresh(ctx context.Context) error {
+	out, err := s.client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(s.name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return describeSSMError(s.String(), err)
+	}
+	if out.Parameter == nil {
+		return fmt.Errorf("%s returned no value", s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data, s.version = []byte(aws.ToString(out.Parameter.Value)), out.Parameter.Version
+
+	return nil
+}
+
+func (s *ssmSource) read() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.data, nil
+}
+
+// stamp returns the version of the parameter last fetched.
+func (s *ssmSource) stamp() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return strconv.FormatInt(s.version, 10)
+}
+
+func (s *ssmSource) String() string {
+	return "SSM parameter " + s.name
+}
+
+// describeSSMError returns err of fetching param with a hint on what to fix,
+// telling a missing parameter, a denied GetParameter and a failed KMS
+// decryption apart.
+func describeSSMError(param string, err error) error {
+	var notFound *types.ParameterNotFound
+	var versionNotFound *types.ParameterVersionNotFound
+	var invalidKey *types.InvalidKeyId
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &notFound), errors.As(err, &versionNotFound):
+		return fmt.Errorf("%s not found, check its name and the region: %s", param, err)
+	case errors.As(err, &invalidKey):
+		return fmt.Errorf("%s cannot be decrypted, its KMS key is invalid or disabled: %s", param, err)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException" &&
+		strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "kms"):
+		return fmt.Errorf("%s cannot be decrypted, the monitor needs kms:Decrypt on its KMS key: %s", param, err)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException":
+		return fmt.Errorf("access to %s denied, the monitor needs ssm:GetParameter on it: %s", param, err)
+	}
+
+	return fmt.Errorf("failed to fetch %s: %s", param, err)
+}
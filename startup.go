@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// healthStateStarting is the state of a cluster, or an endpoint, failing its
+// checks within the startup grace period: neither healthy nor unhealthy yet.
+const healthStateStarting = "starting"
+
+// The -startup-grace-mode values: how the failed checks within the startup
+// grace period are reported.
+const (
+	startupGraceModeStarting = "starting"
+	startupGraceModeSuppress = "suppress"
+)
+
+// startupGrace is the startup grace period, during which a node booting with
+// the monitor started before etcd joined the cluster does not report it
+// unhealthy. It ends when it expires or at the first check that passes, and
+// never starts again.
+type startupGrace struct {
+	until time.Time
+	over  bool
+}
+
+// observe reports whether a check started at start that passed or failed is
+// within the grace period, logging the end of it. A nil grace period is over.
+func (g *startupGrace) observe(start time.Time, passed bool) bool {
+	if g == nil || g.over {
+		return false
+	}
+
+	switch {
+	case passed:
+		log.Printf("[INFO] etcd passed a check, the startup grace period is over")
+	case !start.Before(g.until):
+		log.Printf("[INFO] The startup grace period is over, failed checks are reported as unhealthy again")
+	default:
+		log.Printf("[INFO] Within the startup grace period until %s, reporting the failed check as starting", g.until.Format(time.RFC3339))
+		return true
+	}
+	g.over = true
+
+	return false
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// stateFileDocument is the content of the state file. Other agents on the
+// host parse it, so fields are only ever added.
+type stateFileDocument struct {
+	// State is "running", "paused" while paused with SIGUSR1, or "stopped"
+	// after a clean exit.
+	State   string `json:"state"`
+	PID     int    `json:"pid"`
+	Cluster string `json:"cluster"`
+	Healthy bool   `json:"healthy"`
+	// Timestamp is the time of the check. A consumer can tell that the
+	// monitor hangs or died when it is more than a few CheckInterval old.
+	Timestamp           time.Time `json:"timestamp"`
+	CheckInterval       float64   `json:"checkIntervalSeconds"`
+	UnhealthyCount      float64   `json:"unhealthyCount"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	// LatencyMs is the latency of the slowest endpoint.
+	LatencyMs   float64          `json:"latencyMs"`
+	LastError   string           `json:"lastError,omitempty"`
+	LastErrorAt *time.Time       `json:"lastErrorAt,omitempty"`
+	Endpoints   []EndpointStatus `json:"endpoints"`
+}
+
+// StateFile rewrites a JSON file with the result of the last check after every
+// check, for local consumers such as node-problem-detector. The file is
+// replaced by a rename, so that readers only ever see complete documents.
+type StateFile struct {
+	path string
+	mode os.FileMode
+
+	mu       sync.Mutex
+	doc      stateFileDocument
+	stopped  bool
+	failures int
+}
+
+// NewStateFile returns a state file at path, created with mode. The directory
+// is created if needed.
+func NewStateFile(path string, mode os.FileMode) (*StateFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the state file directory: %s", err)
+	}
+
+	return &StateFile{path: path, mode: mode}, nil
+}
+
+// newStateFileReporter returns the sink of -state-file, with the permissions of
+// -state-file-mode.
+func newStateFileReporter() (Reporter, error) {
+	mode, err := parseFileMode(*stateFileMode)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := NewStateFile(*stateFile, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return sink, nil
+}
+
+// Report writes the result of a check.
+func (s *StateFile) Report(ctx context.Context, result CheckResult) error {
+	return s.report(result, "running")
+}
+
+// ReportPaused writes the result of a check made while paused.
+func (s *StateFile) ReportPaused(ctx context.Context, result CheckResult) error {
+	return s.report(result, "paused")
+}
+
+// report writes the result of a check, with the state of the monitor.
+func (s *StateFile) report(result CheckResult, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return nil
+	}
+	if result.UnhealthyCount > 0 {
+		s.failures++
+	} else {
+		s.failures = 0
+	}
+
+	doc := stateFileDocument{
+		State:               state,
+		PID:                 os.Getpid(),
+		Cluster:             *etcdName,
+		Healthy:             result.UnhealthyCount == 0,
+		Timestamp:           result.Time,
+		CheckInterval:       interval.Seconds(),
+		UnhealthyCount:      result.UnhealthyCount,
+		ConsecutiveFailures: s.failures,
+		LastError:           s.doc.LastError,
+		LastErrorAt:         s.doc.LastErrorAt,
+		Endpoints:           result.Endpoints,
+	}
+	for _, e := range result.Endpoints {
+		if e.LatencyMs > doc.LatencyMs {
+			doc.LatencyMs = e.LatencyMs
+		}
+		if e.Error != "" {
+			doc.LastError = normalizeEndpoint(e.Endpoint) + ": " + e.Error
+			doc.LastErrorAt = &result.Time
+		}
+	}
+	if doc.Endpoints == nil {
+		doc.Endpoints = []EndpointStatus{}
+	}
+	s.doc = doc
+
+	return s.write()
+}
+
+// Shutdown marks the file as stopped, so that consumers do not mistake the
+// last result for a hanging monitor.
+func (s *StateFile) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	s.doc.State = "stopped"
+	s.doc.PID = os.Getpid()
+	s.doc.Cluster = *etcdName
+	if s.doc.Endpoints == nil {
+		s.doc.Endpoints = []EndpointStatus{}
+	}
+
+	return s.write()
+}
+
+// write replaces the file with the current document. The document is written
+// to a temporary file in the same directory, synced and renamed over the
+// file, which is atomic on POSIX file systems.
+func (s *StateFile) write() error {
+	body, err := json.MarshalIndent(s.doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(body, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(s.mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// parseFileMode parses an octal file mode such as 0644.
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q, expected octal permissions such as 0644", s)
+	}
+
+	return os.FileMode(mode), nil
+}
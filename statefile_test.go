@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStateFileConcurrentReaders(t *testing.T) {
+	setFlags(t, "-name", "etcd-a")
+	// A temporary file anywhere but next to the state file could not be
+	// renamed over it atomically, so there must be no need for TMPDIR.
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	dir := filepath.Join(t.TempDir(), "etcd-monitor")
+	path := filepath.Join(dir, "status.json")
+	sink, err := NewStateFile(path, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writes = 200
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				buff, err := ioutil.ReadFile(path)
+				if os.IsNotExist(err) && last == 0 {
+					continue
+				}
+				if err != nil {
+					fail(err)
+					return
+				}
+				var doc stateFileDocument
+				if err := json.Unmarshal(buff, &doc); err != nil {
+					fail(err)
+					return
+				}
+				if doc.ConsecutiveFailures < last {
+					fail(fmt.Errorf("read %d failures after %d, an older document", doc.ConsecutiveFailures, last))
+					return
+				}
+				last = doc.ConsecutiveFailures
+			}
+		}()
+	}
+
+	result := testEndpointsResult()
+	result.UnhealthyCount = 1
+	for i := 0; i < writes; i++ {
+		if err := sink.Report(context.Background(), result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		t.Fatalf("a reader saw an incomplete or stale document: %s", err)
+	default:
+	}
+
+	var doc stateFileDocument
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buff, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.ConsecutiveFailures != writes || doc.State != "running" || doc.Cluster != "etcd-a" {
+		t.Errorf("the last document is %+v, want the running etcd-a with %d failures", doc, writes)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "status.json" {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("the directory holds %v, want only status.json", names)
+	}
+	if mode := files[0].Mode().Perm(); mode != 0640 {
+		t.Errorf("the state file has mode %o, want 0640", mode)
+	}
+}
+
+func TestStateFileShutdown(t *testing.T) {
+	setFlags(t, "-name", "etcd-a")
+	path := filepath.Join(t.TempDir(), "status.json")
+	sink, err := NewStateFile(path, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Report(context.Background(), testEndpointsResult()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// A check still running during the shutdown does not revive the file.
+	if err := sink.Report(context.Background(), testEndpointsResult()); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc stateFileDocument
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buff, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.State != "stopped" {
+		t.Errorf("the state is %q after the shutdown, want stopped", doc.State)
+	}
+}
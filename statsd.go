@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// maxStatsDPacket keeps StatsD packets below the usual MTU so that they
+	// are not fragmented.
+	maxStatsDPacket = 1432
+
+	// sinkErrorInterval is how often the errors of a sink are logged at most.
+	sinkErrorInterval = time.Minute
+)
+
+// StatsDSink sends the health check results to a StatsD daemon over UDP, as
+// gauges and timers below a metric prefix.
+type StatsDSink struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsDSink returns a sink sending to the StatsD daemon at address
+// (host:port) with metric names starting with prefix.
+func NewStatsDSink(address, prefix string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsDSink{
+		conn:   conn,
+		prefix: strings.TrimSuffix(prefix, "."),
+	}, nil
+}
+
+// newStatsDReporter returns the sink of -statsd-address.
+func newStatsDReporter() (Reporter, error) {
+	sink, err := NewStatsDSink(*statsdAddress, *statsdPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -statsd-address: %s", err)
+	}
+
+	return sink, nil
+}
+
+// Report sends the result of a check:
+//
+//	<prefix>.<cluster>.unhealthy (gauge)
+//	<prefix>.<cluster>.<endpoint>.unhealthy (gauge)
+//	<prefix>.<cluster>.<endpoint>.check_latency (timer, ms)
+func (s *StatsDSink) Report(ctx context.Context, result CheckResult) error {
+	base := s.prefix + "." + sanitizeMetricPath(*etcdName)
+
+	lines := []string{fmt.Sprintf("%s.unhealthy:%g|g", base, result.UnhealthyCount)}
+	for _, e := range result.Endpoints {
+		path := base + "." + sanitizeMetricPath(normalizeEndpoint(e.Endpoint))
+		unhealthy := 0
+		if !e.Healthy {
+			unhealthy = 1
+		}
+		lines = append(lines,
+			fmt.Sprintf("%s.unhealthy:%d|g", path, unhealthy),
+			fmt.Sprintf("%s.check_latency:%g|ms", path, e.LatencyMs))
+	}
+
+	return s.send(lines)
+}
+
+// send writes lines in as few packets as possible. StatsD is fire-and-forget,
+// so a failed packet does not stop the others; the first error is returned.
+func (s *StatsDSink) send(lines []string) error {
+	var firstErr error
+	for _, packet := range packLines(lines, maxStatsDPacket) {
+		if _, err := s.conn.Write([]byte(packet)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// packLines joins lines with newlines into packets of at most size bytes. A
+// line longer than size gets a packet of its own.
+func packLines(lines []string, size int) []string {
+	var packets []string
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > size {
+			packets = append(packets, packet.String())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.String())
+	}
+
+	return packets
+}
+
+// sanitizeMetricPath makes s usable as a single component of a dotted metric
+// path by replacing the characters StatsD and Graphite treat specially.
+func sanitizeMetricPath(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ' ', ':', '|', '@', '/', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// sinkErrorLog logs the errors of a reporter at most once per
+// sinkErrorInterval, so that an unreachable daemon does not flood the log.
+type sinkErrorLog struct {
+	name string
+
+	last       time.Time
+	suppressed int
+}
+
+func (l *sinkErrorLog) log(err error) {
+	if time.Since(l.last) < sinkErrorInterval {
+		l.suppressed++
+		return
+	}
+
+	if l.suppressed > 0 {
+		log.Printf("[ERROR] Failed to send to %s: %s (%d more errors since the last message)", l.name, err, l.suppressed)
+	} else {
+		log.Printf("[ERROR] Failed to send to %s: %s", l.name, err)
+	}
+	l.last, l.suppressed = time.Now(), 0
+}
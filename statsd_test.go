@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenUDP returns a UDP socket on the loopback interface, closed when the
+// test ends.
+func listenUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// readDatagrams reads the datagrams sent to conn until none arrives for a
+// while.
+func readDatagrams(t *testing.T, conn *net.UDPConn) []string {
+	t.Helper()
+
+	var datagrams []string
+	buf := make([]byte, 64*1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return datagrams
+			}
+			t.Fatal(err)
+		}
+		datagrams = append(datagrams, string(buf[:n]))
+	}
+}
+
+// testEndpointsResult returns the result of a check of a healthy and a failed
+// endpoint, the cluster still healthy.
+func testEndpointsResult() CheckResult {
+	return CheckResult{
+		Time:  time.Now(),
+		State: healthStateHealthy,
+		Endpoints: []EndpointStatus{
+			{Endpoint: "https://etcd-0:2379", Healthy: true, State: healthStateHealthy, LatencyMs: 12.5},
+			{Endpoint: "https://etcd-1:2379", Healthy: false, State: healthStateUnhealthy, LatencyMs: 5000,
+				Error: "Failed to connect", ErrorType: errorTypeTimeout},
+		},
+	}
+}
+
+func TestStatsDDatagrams(t *testing.T) {
+	setFlags(t, "-name", "etcd-a")
+	listener := listenUDP(t)
+
+	sink, err := NewStatsDSink(listener.LocalAddr().String(), "etcd.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Report(context.Background(), testEndpointsResult()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "etcd.etcd-a.unhealthy:0|g\n" +
+		"etcd.etcd-a.etcd-0_2379.unhealthy:0|g\n" +
+		"etcd.etcd-a.etcd-0_2379.check_latency:12.5|ms\n" +
+		"etcd.etcd-a.etcd-1_2379.unhealthy:1|g\n" +
+		"etcd.etcd-a.etcd-1_2379.check_latency:5000|ms"
+	datagrams := readDatagrams(t, listener)
+	if len(datagrams) != 1 || datagrams[0] != want {
+		t.Errorf("got datagrams %q, want %q", datagrams, want)
+	}
+}
+
+func TestStatsDPackets(t *testing.T) {
+	setFlags(t, "-name", "etcd-a")
+	listener := listenUDP(t)
+
+	sink, err := NewStatsDSink(listener.LocalAddr().String(), "etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := CheckResult{Time: time.Now(), State: healthStateHealthy}
+	for i := 0; i < 60; i++ {
+		result.Endpoints = append(result.Endpoints, EndpointStatus{Endpoint: "https://10.0.0.1:2379", Healthy: true})
+	}
+	if err := sink.Report(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+
+	datagrams := readDatagrams(t, listener)
+	if len(datagrams) < 2 {
+		t.Errorf("got %d datagrams, want the lines split over several", len(datagrams))
+	}
+	for _, d := range datagrams {
+		if len(d) > maxStatsDPacket {
+			t.Errorf("got a datagram of %d bytes, over the %d bytes limit", len(d), maxStatsDPacket)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// StatusResponse is the subset of the etcd v3 maintenance status the monitor
+// uses. The gRPC gateway encodes 64-bit integers as strings.
+type StatusResponse struct {
+	Header struct {
+		MemberID uint64 `json:"member_id,string"`
+		Revision int64  `json:"revision,string"`
+	} `json:"header"`
+	Version   string `json:"version"`
+	DBSize    int64  `json:"dbSize,string"`
+	Leader    uint64 `json:"leader,string"`
+	RaftIndex uint64 `json:"raftIndex,string"`
+	RaftTerm  uint64 `json:"raftTerm,string"`
+
+	RaftAppliedIndex uint64 `json:"raftAppliedIndex,string"`
+	// DBSizeInUse is nil for etcd versions before 3.4, which do not report
+	// it.
+	DBSizeInUse *int64 `json:"dbSizeInUse,string"`
+}
+
+// Probe is a check run after the health check every interval. Probes publish
+// their own metrics and report their own failures; they never affect the
+// unhealthy count.
+type Probe interface {
+	Run()
+}
+
+// newProbes returns the probes enabled by the flags, the ones querying a
+// single member querying the first endpoint. The peer probe connects with
+// peerTLSConfig.
+func newProbes(peerTLSConfig *tls.Config, peerFiles *tlsFiles) ([]Probe, error) {
+	// The probes reading the Prometheus metrics use the scrape URL if set.
+	metricsURL := *scrapeMetricsURL
+	if metricsURL == "" {
+		metricsURL = endpoints[0] + "/metrics"
+	}
+
+	probes := []Probe{NewCertExpiryProbe(clientTLS, *tlsExpiryWarnDays)}
+	if *enableStatusProbe {
+		probe := NewStatusProbe(endpoints[0], *leaderMissingThreshold)
+		probe.SetQuota(*backendQuotaBytes, metricsURL, *dbQuotaWarnPercent)
+		probes = append(probes, probe, NewFragmentationProbe(*defragWarnPercent))
+	}
+	if *enableLeaderProbe {
+		probes = append(probes, NewLeaderProbe(*leaderProbeTimeout))
+	}
+	if *enableMemberProbe {
+		probes = append(probes, NewMemberProbe(endpoints[0], *expectedMembers))
+	}
+	if *enableEtcdAlarmProbe {
+		probes = append(probes, NewEtcdAlarmProbe(endpoints[0]))
+	}
+	if *enableVersionProbe {
+		probes = append(probes, NewVersionProbe(endpoints[0], *strictVersionCheck))
+	}
+	if *enableVersionInfo {
+		probes = append(probes, NewVersionInfoProbe(*versionInfoEvery))
+	}
+	if *enableRevisionLagProbe {
+		probes = append(probes, NewRevisionLagProbe(endpoints[0], int64(*revisionLagThreshold), int64(*revisionLagTolerance)))
+	}
+	if *enableAppliedLagProbe {
+		probes = append(probes, NewAppliedIndexLagProbe(uint64(*appliedLagTolerance), uint64(*appliedLagThreshold), *appliedLagIntervals))
+	}
+	if *checkPeerURLs {
+		probes = append(probes, NewPeerProbe(endpoints[0], peerTLSConfig, peerFiles))
+	}
+	if *enableReadProbe {
+		probe, err := NewReadProbe(endpoints[0], *readProbeKey, *readProbeAPI, *readProbeConsistency, *readProbeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		probes = append(probes, probe)
+	}
+	if *enableWriteProbe {
+		probe, err := NewWriteProbe(endpoints[0], *writeProbeKey, *writeProbeAPI, *writeProbeTTL, *writeProbeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		probes = append(probes, probe)
+	}
+	if *scrapeMetricsURL != "" {
+		probes = append(probes, NewScrapeProbe(*scrapeMetricsURL, endpoints[0]))
+	}
+	if len(forwardMetrics.values) > 0 {
+		probes = append(probes, NewForwardProbe(metricsURL, forwardMetrics.values))
+	}
+
+	return probes, nil
+}
+
+// StatusProbe publishes the metrics derived from the maintenance status of an
+// etcd member and keeps the state needed to compare consecutive checks.
+type StatusProbe struct {
+	endpoint string
+
+	// leader is the leader ID seen by the previous successful check. It is
+	// only meaningful once haveLeader is set, so that the first check after
+	// a restart is not counted as a leader change.
+	leader     uint64
+	haveLeader bool
+
+	// raftIndex is the raft index seen by the previous check. It is
+	// forgotten when a check fails so that the delta is only ever computed
+	// between consecutive checks.
+	raftIndex     uint64
+	haveRaftIndex bool
+
+	// leaderMissingSince is when the member was first seen without a
+	// leader, or zero while it has one. missingThreshold is how long that
+	// may last before a warning is logged, once per leaderless period.
+	leaderMissingSince time.Time
+	missingThreshold   time.Duration
+	missingWarned      bool
+
+	// quotaBytes is the backend quota, or 0 to read it from quotaURL every
+	// check. quotaWarned is set once an undeterminable quota was logged.
+	quotaBytes  int64
+	quotaURL    string
+	warnPercent float64
+	quotaWarned bool
+}
+
+// NewStatusProbe returns a probe for the etcd member at endpoint. A warning is
+// logged when the member has been without a leader for longer than
+// missingThreshold.
+func NewStatusProbe(endpoint string, missingThreshold time.Duration) *StatusProbe {
+	return &StatusProbe{
+		endpoint:         endpoint,
+		missingThreshold: missingThreshold,
+	}
+}
+
+// SetQuota configures the backend quota DBQuotaUtilizationPercent is computed
+// from. If quotaBytes is 0, the quota is read from the Prometheus metrics at
+// metricsURL. A warning is logged above warnPercent.
+func (p *StatusProbe) SetQuota(quotaBytes int64, metricsURL string, warnPercent float64) {
+	p.quotaBytes = quotaBytes
+	p.quotaURL = metricsURL
+	p.warnPercent = warnPercent
+}
+
+// getStatus queries the maintenance status of the etcd member at endpoint
+// through the v3 gRPC gateway.
+func getStatus(endpoint string) (*StatusResponse, error) {
+	return getStatusContext(context.Background(), endpoint)
+}
+
+// getStatusContext is like getStatus, but gives up once ctx is done.
+func getStatusContext(ctx context.Context, endpoint string) (*StatusResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v3/maintenance/status", endpoint), strings.NewReader("{}"))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(buff)))
+	}
+
+	var status StatusResponse
+	if err := json.Unmarshal(buff, &status); err != nil {
+		return nil, fmt.Errorf("invalid status response payload: %s", err)
+	}
+
+	return &status, nil
+}
+
+// Run queries the maintenance status and publishes the metrics derived from
+// it. A failure is reported on its own and does not make the member unhealthy.
+func (p *StatusProbe) Run() {
+	status, err := getStatus(p.endpoint)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get etcd status: %s", err)
+		metrics.Add(newEndpointMetricData(p.endpoint, "StatusProbeFailures", 1, types.StandardUnitCount)...)
+		p.haveRaftIndex = false
+		p.observeLeaderMissing(false)
+		return
+	}
+
+	metrics.Add(newEndpointMetricData(p.endpoint, "DBSizeBytes", float64(status.DBSize), types.StandardUnitBytes)...)
+	p.reportQuotaUtilization(status.DBSize)
+
+	// One datapoint per check, so the Sum statistic of a publish window is
+	// the number of changes observed in it.
+	metrics.Add(newEndpointMetricData(p.endpoint, "LeaderChanges", float64(p.observeLeader(status.Leader)), types.StandardUnitCount)...)
+	p.observeLeaderMissing(status.Leader != 0)
+
+	metrics.Add(newEndpointMetricData(p.endpoint, "RaftTerm", float64(status.RaftTerm), types.StandardUnitCount)...)
+	metrics.Add(newEndpointMetricData(p.endpoint, "RaftIndex", float64(status.RaftIndex), types.StandardUnitCount)...)
+	if delta, ok := p.observeRaftIndex(status.RaftIndex); ok {
+		metrics.Add(newEndpointMetricData(p.endpoint, "RaftIndexDelta", float64(delta), types.StandardUnitCount)...)
+	}
+}
+
+// reportQuotaUtilization publishes DBQuotaUtilizationPercent for a database
+// of dbSize bytes. It is skipped if the quota cannot be determined, as 0 would
+// look like a healthy cluster.
+func (p *StatusProbe) reportQuotaUtilization(dbSize int64) {
+	quota := p.quotaBytes
+	if quota == 0 {
+		var err error
+		quota, err = getBackendQuota(p.quotaURL)
+		if err != nil {
+			if !p.quotaWarned {
+				log.Printf("[WARN] Cannot determine the etcd backend quota, skipping DBQuotaUtilizationPercent: %s", err)
+				p.quotaWarned = true
+			}
+			return
+		}
+		p.quotaWarned = false
+	}
+
+	percent := float64(dbSize) / float64(quota) * 100
+	switch {
+	case percent > 95:
+		log.Printf("[WARN] etcd database uses %.1f%% of its %d byte quota, NOSPACE is imminent; compact and defragment now",
+			percent, quota)
+	case percent > p.warnPercent:
+		log.Printf("[WARN] etcd database uses %.1f%% of its %d byte quota", percent, quota)
+	}
+
+	metrics.Add(newEndpointMetricData(p.endpoint, "DBQuotaUtilizationPercent", percent, types.StandardUnitPercent)...)
+}
+
+// getBackendQuota reads the backend quota from the etcd Prometheus metrics at
+// url.
+func getBackendQuota(url string) (int64, error) {
+	families, err := scrapeMetrics(url)
+	if err != nil {
+		return 0, err
+	}
+
+	family := families["etcd_server_quota_backend_bytes"]
+	if family == nil || len(family.GetMetric()) == 0 {
+		return 0, fmt.Errorf("etcd_server_quota_backend_bytes not found in %s", url)
+	}
+	quota := int64(family.GetMetric()[0].GetGauge().GetValue())
+	if quota <= 0 {
+		return 0, fmt.Errorf("invalid etcd_server_quota_backend_bytes %d in %s", quota, url)
+	}
+
+	return quota, nil
+}
+
+// observeLeaderMissing tracks for how long the member has been without a
+// leader and publishes LeaderMissingSeconds. A failed status call counts as
+// having no leader. The value is carried by every check, so the Maximum
+// statistic of a publish window is the longest leaderless period in it.
+func (p *StatusProbe) observeLeaderMissing(hasLeader bool) {
+	now := time.Now()
+	if hasLeader {
+		if !p.leaderMissingSince.IsZero() {
+			log.Printf("[INFO] etcd has a leader again after %s", now.Sub(p.leaderMissingSince).Round(time.Second))
+		}
+		p.leaderMissingSince = time.Time{}
+		p.missingWarned = false
+	} else if p.leaderMissingSince.IsZero() {
+		p.leaderMissingSince = now
+	}
+
+	var missing time.Duration
+	if !p.leaderMissingSince.IsZero() {
+		missing = now.Sub(p.leaderMissingSince)
+	}
+	if p.missingThreshold > 0 && missing > p.missingThreshold && !p.missingWarned {
+		log.Printf("[WARN] etcd has had no leader for %s", missing.Round(time.Second))
+		p.missingWarned = true
+	}
+
+	metrics.Add(newEndpointMetricData(p.endpoint, "LeaderMissingSeconds", missing.Seconds(), types.StandardUnitSeconds)...)
+}
+
+// observeRaftIndex records the raft index reported by the latest check and
+// returns how far it advanced since the previous check. ok is false if there
+// is no previous check to compare with or the index went backwards, e.g.
+// because the member was restored from a snapshot.
+func (p *StatusProbe) observeRaftIndex(index uint64) (delta uint64, ok bool) {
+	previous, known := p.raftIndex, p.haveRaftIndex
+	p.raftIndex, p.haveRaftIndex = index, true
+	if !known || index < previous {
+		return 0, false
+	}
+
+	return index - previous, true
+}
+
+// observeLeader records the leader ID reported by the latest check and
+// returns 1 if it differs from the previous one, 0 otherwise. Checks without
+// a leader are left to LeaderMissingSeconds, so losing and re-electing the same leader
+// is not a change.
+func (p *StatusProbe) observeLeader(leader uint64) int {
+	if leader == 0 {
+		return 0
+	}
+
+	previous, known := p.leader, p.haveLeader
+	p.leader, p.haveLeader = leader, true
+	if !known || previous == leader {
+		return 0
+	}
+
+	log.Printf("[INFO] etcd leader changed from %x to %x", previous, leader)
+
+	return 1
+}
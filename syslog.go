@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// syslogQueueSize bounds the messages waiting for the syslog daemon.
+	syslogQueueSize = 1000
+
+	// syslogTimeout bounds connecting to a remote syslog daemon and every
+	// write.
+	syslogTimeout = 2 * time.Second
+
+	// syslogRedialInterval is how long messages are dropped after the syslog
+	// daemon could not be reached, before connecting again.
+	syslogRedialInterval = 10 * time.Second
+
+	// syslogFacility is daemon, as for other system services.
+	syslogFacility = 3
+)
+
+// syslogMessage is a message waiting to be written. severity is
+// syslog.LOG_ERR or LOG_INFO.
+type syslogMessage struct {
+	time     time.Time
+	severity syslog.Priority
+	msgID    string
+	text     string
+}
+
+// SyslogLogger writes health state changes and the errors logged by the
+// monitor to syslog, the local daemon or a remote one in RFC 5424 format.
+// Messages are written in the background and dropped while the daemon is
+// unreachable, so that syslog never delays or stops the checks.
+type SyslogLogger struct {
+	network string
+	address string
+	tag     string
+
+	queue    chan syslogMessage
+	detector transitionDetector
+
+	// Only used by the writing goroutine. local is the connection to the
+	// local daemon, conn the one to a remote daemon; both are nil while
+	// disconnected.
+	local    *syslog.Writer
+	conn     net.Conn
+	hostname string
+	lastDial time.Time
+	dropped  int
+}
+
+// NewSyslogLogger returns a logger writing to the local syslog daemon if
+// address is empty, or else to a remote one at udp://host:port or
+// tcp://host:port. tag is the application name of the messages. The daemon
+// does not need to be up yet.
+func NewSyslogLogger(address, tag string) (*SyslogLogger, error) {
+	// The tag is the APP-NAME of RFC 5424 messages.
+	if tag == "" || len(tag) > 48 || strings.IndexFunc(tag, func(r rune) bool { return r <= ' ' || r > '~' }) >= 0 {
+		return nil, fmt.Errorf("invalid syslog tag %q, expected up to 48 printable ASCII characters", tag)
+	}
+
+	l := &SyslogLogger{
+		tag:   tag,
+		queue: make(chan syslogMessage, syslogQueueSize),
+	}
+	if address != "" {
+		parts := strings.SplitN(address, "://", 2)
+		if len(parts) != 2 || parts[0] != "udp" && parts[0] != "tcp" {
+			return nil, fmt.Errorf("invalid syslog address %q, expected udp://host:port or tcp://host:port", address)
+		}
+		if _, _, err := net.SplitHostPort(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid syslog address %q: %s", address, err)
+		}
+		l.network, l.address = parts[0], parts[1]
+	}
+	l.hostname, _ = os.Hostname()
+	if l.hostname == "" {
+		l.hostname = "-"
+	}
+
+	go func() {
+		for m := range l.queue {
+			l.write(m)
+		}
+	}()
+
+	return l, nil
+}
+
+// newSyslogReporter returns the syslog logger of -syslog and -syslog-address,
+// which the log is written to in addition to stderr from now on.
+func newSyslogReporter() (Reporter, error) {
+	logger, err := NewSyslogLogger(*syslogAddress, *syslogTag)
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, logger))
+
+	return logger, nil
+}
+
+// Report writes a message when the cluster becomes unhealthy, with severity
+// err, and when it recovers, with severity info.
+func (l *SyslogLogger) Report(ctx context.Context, result CheckResult) error {
+	t, ok := l.detector.observe(result)
+	if !ok {
+		return nil
+	}
+
+	if t.Healthy {
+		l.send(result.Time, syslog.LOG_INFO, "recovered", fmt.Sprintf(
+			"etcd cluster %s recovered after being unhealthy for %s (%d failed checks)",
+			*etcdName, t.Time.Sub(t.Since).Round(time.Second), t.ConsecutiveFailures))
+	} else {
+		l.send(result.Time, syslog.LOG_ERR, "unhealthy", fmt.Sprintf(
+			"etcd cluster %s is unhealthy: %s", *etcdName, t.Reason()))
+	}
+
+	return nil
+}
+
+// Write implements io.Writer for the standard logger, forwarding the messages
+// logged as errors. It never fails, so that it can be used with
+// io.MultiWriter.
+func (l *SyslogLogger) Write(p []byte) (int, error) {
+	line := string(bytes.TrimRight(p, "\n"))
+	if i := strings.Index(line, "[ERROR] "); i >= 0 {
+		l.send(time.Now(), syslog.LOG_ERR, "error", line[i+len("[ERROR] "):])
+	}
+
+	return len(p), nil
+}
+
+// send queues a message, dropping it if the queue is full.
+func (l *SyslogLogger) send(t time.Time, severity syslog.Priority, msgID, text string) {
+	select {
+	case l.queue <- syslogMessage{time: t, severity: severity, msgID: msgID, text: text}:
+	default:
+	}
+}
+
+// write writes m, connecting first if needed. Failures are logged as
+// warnings, which are not forwarded to syslog.
+func (l *SyslogLogger) write(m syslogMessage) {
+	if err := l.connect(); err != nil {
+		l.dropped++
+		return
+	}
+
+	var err error
+	if l.network == "" {
+		err = l.writeLocal(m)
+	} else {
+		l.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		_, err = l.conn.Write(l.format(m))
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to write to syslog, dropping messages for %s: %s", syslogRedialInterval, err)
+		l.disconnect()
+		l.dropped++
+	}
+}
+
+// connect connects to the daemon unless connected already, or the last
+// attempt was less than syslogRedialInterval ago.
+func (l *SyslogLogger) connect() error {
+	if l.network == "tcp" && l.conn != nil && connClosed(l.conn) {
+		log.Printf("[WARN] The syslog daemon at %s closed the connection", l.address)
+		l.disconnect()
+	}
+	if l.local != nil || l.conn != nil {
+		return nil
+	}
+	if time.Since(l.lastDial) < syslogRedialInterval {
+		return fmt.Errorf("not connected")
+	}
+	l.lastDial = time.Now()
+
+	var err error
+	if l.network == "" {
+		l.local, err = syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, l.tag)
+	} else {
+		l.conn, err = net.DialTimeout(l.network, l.address, syslogTimeout)
+	}
+	if err != nil {
+		l.local, l.conn = nil, nil
+		log.Printf("[WARN] Failed to connect to syslog, dropping messages for %s: %s", syslogRedialInterval, err)
+		return err
+	}
+	if l.dropped > 0 {
+		log.Printf("[INFO] Connected to syslog, %d messages were dropped while disconnected", l.dropped)
+		l.dropped = 0
+	}
+
+	return nil
+}
+
+func (l *SyslogLogger) disconnect() {
+	if l.local != nil {
+		l.local.Close()
+		l.local = nil
+	}
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+func (l *SyslogLogger) writeLocal(m syslogMessage) error {
+	if m.severity == syslog.LOG_ERR {
+		return l.local.Err(m.text)
+	}
+
+	return l.local.Info(m.text)
+}
+
+// format renders m as an RFC 5424 message, with the octet counting framing
+// of RFC 6587 over TCP:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG
+func (l *SyslogLogger) format(m syslogMessage) []byte {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		syslogFacility*8+int(m.severity), m.time.UTC().Format(time.RFC3339Nano),
+		l.hostname, l.tag, os.Getpid(), m.msgID, m.text)
+	if l.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	return []byte(msg)
+}
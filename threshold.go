@@ -0,0 +1,63 @@
+package main
+
+// The states of the cluster and of every endpoint. Degraded is failing, but for
+// fewer than the failure threshold of consecutive checks, and still reported
+// as healthy.
+const (
+	healthStateHealthy   = "healthy"
+	healthStateDegraded  = "degraded"
+	healthStateUnhealthy = "unhealthy"
+)
+
+// healthThreshold debounces check results, so that a single failed or passed
+// check does not flip the reported state: it becomes unhealthy after
+// failureThreshold consecutive failed checks, and healthy again after
+// recoveryThreshold consecutive passed ones. With both thresholds 1 the state
+// follows every check.
+type healthThreshold struct {
+	failureThreshold  int
+	recoveryThreshold int
+
+	state     string
+	failures  int
+	successes int
+}
+
+func newHealthThreshold(failureThreshold, recoveryThreshold int) *healthThreshold {
+	return &healthThreshold{
+		failureThreshold:  failureThreshold,
+		recoveryThreshold: recoveryThreshold,
+		state:             healthStateHealthy,
+	}
+}
+
+// observe returns the state after a check that passed or failed.
+func (t *healthThreshold) observe(passed bool) string {
+	if passed {
+		t.failures = 0
+		t.successes++
+	} else {
+		t.successes = 0
+		t.failures++
+	}
+
+	switch {
+	case t.state == healthStateUnhealthy && t.successes >= t.recoveryThreshold:
+		t.state = healthStateHealthy
+	case t.state == healthStateUnhealthy:
+	case t.failures >= t.failureThreshold:
+		t.state = healthStateUnhealthy
+	case t.failures > 0:
+		t.state = healthStateDegraded
+	default:
+		t.state = healthStateHealthy
+	}
+
+	return t.state
+}
+
+// thresholdsEnabled reports whether the reported state lags behind the check
+// results, so that the raw results are worth publishing too.
+func thresholdsEnabled() bool {
+	return *failureThreshold > 1 || *recoveryThreshold > 1
+}
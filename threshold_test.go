@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCheckTransitions(t *testing.T) {
+	// checks are the results of the checks in a row, 10s apart, P passed and
+	// F failed. unhealthy is the UnhealthyCount reported for each of them, 0
+	// or 1, while RawUnhealthyCount follows the check alone. published tells
+	// for each whether CloudWatch was published to in the "changes" publish
+	// mode, y or -.
+	tests := []struct {
+		name        string
+		args        []string
+		grace       bool
+		maintenance bool
+		checks      string
+		states      []string
+		unhealthy   string
+		published   string
+	}{
+		{
+			name:      "healthy",
+			checks:    "PPP",
+			states:    []string{healthStateHealthy, healthStateHealthy, healthStateHealthy},
+			unhealthy: "000",
+			published: "y--",
+		},
+		{
+			name:      "failure and recovery",
+			checks:    "PFFP",
+			states:    []string{healthStateHealthy, healthStateUnhealthy, healthStateUnhealthy, healthStateHealthy},
+			unhealthy: "0110",
+			published: "yy-y",
+		},
+		{
+			name:      "failing from the start",
+			checks:    "FFP",
+			states:    []string{healthStateUnhealthy, healthStateUnhealthy, healthStateHealthy},
+			unhealthy: "110",
+			published: "y-y",
+		},
+		{
+			name:      "alternating",
+			checks:    "FPFP",
+			states:    []string{healthStateUnhealthy, healthStateHealthy, healthStateUnhealthy, healthStateHealthy},
+			unhealthy: "1010",
+			published: "yyyy",
+		},
+		{
+			name:      "heartbeat",
+			args:      []string{"-heartbeat-interval", "20s"},
+			checks:    "PPPPP",
+			states:    []string{healthStateHealthy, healthStateHealthy, healthStateHealthy, healthStateHealthy, healthStateHealthy},
+			unhealthy: "00000",
+			published: "y-y-y",
+		},
+		{
+			name:      "starting",
+			grace:     true,
+			checks:    "FFPF",
+			states:    []string{healthStateStarting, healthStateStarting, healthStateHealthy, healthStateUnhealthy},
+			unhealthy: "0001",
+			published: "yyyy",
+		},
+		{
+			name:      "failure threshold",
+			args:      []string{"-failure-threshold", "3", "-recovery-threshold", "2"},
+			checks:    "FFFFPPP",
+			states:    []string{healthStateDegraded, healthStateDegraded, healthStateUnhealthy, healthStateUnhealthy, healthStateUnhealthy, healthStateHealthy, healthStateHealthy},
+			unhealthy: "0011100",
+			published: "y-y--y-",
+		},
+		{
+			name:      "recovery threshold",
+			args:      []string{"-recovery-threshold", "3"},
+			checks:    "FPPP",
+			states:    []string{healthStateUnhealthy, healthStateUnhealthy, healthStateUnhealthy, healthStateHealthy},
+			unhealthy: "1110",
+			published: "y--y",
+		},
+		{
+			name:      "alternating below the failure threshold",
+			args:      []string{"-failure-threshold", "3", "-recovery-threshold", "2"},
+			checks:    "FPFPFP",
+			states:    []string{healthStateDegraded, healthStateHealthy, healthStateDegraded, healthStateHealthy, healthStateDegraded, healthStateHealthy},
+			unhealthy: "000000",
+			published: "y-----",
+		},
+		{
+			name:      "alternating below the recovery threshold",
+			args:      []string{"-failure-threshold", "3", "-recovery-threshold", "2"},
+			checks:    "FFFPFPP",
+			states:    []string{healthStateDegraded, healthStateDegraded, healthStateUnhealthy, healthStateUnhealthy, healthStateUnhealthy, healthStateUnhealthy, healthStateHealthy},
+			unhealthy: "0011110",
+			published: "y-y---y",
+		},
+		{
+			name:      "starting with thresholds",
+			args:      []string{"-failure-threshold", "3", "-recovery-threshold", "2"},
+			grace:     true,
+			checks:    "FFFPP",
+			states:    []string{healthStateStarting, healthStateStarting, healthStateStarting, healthStateUnhealthy, healthStateHealthy},
+			unhealthy: "00010",
+			published: "yyyyy",
+		},
+		{
+			name:        "maintenance",
+			maintenance: true,
+			checks:      "FPF",
+			states:      []string{healthStateMaintenance, healthStateMaintenance, healthStateMaintenance},
+			unhealthy:   "000",
+			published:   "y--",
+		},
+		{
+			name:        "maintenance metric",
+			args:        []string{"-maintenance-report", "metric"},
+			maintenance: true,
+			checks:      "FPF",
+			states:      []string{healthStateMaintenance, healthStateMaintenance, healthStateMaintenance},
+			unhealthy:   "000",
+			published:   "yyy",
+		},
+		{
+			name:        "maintenance with thresholds",
+			args:        []string{"-failure-threshold", "3", "-recovery-threshold", "2"},
+			maintenance: true,
+			checks:      "FFFF",
+			states:      []string{healthStateMaintenance, healthStateMaintenance, healthStateMaintenance, healthStateMaintenance},
+			unhealthy:   "0000",
+			published:   "y---",
+		},
+	}
+
+	defer func(saved []string) { endpoints = saved }(endpoints)
+	endpoints = []string{"http://etcd-0:2379"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t, append([]string{"-publish-mode", "changes", "-heartbeat-interval", "1h"}, test.args...)...)
+			start := time.Now()
+
+			state := newMonitorState()
+			if test.grace {
+				state.setStartupGrace(start.Add(time.Hour))
+			}
+			if test.maintenance {
+				state.setMaintenance(&maintenanceFile{windows: []maintenanceWindow{
+					{name: "upgrade", start: start, end: start.Add(time.Hour)},
+				}})
+			}
+			client := &fakePublisher{}
+			batch := NewMetricBatch(client, "etcd", 0)
+			reporter := NewCloudWatchReporter(batch)
+
+			for i, c := range test.checks {
+				passed := c == 'P'
+				count := 0.0
+				if !passed {
+					count = 1
+				}
+				result := state.observeCheck(start.Add(time.Duration(i)*10*time.Second), []bool{passed}, count)
+				if result.State != test.states[i] {
+					t.Errorf("check %d: State = %s, want %s", i+1, result.State, test.states[i])
+				}
+				if unhealthy := float64(test.unhealthy[i] - '0'); result.UnhealthyCount != unhealthy {
+					t.Errorf("check %d: UnhealthyCount = %g, want %g", i+1, result.UnhealthyCount, unhealthy)
+				}
+				if result.RawUnhealthyCount != count {
+					t.Errorf("check %d: RawUnhealthyCount = %g, want %g", i+1, result.RawUnhealthyCount, count)
+				}
+
+				calls := len(client.published())
+				if err := reporter.Report(context.Background(), result); err != nil {
+					t.Fatal(err)
+				}
+				batch.Flush(context.Background())
+				published := len(client.published()) > calls
+				if published != (test.published[i] == 'y') {
+					t.Errorf("check %d: published = %t, want %t", i+1, published, !published)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// The -tls-min-version values.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsPolicy is the minimum TLS version and the cipher suites the connections
+// to etcd are restricted to, e.g. for a security baseline. Zero values leave
+// the Go defaults.
+type tlsPolicy struct {
+	minVersion       string
+	cipherSuites     []uint16
+	cipherSuiteNames []string
+}
+
+// parseTLSPolicy parses -tls-min-version and the comma separated IANA names of
+// -tls-cipher-suites, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Unknown
+// and insecure cipher suites are an error. The cipher suites of TLS 1.3 are
+// not configurable, selecting cipher suites is logged as ignored for it.
+func parseTLSPolicy(minVersion, cipherSuites string) (tlsPolicy, error) {
+	p := tlsPolicy{minVersion: minVersion}
+	if _, ok := tlsVersions[minVersion]; minVersion != "" && !ok {
+		return tlsPolicy{}, fmt.Errorf("invalid -tls-min-version %q, expected \"1.2\" or \"1.3\"", minVersion)
+	}
+
+	suites := map[string]*tls.CipherSuite{}
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = s
+	}
+	insecure := map[string]bool{}
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	for _, name := range strings.Split(cipherSuites, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		s, ok := suites[name]
+		switch {
+		case insecure[name]:
+			return tlsPolicy{}, fmt.Errorf("insecure cipher suite %s in -tls-cipher-suites", name)
+		case !ok:
+			return tlsPolicy{}, fmt.Errorf("unknown cipher suite %q in -tls-cipher-suites, expected an IANA name "+
+				"such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", name)
+		case !supportsTLS12(s):
+			return tlsPolicy{}, fmt.Errorf("cipher suite %s in -tls-cipher-suites is a TLS 1.3 one, "+
+				"those are not configurable", name)
+		}
+		p.cipherSuites = append(p.cipherSuites, s.ID)
+		p.cipherSuiteNames = append(p.cipherSuiteNames, s.Name)
+	}
+
+	switch {
+	case len(p.cipherSuites) > 0 && minVersion == "1.3":
+		log.Printf("[WARN] -tls-cipher-suites is ignored, the cipher suites of TLS 1.3 are not configurable")
+	case len(p.cipherSuites) > 0:
+		log.Printf("[WARN] -tls-cipher-suites only applies up to TLS 1.2, it is ignored for servers negotiating TLS 1.3")
+	}
+
+	return p, nil
+}
+
+// supportsTLS12 reports whether s can be used with TLS 1.2.
+func supportsTLS12(s *tls.CipherSuite) bool {
+	for _, v := range s.SupportedVersions {
+		if v == tls.VersionTLS12 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply restricts config to the policy. config may be nil.
+func (p tlsPolicy) apply(config *tls.Config) {
+	if config == nil {
+		return
+	}
+
+	if p.minVersion != "" {
+		config.MinVersion = tlsVersions[p.minVersion]
+	}
+	if len(p.cipherSuites) > 0 {
+		config.CipherSuites = p.cipherSuites
+	}
+}
+
+// String describes the policy for the banner.
+func (p tlsPolicy) String() string {
+	version := "minimum TLS 1.2 (default)"
+	if p.minVersion != "" {
+		version = "minimum TLS " + p.minVersion
+	}
+	if len(p.cipherSuiteNames) == 0 {
+		return version + ", default cipher suites"
+	}
+
+	return version + ", cipher suites " + strings.Join(p.cipherSuiteNames, ", ")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLSPolicyHandshake(t *testing.T) {
+	ca := newTestCA(t, "etcd-ca")
+	serverCert := ca.issue(t, "etcd-0", []string{"127.0.0.1"}, time.Now().Add(24*time.Hour))
+	caFile := writeTestFile(t, t.TempDir(), "ca.pem", ca.certPEM)
+
+	tests := []struct {
+		name   string
+		args   []string
+		server func(*tls.Config)
+		err    string
+	}{
+		{
+			name: "TLS 1.0 server",
+			server: func(c *tls.Config) {
+				c.MinVersion, c.MaxVersion = tls.VersionTLS10, tls.VersionTLS10
+			},
+			err: "protocol version",
+		},
+		{
+			name: "TLS 1.2 server",
+			server: func(c *tls.Config) {
+				c.MaxVersion = tls.VersionTLS12
+			},
+		},
+		{
+			name: "TLS 1.2 server below -tls-min-version",
+			args: []string{"-tls-min-version", "1.3"},
+			server: func(c *tls.Config) {
+				c.MaxVersion = tls.VersionTLS12
+			},
+			err: "protocol version",
+		},
+		{
+			name: "common cipher suite",
+			args: []string{"-tls-cipher-suites", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			server: func(c *tls.Config) {
+				c.MaxVersion = tls.VersionTLS12
+				c.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}
+			},
+		},
+		{
+			name: "no common cipher suite",
+			args: []string{"-tls-cipher-suites", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+			server: func(c *tls.Config) {
+				c.MaxVersion = tls.VersionTLS12
+				c.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}
+			},
+			err: "handshake failure",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t, test.args...)
+			captureLog(t)
+			server := startTLSServer(t, serverCert, nil, test.server)
+
+			tlsConfig, files, err := newTLSConfig(nil, nil, newFileSource(caFile), true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy, err := parseTLSPolicy(*tlsMinVersion, *tlsCipherSuites)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy.apply(tlsConfig)
+
+			_, err = getHealth(newTransport(tlsConfig, transportOptions{tlsFiles: files}), server.URL)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("the health request failed: %s", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("the health request returned %v, want an error with %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseTLSPolicyErrors(t *testing.T) {
+	tests := []struct {
+		minVersion, cipherSuites string
+		err                      string
+	}{
+		{"1.0", "", `invalid -tls-min-version "1.0"`},
+		{"", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_BOGUS", `unknown cipher suite "TLS_BOGUS"`},
+		{"", "TLS_RSA_WITH_RC4_128_SHA", "insecure cipher suite TLS_RSA_WITH_RC4_128_SHA"},
+		{"", "TLS_AES_128_GCM_SHA256", "is a TLS 1.3 one"},
+	}
+
+	for _, test := range tests {
+		_, err := parseTLSPolicy(test.minVersion, test.cipherSuites)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parseTLSPolicy(%q, %q) = %v, want an error with %q", test.minVersion, test.cipherSuites, err, test.err)
+		}
+	}
+}
+
+func TestUnknownCipherSuiteStartup(t *testing.T) {
+	cmd := monitorCommand("-address", "https://127.0.0.1:2379", "-no-cloudwatch", "-tls-cipher-suites", "TLS_BOGUS")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("the monitor started with an unknown cipher suite:\n%s", out)
+	}
+	if !strings.Contains(string(out), `unknown cipher suite "TLS_BOGUS" in -tls-cipher-suites`) {
+		t.Errorf("the monitor exited without naming the cipher suite:\n%s", out)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// transportOptions tunes the connection handling of the HTTP client talking to
+// etcd.
+type transportOptions struct {
+	// maxIdleConns is the number of idle connections kept, per endpoint and
+	// in total.
+	maxIdleConns        int
+	idleConnTimeout     time.Duration
+	tlsHandshakeTimeout time.Duration
+	// tcpKeepAlive is the interval of TCP keep-alive probes, 0 disables
+	// them.
+	tcpKeepAlive time.Duration
+	// noKeepAlive opens a fresh connection for every request, exercising
+	// the full connect path on every check.
+	noKeepAlive bool
+	// tlsFiles are reloaded for every new connection, nil for plain http.
+	tlsFiles *tlsFiles
+	// serverNames override the server names of the TLS handshakes, nil
+	// keeps the dialed hosts.
+	serverNames *serverNames
+}
+
+// newTransport returns the transport of the HTTP client talking to etcd.
+// tlsConfig may be nil for plain http clusters.
+func newTransport(tlsConfig *tls.Config, opts transportOptions) *http.Transport {
+	keepAlive := opts.tcpKeepAlive
+	if keepAlive == 0 {
+		keepAlive = -1
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: keepAlive,
+	}
+
+	tr := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        opts.maxIdleConns,
+		MaxIdleConnsPerHost: opts.maxIdleConns,
+		IdleConnTimeout:     opts.idleConnTimeout,
+		TLSHandshakeTimeout: opts.tlsHandshakeTimeout,
+		DisableKeepAlives:   opts.noKeepAlive,
+	}
+	if tlsConfig != nil && (opts.tlsFiles != nil || opts.serverNames != nil) {
+		tr.DialTLSContext = dialTLS(dialer, tlsConfig, opts)
+	}
+
+	return tr
+}
+
+// newHTTPClient returns the HTTP client talking to etcd through tr. etcd never
+// redirects, a redirect means the address points at something else, so it is
+// not followed.
+func newHTTPClient(tr http.RoundTripper, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: tr,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// dialTLS returns the DialTLSContext of a transport dialing with dialer and
+// handshaking with tlsConfig, the CA bundle of the TLS files currently in use
+// and the server name of the dialed address. TLSClientConfig has a single
+// server name for all hosts, so the handshake is made here.
+func dialTLS(dialer *net.Dialer, tlsConfig *tls.Config, opts transportOptions) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		c := opts.tlsFiles.withRootCAs(tlsConfig)
+		if c == tlsConfig {
+			c = tlsConfig.Clone()
+		}
+		if name := opts.serverNames.lookup(addr); name != "" {
+			c.ServerName = name
+		}
+		if c.ServerName == "" {
+			c.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		if opts.tlsHandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.tlsHandshakeTimeout)
+			defer cancel()
+		}
+		tlsConn := tls.Client(conn, c)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+}
+
+// connTrace records whether a request reused an idle connection.
+type connTrace struct {
+	reused   bool
+	idleTime time.Duration
+}
+
+// withConnTrace returns req with a trace filling in t once the request got a
+// connection.
+func withConnTrace(req *http.Request, t *connTrace) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.reused, t.idleTime = info.Reused, info.IdleTime
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
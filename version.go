@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// VersionResponse is the response of the /version endpoint.
+type VersionResponse struct {
+	Server  string `json:"etcdserver"`
+	Cluster string `json:"etcdcluster"`
+}
+
+// VersionProbe detects members running different etcd versions, e.g. one left
+// behind by a rolling upgrade.
+type VersionProbe struct {
+	endpoint string
+	strict   bool
+
+	// versions are the server versions by member name seen by the previous
+	// check, to log changes.
+	versions map[string]string
+}
+
+// NewVersionProbe returns a probe discovering the members through the etcd
+// member at endpoint. Unless strict is set, versions that only differ in the
+// patch level are considered equal.
+func NewVersionProbe(endpoint string, strict bool) *VersionProbe {
+	return &VersionProbe{
+		endpoint: endpoint,
+		strict:   strict,
+		versions: make(map[string]string),
+	}
+}
+
+// getVersion returns the versions reported by the etcd member at endpoint.
+func getVersion(endpoint string) (*VersionResponse, error) {
+	resp, err := client.Get(fmt.Sprintf("%s/version", endpoint))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(buff)))
+	}
+
+	return parseVersion(buff)
+}
+
+// parseVersion parses a /version response. Old servers answer with a bare
+// string like "etcd 2.0.13" instead of JSON; it is taken as the server version
+// with an unknown cluster version.
+func parseVersion(buff []byte) (*VersionResponse, error) {
+	var version VersionResponse
+	if err := json.Unmarshal(buff, &version); err == nil {
+		if version.Server == "" {
+			return nil, fmt.Errorf("version response payload has no etcdserver field: %s", strings.TrimSpace(string(buff)))
+		}
+		return &version, nil
+	}
+
+	var bare string
+	if err := json.Unmarshal(buff, &bare); err != nil {
+		bare = string(buff)
+	}
+	bare = strings.TrimPrefix(strings.TrimSpace(bare), "etcd ")
+	if bare == "" || strings.ContainsAny(bare, " {}<>") {
+		return nil, fmt.Errorf("invalid version response payload: %s", strings.TrimSpace(string(buff)))
+	}
+
+	return &VersionResponse{Server: bare}, nil
+}
+
+// Run queries the version of every member and publishes VersionSkew, 1 if
+// they differ and 0 otherwise. Members that cannot be reached are skipped.
+func (p *VersionProbe) Run() {
+	members, err := getMembers(p.endpoint)
+	if err != nil {
+		log.Printf("[ERROR] Failed to list etcd members for the version check: %s", err)
+		metrics.Add(newEndpointMetricData(p.endpoint, "VersionProbeFailures", 1, types.StandardUnitCount)...)
+		return
+	}
+
+	versions := make([]string, len(members))
+	queryMembers(members, func(i int, endpoint string) {
+		version, err := getVersion(endpoint)
+		if err != nil {
+			log.Printf("[WARN] Failed to get the version of %s: %s", endpoint, err)
+			return
+		}
+		versions[i] = version.Server
+	})
+
+	seen := make(map[string]bool)
+	var observed []string
+	for i, member := range members {
+		if versions[i] == "" {
+			continue
+		}
+		if p.versions[member.Name] != versions[i] {
+			log.Printf("[INFO] etcd member %s runs version %s", member.Name, versions[i])
+			p.versions[member.Name] = versions[i]
+		}
+		observed = append(observed, member.Name+"="+versions[i])
+		seen[p.compareVersion(versions[i])] = true
+	}
+	if len(observed) == 0 {
+		log.Printf("[WARN] No member reported its version")
+		return
+	}
+
+	skew := 0.0
+	if len(seen) > 1 {
+		log.Printf("[WARN] etcd members run different versions: %s", strings.Join(observed, ", "))
+		skew = 1.0
+	}
+	metrics.Add(newMetricData("VersionSkew", skew, types.StandardUnitNone)...)
+}
+
+// compareVersion returns the part of version that is compared between
+// members: all of it in strict mode, major.minor otherwise.
+func (p *VersionProbe) compareVersion(version string) string {
+	if p.strict {
+		return version
+	}
+
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+
+	return parts[0] + "." + parts[1]
+}
+
+// VersionInfoProbe publishes the etcd version of every checked endpoint as a
+// dimension, for a fleet-wide view of the versions running.
+type VersionInfoProbe struct {
+	every int
+
+	// checks counts the checks since the last run, which is due when it
+	// reaches every.
+	checks int
+	// versions are the versions by endpoint seen by the previous run, to
+	// only log changes.
+	versions map[string]VersionResponse
+}
+
+// NewVersionInfoProbe returns a probe querying the versions every checks.
+func NewVersionInfoProbe(every int) *VersionInfoProbe {
+	return &VersionInfoProbe{
+		every:    every,
+		checks:   every,
+		versions: make(map[string]VersionResponse),
+	}
+}
+
+// Run publishes EtcdVersionInfo, always 1, with an EtcdVersion dimension
+// holding the server version of each checked endpoint, so that the Sum
+// statistic is the number of endpoints running a version. Endpoints that
+// cannot be reached are skipped.
+func (p *VersionInfoProbe) Run() {
+	if p.checks++; p.checks < p.every {
+		return
+	}
+	p.checks = 0
+
+	current := endpoints
+	versions := make([]*VersionResponse, len(current))
+	queryEndpoints(current, func(i int, endpoint string) {
+		version, err := getVersion(endpoint)
+		if err != nil {
+			log.Printf("[WARN] Failed to get the version of %s: %s", endpoint, err)
+			return
+		}
+		versions[i] = version
+	})
+
+	for i, endpoint := range current {
+		version := versions[i]
+		if version == nil {
+			continue
+		}
+		if previous, ok := p.versions[endpoint]; !ok || previous != *version {
+			cluster := version.Cluster
+			if cluster == "" {
+				cluster = "unknown"
+			}
+			log.Printf("[INFO] etcd endpoint %s runs server version %s, cluster version %s", endpoint, version.Server, cluster)
+			p.versions[endpoint] = *version
+		}
+
+		metrics.Add(buildMetricData(clusterDimensionSets("EtcdVersion", version.Server), "EtcdVersionInfo", 1, types.StandardUnitNone, time.Now())...)
+	}
+}
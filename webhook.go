@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// webhookSignatureHeader holds the HMAC-SHA256 of the request body as
+// "sha256=<hex>", if a secret is configured.
+const webhookSignatureHeader = "X-Etcd-Monitor-Signature"
+
+// webhookEvent is the document posted for an endpoint. Receivers parse it, so
+// fields are only ever added.
+type webhookEvent struct {
+	Cluster string `json:"cluster"`
+	// Endpoint is the checked endpoint, in host:port form.
+	Endpoint string `json:"endpoint"`
+	// PreviousState is "healthy", "unhealthy" or "unknown" before the
+	// first check.
+	PreviousState       string    `json:"previous_state"`
+	State               string    `json:"state"`
+	Timestamp           time.Time `json:"timestamp"`
+	LatencyMs           float64   `json:"latency_ms"`
+	Error               string    `json:"error"`
+	ErrorType           string    `json:"error_type"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	ClusterHealthy      bool      `json:"cluster_healthy"`
+	MonitorVersion      string    `json:"monitor_version"`
+}
+
+// newWebhookEvent returns the document about the endpoint of c, observed in
+// result.
+func newWebhookEvent(c endpointChange, result CheckResult) webhookEvent {
+	return webhookEvent{
+		Cluster:             *etcdName,
+		Endpoint:            c.Endpoint,
+		PreviousState:       c.Previous,
+		State:               c.State,
+		Timestamp:           result.Time,
+		LatencyMs:           c.Status.LatencyMs,
+		Error:               c.Status.Error,
+		ErrorType:           c.Status.ErrorType,
+		ConsecutiveFailures: c.Status.ConsecutiveFailures,
+		ClusterHealthy:      result.UnhealthyCount == 0,
+		MonitorVersion:      version,
+	}
+}
+
+// WebhookNotifier posts a JSON document to a URL when an endpoint changes
+// state, or for every endpoint on every check, as a generic integration with
+// alerting systems the monitor does not support.
+type WebhookNotifier struct {
+	url        string
+	headers    http.Header
+	secret     []byte
+	everyCheck bool
+	dryRun     bool
+	queue      *notificationQueue
+
+	states endpointStates
+}
+
+// NewWebhookNotifier returns a notifier posting to url with headers added to
+// every request, signing the requests with secret if it is not empty. With
+// dryRun the documents are only logged.
+func NewWebhookNotifier(url string, headers http.Header, secret []byte, everyCheck, dryRun bool) (*WebhookNotifier, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("the webhook URL must be an http(s) URL")
+	}
+
+	return &WebhookNotifier{
+		url:        url,
+		headers:    headers,
+		secret:     secret,
+		everyCheck: everyCheck,
+		dryRun:     dryRun,
+		queue:      newNotificationQueue("the webhook"),
+	}, nil
+}
+
+// newWebhookReporter returns the notifier of -webhook-url, signing with the
+// secret of -webhook-secret-file if set.
+func newWebhookReporter() (Reporter, error) {
+	headers, err := parseWebhookHeaders(webhookHeaders.values)
+	if err != nil {
+		return nil, err
+	}
+	var secret []byte
+	if *webhookSecretFile != "" {
+		secret, err = readWebhookSecret(*webhookSecretFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	notifier, err := NewWebhookNotifier(*webhookURL, headers, secret, *webhookEveryCheck, *dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifier, nil
+}
+
+// Report queues a document for every endpoint whose state changed, or for
+// every endpoint with everyCheck.
+func (n *WebhookNotifier) Report(ctx context.Context, result CheckResult) error {
+	if dropped := n.queue.takeDropped(); dropped > 0 {
+		metrics.Add(newMetricData("DroppedWebhooks", float64(dropped), types.StandardUnitCount)...)
+	}
+
+	for _, c := range n.states.observe(result) {
+		if !c.Changed && !n.everyCheck {
+			continue
+		}
+
+		event := newWebhookEvent(c, result)
+		n.queue.push(c.State+" event for "+c.Endpoint, func(ctx context.Context) error {
+			return n.post(ctx, event)
+		})
+	}
+
+	return nil
+}
+
+// Shutdown delivers the pending notifications until ctx is done.
+func (n *WebhookNotifier) Shutdown(ctx context.Context) error {
+	return n.queue.Shutdown(ctx)
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+	if n.dryRun {
+		log.Printf("[INFO] Dry run, not posting to the webhook: %s", body)
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return &notifyError{err: err, permanent: true}
+	}
+	for name, values := range n.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "etcd-monitor/"+version)
+	if len(n.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(n.secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reply, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &notifyError{
+			err:        fmt.Errorf("rate limited by the webhook"),
+			retryAfter: time.Duration(seconds) * time.Second,
+		}
+	default:
+		return &notifyError{
+			err:       fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(reply))),
+			permanent: resp.StatusCode < 500,
+		}
+	}
+}
+
+// webhookSignature returns the hex encoded HMAC-SHA256 of body with secret.
+func webhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseWebhookHeaders parses headers in "Name: value" form.
+func parseWebhookHeaders(headers []string) (http.Header, error) {
+	parsed := make(http.Header)
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid webhook header %q, expected Name: value", h)
+		}
+		name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(parts[0]))
+		if name == webhookSignatureHeader {
+			return nil, fmt.Errorf("the webhook header %s is set by the monitor", name)
+		}
+		parsed.Add(name, strings.TrimSpace(parts[1]))
+	}
+
+	return parsed, nil
+}
+
+// readWebhookSecret returns the signing secret held in file.
+func readWebhookSecret(file string) ([]byte, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the webhook secret file: %s", err)
+	}
+	secret := bytes.TrimSpace(buff)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("the webhook secret file %s is empty", file)
+	}
+
+	return secret, nil
+}
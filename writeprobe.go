@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// errPermissionDenied is returned by the write probe when etcd rejects the
+// write because of missing permissions.
+var errPermissionDenied = errors.New("permission denied")
+
+// WriteProbe writes a canary key and reads it back, the only proof that etcd
+// can commit writes. The key is attached to a lease so that it disappears on
+// its own once the monitor stops.
+type WriteProbe struct {
+	endpoint string
+	key      string
+	api      string
+	ttl      time.Duration
+	timeout  time.Duration
+}
+
+// NewWriteProbe returns a probe writing key through the etcd member at
+// endpoint with the given API ("v2" or "v3"). The key expires after ttl.
+func NewWriteProbe(endpoint, key, api string, ttl, timeout time.Duration) (*WriteProbe, error) {
+	switch api {
+	case readAPIV2, readAPIV3:
+	default:
+		return nil, fmt.Errorf("invalid write probe API %q, expected %q or %q", api, readAPIV2, readAPIV3)
+	}
+	if !strings.HasPrefix(key, "/") {
+		return nil, fmt.Errorf("invalid write probe key %q, must start with /", key)
+	}
+	if ttl < time.Second {
+		return nil, fmt.Errorf("invalid write probe TTL %s, must be at least 1s", ttl)
+	}
+
+	return &WriteProbe{
+		endpoint: endpoint,
+		key:      key,
+		api:      api,
+		ttl:      ttl,
+		timeout:  timeout,
+	}, nil
+}
+
+// Run writes and reads back the canary key and publishes WriteProbeLatencyMs
+// and WriteProbeFailed. A failure is reported on its own and does not make the
+// member unhealthy.
+func (p *WriteProbe) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	start := time.Now()
+	err := p.roundTrip(ctx, value)
+	latency := time.Since(start)
+	if err != nil {
+		if errors.Is(err, errPermissionDenied) {
+			log.Printf("[ERROR] Write probe of %s denied, grant the client certificate user readwrite access to the key: %s",
+				p.key, err)
+		} else {
+			log.Printf("[ERROR] Write probe of %s failed: %s", p.key, err)
+		}
+		metrics.Add(newEndpointMetricData(p.endpoint, "WriteProbeFailed", 1, types.StandardUnitNone)...)
+		return
+	}
+
+	ms := float64(latency) / float64(time.Millisecond)
+	metrics.Add(newEndpointMetricData(p.endpoint, "WriteProbeLatencyMs", ms, types.StandardUnitMilliseconds)...)
+	metrics.Add(newEndpointMetricData(p.endpoint, "WriteProbeFailed", 0, types.StandardUnitNone)...)
+}
+
+// roundTrip writes value and checks that reading the key returns it.
+func (p *WriteProbe) roundTrip(ctx context.Context, value string) error {
+	var read string
+	var err error
+	switch c, ok := etcdClients[p.endpoint]; {
+	case p.api == readAPIV2:
+		read, err = p.roundTripV2(ctx, value)
+	case ok:
+		read, err = p.roundTripClient(ctx, c, value)
+	default:
+		read, err = p.roundTripV3(ctx, value)
+	}
+	if err != nil {
+		return err
+	}
+	if read != value {
+		return fmt.Errorf("read back %q, wrote %q", read, value)
+	}
+
+	return nil
+}
+
+// roundTripClient writes and reads the key with the gRPC client.
+func (p *WriteProbe) roundTripClient(ctx context.Context, c *clientv3.Client, value string) (string, error) {
+	lease, err := c.Grant(ctx, int64(p.ttl.Seconds()))
+	if err == nil {
+		_, err = c.Put(ctx, p.key, value, clientv3.WithLease(lease.ID))
+	}
+	if err != nil {
+		if errors.Is(err, rpctypes.ErrPermissionDenied) {
+			return "", fmt.Errorf("%w: %s", errPermissionDenied, err)
+		}
+		return "", err
+	}
+
+	resp, err := c.Get(ctx, p.key)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", fmt.Errorf("key not found after writing it")
+	}
+
+	return string(resp.Kvs[0].Value), nil
+}
+
+// roundTripV3 writes and reads the key through the v3 gRPC gateway.
+func (p *WriteProbe) roundTripV3(ctx context.Context, value string) (string, error) {
+	var lease struct {
+		ID string `json:"ID"`
+	}
+	err := p.post(ctx, "/v3/lease/grant", map[string]interface{}{"TTL": int64(p.ttl.Seconds())}, &lease)
+	if err != nil {
+		return "", err
+	}
+
+	key := base64.StdEncoding.EncodeToString([]byte(p.key))
+	err = p.post(ctx, "/v3/kv/put", map[string]interface{}{
+		"key":   key,
+		"value": base64.StdEncoding.EncodeToString([]byte(value)),
+		"lease": lease.ID,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var rangeResp struct {
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := p.post(ctx, "/v3/kv/range", map[string]interface{}{"key": key}, &rangeResp); err != nil {
+		return "", err
+	}
+	if len(rangeResp.Kvs) == 0 {
+		return "", fmt.Errorf("key not found after writing it")
+	}
+	read, err := base64.StdEncoding.DecodeString(rangeResp.Kvs[0].Value)
+	if err != nil {
+		return "", fmt.Errorf("invalid range response payload: %s", err)
+	}
+
+	return string(read), nil
+}
+
+// post sends body as JSON to path on the gateway and decodes the response
+// into result, if not nil.
+func (p *WriteProbe) post(ctx context.Context, path string, body, result interface{}) error {
+	buff, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+path, bytes.NewReader(buff))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return p.do(req, result)
+}
+
+// roundTripV2 writes and reads the key through the v2 keys API.
+func (p *WriteProbe) roundTripV2(ctx context.Context, value string) (string, error) {
+	form := url.Values{}
+	form.Set("value", value)
+	form.Set("ttl", strconv.Itoa(int(p.ttl.Seconds())))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		fmt.Sprintf("%s/v2/keys%s", p.endpoint, p.key), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := p.do(req, nil); err != nil {
+		return "", err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/v2/keys%s?quorum=true", p.endpoint, p.key), nil)
+	if err != nil {
+		return "", err
+	}
+	var getResp struct {
+		Node struct {
+			Value string `json:"value"`
+		} `json:"node"`
+	}
+	if err := p.do(req, &getResp); err != nil {
+		return "", err
+	}
+
+	return getResp.Node.Value, nil
+}
+
+// do sends req and decodes the response into result, if not nil. 401 and 403
+// responses are reported as errPermissionDenied.
+func (p *WriteProbe) do(req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %s", errPermissionDenied, strings.TrimSpace(string(buff)))
+	default:
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(buff)))
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(buff, result); err != nil {
+		return fmt.Errorf("invalid response payload: %s", err)
+	}
+
+	return nil
+}